@@ -4,6 +4,7 @@ go 1.15
 
 require (
 	github.com/boltdb/bolt v1.3.1
+	go.etcd.io/bbolt v1.3.6
 	golang.org/x/crypto v0.0.0-20210711020723-a769d52b0f97
 	golang.org/x/sys v0.0.0-20210630005230-0f9fa26af87c // indirect
 )
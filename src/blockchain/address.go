@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+/*
+An address names the locking key of an output in one of two forms:
+
+	Base58Check  version | key | CheckSum, version 0x00 for a key hash and schnorrVersion for an
+	             x-only Schnorr key. The form of Wallet.GetAddress and of the wallet file
+	Bech32       hrp 1 data, version 0 and the key hash in Bech32, version 1 and the Schnorr key in
+	             Bech32m, like segwit v0 and taproot addresses. The hrp names the network, an
+	             address of another network is rejected
+
+Both decode to the same locking key, so they can be used in place of each other. The network
+comes from the NETWORK env. var, main when it is not set.
+*/
+const bech32KeyHashVersion = 0
+const bech32SchnorrVersion = 1
+
+var networkHRPs = map[string]string{
+	"main":    "bbc",
+	"test":    "tbbc",
+	"regtest": "bbcrt",
+}
+
+var (
+	errAddressEmpty     = errors.New("empty address")
+	errAddressChar      = errors.New("invalid character")
+	errAddressMixedCase = errors.New("mixed upper and lower case")
+	errAddressChecksum  = errors.New("bad checksum")
+	errAddressLength    = errors.New("bad length")
+	errAddressVersion   = errors.New("unknown address version")
+	errAddressVariant   = errors.New("checksum variant does not match the version")
+	errAddressPadding   = errors.New("bad padding")
+	errAddressNetwork   = errors.New("address of another network")
+)
+
+// AddressError tells why an address does not decode, Err is one of the errAddress* kinds
+type AddressError struct {
+	Address string
+	Err     error
+	Pos     int // of the bad character, -1 when there is none
+}
+
+func (e *AddressError) Error() string {
+	if e.Pos >= 0 {
+		return fmt.Sprintf("address %q: %s at position %d", e.Address, e.Err, e.Pos)
+	}
+	return fmt.Sprintf("address %q: %s", e.Address, e.Err)
+}
+
+func (e *AddressError) Unwrap() error {
+	return e.Err
+}
+
+// networkHRP is the hrp of the network the node runs on, the CLI checks NETWORK before any command
+func networkHRP() (string, error) {
+	network := os.Getenv("NETWORK")
+	if network == "" {
+		network = "main"
+	}
+	hrp, found := networkHRPs[network]
+	if !found {
+		return "", fmt.Errorf("unknown network %q, it is main, test or regtest", network)
+	}
+	return hrp, nil
+}
+
+// DecodeAddress returns the locking key of an address in either form
+func DecodeAddress(address string) ([]byte, error) {
+	if address == "" {
+		return nil, &AddressError{address, errAddressEmpty, -1}
+	}
+	if isBech32Address(address) {
+		return decodeBech32Address(address)
+	}
+	return decodeBase58Address(address)
+}
+
+// a Base58 address starts with 1 or 3, a Bech32 one with the hrp of a network
+func isBech32Address(address string) bool {
+	lower := strings.ToLower(address)
+	for _, hrp := range networkHRPs {
+		if strings.HasPrefix(lower, hrp+"1") {
+			return true
+		}
+	}
+	return false
+}
+
+func decodeBase58Address(address string) ([]byte, error) {
+	for i := 0; i < len(address); i++ {
+		if bytes.IndexByte(b58Alphabet, address[i]) < 0 {
+			return nil, &AddressError{address, errAddressChar, i}
+		}
+	}
+	decoded := Base58Decode([]byte(address))
+	if len(decoded) < 1+addressChecksumLen {
+		return nil, &AddressError{address, errAddressLength, -1}
+	}
+	payload := decoded[:len(decoded)-addressChecksumLen]
+	if !bytes.Equal(CheckSum(payload), decoded[len(payload):]) {
+		return nil, &AddressError{address, errAddressChecksum, -1}
+	}
+	key := payload[1:]
+	switch {
+	case payload[0] == version && len(key) == ripemd160Size:
+	case payload[0] == schnorrVersion && len(key) == schnorrKeyLen:
+	case payload[0] == version || payload[0] == schnorrVersion:
+		return nil, &AddressError{address, errAddressLength, -1}
+	default:
+		return nil, &AddressError{address, errAddressVersion, -1}
+	}
+	return key, nil
+}
+
+func decodeBech32Address(address string) ([]byte, error) {
+	hrp, data, variant, err := bech32Decode(address)
+	if err != nil {
+		return nil, err
+	}
+	network, err := networkHRP()
+	if err != nil {
+		return nil, err
+	}
+	if hrp != network {
+		return nil, &AddressError{address, errAddressNetwork, -1}
+	}
+	if len(data) < 1 {
+		return nil, &AddressError{address, errAddressLength, -1}
+	}
+	key, ok := convertBits(data[1:], 5, 8, false)
+	if !ok {
+		return nil, &AddressError{address, errAddressPadding, -1}
+	}
+	switch data[0] {
+	case bech32KeyHashVersion:
+		if variant != bech32Plain {
+			return nil, &AddressError{address, errAddressVariant, -1}
+		}
+		if len(key) != ripemd160Size {
+			return nil, &AddressError{address, errAddressLength, -1}
+		}
+	case bech32SchnorrVersion:
+		if variant != bech32M {
+			return nil, &AddressError{address, errAddressVariant, -1}
+		}
+		if len(key) != schnorrKeyLen {
+			return nil, &AddressError{address, errAddressLength, -1}
+		}
+	default:
+		return nil, &AddressError{address, errAddressVersion, -1}
+	}
+	return key, nil
+}
+
+// Bech32Address is the Bech32 form of the address of an output locked to key, "" for none or an unknown network
+func Bech32Address(key []byte) string {
+	hrp, err := networkHRP()
+	if err != nil {
+		return ""
+	}
+	switch len(key) {
+	case ripemd160Size:
+		data, _ := convertBits(key, 8, 5, true)
+		return bech32Encode(hrp, append([]byte{bech32KeyHashVersion}, data...), bech32Plain)
+	case schnorrKeyLen:
+		data, _ := convertBits(key, 8, 5, true)
+		return bech32Encode(hrp, append([]byte{bech32SchnorrVersion}, data...), bech32M)
+	}
+	return ""
+}
+
+/*
+NormalizeAddress is the Base58 form of an address, the one the wallet file knows it by. Commands
+look addresses up in the wallet with it, so either form can be given
+*/
+func NormalizeAddress(address string) (string, error) {
+	key, err := DecodeAddress(address)
+	if err != nil {
+		return "", err
+	}
+	return string(LockingKeyAddress(key)), nil
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"log"
+)
+
+/*
+The address index is optional, it exists when it was enabled with reindex -addrindex and is then
+kept up to date by connectBlock and disconnectBlock like the other chain indexes.
+One entry per (address, tx) of the active chain:
+
+	key   uint8 key length | locking key | uint32 height | uint32 position, big endian so the
+	      entries of one address sort by height
+	value int64 delta, what the tx paid to the address minus what it spent from it
+*/
+const addrIndexBucket = "addrindex"
+
+var errNoAddrIndex = errors.New("address index is not enabled, run reindex -addrindex")
+var errPrunedAddrIndex = errors.New("the address index needs every block, it can not be used with pruning")
+
+type AddressTx struct {
+	TXid     []byte
+	Height   int
+	Position int
+	Delta    int
+}
+
+func addrIndexPrefix(lockingKey []byte) []byte {
+	return append([]byte{byte(len(lockingKey))}, lockingKey...)
+}
+
+func addrIndexKey(lockingKey []byte, height, pos int) []byte {
+	key := addrIndexPrefix(lockingKey)
+	key = append(key, heightKey(height)...)
+	return append(key, heightKey(pos)...)
+}
+
+// addressDeltas sums what every address gained or lost in tx, the spent outputs come from the txindex
+func addressDeltas(tx *StorageTx, t *Transaction) (map[string]int, error) {
+	deltas := make(map[string]int)
+	for _, out := range t.VOut {
+		if out.Type() == OutputPubKeyHash || out.Type() == OutputSchnorr {
+			deltas[string(out.PubKeyHash)] += out.Value
+		}
+	}
+	if t.isCoinbaseTX() {
+		return deltas, nil
+	}
+	for _, in := range t.VIn {
+		prevTX, _, err := findTransactionInTx(tx, in.TXid)
+		if err != nil {
+			return nil, err
+		}
+		if in.Vout < 0 || in.Vout >= len(prevTX.VOut) {
+			return nil, errors.New("input spends an output that does not exist")
+		}
+		out := prevTX.VOut[in.Vout]
+		if out.Type() == OutputPubKeyHash || out.Type() == OutputSchnorr {
+			deltas[string(out.PubKeyHash)] -= out.Value
+		}
+	}
+	return deltas, nil
+}
+
+// indexAddresses adds the entries of b to the address index, or removes them, if the index is enabled
+func indexAddresses(tx *StorageTx, b *block, connect bool) error {
+	index := tx.Indexes().Index(addrIndexBucket)
+	if index == nil {
+		return nil
+	}
+	for pos, t := range b.Transactions {
+		deltas, err := addressDeltas(tx, t)
+		if err != nil {
+			return err
+		}
+		for lockingKey, delta := range deltas {
+			key := addrIndexKey([]byte(lockingKey), b.Height, pos)
+			if !connect {
+				err = index.Delete(key)
+			} else {
+				var buff bytes.Buffer
+				writeUint64(&buff, uint64(int64(delta)))
+				err = index.Put(key, buff.Bytes())
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (bc *BlockChain) hasAddressIndex() bool {
+	found := false
+	err := bc.db.View(func(tx *StorageTx) error {
+		found = tx.Indexes().Index(addrIndexBucket) != nil
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+	return found
+}
+
+// EnableAddressIndex creates the address index and fills it from the active chain, which must not be pruned
+func (bc *BlockChain) EnableAddressIndex() {
+	if bc.db.PruneHeight() > 0 {
+		log.Panic(errPrunedAddrIndex)
+	}
+	err := bc.db.Update(func(tx *StorageTx) error {
+		_, err := tx.Indexes().CreateIndex(addrIndexBucket)
+		return err
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+	bc.ReindexChain()
+}
+
+/*
+AddressHistory lists the transactions of the active chain that paid or spent lockingKey, newest first.
+skip and count select one page, total is the number of transactions of the address
+*/
+func (bc *BlockChain) AddressHistory(lockingKey []byte, skip, count int) ([]AddressTx, int, error) {
+	var history []AddressTx
+	total := 0
+	err := bc.db.View(func(tx *StorageTx) error {
+		index := tx.Indexes().Index(addrIndexBucket)
+		if index == nil {
+			return errNoAddrIndex
+		}
+		prefix := addrIndexPrefix(lockingKey)
+		c := index.Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			total++
+		}
+		// walk back from the last entry of the address
+		k, v := c.Seek(append(append([]byte{}, prefix...), bytes.Repeat([]byte{0xff}, 8)...))
+		if k == nil {
+			k, v = c.Last()
+		} else if !bytes.HasPrefix(k, prefix) {
+			k, v = c.Prev()
+		}
+		for i := 0; i < skip && k != nil && bytes.HasPrefix(k, prefix); i++ {
+			k, v = c.Prev()
+		}
+		for ; k != nil && bytes.HasPrefix(k, prefix) && len(history) < count; k, v = c.Prev() {
+			entry := k[len(prefix):]
+			delta, err := readUint64(bytes.NewReader(v))
+			if err != nil {
+				return err
+			}
+			height := int(binary.BigEndian.Uint32(entry[:4]))
+			pos := int(binary.BigEndian.Uint32(entry[4:]))
+			hash := tx.Indexes().Index(heightIndexBucket).Get(heightKey(height))
+			b, err := tx.Blocks().Get(hash)
+			if err != nil {
+				return err
+			}
+			history = append(history, AddressTx{b.Transactions[pos].ID, height, pos, int(int64(delta))})
+		}
+		return nil
+	})
+	return history, total, err
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestAddressHistory(t *testing.T) {
+	bc, w := newTestChain(t)
+	genesis, err := bc.GetBlock(bc.tip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := bc.AddressHistory(w.LockingKey(), 0, 10); err != errNoAddrIndex {
+		t.Fatalf("history without the index: %v", err)
+	}
+	bc.EnableAddressIndex()
+
+	// the index is kept up to date by the blocks connected after it was enabled
+	to := NewWallet()
+	tx := NewPaymentsTransaction(w, []Payment{{string(to.GetAddress()), 4}}, "", &UTXOSet{bc}, nil)
+	if _, err := bc.MineBlock([]*Transaction{NewCoinbaseTX(string(NewWallet().GetAddress()), ""), tx}); err != nil {
+		t.Fatal(err)
+	}
+	history, total, err := bc.AddressHistory(w.LockingKey(), 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 2 || len(history) != 2 {
+		t.Fatalf("%d txs of %d, want 2", len(history), total)
+	}
+	reward := genesis.Transactions[0].VOut[0].Value
+	if !bytes.Equal(history[0].TXid, tx.ID) || history[0].Height != 1 || history[0].Position != 1 || history[0].Delta != -4 {
+		t.Fatalf("the newest entry is %+v", history[0])
+	}
+	if !bytes.Equal(history[1].TXid, genesis.Transactions[0].ID) || history[1].Delta != reward {
+		t.Fatalf("the oldest entry is %+v", history[1])
+	}
+	page, total, err := bc.AddressHistory(w.LockingKey(), 1, 1)
+	if err != nil || total != 2 || len(page) != 1 || !bytes.Equal(page[0].TXid, genesis.Transactions[0].ID) {
+		t.Fatalf("the second page is %+v: %v", page, err)
+	}
+	if history, _, _ := bc.AddressHistory(to.LockingKey(), 0, 10); len(history) != 1 || history[0].Delta != 4 {
+		t.Fatalf("the payee history is %+v", history)
+	}
+
+	// the entries of disconnected blocks go away in a reorg
+	b1 := forkBlock(&genesis)
+	bc.AddBlock(b1)
+	bc.AddBlock(forkBlock(b1))
+	if history, total, err := bc.AddressHistory(to.LockingKey(), 0, 10); err != nil || total != 0 || len(history) != 0 {
+		t.Fatalf("the payee still has %d txs after the reorg: %v", total, err)
+	}
+	if _, total, _ := bc.AddressHistory(w.LockingKey(), 0, 10); total != 1 {
+		t.Fatalf("the payer has %d txs after the reorg, want the genesis coinbase", total)
+	}
+}
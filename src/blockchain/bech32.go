@@ -0,0 +1,142 @@
+package main
+
+import (
+	"strings"
+)
+
+/*
+Bech32 (BIP-173) and Bech32m (BIP-350): hrp | "1" | data in 5-bit groups | 6 checksum characters.
+The checksum is a BCH code that detects any error in up to 4 characters, the two variants only
+differ in the constant the checksum is xored with. Strings are lowercase or uppercase, never both.
+*/
+const bech32Charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+const bech32MaxLen = 90
+const bech32ChecksumLen = 6
+
+type bech32Variant int
+
+const (
+	bech32Plain bech32Variant = iota // BIP-173
+	bech32M                          // BIP-350
+)
+
+var bech32Consts = map[bech32Variant]uint32{bech32Plain: 1, bech32M: 0x2bc830a3}
+
+func bech32Polymod(values []byte) uint32 {
+	gen := []uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := 0; i < 5; i++ {
+			if (top>>uint(i))&1 == 1 {
+				chk ^= gen[i]
+			}
+		}
+	}
+	return chk
+}
+
+func bech32HRPExpand(hrp string) []byte {
+	out := make([]byte, 0, 2*len(hrp)+1)
+	for i := 0; i < len(hrp); i++ {
+		out = append(out, hrp[i]>>5)
+	}
+	out = append(out, 0)
+	for i := 0; i < len(hrp); i++ {
+		out = append(out, hrp[i]&31)
+	}
+	return out
+}
+
+func bech32Checksum(hrp string, data []byte, variant bech32Variant) []byte {
+	values := append(bech32HRPExpand(hrp), data...)
+	values = append(values, make([]byte, bech32ChecksumLen)...)
+	mod := bech32Polymod(values) ^ bech32Consts[variant]
+	out := make([]byte, bech32ChecksumLen)
+	for i := range out {
+		out[i] = byte(mod>>uint(5*(5-i))) & 31
+	}
+	return out
+}
+
+// bech32Encode writes hrp and the 5-bit groups of data, lowercase
+func bech32Encode(hrp string, data []byte, variant bech32Variant) string {
+	var sb strings.Builder
+	sb.WriteString(hrp)
+	sb.WriteByte('1')
+	for _, d := range append(data, bech32Checksum(hrp, data, variant)...) {
+		sb.WriteByte(bech32Charset[d])
+	}
+	return sb.String()
+}
+
+/*
+bech32Decode splits s into its hrp and 5-bit groups and tells which variant the checksum is of.
+Errors are errAddress* kinds with the position of the bad character where there is one
+*/
+func bech32Decode(s string) (string, []byte, bech32Variant, error) {
+	if len(s) > bech32MaxLen {
+		return "", nil, 0, &AddressError{s, errAddressLength, -1}
+	}
+	lower, upper := false, false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < 33 || c > 126 {
+			return "", nil, 0, &AddressError{s, errAddressChar, i}
+		}
+		lower = lower || (c >= 'a' && c <= 'z')
+		upper = upper || (c >= 'A' && c <= 'Z')
+	}
+	if lower && upper {
+		return "", nil, 0, &AddressError{s, errAddressMixedCase, -1}
+	}
+	address := s
+	s = strings.ToLower(s)
+	sep := strings.LastIndexByte(s, '1')
+	if sep < 1 || sep+1+bech32ChecksumLen > len(s) {
+		return "", nil, 0, &AddressError{address, errAddressLength, -1}
+	}
+	hrp := s[:sep]
+	data := make([]byte, 0, len(s)-sep-1)
+	for i := sep + 1; i < len(s); i++ {
+		d := strings.IndexByte(bech32Charset, s[i])
+		if d < 0 {
+			return "", nil, 0, &AddressError{address, errAddressChar, i}
+		}
+		data = append(data, byte(d))
+	}
+	mod := bech32Polymod(append(bech32HRPExpand(hrp), data...))
+	for variant, c := range bech32Consts {
+		if mod == c {
+			return hrp, data[:len(data)-bech32ChecksumLen], variant, nil
+		}
+	}
+	return "", nil, 0, &AddressError{address, errAddressChecksum, -1}
+}
+
+// convertBits regroups bits, from 8 to 5 with padding when encoding, back from 5 to 8 without
+func convertBits(data []byte, from, to uint, pad bool) ([]byte, bool) {
+	acc, bits := uint32(0), uint(0)
+	maxv := uint32(1)<<to - 1
+	var out []byte
+	for _, v := range data {
+		if uint32(v)>>from != 0 {
+			return nil, false
+		}
+		acc = acc<<from | uint32(v)
+		bits += from
+		for bits >= to {
+			bits -= to
+			out = append(out, byte(acc>>bits&maxv))
+		}
+	}
+	if pad {
+		if bits > 0 {
+			out = append(out, byte(acc<<(to-bits)&maxv))
+		}
+	} else if bits >= from || acc<<(to-bits)&maxv != 0 {
+		return nil, false
+	}
+	return out, true
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestBech32Vectors(t *testing.T) {
+	// the valid test vectors of BIP-173 and BIP-350
+	valid := map[bech32Variant][]string{
+		bech32Plain: {
+			"A12UEL5L",
+			"a12uel5l",
+			"an83characterlonghumanreadablepartthatcontainsthenumber1andtheexcludedcharactersbio1tt5tgs",
+			"abcdef1qpzry9x8gf2tvdw0s3jn54khce6mua7lmqqqxw",
+			"11qqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqc8247j",
+			"split1checkupstagehandshakeupstreamerranterredcaperred2y9e3w",
+			"?1ezyfcl",
+		},
+		bech32M: {
+			"A1LQFN3A",
+			"a1lqfn3a",
+			"abcdef1l7aum6echk45nj3s0wdvt2fg8x9yrzpqzd3ryx",
+			"11llllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllludsr8",
+			"split1checkupstagehandshakeupstreamerranterredcaperredlc445v",
+			"?1v759aa",
+		},
+	}
+	for variant, list := range valid {
+		for _, s := range list {
+			hrp, data, got, err := bech32Decode(s)
+			if err != nil {
+				t.Errorf("%s: %v", s, err)
+				continue
+			}
+			if got != variant {
+				t.Errorf("%s: variant %d, want %d", s, got, variant)
+			}
+			if again := bech32Encode(hrp, data, variant); again != strings.ToLower(s) {
+				t.Errorf("%s: encoded again as %s", s, again)
+			}
+		}
+	}
+
+	invalid := []string{
+		"\x201nwldj5",  // hrp character out of range
+		"x1b4n0q5v",    // invalid data character
+		"li1dgmt3",     // too short checksum
+		"A1G7SGD8",     // checksum calculated with uppercase hrp
+		"10a06t8",      // empty hrp
+		"1qzzfhee",     // empty hrp
+		"pzry9x0s0muk", // no separator
+		"A12UEl5L",     // mixed case
+		"an84characterslonghumanreadablepartthatcontainsthenumber1andtheexcludedcharactersbio1569pvx", // too long
+	}
+	for _, s := range invalid {
+		if _, _, _, err := bech32Decode(s); err == nil {
+			t.Errorf("%q decodes", s)
+		}
+	}
+}
+
+func TestConvertBitsRoundTrip(t *testing.T) {
+	for n := 0; n < 40; n++ {
+		data := bytes.Repeat([]byte{0xa5}, n)
+		groups, ok := convertBits(data, 8, 5, true)
+		if !ok {
+			t.Fatalf("%d bytes do not convert", n)
+		}
+		back, ok := convertBits(groups, 5, 8, false)
+		if !ok || !bytes.Equal(back, data) {
+			t.Fatalf("%d bytes came back as %x", n, back)
+		}
+	}
+}
+
+func TestBech32AddressRoundTrip(t *testing.T) {
+	os.Unsetenv("NETWORK")
+	for _, w := range []*Wallet{NewWallet(), NewSchnorrWallet()} {
+		address := Bech32Address(w.LockingKey())
+		key, err := DecodeAddress(address)
+		if err != nil {
+			t.Fatalf("%s: %v", address, err)
+		}
+		if !bytes.Equal(key, w.LockingKey()) {
+			t.Fatalf("%s decodes to another key", address)
+		}
+		if base58, err := NormalizeAddress(strings.ToUpper(address)); err != nil || base58 != string(w.GetAddress()) {
+			t.Fatalf("%s normalizes to %s: %v", address, base58, err)
+		}
+	}
+
+	address := Bech32Address(NewWallet().LockingKey())
+	os.Setenv("NETWORK", "test")
+	defer os.Unsetenv("NETWORK")
+	if _, err := DecodeAddress(address); err == nil {
+		t.Fatal("an address of the main network decodes on the test one")
+	}
+	os.Setenv("NETWORK", "nonsense")
+	if _, err := networkHRP(); err == nil {
+		t.Fatal("an unknown network has an hrp")
+	}
+}
@@ -2,7 +2,7 @@ package main
 
 import (
 	"bytes"
-	"encoding/gob"
+	"crypto/sha256"
 	"log"
 	"time"
 )
@@ -38,26 +38,81 @@ type block struct {
 	// all members are capitalized first
 }
 
-// convert a block to a byte array
+const merkleRootLen = 32
+
+// the part of a block covered by the proof of work, transactions are committed through the merkle root
+type blockHeader struct {
+	Timestamp     int64
+	PrevBlockHash []byte
+	MerkleRoot    []byte
+	TargetBits    int
+	Nonce         int
+	Height        int
+}
+
+func (b *block) Header() *blockHeader {
+	return &blockHeader{b.Timestamp, b.PrevBlockHash, b.HashTransactions(), targetBits, b.Nonce, b.Height}
+}
+
+func (h *blockHeader) Serialize() []byte {
+	var res bytes.Buffer
+	writeBlockHeader(&res, h)
+	return res.Bytes()
+}
+
+func (h *blockHeader) Hash() []byte {
+	hash := sha256.Sum256(h.Serialize())
+	return hash[:]
+}
+
+// convert a block to a byte array, see serialization.go for the layout
 func (b *block) Serialize() []byte {
 	var res bytes.Buffer
-	encoder := gob.NewEncoder(&res) // address
-	err := encoder.Encode(b)        // here b is the source
-	if err != nil {
-		log.Panic(err)
+	writeBlockHeader(&res, b.Header())
+	writeVarInt(&res, uint64(len(b.Transactions)))
+	for _, tx := range b.Transactions {
+		writeTransaction(&res, tx, true)
 	}
 	return res.Bytes()
 }
 
-// convert byte array to a block
+// convert byte array to a block, the hash is recomputed from the header
+func DeserializeBlock(buffer []byte) (*block, error) {
+	r := bytes.NewReader(buffer)
+	header, err := readBlockHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	n, err := readCount(r)
+	if err != nil {
+		return nil, err
+	}
+	b := &block{
+		Timestamp:     header.Timestamp,
+		PrevBlockHash: header.PrevBlockHash,
+		Hash:          header.Hash(),
+		Nonce:         header.Nonce,
+		Height:        header.Height,
+	}
+	for i := 0; i < n; i++ {
+		tx, err := readTransaction(r)
+		if err != nil {
+			return nil, err
+		}
+		b.Transactions = append(b.Transactions, tx)
+	}
+	if err = checkFullyRead(r); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
 func Deserialize(buffer []byte) *block {
-	var b block
-	decoder := gob.NewDecoder(bytes.NewReader(buffer)) // convert []byte to io.Reader
-	err := decoder.Decode(&b)                          // here b is the dest
+	b, err := DeserializeBlock(buffer)
 	if err != nil {
 		log.Panic(err)
 	}
-	return &b
+	return b
 }
 
 // initialize a new block
@@ -99,10 +154,11 @@ func NewGenesisBlock(coinbase *Transaction) *block {
 	return NewBlock([]*Transaction{coinbase}, []byte{}, 0) //  there is no blockchain yet
 }
 
+// the merkle root commits to the txids only, witness data is committed by the coinbase
 func (b *block) HashTransactions() []byte {
 	var transaction [][]byte
 	for _, tx := range b.Transactions {
-		transaction = append(transaction, tx.Serialize())
+		transaction = append(transaction, tx.SerializeNoWitness())
 	}
 	mTree := NewMerkelTree(transaction)
 	return mTree.Root.Data
@@ -1,12 +1,9 @@
 package main
 
 import (
-	"bytes"
-	"crypto/ecdsa"
+	"encoding/binary"
 	"encoding/hex"
-	"errors"
 	"fmt"
-	"github.com/boltdb/bolt"
 	"log"
 	"os"
 )
@@ -20,8 +17,9 @@ const genesisCoinbaseData = "The Times 03/Jan/2009 Chancellor on brink of second
 //} // array formed by blocks
 
 type BlockChain struct {
-	tip []byte   // only stored the last block hash
-	db  *bolt.DB // along with its specific db
+	tip           []byte   // only stored the last block hash
+	db            *Storage // along with its specific db
+	indexesBehind bool     // the tip moved to a block whose ancestors are not known yet, see setTip
 }
 
 //func (chain *BlockChain) AddBlock(data string) {
@@ -59,84 +57,92 @@ type BlockChain struct {
 //	}
 //}
 
-func dbExists(thisdbFile string) bool {
-	if _, err := os.Stat(thisdbFile); os.IsNotExist(err) {
-		return false
-	}
-	return true
-}
-
-// transaction version of AddBlock, but the two are essentially the same
-func (chain *BlockChain) MineBlock(transactions []*Transaction) *block {
+/*
+transaction version of AddBlock, but the two are essentially the same. The transactions must
+verify and spend outputs of the UTXO set, see FilterSpendable, otherwise nothing is mined and
+errInvalidTransaction or errSpendsMissingOutput is returned
+*/
+func (chain *BlockChain) MineBlock(transactions []*Transaction) (*block, error) {
 	var prevHash []byte
 	var prevHeight int
 
-	for _, tx := range transactions {
-		if chain.VerifyTransaction(tx) != true {
-			log.Panic("ERROR: Invalid transaction")
-		}
+	if chain.VerifyTransactions(transactions) != true {
+		return nil, errInvalidTransaction
 	}
+	AddWitnessCommitment(transactions) // changes the coinbase, so it has to happen before mining
 
-	err := chain.db.View(func(tx *bolt.Tx) error { // only View not edit
-		bucket := tx.Bucket([]byte(blocksBucket))
-		if bucket == nil {
-			log.Panic("Bucket is Null")
+	err := chain.db.View(func(tx *StorageTx) error { // only View not edit
+		prevHash = tx.Blocks().Tip() // get the prev block (aka. last block) hash
+		prevBlock, err := tx.Blocks().Get(prevHash)
+		if err != nil {
+			return err
 		}
-		prevHash = bucket.Get([]byte("l")) // get the prev block (aka. last block) hash
-		prevBlockData := bucket.Get(prevHash)
-		prevBlock := *Deserialize(prevBlockData)
 		prevHeight = prevBlock.Height //  get the current height
 		return nil
 	})
+	if err != nil {
+		return nil, err
+	}
 	newBlock := NewBlock(transactions, prevHash, prevHeight+1) // the new block extends the chain
-	err = chain.db.Update(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket([]byte(blocksBucket))
-		e := bucket.Put(newBlock.Hash, newBlock.Serialize()) // store the new block : hash to block
+	// the block, the tip, the indexes and the UTXO set in one commit
+	err = chain.db.Update(func(tx *StorageTx) error {
+		blocks := tx.Blocks()
+		e := blocks.Put(newBlock) // store the new block : hash to block
 		if e != nil {
 			log.Panic(e)
 		}
-		e = bucket.Put([]byte("l"), newBlock.Hash) // update the l entry to the new block hash
+		lastBlock, e := blocks.Get(blocks.Tip())
 		if e != nil {
 			log.Panic(e)
 		}
-		chain.tip = newBlock.Hash // dont forget update the chain tip, that is add a block to the whole chain
-		return nil
+		return setTip(tx, newBlock, lastBlock) // an error keeps nothing of the block
 	})
 	if err != nil {
-		log.Panic(err)
+		return nil, err
 	}
-	return newBlock
+	chain.tip = newBlock.Hash // dont forget update the chain tip, that is add a block to the whole chain
+	return newBlock, nil
 }
 
 func (bc *BlockChain) AddBlock(block *block) {
-	err := bc.db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(blocksBucket))
-		blockInDb := b.Get(block.Hash)
-
-		if blockInDb != nil {
+	err := bc.db.Update(func(tx *StorageTx) error {
+		blocks := tx.Blocks()
+		if blocks.Has(block.Hash) {
+			return nil
+		}
+		if !block.HasValidWitnessCommitment() {
+			fmt.Printf("Rejected block %x: witness commitment does not match\n", block.Hash)
 			return nil
 		}
 
-		blockData := block.Serialize()
-		err := b.Put(block.Hash, blockData)
+		err := blocks.Put(block)
 		if err != nil {
 			log.Panic(err)
 		}
 
-		lastHash := b.Get([]byte("l"))
-		lastBlockData := b.Get(lastHash)
-		lastBlock := Deserialize(lastBlockData)
+		lastBlock, err := blocks.Get(blocks.Tip())
+		if err != nil {
+			log.Panic(err)
+		}
 
 		if block.Height > lastBlock.Height {
-			err = b.Put([]byte("l"), block.Hash)
-			if err != nil {
+			err = setTip(tx, block, lastBlock)
+			if err == errSpendsMissingOutput || err == errBadBlockSignature {
+				return err // nothing of the block is kept
+			}
+			if err != nil && err != errMissingAncestor {
 				log.Panic(err)
 			}
+			bc.indexesBehind = bc.indexesBehind || err == errMissingAncestor
 			bc.tip = block.Hash
 		}
 
 		return nil
 	})
+	if err == errSpendsMissingOutput || err == errBadBlockSignature {
+		fmt.Printf("Rejected block %x: %s\n", block.Hash, err)
+		return
+	}
 	if err != nil {
 		log.Panic(err)
 	}
@@ -191,23 +197,24 @@ func CreateBlockChain(address string, nodeId string) *BlockChain {
 		os.Exit(1)
 	}
 	var tip []byte
-	db, err := bolt.Open(thisdbFile, 0600, nil)
-	if err != nil {
-		log.Panic(err)
-	}
+	db := openStorage(thisdbFile)
 	fmt.Println("CreateBlockChain1")
-	err = db.Update(func(tx *bolt.Tx) error {
+	err := db.Update(func(tx *StorageTx) error {
 		coinbasetx := NewCoinbaseTX(address, genesisCoinbaseData)
 		genesis := NewGenesisBlock(coinbasetx)
-		bucket, err := tx.CreateBucket([]byte(blocksBucket)) // there is none yet, create one
+		err := tx.Blocks().Put(genesis) // there is none yet, this creates the store
+		if err != nil {
+			log.Panic(err)
+		}
+		err = tx.Blocks().SetTip(genesis.Hash)
 		if err != nil {
 			log.Panic(err)
 		}
-		err = bucket.Put(genesis.Hash, genesis.Serialize())
+		err = connectBlock(tx, genesis)
 		if err != nil {
 			log.Panic(err)
 		}
-		err = bucket.Put([]byte{'l'}, genesis.Hash)
+		err = connectUTXO(tx, genesis)
 		if err != nil {
 			log.Panic(err)
 		}
@@ -218,7 +225,7 @@ func CreateBlockChain(address string, nodeId string) *BlockChain {
 		log.Panic(err)
 	}
 	fmt.Println("CreateBlockChain2")
-	bc := &BlockChain{tip, db}
+	bc := &BlockChain{tip: tip, db: db}
 	return bc
 }
 
@@ -233,21 +240,35 @@ func NewBlockChain(nodeId string) *BlockChain {
 	}
 
 	var tip []byte
-	db, err := bolt.Open(thisdbFile, 0600, nil)
-	if err != nil {
+	db := openStorage(thisdbFile)
+	if isLegacyDB(db) {
+		fmt.Println("Blockchain uses the old gob encoding. Run migratedb first.")
+		db.Close()
+		os.Exit(1)
+	}
+	if moved, err := db.importBlocksBucket(); err != nil {
 		log.Panic(err)
+	} else if moved > 0 {
+		fmt.Printf("Moved %d blocks from the db to %s\n", moved, blockDir(thisdbFile))
 	}
 	// dp Update is a transaction involving reading and updating
-	err = db.Update(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket([]byte(blocksBucket))
-		tip = bucket.Get([]byte("l"))
+	err := db.View(func(tx *StorageTx) error {
+		tip = tx.Blocks().Tip()
 		return nil
 	})
 	if err != nil {
 		log.Panic(err)
 	}
 
-	bc := BlockChain{tip, db}
+	bc := BlockChain{tip: tip, db: db}
+	if !bc.hasChainIndex() { // databases from before the chain indexes
+		fmt.Println("Building chain indexes...")
+		bc.ReindexChain()
+	}
+	if utxo := (UTXOSet{&bc}); !utxo.hasUTXOSet() { // the set was keyed by txid before
+		fmt.Println("Rebuilding the UTXO set...")
+		utxo.Reindex()
+	}
 	return &bc // initialize a new block
 }
 
@@ -299,6 +320,7 @@ func (bc *BlockChain) FindUnspentTransactions(pubKeyHash []byte) []Transaction{
 	return unspentTX
 }
 */
+/*
 //almost identical to Blockchain.FindUnspentTransactions, but now it returns a map of TransactionID → TransactionOutputs pairs
 func (bc *BlockChain) FindUTXO() map[string]TXOutputs {
 	UTXO := make(map[string]TXOutputs)
@@ -346,6 +368,7 @@ func (bc *BlockChain) FindUTXO() map[string]TXOutputs {
 	//fmt.Println(len(unspentTX))
 	return UTXO
 }
+*/
 
 // just pick the output
 /*
@@ -387,23 +410,34 @@ func (bc *BlockChain) FindSpendableOutput(PubKeyHash []byte, amount int) (int, m
 	return accumulated, unspentOutputs
 }*/
 
+/*
+a txindex lookup, no chain scan. When the block of the tx is pruned, the unspent outputs of the tx
+are taken from the UTXO set, they are all signing and verifying need
+*/
 func (bc *BlockChain) FindPrevTransaction(ID []byte) (Transaction, error) {
-	bci := bc.Iterator()
-	for {
-		block := bci.Next()
-		for _, tx := range block.Transactions {
-			if bytes.Compare(tx.ID, ID) == 0 {
-				return *tx, nil
-			}
-		}
-		if len(block.PrevBlockHash) == 0 {
-			break
+	tx, _, err := bc.FindTransaction(ID)
+	if err != nil {
+		if unspent, ok := (UTXOSet{bc}).unspentTransaction(ID); ok {
+			return unspent, nil
 		}
+		return Transaction{}, err
+	}
+	return *tx, nil
+}
+
+func (bc *BlockChain) SignTransaction(tx *Transaction, wallet *Wallet) {
+	if !wallet.CanSign() {
+		log.Panic(errWalletLocked)
+	}
+	prevTXs := bc.findPrevTransactions(tx)
+	if wallet.IsSchnorr() {
+		tx.SignSchnorr(wallet.SchnorrKey, prevTXs, SigHashAll)
+	} else {
+		tx.Sign(wallet.PrivateKey, prevTXs, SigHashAll)
 	}
-	return Transaction{}, errors.New("transaction is not found")
 }
 
-func (bc *BlockChain) SignTransaction(tx *Transaction, privKey ecdsa.PrivateKey) {
+func (bc *BlockChain) findPrevTransactions(tx *Transaction) map[string]Transaction {
 	prevTXs := make(map[string]Transaction)
 	for _, vin := range tx.VIn {
 		prevTX, err := bc.FindPrevTransaction(vin.TXid)
@@ -412,65 +446,88 @@ func (bc *BlockChain) SignTransaction(tx *Transaction, privKey ecdsa.PrivateKey)
 		}
 		prevTXs[hex.EncodeToString(prevTX.ID)] = prevTX
 	}
-	tx.Sign(privKey, prevTXs)
+	return prevTXs
 }
 
 func (bc *BlockChain) VerifyTransaction(tx *Transaction) bool {
 	if tx.isCoinbaseTX() {
 		return true
 	}
-	prevTXs := make(map[string]Transaction)
-	for _, vin := range tx.VIn {
-		prevTX, err := bc.FindPrevTransaction(vin.TXid)
-		if err != nil {
-			log.Panic(err)
-		}
-		prevTXs[hex.EncodeToString(prevTX.ID)] = prevTX
-	}
-	return tx.Verify(prevTXs)
+	return tx.Verify(bc.findPrevTransactions(tx))
 }
 
+// hashes of the active chain from the tip down, read from the height index when it is up to date
 func (bc *BlockChain) GetBlockHashes() [][]byte {
 	var blockHashes [][]byte
-	bci := bc.Iterator()
-	for {
-		b := bci.Next()
-		blockHashes = append(blockHashes, b.Hash)
-		if len(b.PrevBlockHash) == 0 {
-			break
+	err := bc.db.View(func(tx *StorageTx) error {
+		heights, err := activeHeights(tx)
+		if err != nil {
+			return err
+		}
+		c := heights.Cursor()
+		for _, hash := c.Last(); hash != nil; _, hash = c.Prev() {
+			blockHashes = append(blockHashes, hash)
+		}
+		return nil
+	})
+	if err == nil {
+		return blockHashes
+	}
+	if err != errIndexBehind {
+		log.Panic(err)
+	}
+
+	err = bc.db.View(func(tx *StorageTx) error { // headers only, the blocks may be pruned
+		for hash := bc.tip; len(hash) > 0; {
+			header, err := tx.Blocks().Header(hash)
+			if err != nil {
+				return err
+			}
+			blockHashes = append(blockHashes, hash)
+			hash = header.PrevBlockHash
 		}
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
 	}
 	return blockHashes
 }
 
+/*
+GetBlock reads a block of the db. A pruned block has only its header left, that is errBlockPruned,
+an unknown hash errBlockNotFound; callers decide whether that is fatal
+*/
 func (bc *BlockChain) GetBlock(blockhash []byte) (block, error) {
 	var b block
-	err := bc.db.View(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket([]byte(blocksBucket))
-		blockData := bucket.Get(blockhash)
-		if blockData == nil {
-			return errors.New("Block is not found.")
+	err := bc.db.View(func(tx *StorageTx) error {
+		found, err := tx.Blocks().Get(blockhash)
+		if err != nil {
+			return err
 		}
-		b = *Deserialize(blockData)
+		b = *found
 		return nil
 	})
-	if err != nil {
-		log.Panic(err)
-	}
-	return b, nil
+	return b, err
 }
 
 func (bc *BlockChain) GetBestHeight() int {
-	var lastBlock block
-	err := bc.db.View(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket([]byte(blocksBucket))
-		lastHash := bucket.Get([]byte("l"))
-		lastBlockData := bucket.Get(lastHash)
-		lastBlock = *Deserialize(lastBlockData)
+	var lastHeight int
+	err := bc.db.View(func(tx *StorageTx) error {
+		if heights, err := activeHeights(tx); err == nil {
+			key, _ := heights.Cursor().Last()
+			lastHeight = int(binary.BigEndian.Uint32(key))
+			return nil
+		}
+		lastBlock, err := tx.Blocks().Get(tx.Blocks().Tip())
+		if err != nil {
+			return err
+		}
+		lastHeight = lastBlock.Height
 		return nil
 	})
 	if err != nil {
 		log.Panic(err)
 	}
-	return lastBlock.Height
+	return lastHeight
 }
@@ -1,14 +1,13 @@
 package main
 
 import (
-	"github.com/boltdb/bolt"
 	"log"
 )
 
 // we dont want all blocks in memory, thus we need an dedicated iterator for block chain
 type BlockChainIterator struct {
 	currentHash []byte
-	db          *bolt.DB
+	db          *Storage
 }
 
 func (chain *BlockChain) Iterator() *BlockChainIterator {
@@ -18,11 +17,10 @@ func (chain *BlockChain) Iterator() *BlockChainIterator {
 
 func (it *BlockChainIterator) Next() *block {
 	var b *block
-	err := it.db.View(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket([]byte(blocksBucket))
-		Encoded := bucket.Get(it.currentHash)
-		b = Deserialize(Encoded) // get the current (you can say "next") block
-		return nil
+	err := it.db.View(func(tx *StorageTx) error {
+		var err error
+		b, err = tx.Blocks().Get(it.currentHash) // get the current (you can say "next") block
+		return err
 	})
 	if err != nil {
 		log.Panic(err)
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+// newTestChain creates a chain in the memory engine whose genesis coinbase pays a new wallet
+func newTestChain(t *testing.T) (*BlockChain, *Wallet) {
+	t.Helper()
+	os.Setenv(dbEngineEnv, "memory")
+	w := NewWallet()
+	nodeID := strings.Replace(t.Name(), "/", "_", -1)
+	bc := CreateBlockChain(string(w.GetAddress()), nodeID)
+	t.Cleanup(func() {
+		bc.db.Close()
+		memoryDBs.Lock()
+		delete(memoryDBs.stores, fmt.Sprintf(dbFile, nodeID))
+		memoryDBs.Unlock()
+	})
+	return bc, w
+}
+
+// nextBlock mines txs on top of the tip without connecting them, like a block from a peer
+func nextBlock(bc *BlockChain, txs ...*Transaction) *block {
+	miner := NewWallet()
+	txs = append([]*Transaction{NewCoinbaseTX(string(miner.GetAddress()), "")}, txs...)
+	AddWitnessCommitment(txs)
+	return NewBlock(txs, bc.tip, bc.GetBestHeight()+1)
+}
+
+// forkBlock mines txs on top of prev without connecting them
+func forkBlock(prev *block, txs ...*Transaction) *block {
+	miner := NewWallet()
+	txs = append([]*Transaction{NewCoinbaseTX(string(miner.GetAddress()), "")}, txs...)
+	AddWitnessCommitment(txs)
+	return NewBlock(txs, prev.Hash, prev.Height+1)
+}
+
+func outputsValue(outs []TXOutput) int {
+	total := 0
+	for _, out := range outs {
+		total += out.Value
+	}
+	return total
+}
+
+func TestMineBlockSpends(t *testing.T) {
+	bc, w := newTestChain(t)
+	to := NewWallet()
+	tx := NewPaymentsTransaction(w, []Payment{{string(to.GetAddress()), 4}}, "", &UTXOSet{bc}, nil)
+	b, err := bc.MineBlock([]*Transaction{NewCoinbaseTX(string(w.GetAddress()), ""), tx})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(bc.tip, b.Hash) {
+		t.Fatal("the mined block is not the tip")
+	}
+	if balance := outputsValue((UTXOSet{bc}).FindUTXO(to.LockingKey())); balance != 4 {
+		t.Fatalf("balance %d, want 4", balance)
+	}
+}
+
+// inTempDir runs the test in a directory of its own, wallet files are written to the working directory
+func inTempDir(t *testing.T) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "wallet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.Chdir(wd)
+		os.RemoveAll(dir)
+	})
+}
@@ -0,0 +1,364 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+/*
+Blocks are kept in append-only data files next to the db instead of inside it:
+
+	<db>.blocks/blk00000.dat  blocks, one record each
+	<db>.blocks/rev00000.dat  undo data of the blocks, same record layout
+	record                    4 byte magic | uint32 length | payload
+
+A file is closed for writing once it reaches maxBlockFileSize and the next number is started.
+The db only keeps a small index entry per block, see blockIndexBucket. A record is synced to
+disk before the index entry pointing at it is committed, so a crash can leave unused bytes at the
+end of a file but never an index entry without its data.
+*/
+const maxBlockFileSize = 128 << 20
+const blockFilePrefix = "blk"
+const undoFilePrefix = "rev"
+
+var blockFileMagic = []byte{0xba, 0xb7, 0xb1, 0x0c}
+
+// where a record's payload is
+type filePos struct {
+	File   uint32
+	Offset uint32
+	Length uint32
+}
+
+type blockFiles struct {
+	sync.Mutex
+	dir     string
+	current map[string]uint32 // prefix -> number of the file being appended to
+}
+
+func blockDir(dbPath string) string {
+	return dbPath + ".blocks"
+}
+
+func openBlockFiles(dir string) (*blockFiles, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	f := &blockFiles{dir: dir, current: make(map[string]uint32)}
+	for _, prefix := range []string{blockFilePrefix, undoFilePrefix} {
+		numbers, err := f.fileNumbers(prefix)
+		if err != nil {
+			return nil, err
+		}
+		if len(numbers) > 0 {
+			f.current[prefix] = numbers[len(numbers)-1]
+		}
+	}
+	return f, nil
+}
+
+func (f *blockFiles) path(prefix string, number uint32) string {
+	return filepath.Join(f.dir, fmt.Sprintf("%s%05d.dat", prefix, number))
+}
+
+// fileNumbers lists the numbers of the existing files with prefix, in order
+func (f *blockFiles) fileNumbers(prefix string) ([]uint32, error) {
+	entries, err := ioutil.ReadDir(f.dir)
+	if err != nil {
+		return nil, err
+	}
+	var numbers []uint32
+	for _, e := range entries {
+		var n uint32
+		name := e.Name()
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		if _, err := fmt.Sscanf(name, prefix+"%05d.dat", &n); err == nil {
+			numbers = append(numbers, n)
+		}
+	}
+	sort.Slice(numbers, func(i, j int) bool { return numbers[i] < numbers[j] })
+	return numbers, nil
+}
+
+// append writes one record to the current file of prefix, or to a new one when it is full
+func (f *blockFiles) append(prefix string, payload []byte) (filePos, error) {
+	f.Lock()
+	defer f.Unlock()
+	number := f.current[prefix]
+	size := int64(0)
+	if info, err := os.Stat(f.path(prefix, number)); err == nil {
+		size = info.Size()
+	}
+	recordLen := int64(len(blockFileMagic) + 4 + len(payload))
+	if size > 0 && size+recordLen > maxBlockFileSize {
+		number++
+		size = 0
+	}
+
+	file, err := os.OpenFile(f.path(prefix, number), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return filePos{}, err
+	}
+	defer file.Close()
+	var buff bytes.Buffer
+	buff.Write(blockFileMagic)
+	writeUint32(&buff, uint32(len(payload)))
+	buff.Write(payload)
+	if _, err = file.Write(buff.Bytes()); err != nil {
+		return filePos{}, err
+	}
+	if err = file.Sync(); err != nil {
+		return filePos{}, err
+	}
+	f.current[prefix] = number
+	return filePos{number, uint32(size) + uint32(len(blockFileMagic)+4), uint32(len(payload))}, nil
+}
+
+func (f *blockFiles) read(prefix string, pos filePos) ([]byte, error) {
+	file, err := os.Open(f.path(prefix, pos.File))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	header := make([]byte, len(blockFileMagic)+4)
+	if _, err = file.ReadAt(header, int64(pos.Offset)-int64(len(header))); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(header[:len(blockFileMagic)], blockFileMagic) ||
+		binary.LittleEndian.Uint32(header[len(blockFileMagic):]) != pos.Length {
+		return nil, fmt.Errorf("%s has no record at offset %d", f.path(prefix, pos.File), pos.Offset)
+	}
+	payload := make([]byte, pos.Length)
+	if _, err = file.ReadAt(payload, int64(pos.Offset)); err != nil {
+		if err == io.EOF {
+			err = errors.New("block file is truncated")
+		}
+		return nil, err
+	}
+	return payload, nil
+}
+
+/*
+The block index bucket of a db with block files:
+
+	block hash -> block filePos | undo filePos | header
+	              the positions as 3 uint32, a length of 0 means the data is not there: no undo
+	              data, or a block pruned from the files. Entries written before pruning existed
+	              have no header, it is then read from the block
+	"l"        -> hash of the tip
+	"p"        -> uint32 height of the first block that was not pruned, see prune.go
+*/
+const blockIndexBucket = "blockindex"
+
+type blockIndexEntry struct {
+	Block  filePos
+	Undo   filePos
+	Header *blockHeader // nil in old entries
+}
+
+func (e blockIndexEntry) Serialize() []byte {
+	var buff bytes.Buffer
+	for _, pos := range []filePos{e.Block, e.Undo} {
+		writeUint32(&buff, pos.File)
+		writeUint32(&buff, pos.Offset)
+		writeUint32(&buff, pos.Length)
+	}
+	if e.Header != nil {
+		writeBlockHeader(&buff, e.Header)
+	}
+	return buff.Bytes()
+}
+
+func DeserializeBlockIndexEntry(data []byte) (blockIndexEntry, error) {
+	var e blockIndexEntry
+	r := bytes.NewReader(data)
+	for _, pos := range []*filePos{&e.Block, &e.Undo} {
+		for _, field := range []*uint32{&pos.File, &pos.Offset, &pos.Length} {
+			v, err := readUint32(r)
+			if err != nil {
+				return e, err
+			}
+			*field = v
+		}
+	}
+	if r.Len() > 0 {
+		var err error
+		if e.Header, err = readBlockHeader(r); err != nil {
+			return e, err
+		}
+	}
+	return e, checkFullyRead(r)
+}
+
+// BlockStore on block files, the index entries and the tip are in the db
+type fileBlockStore struct {
+	tx    KVTx
+	files *blockFiles
+}
+
+func (s fileBlockStore) entry(hash []byte) (blockIndexEntry, bool, error) {
+	bucket := s.tx.Bucket([]byte(blockIndexBucket))
+	if bucket == nil {
+		return blockIndexEntry{}, false, nil
+	}
+	data := bucket.Get(hash)
+	if data == nil {
+		return blockIndexEntry{}, false, nil
+	}
+	e, err := DeserializeBlockIndexEntry(data)
+	return e, err == nil, err
+}
+
+func (s fileBlockStore) putEntry(hash []byte, e blockIndexEntry) error {
+	bucket, err := s.tx.CreateBucketIfNotExists([]byte(blockIndexBucket))
+	if err != nil {
+		return err
+	}
+	return bucket.Put(hash, e.Serialize())
+}
+
+func (s fileBlockStore) Get(hash []byte) (*block, error) {
+	e, ok, err := s.entry(hash)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errBlockNotFound
+	}
+	if e.Block.Length == 0 {
+		return nil, errBlockPruned
+	}
+	data, err := s.files.read(blockFilePrefix, e.Block)
+	if err != nil {
+		return nil, err
+	}
+	return DeserializeBlock(data)
+}
+
+func (s fileBlockStore) Has(hash []byte) bool {
+	_, ok, _ := s.entry(hash)
+	return ok
+}
+
+func (s fileBlockStore) Put(b *block) error {
+	return s.putRaw(b.Hash, b.Serialize(), b.Header())
+}
+
+func (s fileBlockStore) putRaw(hash, data []byte, header *blockHeader) error {
+	if _, ok, _ := s.entry(hash); ok {
+		return nil
+	}
+	pos, err := s.files.append(blockFilePrefix, data)
+	if err != nil {
+		return err
+	}
+	return s.putEntry(hash, blockIndexEntry{Block: pos, Header: header})
+}
+
+// Header is kept in the index entry, it is there after the block is pruned
+func (s fileBlockStore) Header(hash []byte) (*blockHeader, error) {
+	e, ok, err := s.entry(hash)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errBlockNotFound
+	}
+	if e.Header != nil {
+		return e.Header, nil
+	}
+	b, err := s.Get(hash)
+	if err != nil {
+		return nil, err
+	}
+	return b.Header(), nil
+}
+
+func (s fileBlockStore) Tip() []byte {
+	bucket := s.tx.Bucket([]byte(blockIndexBucket))
+	if bucket == nil {
+		return nil
+	}
+	return bucket.Get([]byte("l"))
+}
+
+func (s fileBlockStore) SetTip(hash []byte) error {
+	bucket, err := s.tx.CreateBucketIfNotExists([]byte(blockIndexBucket))
+	if err != nil {
+		return err
+	}
+	return bucket.Put([]byte("l"), hash)
+}
+
+func (s fileBlockStore) PutUndo(hash, undo []byte) error {
+	e, ok, err := s.entry(hash)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errBlockNotFound
+	}
+	if e.Undo, err = s.files.append(undoFilePrefix, undo); err != nil {
+		return err
+	}
+	return s.putEntry(hash, e)
+}
+
+func (s fileBlockStore) GetUndo(hash []byte) ([]byte, error) {
+	e, ok, err := s.entry(hash)
+	if err != nil {
+		return nil, err
+	}
+	if !ok || e.Undo.Length == 0 {
+		return nil, errUndoNotFound
+	}
+	return s.files.read(undoFilePrefix, e.Undo)
+}
+
+/*
+importBlocksBucket moves the blocks of a db written before block files existed out of the
+blocks bucket into the files, it returns how many were moved
+*/
+func (s *Storage) importBlocksBucket() (int, error) {
+	if s.files == nil {
+		return 0, nil
+	}
+	moved := 0
+	err := s.Update(func(tx *StorageTx) error {
+		bucket := tx.kv.Bucket([]byte(blocksBucket))
+		if bucket == nil {
+			return nil
+		}
+		store := fileBlockStore{tx.kv, s.files}
+		c := bucket.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			if bytes.Equal(k, []byte("l")) {
+				if err := store.SetTip(v); err != nil {
+					return err
+				}
+				continue
+			}
+			b, err := DeserializeBlock(v)
+			if err != nil {
+				return err
+			}
+			if err = store.putRaw(k, v, b.Header()); err != nil {
+				return err
+			}
+			moved++
+		}
+		return tx.kv.DeleteBucket([]byte(blocksBucket))
+	})
+	return moved, err
+}
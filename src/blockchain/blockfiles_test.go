@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newFileTestChain creates a chain in the bbolt engine with block files, in a directory of its own
+func newFileTestChain(t *testing.T, nodeID string) (*BlockChain, *Wallet) {
+	t.Helper()
+	inTempDir(t)
+	os.Setenv(dbEngineEnv, "bbolt")
+	t.Cleanup(func() { os.Setenv(dbEngineEnv, "memory") })
+	w := NewWallet()
+	return CreateBlockChain(string(w.GetAddress()), nodeID), w
+}
+
+func TestBlockFilesAppendRead(t *testing.T) {
+	inTempDir(t)
+	files, err := openBlockFiles("test.blocks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := files.append(blockFilePrefix, []byte("first"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := files.append(blockFilePrefix, []byte("second record"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.File != 0 || second.File != 0 || second.Offset <= first.Offset {
+		t.Fatalf("the records are at %+v and %+v", first, second)
+	}
+	for pos, want := range map[filePos]string{first: "first", second: "second record"} {
+		if data, err := files.read(blockFilePrefix, pos); err != nil || string(data) != want {
+			t.Fatalf("read %q: %v", data, err)
+		}
+	}
+	if _, err := files.read(blockFilePrefix, filePos{0, second.Offset + 1, second.Length}); err == nil {
+		t.Fatal("read a record at a wrong offset")
+	}
+	if _, err := files.read(undoFilePrefix, first); err == nil {
+		t.Fatal("read from an undo file never written")
+	}
+
+	// a reopened directory appends after the records already there
+	if files, err = openBlockFiles("test.blocks"); err != nil {
+		t.Fatal(err)
+	}
+	third, err := files.append(blockFilePrefix, []byte("third"))
+	if err != nil || third.Offset <= second.Offset {
+		t.Fatalf("the third record is at %+v: %v", third, err)
+	}
+
+	if err := os.Truncate(files.path(blockFilePrefix, 0), int64(third.Offset+2)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := files.read(blockFilePrefix, third); err == nil {
+		t.Fatal("read a truncated record")
+	}
+}
+
+func TestBlockIndexEntryRoundTrip(t *testing.T) {
+	header := &blockHeader{1, []byte{2}, bytes.Repeat([]byte{3}, merkleRootLen), targetBits, 4, 5}
+	for _, e := range []blockIndexEntry{
+		{Block: filePos{1, 8, 100}},
+		{Block: filePos{1, 8, 100}, Undo: filePos{2, 16, 50}, Header: header},
+	} {
+		back, err := DeserializeBlockIndexEntry(e.Serialize())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(back.Serialize(), e.Serialize()) || back.Block != e.Block || back.Undo != e.Undo {
+			t.Fatalf("the entry came back as %+v", back)
+		}
+	}
+	if _, err := DeserializeBlockIndexEntry(make([]byte, 23)); err == nil {
+		t.Fatal("a short entry is taken")
+	}
+}
+
+func TestChainInBlockFiles(t *testing.T) {
+	bc, w := newFileTestChain(t, "files")
+	tx := NewPaymentsTransaction(w, []Payment{{string(NewWallet().GetAddress()), 4}}, "", &UTXOSet{bc}, nil)
+	mined, err := bc.MineBlock([]*Transaction{NewCoinbaseTX(string(w.GetAddress()), ""), tx})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bc.db.Close()
+	if _, err := os.Stat(filepath.Join(blockDir(fmt.Sprintf(dbFile, "files")), "blk00000.dat")); err != nil {
+		t.Fatalf("the block file is not there: %v", err)
+	}
+
+	bc = NewBlockChain("files")
+	defer bc.db.Close()
+	b, err := bc.GetBlock(bc.tip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b.Hash, mined.Hash) || !bytes.Equal(b.Serialize(), mined.Serialize()) {
+		t.Fatal("the block read back is not the one mined")
+	}
+	// the undo data of the block went to its rev file
+	err = bc.db.View(func(tx *StorageTx) error {
+		data, err := tx.Blocks().GetUndo(mined.Hash)
+		if err != nil {
+			return err
+		}
+		_, err = DeserializeBlockUndo(data)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("the undo data of the block: %v", err)
+	}
+}
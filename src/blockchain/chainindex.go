@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+)
+
+/*
+Indexes of the active chain, kept in the same db as the blocks:
+
+	txindex   txid -> block hash (varbytes) | uint32 position of the tx in the block
+	height    uint32 height, big endian so keys sort by height -> block hash
+	addrindex optional, see addrindex.go
+
+They are updated in the same storage update that moves the tip, by connecting and
+disconnecting blocks, and can always be rebuilt from the blocks with reindex.
+*/
+const txIndexBucket = "txindex"
+const heightIndexBucket = "height"
+
+var errMissingAncestor = errors.New("block ancestor is not in the database")
+var errIndexBehind = errors.New("height index is behind the chain tip")
+var errNoBlockAtHeight = errors.New("the active chain has no block at that height")
+
+func heightKey(height int) []byte {
+	key := make([]byte, 4)
+	binary.BigEndian.PutUint32(key, uint32(height))
+	return key
+}
+
+type txLocation struct {
+	BlockHash []byte
+	Position  int
+}
+
+func (loc txLocation) Serialize() []byte {
+	var buff bytes.Buffer
+	writeVarBytes(&buff, loc.BlockHash)
+	writeUint32(&buff, uint32(loc.Position))
+	return buff.Bytes()
+}
+
+func DeserializeTxLocation(data []byte) (txLocation, error) {
+	var loc txLocation
+	r := bytes.NewReader(data)
+	hash, err := readVarBytes(r)
+	if err != nil {
+		return loc, err
+	}
+	pos, err := readUint32(r)
+	if err != nil {
+		return loc, err
+	}
+	loc.BlockHash, loc.Position = hash, int(pos)
+	return loc, checkFullyRead(r)
+}
+
+// connectBlock adds b and its transactions to the indexes, b extends the active chain
+func connectBlock(tx *StorageTx, b *block) error {
+	index, err := tx.Indexes().CreateIndex(txIndexBucket)
+	if err != nil {
+		return err
+	}
+	for pos, t := range b.Transactions {
+		if err = index.Put(t.ID, txLocation{b.Hash, pos}.Serialize()); err != nil {
+			return err
+		}
+	}
+	heights, err := tx.Indexes().CreateIndex(heightIndexBucket)
+	if err != nil {
+		return err
+	}
+	if err = heights.Put(heightKey(b.Height), b.Hash); err != nil {
+		return err
+	}
+	return indexAddresses(tx, b, true) // after the txindex, a tx may spend one of the same block
+}
+
+// disconnectBlock removes the transactions of b, which is the current tip, from the indexes
+func disconnectBlock(tx *StorageTx, b *block) error {
+	if err := indexAddresses(tx, b, false); err != nil { // needs the txindex entries of b
+		return err
+	}
+	if index := tx.Indexes().Index(txIndexBucket); index != nil {
+		for _, t := range b.Transactions {
+			if err := index.Delete(t.ID); err != nil {
+				return err
+			}
+		}
+	}
+	if heights := tx.Indexes().Index(heightIndexBucket); heights != nil {
+		return heights.Delete(heightKey(b.Height))
+	}
+	return nil
+}
+
+func ancestor(tx *StorageTx, hash []byte) (*block, error) {
+	b, err := tx.Blocks().Get(hash)
+	if err == errBlockNotFound {
+		return nil, errMissingAncestor
+	}
+	return b, err
+}
+
+/*
+setTip makes newTip the head of the active chain. Both branches are walked back to the
+fork point, the old branch is disconnected from the tip down and the new one connected
+from the fork up. When a block of the new branch is not known yet (blocks of a sync
+arrive newest first) the tip still moves and errMissingAncestor is returned, the indexes
+are then rebuilt by ReindexChain once the download is complete.
+The UTXO set moves with the indexes in the same update when it is at the old tip, so a crash
+can not leave the tip and the set apart. Otherwise it is brought up later by UTXOSet.CatchUp.
+*/
+func setTip(tx *StorageTx, newTip, oldTip *block) error {
+	var connect, disconnect []*block
+	a, b := newTip, oldTip
+	var err error
+	for err == nil && a.Height > b.Height {
+		connect = append(connect, a)
+		a, err = ancestor(tx, a.PrevBlockHash)
+	}
+	for err == nil && b.Height > a.Height {
+		disconnect = append(disconnect, b)
+		b, err = ancestor(tx, b.PrevBlockHash)
+	}
+	for err == nil && !bytes.Equal(a.Hash, b.Hash) {
+		connect = append(connect, a)
+		disconnect = append(disconnect, b)
+		if a, err = ancestor(tx, a.PrevBlockHash); err == nil {
+			b, err = ancestor(tx, b.PrevBlockHash)
+		}
+	}
+
+	if e := tx.Blocks().SetTip(newTip.Hash); e != nil {
+		return e
+	}
+	if err != nil {
+		return err
+	}
+	utxoAtTip := bytes.Equal(tx.Chainstate().BestBlock(), oldTip.Hash)
+	for _, blk := range disconnect {
+		if err = disconnectBlock(tx, blk); err != nil {
+			return err
+		}
+		if utxoAtTip {
+			if err = disconnectUTXO(tx, blk); err != nil {
+				return err
+			}
+		}
+	}
+	for i := len(connect) - 1; i >= 0; i-- {
+		if err = connectBlock(tx, connect[i]); err != nil {
+			return err
+		}
+		if utxoAtTip {
+			if err = connectUTXO(tx, connect[i]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+/*
+ReindexChain rebuilds the chain indexes from the active chain, the address index only when it is enabled.
+Pruned blocks only get their height entry
+*/
+func (bc *BlockChain) ReindexChain() {
+	err := bc.db.Update(func(tx *StorageTx) error {
+		indexes := tx.Indexes()
+		names := []string{txIndexBucket, heightIndexBucket}
+		if indexes.Index(addrIndexBucket) != nil {
+			names = append(names, addrIndexBucket)
+		}
+		for _, name := range names {
+			if err := indexes.DropIndex(name); err != nil {
+				return err
+			}
+			if _, err := indexes.CreateIndex(name); err != nil {
+				return err
+			}
+		}
+		var hashes [][]byte // blocks are connected oldest first, the address index looks up spent outputs
+		var heights []int
+		for hash := bc.tip; len(hash) > 0; {
+			header, err := tx.Blocks().Header(hash)
+			if err != nil {
+				return err
+			}
+			hashes = append(hashes, hash)
+			heights = append(heights, header.Height)
+			hash = header.PrevBlockHash
+		}
+		heightIndex := tx.Indexes().Index(heightIndexBucket)
+		for i := len(hashes) - 1; i >= 0; i-- {
+			b, err := tx.Blocks().Get(hashes[i])
+			if err == errBlockPruned { // only its height, its transactions are not in the txindex any more
+				if err = heightIndex.Put(heightKey(heights[i]), hashes[i]); err != nil {
+					return err
+				}
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if err = connectBlock(tx, b); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+	bc.indexesBehind = false
+}
+
+func (bc *BlockChain) hasChainIndex() bool {
+	found := false
+	err := bc.db.View(func(tx *StorageTx) error {
+		found = tx.Indexes().Index(txIndexBucket) != nil && tx.Indexes().Index(heightIndexBucket) != nil
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+	return found
+}
+
+func findTransactionInTx(tx *StorageTx, ID []byte) (*Transaction, *block, error) {
+	index := tx.Indexes().Index(txIndexBucket)
+	if index == nil {
+		return nil, nil, errors.New("transaction index is missing, run reindex")
+	}
+	data := index.Get(ID)
+	if data == nil {
+		return nil, nil, fmt.Errorf("transaction %x is not found", ID)
+	}
+	loc, err := DeserializeTxLocation(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	b, err := tx.Blocks().Get(loc.BlockHash)
+	if err != nil {
+		return nil, nil, err
+	}
+	if loc.Position >= len(b.Transactions) {
+		return nil, nil, fmt.Errorf("transaction index points past the end of block %x", loc.BlockHash)
+	}
+	return b.Transactions[loc.Position], b, nil
+}
+
+// FindTransaction looks a tx of the active chain up in the txindex
+func (bc *BlockChain) FindTransaction(ID []byte) (*Transaction, *block, error) {
+	var t *Transaction
+	var b *block
+	err := bc.db.View(func(tx *StorageTx) error {
+		var err error
+		t, b, err = findTransactionInTx(tx, ID)
+		return err
+	})
+	return t, b, err
+}
+
+/*
+activeHeights returns the height index when it describes the current tip. During a sync the tip
+moves before the blocks below it arrive, the index is then behind until it is rebuilt
+*/
+func activeHeights(tx *StorageTx) (KVBucket, error) {
+	heights := tx.Indexes().Index(heightIndexBucket)
+	if heights == nil {
+		return nil, errIndexBehind
+	}
+	_, top := heights.Cursor().Last()
+	if !bytes.Equal(top, tx.Blocks().Tip()) {
+		return nil, errIndexBehind
+	}
+	return heights, nil
+}
+
+// GetBlockByHeight returns the block of the active chain at height
+func (bc *BlockChain) GetBlockByHeight(height int) (*block, error) {
+	var b *block
+	err := bc.db.View(func(tx *StorageTx) error {
+		if height < 0 {
+			return fmt.Errorf("block height %d is negative", height)
+		}
+		heights, err := activeHeights(tx)
+		if err != nil {
+			return err
+		}
+		hash := heights.Get(heightKey(height))
+		if hash == nil {
+			return errNoBlockAtHeight
+		}
+		b, err = tx.Blocks().Get(hash)
+		return err
+	})
+	return b, err
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+// reorgChain mines a payment on top of the genesis block, then reorgs to a longer branch without it
+func reorgChain(t *testing.T) (*BlockChain, *Transaction, []*block) {
+	t.Helper()
+	bc, w := newTestChain(t)
+	genesis, err := bc.GetBlock(bc.tip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := NewPaymentsTransaction(w, []Payment{{string(NewWallet().GetAddress()), 4}}, "", &UTXOSet{bc}, nil)
+	a1, err := bc.MineBlock([]*Transaction{NewCoinbaseTX(string(w.GetAddress()), ""), tx})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, b, err := bc.FindTransaction(tx.ID); err != nil || !bytes.Equal(b.Hash, a1.Hash) {
+		t.Fatalf("the mined tx is not indexed: %v", err)
+	}
+	b1 := forkBlock(&genesis)
+	b2 := forkBlock(b1)
+	bc.AddBlock(b1)
+	bc.AddBlock(b2)
+	return bc, tx, []*block{&genesis, b1, b2}
+}
+
+func TestTxIndexFollowsReorg(t *testing.T) {
+	bc, tx, branch := reorgChain(t)
+	if _, _, err := bc.FindTransaction(tx.ID); err == nil {
+		t.Fatal("a tx of the old branch is still indexed")
+	}
+	for _, want := range branch {
+		if _, b, err := bc.FindTransaction(want.Transactions[0].ID); err != nil || !bytes.Equal(b.Hash, want.Hash) {
+			t.Fatalf("the coinbase of block %d is not indexed: %v", want.Height, err)
+		}
+	}
+}
+
+func TestHeightIndexFollowsReorg(t *testing.T) {
+	bc, _, branch := reorgChain(t)
+	for height, want := range branch {
+		b, err := bc.GetBlockByHeight(height)
+		if err != nil || !bytes.Equal(b.Hash, want.Hash) {
+			t.Fatalf("height %d is not the block of the new branch: %v", height, err)
+		}
+	}
+	if _, err := bc.GetBlockByHeight(len(branch)); err != errNoBlockAtHeight {
+		t.Fatalf("a height above the tip: %v", err)
+	}
+	if bc.indexesBehind {
+		t.Fatal("a reorg over known blocks left the indexes behind")
+	}
+}
+
+func TestIndexesAfterNewestFirstSync(t *testing.T) {
+	bc, _ := newTestChain(t)
+	tip, err := bc.GetBlock(bc.tip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b1 := forkBlock(&tip)
+	b2 := forkBlock(b1)
+	b3 := forkBlock(b2)
+
+	// blocks of a sync arrive newest first
+	bc.AddBlock(b3)
+	if !bc.indexesBehind {
+		t.Fatal("the tip moved over unknown blocks and the indexes are not behind")
+	}
+	if _, err := bc.GetBlockByHeight(3); err != errIndexBehind {
+		t.Fatalf("looked up a height of an index behind the tip: %v", err)
+	}
+	bc.AddBlock(b2)
+	bc.AddBlock(b1)
+	bc.ReindexChain()
+	if bc.indexesBehind {
+		t.Fatal("the indexes are behind after a reindex")
+	}
+	for height, want := range []*block{b1, b2, b3} {
+		b, err := bc.GetBlockByHeight(height + 1)
+		if err != nil || !bytes.Equal(b.Hash, want.Hash) {
+			t.Fatalf("height %d: %v", height+1, err)
+		}
+	}
+
+	// relayed blocks on top of the tip are indexed as they come
+	b4 := forkBlock(b3)
+	bc.AddBlock(b4)
+	if bc.indexesBehind {
+		t.Fatal("a block on top of the tip left the indexes behind")
+	}
+	if b, err := bc.GetBlockByHeight(4); err != nil || !bytes.Equal(b.Hash, b4.Hash) {
+		t.Fatalf("the relayed block is not indexed: %v", err)
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"runtime"
+	"strings"
 )
 
 // we want to manipulate the cmd
@@ -92,9 +94,39 @@ func (cli *CLI) printUsage() {
 	fmt.Println("  getbalance -address ADDRESS - Get balance of ADDRESS")
 	fmt.Println("  createblockchain -address ADDRESS - Create a blockchain and send genesis block reward to ADDRESS")
 	fmt.Println("  printchain - Print all the blocks of the blockchain")
+	fmt.Println("  history -address ADDRESS -page PAGE -pagesize N - List the transactions of ADDRESS, newest first. Needs the address index")
+	fmt.Println("  reindex -addrindex - Rebuild the UTXO set and the chain indexes. Enable the address index when -addrindex is set")
+	fmt.Println("  getblock -height HEIGHT | -hash HASH - Print the block at HEIGHT of the chain, or the block with HASH")
+	fmt.Println("  createwallet -schnorr -mnemonic -passphrase PASS -walletpassphrase WPASS -bech32 - Generate a new key pair and save it into the wallet file. A secp256k1 Schnorr key when -schnorr is set. -mnemonic starts an HD wallet and prints its mnemonic, the next addresses then come from it. WPASS unlocks an encrypted wallet. -bech32 prints the address in Bech32 form")
+	fmt.Println("  vanity -prefix PREFIX -ignorecase -schnorr -workers N -walletpassphrase WPASS - Search keys until the address starts with PREFIX and save it into the wallet file. Prints the difficulty and the expected time first")
+	fmt.Println("  listaddress -bech32 - List the addresses of the wallet, with their Bech32 form when -bech32 is set")
+	fmt.Println("  restorewallet -mnemonic WORDS -passphrase PASS -walletpassphrase WPASS - Restore the HD wallet of WORDS and find its used addresses in the chain")
+	fmt.Println("  getxpub - Print the extended public key of the HD wallet, for importaddress -xpub on a watching node")
+	fmt.Println("  importaddress -address ADDRESS | -xpub XPUB - Watch ADDRESS, or the used addresses of XPUB, without their keys")
+	fmt.Println("  dumpprivkey -address ADDRESS -passphrase PASS - Print the private key of ADDRESS in text form")
+	fmt.Println("  importprivkey -key KEY -passphrase PASS -rescan - Add a private key from dumpprivkey to the wallet. -rescan finds its past transactions in the chain")
+	fmt.Println("  dumpwallet -file FILE -passphrase PASS - Write all private keys of the wallet to FILE")
+	fmt.Println("  importwallet -file FILE -passphrase PASS -rescan - Add the private keys of a dumpwallet FILE to the wallet. -rescan finds their past transactions in the chain")
+	fmt.Println("  signtx -file FILE -passphrase PASS - Sign the unsigned tx in FILE with the keys of the wallet")
+	fmt.Println("  sendtx -file FILE -miner ADDRESS - Send the signed tx in FILE to the network, or mine it here with the reward to ADDRESS")
+	fmt.Println("  encryptwallet -passphrase PASS - Encrypt the private keys of the wallet file with PASS")
+	fmt.Println("  walletpassphrase -passphrase PASS -timeout SECONDS - Unlock the wallet of the running node for SECONDS, 0 locks it")
+	fmt.Println("  changepassphrase -old OLD -new NEW - Change the passphrase of an encrypted wallet")
+	fmt.Println("  signmessage -address ADDRESS -message MESSAGE -passphrase PASS - Sign MESSAGE with the key of ADDRESS in the wallet. PASS unlocks an encrypted wallet")
+	fmt.Println("  verifymessage -address ADDRESS -signature SIG -message MESSAGE - Check that SIG was made for MESSAGE by the key of ADDRESS")
+	fmt.Println("  aggregatekeys -addresses ADDR1,ADDR2,... -watch - Aggregate the keys of Schnorr addresses into one MuSig address. -watch follows it in the wallet so that it can be spent from")
 	//fmt.Println("  send -from FROM -to TO -amount AMOUNT - Send AMOUNT of coins from FROM address to TO")
-	fmt.Println("  send -from FROM -to TO -amount AMOUNT -mine - Send AMOUNT of coins from FROM address to TO. Mine on the same node, when -mine is set.")
-	fmt.Println("  startnode -miner ADDRESS - Start a node with ID specified in NODE_ID env. var. -miner enables mining")
+	fmt.Println("  send -from FROM -to TO -amount AMOUNT -mine -passphrase PASS -unsigned FILE -strategy S -inputs TXID:VOUT,... - Send AMOUNT of coins from FROM address to TO. Mine on the same node, when -mine is set. PASS unlocks an encrypted wallet, a running node uses its own. From a watch-only address the unsigned tx is written to FILE. S picks the outputs to spend: bnb (default), largest, oldest or random. -inputs spends exactly the given outputs")
+	fmt.Println("  sendmany -from FROM -to ADDR:AMOUNT,... | -file FILE -mine -passphrase PASS -unsigned FILE -strategy S -inputs TXID:VOUT,... - Pay every address of the list in one tx from FROM, with one change output. FILE is CSV (ADDRESS,AMOUNT lines) or JSON. The other flags are the ones of send")
+	fmt.Println("  listtransactions -count N - List the last N transactions of the wallet, pending ones first")
+	fmt.Println("  listunspent -address ADDRESS - List the unspent outputs of the wallet, or of ADDRESS")
+	fmt.Println("  freeze -outpoints TXID:VOUT,... -unfreeze - Keep outputs of the wallet from being spent, or let them be spent again when -unfreeze is set")
+	fmt.Println("  migratedb -resign -wallets NODE_IDS -passphrase PASS - Convert a gob encoded blockchain database of NODE_ID to the canonical encoding. Sign inputs again with the node wallet and the wallets of the comma separated NODE_IDS when -resign is set")
+	fmt.Println("  verifychain -depth N -level L -repair - Check the last N blocks (0 for all) at level L (0-4) and the UTXO set. Fix what is found when -repair is set")
+	fmt.Println("  dumputxo -file FILE - Write the UTXO set with its commitment to a snapshot FILE")
+	fmt.Println("  loadutxo -file FILE - Load a UTXO snapshot FILE into a node that has not synced up to its block yet")
+	fmt.Println("  startnode -miner ADDRESS -snapshot FILE -prune MB - Start a node with ID specified in NODE_ID env. var. -miner enables mining. -snapshot loads a UTXO snapshot first. -prune deletes old blocks to keep block files under MB")
+	fmt.Println("Addresses are taken in Base58 or Bech32 form. The NETWORK env. var (main, test or regtest) sets the Bech32 prefix, main by default")
 }
 
 func (cli *CLI) validateArgs() {
@@ -112,14 +144,44 @@ func (cli *CLI) Run() {
 		fmt.Printf("NODE_ID env. var is not set!")
 		os.Exit(1)
 	}
+	if _, err := networkHRP(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
 	createBlockchainCmd := flag.NewFlagSet("createblockchain", flag.ExitOnError)
 	getBalanceCmd := flag.NewFlagSet("getbalance", flag.ExitOnError)
 	sendCmd := flag.NewFlagSet("send", flag.ExitOnError)
+	sendManyCmd := flag.NewFlagSet("sendmany", flag.ExitOnError)
+	listUnspentCmd := flag.NewFlagSet("listunspent", flag.ExitOnError)
+	listTransactionsCmd := flag.NewFlagSet("listtransactions", flag.ExitOnError)
+	freezeCmd := flag.NewFlagSet("freeze", flag.ExitOnError)
 	printchainCmd := flag.NewFlagSet("printchain", flag.ExitOnError)
 	createWalletCmd := flag.NewFlagSet("createwallet", flag.ExitOnError)
 	listAddressCmd := flag.NewFlagSet("listaddress", flag.ExitOnError)
+	restoreWalletCmd := flag.NewFlagSet("restorewallet", flag.ExitOnError)
+	encryptWalletCmd := flag.NewFlagSet("encryptwallet", flag.ExitOnError)
+	getXPubCmd := flag.NewFlagSet("getxpub", flag.ExitOnError)
+	importAddressCmd := flag.NewFlagSet("importaddress", flag.ExitOnError)
+	signTxCmd := flag.NewFlagSet("signtx", flag.ExitOnError)
+	dumpPrivKeyCmd := flag.NewFlagSet("dumpprivkey", flag.ExitOnError)
+	importPrivKeyCmd := flag.NewFlagSet("importprivkey", flag.ExitOnError)
+	dumpWalletCmd := flag.NewFlagSet("dumpwallet", flag.ExitOnError)
+	importWalletCmd := flag.NewFlagSet("importwallet", flag.ExitOnError)
+	sendTxCmd := flag.NewFlagSet("sendtx", flag.ExitOnError)
+	walletPassphraseCmd := flag.NewFlagSet("walletpassphrase", flag.ExitOnError)
+	changePassphraseCmd := flag.NewFlagSet("changepassphrase", flag.ExitOnError)
 	reindexCmd := flag.NewFlagSet("reindex", flag.ExitOnError)
 	startNodeCmd := flag.NewFlagSet("startnode", flag.ExitOnError)
+	migrateDBCmd := flag.NewFlagSet("migratedb", flag.ExitOnError)
+	aggregateKeysCmd := flag.NewFlagSet("aggregatekeys", flag.ExitOnError)
+	signMessageCmd := flag.NewFlagSet("signmessage", flag.ExitOnError)
+	vanityCmd := flag.NewFlagSet("vanity", flag.ExitOnError)
+	verifyMessageCmd := flag.NewFlagSet("verifymessage", flag.ExitOnError)
+	getBlockCmd := flag.NewFlagSet("getblock", flag.ExitOnError)
+	historyCmd := flag.NewFlagSet("history", flag.ExitOnError)
+	dumpUTXOCmd := flag.NewFlagSet("dumputxo", flag.ExitOnError)
+	verifyChainCmd := flag.NewFlagSet("verifychain", flag.ExitOnError)
+	loadUTXOCmd := flag.NewFlagSet("loadutxo", flag.ExitOnError)
 
 	createBlockchainAddr := createBlockchainCmd.String("address", "", "The address to send genesis block reward to")
 	getBalanceValue := getBalanceCmd.String("address", "", "The address to get balance for")
@@ -127,7 +189,82 @@ func (cli *CLI) Run() {
 	sendTo := sendCmd.String("to", "", "Destination wallet address")
 	sendAmount := sendCmd.Int("amount", 0, "Amount to send")
 	sendMine := sendCmd.Bool("mine", false, "Mine immediately on the same node")
+	sendPassphrase := sendCmd.String("passphrase", "", "Passphrase of an encrypted wallet")
+	sendUnsigned := sendCmd.String("unsigned", "", "File to write the unsigned tx of a watch-only address to")
+	sendStrategy := sendCmd.String("strategy", "", "Coin selection: bnb, largest, oldest or random")
+	sendInputs := sendCmd.String("inputs", "", "Comma separated outputs (txid:vout) to spend, instead of selecting them")
+	sendManyFrom := sendManyCmd.String("from", "", "Source wallet address")
+	sendManyTo := sendManyCmd.String("to", "", "Comma separated ADDRESS:AMOUNT pairs")
+	sendManyFile := sendManyCmd.String("file", "", "CSV or JSON file with the payments")
+	sendManyMine := sendManyCmd.Bool("mine", false, "Mine immediately on the same node")
+	sendManyPassphrase := sendManyCmd.String("passphrase", "", "Passphrase of an encrypted wallet")
+	sendManyUnsigned := sendManyCmd.String("unsigned", "", "File to write the unsigned tx of a watch-only address to")
+	sendManyStrategy := sendManyCmd.String("strategy", "", "Coin selection: bnb, largest, oldest or random")
+	sendManyInputs := sendManyCmd.String("inputs", "", "Comma separated outputs (txid:vout) to spend, instead of selecting them")
+	listTransactionsCount := listTransactionsCmd.Int("count", 10, "Number of transactions to list")
+	listUnspentAddress := listUnspentCmd.String("address", "", "Only list the outputs of ADDRESS")
+	freezeOutpoints := freezeCmd.String("outpoints", "", "Comma separated outputs (txid:vout)")
+	freezeUnfreeze := freezeCmd.Bool("unfreeze", false, "Let the outputs be spent again")
+	importAddressAddress := importAddressCmd.String("address", "", "Address to watch")
+	importAddressXPub := importAddressCmd.String("xpub", "", "Extended public key to watch the addresses of")
+	signTxFile := signTxCmd.String("file", "", "Unsigned tx file")
+	dumpPrivKeyAddress := dumpPrivKeyCmd.String("address", "", "Address whose key to print")
+	dumpPrivKeyPassphrase := dumpPrivKeyCmd.String("passphrase", "", "Passphrase of an encrypted wallet")
+	importPrivKeyKey := importPrivKeyCmd.String("key", "", "Private key printed by dumpprivkey")
+	importPrivKeyPassphrase := importPrivKeyCmd.String("passphrase", "", "Passphrase of an encrypted wallet")
+	importPrivKeyRescan := importPrivKeyCmd.Bool("rescan", false, "Look for the transactions of the key in the whole chain")
+	dumpWalletFile := dumpWalletCmd.String("file", "", "File to write the keys to")
+	dumpWalletPassphrase := dumpWalletCmd.String("passphrase", "", "Passphrase of an encrypted wallet")
+	importWalletFile := importWalletCmd.String("file", "", "File written by dumpwallet")
+	importWalletPassphrase := importWalletCmd.String("passphrase", "", "Passphrase of an encrypted wallet")
+	importWalletRescan := importWalletCmd.Bool("rescan", false, "Look for the transactions of the keys in the whole chain")
+	signTxPassphrase := signTxCmd.String("passphrase", "", "Passphrase of an encrypted wallet")
+	sendTxFile := sendTxCmd.String("file", "", "Signed tx file")
+	sendTxMiner := sendTxCmd.String("miner", "", "Mine the tx on this node and send the reward to ADDRESS")
+	createWalletSchnorr := createWalletCmd.Bool("schnorr", false, "Create a Schnorr (secp256k1) key instead of an ECDSA one")
+	createWalletBech32 := createWalletCmd.Bool("bech32", false, "Print the new address in Bech32 form")
+	listAddressBech32 := listAddressCmd.Bool("bech32", false, "Print the Bech32 form of each address too")
+	createWalletMnemonic := createWalletCmd.Bool("mnemonic", false, "Start an HD wallet from a new mnemonic")
+	createWalletPassphrase := createWalletCmd.String("passphrase", "", "Optional passphrase of the mnemonic")
+	restoreWalletMnemonic := restoreWalletCmd.String("mnemonic", "", "The words of the mnemonic")
+	restoreWalletPassphrase := restoreWalletCmd.String("passphrase", "", "Passphrase of the mnemonic, if it has one")
+	createWalletWalletPassphrase := createWalletCmd.String("walletpassphrase", "", "Passphrase of an encrypted wallet file")
+	restoreWalletWalletPassphrase := restoreWalletCmd.String("walletpassphrase", "", "Passphrase of an encrypted wallet file")
+	encryptWalletPassphrase := encryptWalletCmd.String("passphrase", "", "New passphrase of the wallet")
+	walletPassphrasePassphrase := walletPassphraseCmd.String("passphrase", "", "Passphrase of the wallet")
+	walletPassphraseTimeout := walletPassphraseCmd.Int("timeout", 60, "Seconds the wallet stays unlocked, 0 locks it")
+	changePassphraseOld := changePassphraseCmd.String("old", "", "Current passphrase")
+	changePassphraseNew := changePassphraseCmd.String("new", "", "New passphrase")
+	aggregateKeysAddrs := aggregateKeysCmd.String("addresses", "", "Comma separated Schnorr addresses")
+	aggregateKeysWatch := aggregateKeysCmd.Bool("watch", false, "Watch the aggregate address with the signer keys")
+	vanityPrefix := vanityCmd.String("prefix", "", "Base58 prefix of the address, starting with 1, or 3 for -schnorr")
+	vanityIgnoreCase := vanityCmd.Bool("ignorecase", false, "Match the prefix in any case")
+	vanitySchnorr := vanityCmd.Bool("schnorr", false, "Search Schnorr (secp256k1) keys instead of ECDSA ones")
+	vanityWorkers := vanityCmd.Int("workers", runtime.NumCPU(), "Number of keys searched in parallel")
+	vanityWalletPassphrase := vanityCmd.String("walletpassphrase", "", "Passphrase of an encrypted wallet file")
+	signMessageAddress := signMessageCmd.String("address", "", "Address whose key signs")
+	signMessageMessage := signMessageCmd.String("message", "", "Message to sign")
+	signMessagePassphrase := signMessageCmd.String("passphrase", "", "Passphrase of an encrypted wallet")
+	verifyMessageAddress := verifyMessageCmd.String("address", "", "Address that signed")
+	verifyMessageSignature := verifyMessageCmd.String("signature", "", "Signature printed by signmessage")
+	verifyMessageMessage := verifyMessageCmd.String("message", "", "Message that was signed")
+	migrateResign := migrateDBCmd.Bool("resign", false, "Sign inputs again with the keys in the node wallet")
+	migrateWallets := migrateDBCmd.String("wallets", "", "Node IDs of more wallets with keys to sign with, comma separated")
+	migratePassphrase := migrateDBCmd.String("passphrase", "", "Passphrase of an encrypted wallet")
+	reindexAddrIndex := reindexCmd.Bool("addrindex", false, "Enable and build the address index")
+	historyAddress := historyCmd.String("address", "", "The address to list transactions for")
+	historyPage := historyCmd.Int("page", 1, "Page to show, 1 is the newest")
+	historyPageSize := historyCmd.Int("pagesize", 10, "Transactions per page")
+	getBlockHeight := getBlockCmd.Int("height", -1, "Height of the block in the chain")
+	getBlockHash := getBlockCmd.String("hash", "", "Hash of the block")
+	dumpUTXOFile := dumpUTXOCmd.String("file", "", "Snapshot file to write")
+	verifyChainDepth := verifyChainCmd.Int("depth", defaultCheckDepth, "Number of blocks to check from the tip, 0 for all")
+	verifyChainLevel := verifyChainCmd.Int("level", defaultCheckLevel, "How thorough the checks are, 0 to 4")
+	verifyChainRepair := verifyChainCmd.Bool("repair", false, "Fix the problems that are found")
+	loadUTXOFile := loadUTXOCmd.String("file", "", "Snapshot file to load")
 	startNodeMinder := startNodeCmd.String("miner", "", "Enable mining mode and send reward to ADDRESS")
+	startNodeSnapshot := startNodeCmd.String("snapshot", "", "Load the UTXO snapshot FILE before starting")
+	startNodePrune := startNodeCmd.Int("prune", 0, "Delete old blocks to keep the block files under MB, 0 keeps every block")
 
 	switch os.Args[1] {
 	case "createblockchain":
@@ -145,6 +282,26 @@ func (cli *CLI) Run() {
 		if err != nil {
 			log.Panic(err)
 		}
+	case "sendmany":
+		err := sendManyCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "listtransactions":
+		err := listTransactionsCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "listunspent":
+		err := listUnspentCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "freeze":
+		err := freezeCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
 	case "printchain":
 		err := printchainCmd.Parse(os.Args[2:])
 		if err != nil {
@@ -155,6 +312,66 @@ func (cli *CLI) Run() {
 		if err != nil {
 			log.Panic(err)
 		}
+	case "restorewallet":
+		err := restoreWalletCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "getxpub":
+		err := getXPubCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "importaddress":
+		err := importAddressCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "signtx":
+		err := signTxCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "dumpprivkey":
+		err := dumpPrivKeyCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "importprivkey":
+		err := importPrivKeyCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "dumpwallet":
+		err := dumpWalletCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "importwallet":
+		err := importWalletCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "sendtx":
+		err := sendTxCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "encryptwallet":
+		err := encryptWalletCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "walletpassphrase":
+		err := walletPassphraseCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "changepassphrase":
+		err := changePassphraseCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
 	case "listaddress":
 		err := listAddressCmd.Parse(os.Args[2:])
 		if err != nil {
@@ -170,6 +387,56 @@ func (cli *CLI) Run() {
 		if err != nil {
 			log.Panic(err)
 		}
+	case "aggregatekeys":
+		err := aggregateKeysCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "vanity":
+		err := vanityCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "signmessage":
+		err := signMessageCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "verifymessage":
+		err := verifyMessageCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "getblock":
+		err := getBlockCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "history":
+		err := historyCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "migratedb":
+		err := migrateDBCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "verifychain":
+		err := verifyChainCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "dumputxo":
+		err := dumpUTXOCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "loadutxo":
+		err := loadUTXOCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
 	default:
 		cli.printUsage()
 		os.Exit(1)
@@ -194,19 +461,193 @@ func (cli *CLI) Run() {
 			sendCmd.Usage()
 			os.Exit(1)
 		}
-		cli.send(*sendFrom, *sendTo, *sendAmount, nodeID, *sendMine)
+		cli.send(*sendFrom, *sendTo, *sendAmount, nodeID, *sendMine, *sendPassphrase, *sendUnsigned, *sendStrategy, *sendInputs)
+	}
+	if sendManyCmd.Parsed() {
+		if *sendManyFrom == "" || (*sendManyTo == "") == (*sendManyFile == "") {
+			sendManyCmd.Usage()
+			os.Exit(1)
+		}
+		cli.sendMany(*sendManyFrom, *sendManyTo, *sendManyFile, nodeID, *sendManyMine, *sendManyPassphrase, *sendManyUnsigned, *sendManyStrategy, *sendManyInputs)
+	}
+	if listTransactionsCmd.Parsed() {
+		if *listTransactionsCount < 1 {
+			listTransactionsCmd.Usage()
+			os.Exit(1)
+		}
+		cli.listTransactions(nodeID, *listTransactionsCount)
+	}
+	if listUnspentCmd.Parsed() {
+		cli.listUnspent(nodeID, *listUnspentAddress)
+	}
+	if freezeCmd.Parsed() {
+		if *freezeOutpoints == "" {
+			freezeCmd.Usage()
+			os.Exit(1)
+		}
+		cli.freeze(nodeID, *freezeOutpoints, *freezeUnfreeze)
 	}
 	if printchainCmd.Parsed() {
 		cli.printChain(nodeID)
 	}
 	if createWalletCmd.Parsed() {
-		cli.createWallet(nodeID)
+		if *createWalletSchnorr && *createWalletMnemonic {
+			createWalletCmd.Usage()
+			os.Exit(1)
+		}
+		cli.createWallet(nodeID, *createWalletSchnorr, *createWalletMnemonic, *createWalletPassphrase, *createWalletWalletPassphrase, *createWalletBech32)
+	}
+	if restoreWalletCmd.Parsed() {
+		if *restoreWalletMnemonic == "" {
+			restoreWalletCmd.Usage()
+			os.Exit(1)
+		}
+		cli.restoreWallet(nodeID, *restoreWalletMnemonic, *restoreWalletPassphrase, *restoreWalletWalletPassphrase)
+	}
+	if getXPubCmd.Parsed() {
+		cli.getXPub(nodeID)
+	}
+	if importAddressCmd.Parsed() {
+		if *importAddressAddress == "" && *importAddressXPub == "" {
+			importAddressCmd.Usage()
+			os.Exit(1)
+		}
+		cli.importAddress(nodeID, *importAddressAddress, *importAddressXPub)
+	}
+	if signTxCmd.Parsed() {
+		if *signTxFile == "" {
+			signTxCmd.Usage()
+			os.Exit(1)
+		}
+		cli.signTx(nodeID, *signTxFile, *signTxPassphrase)
+	}
+	if dumpPrivKeyCmd.Parsed() {
+		if *dumpPrivKeyAddress == "" {
+			dumpPrivKeyCmd.Usage()
+			os.Exit(1)
+		}
+		cli.dumpPrivKey(nodeID, *dumpPrivKeyAddress, *dumpPrivKeyPassphrase)
+	}
+	if importPrivKeyCmd.Parsed() {
+		if *importPrivKeyKey == "" {
+			importPrivKeyCmd.Usage()
+			os.Exit(1)
+		}
+		cli.importPrivKey(nodeID, *importPrivKeyKey, *importPrivKeyPassphrase, *importPrivKeyRescan)
+	}
+	if dumpWalletCmd.Parsed() {
+		if *dumpWalletFile == "" {
+			dumpWalletCmd.Usage()
+			os.Exit(1)
+		}
+		cli.dumpWallet(nodeID, *dumpWalletFile, *dumpWalletPassphrase)
+	}
+	if importWalletCmd.Parsed() {
+		if *importWalletFile == "" {
+			importWalletCmd.Usage()
+			os.Exit(1)
+		}
+		cli.importWallet(nodeID, *importWalletFile, *importWalletPassphrase, *importWalletRescan)
+	}
+	if sendTxCmd.Parsed() {
+		if *sendTxFile == "" {
+			sendTxCmd.Usage()
+			os.Exit(1)
+		}
+		cli.sendSignedTx(nodeID, *sendTxFile, *sendTxMiner)
+	}
+	if encryptWalletCmd.Parsed() {
+		if *encryptWalletPassphrase == "" {
+			encryptWalletCmd.Usage()
+			os.Exit(1)
+		}
+		cli.encryptWallet(nodeID, *encryptWalletPassphrase)
+	}
+	if walletPassphraseCmd.Parsed() {
+		if *walletPassphraseTimeout < 0 || (*walletPassphraseTimeout > 0 && *walletPassphrasePassphrase == "") {
+			walletPassphraseCmd.Usage()
+			os.Exit(1)
+		}
+		cli.walletPassphrase(nodeID, *walletPassphrasePassphrase, *walletPassphraseTimeout)
+	}
+	if changePassphraseCmd.Parsed() {
+		if *changePassphraseOld == "" || *changePassphraseNew == "" {
+			changePassphraseCmd.Usage()
+			os.Exit(1)
+		}
+		cli.changePassphrase(nodeID, *changePassphraseOld, *changePassphraseNew)
 	}
 	if listAddressCmd.Parsed() {
-		cli.listAddress(nodeID)
+		cli.listAddress(nodeID, *listAddressBech32)
 	}
 	if reindexCmd.Parsed() {
-		cli.reindex(nodeID)
+		cli.reindex(nodeID, *reindexAddrIndex)
+	}
+	if aggregateKeysCmd.Parsed() {
+		if *aggregateKeysAddrs == "" {
+			aggregateKeysCmd.Usage()
+			os.Exit(1)
+		}
+		cli.aggregateKeys(nodeID, strings.Split(*aggregateKeysAddrs, ","), *aggregateKeysWatch)
+	}
+	if vanityCmd.Parsed() {
+		if *vanityPrefix == "" || *vanityWorkers < 1 {
+			vanityCmd.Usage()
+			os.Exit(1)
+		}
+		cli.vanity(nodeID, *vanityPrefix, *vanityIgnoreCase, *vanitySchnorr, *vanityWorkers, *vanityWalletPassphrase)
+	}
+	if signMessageCmd.Parsed() {
+		if *signMessageAddress == "" {
+			signMessageCmd.Usage()
+			os.Exit(1)
+		}
+		cli.signMessage(nodeID, *signMessageAddress, *signMessageMessage, *signMessagePassphrase)
+	}
+	if verifyMessageCmd.Parsed() {
+		if *verifyMessageAddress == "" || *verifyMessageSignature == "" {
+			verifyMessageCmd.Usage()
+			os.Exit(1)
+		}
+		cli.verifyMessage(*verifyMessageAddress, *verifyMessageSignature, *verifyMessageMessage)
+	}
+	if historyCmd.Parsed() {
+		if *historyAddress == "" || *historyPage < 1 || *historyPageSize < 1 {
+			historyCmd.Usage()
+			os.Exit(1)
+		}
+		cli.history(*historyAddress, *historyPage, *historyPageSize, nodeID)
+	}
+	if getBlockCmd.Parsed() {
+		if (*getBlockHeight < 0) == (*getBlockHash == "") { // exactly one of them
+			getBlockCmd.Usage()
+			os.Exit(1)
+		}
+		cli.getBlock(nodeID, *getBlockHeight, *getBlockHash)
+	}
+	if migrateDBCmd.Parsed() {
+		cli.migrateDB(nodeID, *migrateResign, *migrateWallets, *migratePassphrase)
+	}
+	if verifyChainCmd.Parsed() {
+		if *verifyChainDepth < 0 || *verifyChainLevel < 0 || *verifyChainLevel > maxCheckLevel {
+			verifyChainCmd.Usage()
+			os.Exit(1)
+		}
+		cli.verifyChain(nodeID, *verifyChainDepth, *verifyChainLevel, *verifyChainRepair)
+	}
+	if dumpUTXOCmd.Parsed() {
+		if *dumpUTXOFile == "" {
+			dumpUTXOCmd.Usage()
+			os.Exit(1)
+		}
+		cli.dumpUTXO(nodeID, *dumpUTXOFile)
+	}
+	if loadUTXOCmd.Parsed() {
+		if *loadUTXOFile == "" {
+			loadUTXOCmd.Usage()
+			os.Exit(1)
+		}
+		cli.loadUTXO(nodeID, *loadUTXOFile)
 	}
 	if startNodeCmd.Parsed() {
 		nodeID := os.Getenv("NODE_ID")
@@ -214,6 +655,10 @@ func (cli *CLI) Run() {
 			startNodeCmd.Usage()
 			os.Exit(1)
 		}
-		cli.startnode(nodeID, *startNodeMinder)
+		if *startNodePrune < 0 {
+			startNodeCmd.Usage()
+			os.Exit(1)
+		}
+		cli.startnode(nodeID, *startNodeMinder, *startNodeSnapshot, *startNodePrune)
 	}
 }
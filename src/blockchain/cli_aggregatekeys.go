@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+func (cli *CLI) aggregateKeys(nodeID string, addresses []string, watch bool) {
+	var pubKeys [][]byte
+	for _, address := range addresses {
+		key, err := DecodeAddress(address)
+		if err != nil {
+			log.Panicf("ERROR: Address %s is not valid: %s", address, err)
+		}
+		if len(key) != schnorrKeyLen {
+			log.Panicf("ERROR: Address %s is not a Schnorr address", address)
+		}
+		pubKeys = append(pubKeys, key)
+	}
+	ctx, err := MuSigAggregateKeys(pubKeys)
+	if err != nil {
+		log.Panic(err)
+	}
+	fmt.Printf("Aggregate key: %x\n", ctx.AggregateKey())
+	fmt.Printf("Aggregate address: %s\n", SchnorrAddress(ctx.AggregateKey()))
+	if watch {
+		wallets, _ := NewWallets(nodeID)
+		if _, err := wallets.ImportMuSig(ctx); err != nil {
+			log.Panic(err)
+		}
+		wallets.SaveToFile(nodeID)
+		fmt.Println("Watching it, send -unsigned spends from it and signtx of every signer signs")
+	}
+}
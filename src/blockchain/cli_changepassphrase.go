@@ -0,0 +1,18 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+func (cli *CLI) changePassphrase(nodeID, old, new string) {
+	wallets, err := NewWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+	if err = wallets.ChangePassphrase(old, new); err != nil {
+		log.Panic(err)
+	}
+	wallets.SaveToFile(nodeID)
+	fmt.Println("Wallet passphrase is changed")
+}
@@ -6,8 +6,5 @@ func (cli *CLI) createBlockchain(address string, nodeID string) {
 	bc := CreateBlockChain(address, nodeID)
 	defer bc.db.Close()
 
-	utxo := UTXOSet{bc}
-	utxo.Reindex()
-
 	fmt.Println("Create New BlockChain Done.")
 }
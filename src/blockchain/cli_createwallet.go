@@ -1,10 +1,37 @@
 package main
 
-import "fmt"
+import (
+	"fmt"
+	"log"
+)
 
-func (cli *CLI) createWallet(nodeID string) {
+// an HD wallet file hands out its next receiving address, otherwise a random key is made
+// an encrypted wallet needs walletPassphrase for new keys, HD addresses come without it
+// bech32 prints the Bech32 form of the address, the wallet file keeps the Base58 one either way
+func (cli *CLI) createWallet(nodeID string, schnorr, mnemonic bool, passphrase, walletPassphrase string, bech32 bool) {
 	wallets, _ := NewWallets(nodeID)
-	address := wallets.CreateWallet()
+	if schnorr || mnemonic || wallets.HD == nil {
+		wallets.unlockWith(walletPassphrase)
+	}
+	var address string
+	switch {
+	case mnemonic:
+		words, err := wallets.CreateHDWallet(passphrase)
+		if err != nil {
+			log.Panic(err)
+		}
+		fmt.Printf("Your mnemonic, write it down, it restores every address of the wallet:\n\n  %s\n\n", words)
+		address = wallets.NewHDAddress(hdReceiveChain)
+	case schnorr:
+		address = wallets.CreateSchnorrWallet()
+	case wallets.HD != nil:
+		address = wallets.NewHDAddress(hdReceiveChain)
+	default:
+		address = wallets.CreateWallet()
+	}
 	wallets.SaveToFile(nodeID)
+	if bech32 {
+		address = wallets.GetWallet(address).GetBech32Address()
+	}
 	fmt.Printf("Your new address: %s\n", address)
 }
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+// prints the key of address, importprivkey reads it. No chain is needed
+func (cli *CLI) dumpPrivKey(nodeID, address, passphrase string) {
+	address, err := NormalizeAddress(address)
+	if err != nil {
+		log.Panicf("ERROR: Address is not valid: %s", err)
+	}
+	wallets, err := NewWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+	if _, found := wallets.Wallets[address]; !found {
+		log.Panicf("ERROR: %s has no key in the wallet", address)
+	}
+	wallets.unlockWith(passphrase)
+	key, err := EncodePrivKey(wallets.GetWallet(address))
+	if err != nil {
+		log.Panic(err)
+	}
+	fmt.Println(key)
+}
@@ -0,0 +1,18 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+func (cli *CLI) dumpUTXO(nodeID, file string) {
+	bc := NewBlockChain(nodeID)
+	defer bc.db.Close()
+	base, count, err := UTXOSet{bc}.DumpSnapshot(file)
+	if err != nil {
+		log.Panic(err)
+	}
+	fmt.Printf("Wrote %d outputs at height %d to %s\n", count, base.Height, file)
+	fmt.Printf("Block: %x\n", base.Hash)
+	fmt.Printf("Commitment: %x\n", base.Commitment)
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+)
+
+// writes every key of the wallet to file, see walletkeys.go
+func (cli *CLI) dumpWallet(nodeID, file, passphrase string) {
+	wallets, err := NewWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+	wallets.unlockWith(passphrase)
+	dump, err := wallets.DumpWallet(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+	if err := ioutil.WriteFile(file, []byte(dump), 0600); err != nil {
+		log.Panic(err)
+	}
+	fmt.Printf("Wrote %d keys to %s\n", len(wallets.Wallets), file)
+}
@@ -0,0 +1,18 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+func (cli *CLI) encryptWallet(nodeID, passphrase string) {
+	wallets, err := NewWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+	if err = wallets.EncryptWallet(passphrase); err != nil {
+		log.Panic(err)
+	}
+	wallets.SaveToFile(nodeID)
+	fmt.Println("Wallet is encrypted. Earlier copies of the wallet file still have the keys in plaintext, delete them")
+}
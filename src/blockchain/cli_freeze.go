@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+// freeze keeps outputs of the wallet out of coin selection, a running node of nodeID does it itself
+func (cli *CLI) freeze(nodeID, outpoints string, unfreeze bool) {
+	ops, err := ParseOutpoints(outpoints)
+	if err != nil {
+		log.Panic(err)
+	}
+	if localNodeRunning(nodeID) { // it keeps the wallet in memory and writes it
+		sendFreeze(nodeID, outpoints, unfreeze)
+		fmt.Printf("Handed the outputs to node %s, it prints the result\n", nodeID)
+		return
+	}
+	wallets, err := NewWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+	if unfreeze {
+		wallets.Unfreeze(ops)
+	} else {
+		bc := NewBlockChain(nodeID)
+		err = wallets.Freeze(ops, &UTXOSet{bc})
+		bc.db.Close()
+		if err != nil {
+			log.Panic(err)
+		}
+	}
+	wallets.SaveToFile(nodeID)
+	fmt.Printf("%d outputs of the wallet are frozen\n", len(wallets.Frozen))
+}
@@ -5,22 +5,38 @@ import (
 	"log"
 )
 
+// the balance is split into confirmed, immature and pending for addresses of the wallet, see wallettx.go
 func (cli *CLI) getBalance(address string, nodeID string) {
-	if !VerifyAddress(address) {
-		log.Panic("ERROR: Address is not valid")
-
+	//if !VerifyAddress(address) {
+	//	log.Panic("ERROR: Address is not valid")
+	//
+	//}
+	address, err := NormalizeAddress(address)
+	if err != nil {
+		log.Panicf("ERROR: Address is not valid: %s", err)
 	}
 	bc := NewBlockChain(nodeID)
 	utxo := UTXOSet{bc}
 
 	defer bc.db.Close()
 
-	balance := 0
-	decoded := Base58Decode([]byte(address))
-	pubKeyHash := decoded[1 : len(decoded)-4]
-	UTXO := utxo.FindUTXO(pubKeyHash)
-	for _, out := range UTXO {
-		balance += out.Value // the coin change output
+	//balance := 0
+	pubKeyHash, _ := DecodeAddress(address)
+	//UTXO := utxo.FindUTXO(pubKeyHash)
+	//for _, out := range UTXO {
+	//	balance += out.Value // the coin change output
+	//}
+	wallets, _ := NewWallets(nodeID)
+	if wallets.SyncTxs(bc) {
+		wallets.SaveToFile(nodeID)
+	}
+	balance := wallets.Balance(pubKeyHash, &utxo)
+	suffix := ""
+	if wallets.IsWatchOnly(address) {
+		suffix = " (watch-only)"
+	}
+	fmt.Printf("Balance of '%s': %d%s\n", address, balance.Confirmed+balance.Immature, suffix)
+	if balance.Immature > 0 || balance.Pending > 0 {
+		fmt.Printf("  confirmed %d, immature %d, pending %d\n", balance.Confirmed, balance.Immature, balance.Pending)
 	}
-	fmt.Printf("Balance of '%s': %d\n", address, balance)
 }
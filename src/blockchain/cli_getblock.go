@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strconv"
+)
+
+// getBlock prints one block of the chain, picked by height or by hash
+func (cli *CLI) getBlock(nodeID string, height int, hash string) {
+	bc := NewBlockChain(nodeID)
+	defer bc.db.Close()
+
+	var b *block
+	if hash != "" {
+		blockHash, err := hex.DecodeString(hash)
+		if err != nil {
+			log.Panicf("ERROR: Block hash %s is not valid hex", hash)
+		}
+		found, err := bc.GetBlock(blockHash)
+		if err != nil {
+			log.Panic(err)
+		}
+		b = &found
+	} else {
+		var err error
+		if b, err = bc.GetBlockByHeight(height); err != nil {
+			log.Panic(err)
+		}
+	}
+
+	fmt.Printf("Height: %d\n", b.Height)
+	fmt.Printf("Hash: %x\n", b.Hash)
+	fmt.Printf("Prev. hash: %x\n", b.PrevBlockHash)
+	fmt.Printf("Timestamp: %d\n", b.Timestamp)
+	fmt.Printf("Nonce: %d\n", b.Nonce)
+	fmt.Printf("Merkle root: %x\n", b.HashTransactions())
+	pow := NewProofOfWork(b)
+	fmt.Printf("PoW: %s\n", strconv.FormatBool(pow.Validate()))
+	fmt.Printf("Transactions: %d\n", len(b.Transactions))
+	for _, tx := range b.Transactions {
+		fmt.Printf("  tx %x\n", tx.ID)
+		for _, in := range tx.VIn {
+			if tx.isCoinbaseTX() {
+				fmt.Println("    in  coinbase")
+				break
+			}
+			fmt.Printf("    in  %x:%d\n", in.TXid, in.Vout)
+		}
+		for i, out := range tx.VOut {
+			fmt.Printf("    out %d: %d to %x\n", i, out.Value, out.PubKeyHash)
+		}
+	}
+}
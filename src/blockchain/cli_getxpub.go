@@ -0,0 +1,18 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+// the account key works while the wallet is locked, a watching node imports it with importaddress -xpub
+func (cli *CLI) getXPub(nodeID string) {
+	wallets, err := NewWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+	if wallets.HD == nil {
+		log.Panic("the wallet has no HD wallet, start one with createwallet -mnemonic")
+	}
+	fmt.Printf("Extended public key of %s: %s\n", FormatPath(hdAccountPath), wallets.HD.AccountKey())
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+func (cli *CLI) history(address string, page, pageSize int, nodeID string) {
+	address, err := NormalizeAddress(address)
+	if err != nil {
+		log.Panicf("ERROR: Address is not valid: %s", err)
+	}
+	bc := NewBlockChain(nodeID)
+	defer bc.db.Close()
+
+	lockingKey, _ := DecodeAddress(address)
+	entries, total, err := bc.AddressHistory(lockingKey, (page-1)*pageSize, pageSize)
+	if err == errNoAddrIndex {
+		fmt.Println("The address index is not enabled. Build it with reindex -addrindex first.")
+		bc.db.Close()
+		os.Exit(1)
+	}
+	if err != nil {
+		log.Panic(err)
+	}
+	bestHeight := bc.GetBestHeight()
+	pages := (total + pageSize - 1) / pageSize
+	watchOnly := ""
+	if wallets, _ := NewWallets(nodeID); wallets.IsWatchOnly(address) {
+		watchOnly = " (watch-only)"
+	}
+	fmt.Printf("History of '%s'%s: %d transactions, page %d of %d\n", address, watchOnly, total, page, pages)
+	for _, entry := range entries {
+		tx, _, err := bc.FindTransaction(entry.TXid)
+		if err != nil {
+			log.Panic(err)
+		}
+		fmt.Printf("%x  height %d  confirmations %d  amount %+d\n", entry.TXid, entry.Height, bestHeight-entry.Height+1, entry.Delta)
+		fmt.Printf("  %s\n", counterparties(bc, tx, lockingKey, entry.Delta))
+	}
+}
+
+// the other side of a tx: who paid the address, or who the address paid
+func counterparties(bc *BlockChain, tx *Transaction, lockingKey []byte, delta int) string {
+	seen := make(map[string]bool)
+	var parties []string
+	add := func(key []byte) {
+		address := LockingKeyAddress(key)
+		if address == nil || bytes.Equal(key, lockingKey) || seen[string(address)] {
+			return
+		}
+		seen[string(address)] = true
+		parties = append(parties, string(address))
+	}
+	if delta > 0 {
+		if tx.isCoinbaseTX() {
+			return "from: coinbase"
+		}
+		for _, in := range tx.VIn {
+			prevTX, err := bc.FindPrevTransaction(in.TXid)
+			if err != nil {
+				log.Panic(err)
+			}
+			add(prevTX.VOut[in.Vout].PubKeyHash)
+		}
+		return "from: " + strings.Join(parties, ", ")
+	}
+	for _, out := range tx.VOut {
+		add(out.PubKeyHash)
+	}
+	if len(parties) == 0 {
+		return "to: self"
+	}
+	return "to: " + strings.Join(parties, ", ")
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+func (cli *CLI) importAddress(nodeID, address, xpub string) {
+	wallets, _ := NewWallets(nodeID)
+	if address != "" {
+		if err := wallets.ImportAddress(address); err != nil {
+			log.Panic(err)
+		}
+		fmt.Printf("Watching %s\n", address)
+	}
+	if xpub != "" {
+		var used map[string]bool
+		if dbExists(fmt.Sprintf(dbFile, nodeID)) {
+			bc := NewBlockChain(nodeID)
+			used = bc.LockingKeysInUse()
+			bc.db.Close()
+		}
+		added, err := wallets.ImportXPub(xpub, used)
+		if err != nil {
+			log.Panic(err)
+		}
+		for _, a := range added {
+			fmt.Printf("Watching %s\n", a)
+		}
+		fmt.Printf("Watching %d new addresses of the extended public key\n", len(added))
+	}
+	wallets.SaveToFile(nodeID)
+}
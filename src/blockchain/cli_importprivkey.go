@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+func (cli *CLI) importPrivKey(nodeID, key, passphrase string, rescan bool) {
+	w, err := DecodePrivKey(key)
+	if err != nil {
+		log.Panic(err)
+	}
+	cli.importKeys(nodeID, []*Wallet{w}, passphrase, rescan)
+}
+
+/*
+importKeys adds keys to the wallet file, a running node reads them before it writes the file again.
+rescan finds the past transactions of the keys, the balance comes from the UTXO set either way
+*/
+func (cli *CLI) importKeys(nodeID string, keys []*Wallet, passphrase string, rescan bool) {
+	wallets, _ := NewWallets(nodeID)
+	wallets.unlockWith(passphrase)
+	var imported []string
+	for _, w := range keys {
+		address, err := wallets.ImportKey(w)
+		if err == errAlreadyInWallet {
+			fmt.Printf("%s is in the wallet already\n", address)
+			continue
+		}
+		if err != nil {
+			log.Panic(err)
+		}
+		imported = append(imported, address)
+		fmt.Printf("Imported %s\n", address)
+	}
+	if rescan && len(imported) > 0 {
+		if !dbExists(fmt.Sprintf(dbFile, nodeID)) {
+			fmt.Println("No blockchain yet, nothing to rescan")
+		} else {
+			bc := NewBlockChain(nodeID)
+			UTXO := UTXOSet{bc}
+			wallets.Rescan(bc)
+			for _, address := range imported {
+				balance := wallets.Balance(wallets.GetWallet(address).LockingKey(), &UTXO)
+				fmt.Printf("Balance of '%s': %d\n", address, balance.Confirmed+balance.Immature)
+			}
+			bc.db.Close()
+		}
+	}
+	wallets.SaveToFile(nodeID)
+	fmt.Printf("Imported %d keys\n", len(imported))
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+)
+
+// reads the keys of a dumpwallet file, keys in the wallet already are skipped
+func (cli *CLI) importWallet(nodeID, file, passphrase string, rescan bool) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		log.Panic(err)
+	}
+	keys, err := ParseWalletDump(content)
+	if err != nil {
+		log.Panic(err)
+	}
+	cli.importKeys(nodeID, keys, passphrase, rescan)
+}
@@ -5,13 +5,24 @@ import (
 	"log"
 )
 
-func (cli *CLI) listAddress(nodeID string) {
+func (cli *CLI) listAddress(nodeID string, bech32 bool) {
 	wallets, err := NewWallets(nodeID)
 	if err != nil {
 		log.Panic(err)
 	}
 	addresses := wallets.GetAddresses()
 	for _, addr := range addresses {
-		fmt.Println(addr)
+		if bech32 {
+			fmt.Printf("%s %s\n", addr, wallets.GetWallet(addr).GetBech32Address())
+		} else {
+			fmt.Println(addr)
+		}
+	}
+	for _, addr := range wallets.GetWatchOnlyAddresses() {
+		if bech32 {
+			fmt.Printf("%s %s (watch-only)\n", addr, Bech32Address(wallets.Watch[addr].LockingKey))
+		} else {
+			fmt.Printf("%s (watch-only)\n", addr)
+		}
 	}
 }
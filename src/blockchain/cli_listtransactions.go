@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// listTransactions prints the last count txs of the wallet, the pending ones first
+func (cli *CLI) listTransactions(nodeID string, count int) {
+	wallets, err := NewWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+	bc := NewBlockChain(nodeID)
+	defer bc.db.Close()
+	if wallets.SyncTxs(bc) {
+		wallets.SaveToFile(nodeID)
+	}
+	best := bc.GetBestHeight()
+	txs := wallets.WalletTxs()
+	if len(txs) > count {
+		txs = txs[:count]
+	}
+	for _, wtx := range txs {
+		state := "pending"
+		switch {
+		case wtx.Conflicted:
+			state = "conflicted"
+		case wtx.Height >= 0:
+			state = fmt.Sprintf("height %d  confirmations %d", wtx.Height, best-wtx.Height+1)
+		}
+		kind := "sent"
+		if wtx.Coinbase {
+			kind = "mined"
+		} else if wtx.Credit > wtx.Debit {
+			kind = "received"
+		}
+		tx := wtx.Transaction()
+		fmt.Printf("%x  %s  %s  amount %+d  %s\n", tx.ID, time.Unix(wtx.Time, 0).Format("2006-01-02 15:04:05"), kind, wtx.Credit-wtx.Debit, state)
+	}
+	fmt.Printf("%d of %d transactions\n", len(txs), len(wallets.Txs))
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"log"
+	"sort"
+)
+
+// listUnspent prints the unspent outputs of the wallet, or of address, oldest first
+func (cli *CLI) listUnspent(nodeID, address string) {
+	if address != "" {
+		normalized, err := NormalizeAddress(address)
+		if err != nil {
+			log.Panicf("ERROR: Address is not valid: %s", err)
+		}
+		address = normalized
+	}
+	wallets, err := NewWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+	mine := wallets.AddressesByLockingKey()
+	bc := NewBlockChain(nodeID)
+	defer bc.db.Close()
+	if wallets.SyncTxs(bc) {
+		wallets.SaveToFile(nodeID)
+	}
+	height := bc.GetBestHeight()
+	spent := wallets.PendingSpends()
+
+	var coins []Coin
+	err = bc.db.View(func(tx *StorageTx) error {
+		return tx.Chainstate().ForEach(func(op Outpoint, entry UTXOEntry) error {
+			owner, found := mine[hex.EncodeToString(entry.Output.PubKeyHash)]
+			if found && (address == "" || owner == address) {
+				coins = append(coins, Coin{Outpoint{append([]byte{}, op.TXid...), op.Vout}, entry})
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+	sort.SliceStable(coins, func(i, j int) bool { return coins[i].Height < coins[j].Height })
+
+	total := 0
+	for _, c := range coins {
+		owner := mine[hex.EncodeToString(c.Output.PubKeyHash)]
+		fmt.Printf("%s %d %s, %d confirmations", c, c.Output.Value, owner, height-c.Height+1)
+		if c.Coinbase {
+			fmt.Print(", coinbase")
+		}
+		if c.Coinbase && height-c.Height+1 < coinbaseMaturity {
+			fmt.Print(", immature")
+		}
+		if spent[c.String()] {
+			fmt.Print(", spent by a pending tx")
+		}
+		if wallets.Frozen[c.String()] {
+			fmt.Print(", frozen")
+		}
+		if wallets.IsWatchOnly(owner) {
+			fmt.Print(", watch-only")
+		}
+		fmt.Println()
+		total += c.Output.Value
+	}
+	fmt.Printf("%d unspent outputs worth %d\n", len(coins), total)
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+func (cli *CLI) loadUTXO(nodeID, file string) {
+	bc := NewBlockChain(nodeID)
+	defer bc.db.Close()
+	base, count, err := UTXOSet{bc}.LoadSnapshot(file)
+	if err != nil {
+		log.Panic(err)
+	}
+	fmt.Printf("Loaded %d outputs at height %d from %s\n", count, base.Height, file)
+	fmt.Printf("Block: %x\n", base.Hash)
+	fmt.Printf("Commitment: %x\n", base.Commitment)
+	fmt.Println("The history up to this block is validated once the node has synced it")
+}
@@ -0,0 +1,11 @@
+package main
+
+import "strings"
+
+func (cli *CLI) migrateDB(nodeID string, resign bool, walletIDs string, passphrase string) {
+	keyIDs := []string{nodeID}
+	if walletIDs != "" {
+		keyIDs = append(keyIDs, strings.Split(walletIDs, ",")...)
+	}
+	MigrateDB(nodeID, resign, keyIDs, passphrase)
+}
@@ -2,23 +2,43 @@ package main
 
 import (
 	"fmt"
+	"log"
 	"strconv"
 )
 
 func (cli *CLI) printChain(nodeID string) {
 	bc := NewBlockChain(nodeID)
 	defer bc.db.Close()
-	bci := bc.Iterator()
-	for {
-		block := bci.Next()
-		fmt.Printf("Prev. hash: %x\n", block.PrevBlockHash)
-		fmt.Printf("Hash: %x\n", block.Hash)
-		pow := NewProofOfWork(block)
-		fmt.Printf("PoW: %s\n", strconv.FormatBool(pow.Validate()))
+	//bci := bc.Iterator()
+	//for {
+	//	block := bci.Next()
+	for hash := bc.tip; len(hash) > 0; { // by header, the blocks may be pruned
+		var block *block
+		var header *blockHeader
+		err := bc.db.View(func(tx *StorageTx) error {
+			var err error
+			if header, err = tx.Blocks().Header(hash); err != nil {
+				return err
+			}
+			block, err = tx.Blocks().Get(hash)
+			if err == errBlockPruned {
+				return nil
+			}
+			return err
+		})
+		if err != nil {
+			log.Panic(err)
+		}
+		fmt.Printf("Prev. hash: %x\n", header.PrevBlockHash)
+		fmt.Printf("Hash: %x\n", hash)
+		if block == nil {
+			fmt.Println("PoW: pruned")
+		} else {
+			pow := NewProofOfWork(block)
+			fmt.Printf("PoW: %s\n", strconv.FormatBool(pow.Validate()))
+		}
 		fmt.Println()
 
-		if len(block.PrevBlockHash) == 0 {
-			break
-		}
+		hash = header.PrevBlockHash
 	}
 }
@@ -2,8 +2,14 @@ package main
 
 import "fmt"
 
-func (cli *CLI) reindex(nodeID string) {
+func (cli *CLI) reindex(nodeID string, addrIndex bool) {
 	bc := NewBlockChain(nodeID)
+	defer bc.db.Close()
+	if addrIndex {
+		bc.EnableAddressIndex()
+	} else {
+		bc.ReindexChain()
+	}
 	utx := UTXOSet{bc}
 	utx.Reindex()
 	cnt := utx.CountTransactions()
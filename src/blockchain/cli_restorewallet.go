@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+func (cli *CLI) restoreWallet(nodeID, mnemonic, passphrase, walletPassphrase string) {
+	if _, err := MnemonicToEntropy(mnemonic); err != nil {
+		log.Panic(err)
+	}
+	wallets, _ := NewWallets(nodeID)
+	wallets.unlockWith(walletPassphrase)
+	var used map[string]bool
+	if dbExists(fmt.Sprintf(dbFile, nodeID)) {
+		bc := NewBlockChain(nodeID)
+		used = bc.LockingKeysInUse()
+		bc.db.Close()
+	} else {
+		fmt.Println("No blockchain yet, no addresses to look for")
+	}
+	found, err := wallets.RestoreHDWallet(mnemonic, passphrase, used)
+	if err != nil {
+		log.Panic(err)
+	}
+	for _, address := range found {
+		fmt.Printf("Found %s\n", address)
+	}
+	if len(found) == 0 {
+		fmt.Printf("Your new address: %s\n", wallets.NewHDAddress(hdReceiveChain))
+	}
+	wallets.SaveToFile(nodeID)
+	fmt.Printf("Restored the HD wallet with %d used addresses\n", len(found))
+}
@@ -5,13 +5,40 @@ import (
 	"log"
 )
 
-func (cli *CLI) send(from, to string, amount int, nodeID string, mineNow bool) {
+/*
+a running node of nodeID signs with its own wallet, unlock it with walletpassphrase first.
+From a watch-only address the tx is written to the unsigned file instead, see signtx.
+strategy picks the outputs to spend and inputs names them, see coinselect.go
+*/
+func (cli *CLI) send(from, to string, amount int, nodeID string, mineNow bool, passphrase, unsigned, strategy, inputs string) {
 	//bc := NewBlockChain(from)
-	if !VerifyAddress(from) {
-		log.Panic("ERROR: Sender address is not valid")
+	if _, err := DecodeAddress(to); err != nil {
+		log.Panicf("ERROR: Recipient address is not valid: %s", err)
 	}
-	if !VerifyAddress(to) {
-		log.Panic("ERROR: Recipient address is not valid")
+	cli.sendPayments(from, []Payment{{to, amount}}, nodeID, mineNow, passphrase, unsigned, strategy, inputs)
+}
+
+// sendPayments pays all of payments from one address in a single tx, like send does for one
+func (cli *CLI) sendPayments(from string, payments []Payment, nodeID string, mineNow bool, passphrase, unsigned, strategy, inputs string) {
+	from, err := NormalizeAddress(from) // the wallet knows it by the Base58 form
+	if err != nil {
+		log.Panicf("ERROR: Sender address is not valid: %s", err)
+	}
+	if _, err := NewCoinControl(strategy, inputs, nil); err != nil { // checked before anything is handed on
+		log.Panic(err)
+	}
+	amount, err := PaymentsTotal(payments)
+	if err != nil {
+		log.Panic(err)
+	}
+	if unsigned != "" {
+		cli.sendUnsigned(from, payments, nodeID, unsigned, strategy, inputs)
+		return
+	}
+	if !mineNow && localNodeRunning(nodeID) { // it has the db open
+		sendSend(nodeID, from, payments, strategy, inputs)
+		fmt.Printf("Handed the tx to node %s, it prints the result\n", nodeID)
+		return
 	}
 	bc := NewBlockChain(nodeID)
 	UTXO := UTXOSet{bc}
@@ -25,15 +52,76 @@ func (cli *CLI) send(from, to string, amount int, nodeID string, mineNow bool) {
 	if err != nil {
 		log.Panic(err)
 	}
+	if wallets.IsWatchOnly(from) {
+		log.Panic("ERROR: Sender address is watch-only, write an unsigned tx with -unsigned FILE")
+	}
+	wallets.unlockWith(passphrase)
 	wallet := wallets.GetWallet(from)
-	tx := NewUTXOTransaction(&wallet, to, amount, &UTXO)
+	wallets.SyncTxs(bc) // the pending txs of the wallet tell which outputs are spent already
+	cc, _ := NewCoinControl(strategy, inputs, wallets)
+	if _, _, err := UTXO.SelectCoins(wallet.LockingKey(), amount, cc); err != nil { // before a change address is handed out
+		log.Panic(err)
+	}
+	change := ""
+	if wallets.HD != nil { // a fresh address for the change, it is covered by the mnemonic
+		change = wallets.NewHDAddress(hdChangeChain)
+		wallets.SaveToFile(nodeID)
+	}
+	tx := NewPaymentsTransaction(&wallet, payments, change, &UTXO, cc)
 	if mineNow {
-		cbtx := NewCoinbaseTX(from, "")                    // the reward
-		newBlock := bc.MineBlock([]*Transaction{cbtx, tx}) // add it to the chain
-		UTXO.Update(newBlock)
+		cbtx := NewCoinbaseTX(from, "") // the reward
+		// add it to the chain, the UTXO set is updated with it
+		if _, err := bc.MineBlock([]*Transaction{cbtx, tx}); err != nil {
+			log.Panic(err)
+		}
+		wallets.SyncTxs(bc)
 	} else {
 		sendTx(knownAddr[0], tx)
+		wallets.TrackTx(tx, &UTXO) // pending until a block has it
 	}
+	wallets.SaveToFile(nodeID)
 
 	fmt.Println("Send Coin Success")
 }
+
+func (cli *CLI) sendUnsigned(from string, payments []Payment, nodeID, file, strategy, inputs string) {
+	wallets, err := NewWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+	entry, found := wallets.Watch[from]
+	if !found {
+		log.Panic("ERROR: Sender address is not watch-only, send signs it right away")
+	}
+	bc := NewBlockChain(nodeID)
+	UTXO := UTXOSet{bc}
+	defer bc.db.Close()
+	wallets.SyncTxs(bc)
+	cc, _ := NewCoinControl(strategy, inputs, wallets)
+	amount, err := PaymentsTotal(payments)
+	if err != nil {
+		log.Panic(err)
+	}
+	if _, _, err := UTXO.SelectCoins(entry.LockingKey, amount, cc); err != nil {
+		log.Panic(err)
+	}
+	change := wallets.newWatchChange(from)
+	if change == "" {
+		change = from
+	}
+	u := NewUnsignedTransaction(entry.LockingKey, payments, change, &UTXO, cc)
+	if entry.MuSigKeys != nil { // every input is locked to the aggregate key
+		for range u.Tx.VIn {
+			u.MuSig = append(u.MuSig, newMuSigInput(entry.MuSigKeys))
+		}
+	}
+	if entry.XPub != "" { // the signer may not know the address, e.g. a change one of this node
+		for range u.Tx.VIn {
+			u.Paths = append(u.Paths, entry.Path)
+		}
+	}
+	wallets.SaveToFile(nodeID)
+	u.WriteFile(file)
+	fmt.Println(u)
+	fmt.Printf("Wrote the unsigned tx to %s, sign it with signtx on the node with the key\n", file)
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+sendmany pays every address of a list in one tx with one change output. The list comes as
+ADDRESS:AMOUNT pairs, comma separated, or from a file in one of the forms:
+
+	CSV   a line of ADDRESS,AMOUNT per payment, a header line and # comments are skipped
+	JSON  [{"address": "ADDRESS", "amount": AMOUNT}, ...] or {"ADDRESS": AMOUNT, ...}
+
+An address is paid once, a list naming it twice is taken for a mistake.
+*/
+func (cli *CLI) sendMany(from, list, file string, nodeID string, mineNow bool, passphrase, unsigned, strategy, inputs string) {
+	var payments []Payment
+	var err error
+	if file != "" {
+		payments, err = readPaymentsFile(file)
+	} else {
+		payments, err = parsePaymentList(list)
+	}
+	if err == nil {
+		err = checkPayments(payments)
+	}
+	if err != nil {
+		log.Panic(err)
+	}
+	total, _ := PaymentsTotal(payments) // checked by checkPayments
+	fmt.Printf("Paying %d to %d addresses\n", total, len(payments))
+	cli.sendPayments(from, payments, nodeID, mineNow, passphrase, unsigned, strategy, inputs)
+}
+
+func parsePaymentList(list string) ([]Payment, error) {
+	var payments []Payment
+	for _, pair := range strings.Split(list, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		parts := strings.Split(pair, ":")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("%q is not ADDRESS:AMOUNT", pair)
+		}
+		p, err := newPayment(parts[0], parts[1])
+		if err != nil {
+			return nil, err
+		}
+		payments = append(payments, p)
+	}
+	return payments, nil
+}
+
+func newPayment(address, amount string) (Payment, error) {
+	value, err := strconv.Atoi(strings.TrimSpace(amount))
+	if err != nil {
+		return Payment{}, fmt.Errorf("bad amount %q for %s", amount, address)
+	}
+	return Payment{strings.TrimSpace(address), value}, nil
+}
+
+func readPaymentsFile(path string) ([]Payment, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	content = bytes.TrimSpace(content)
+	if len(content) > 0 && (content[0] == '[' || content[0] == '{') {
+		return parsePaymentsJSON(content)
+	}
+	return parsePaymentsCSV(content)
+}
+
+func parsePaymentsCSV(content []byte) ([]Payment, error) {
+	r := csv.NewReader(bytes.NewReader(content))
+	r.Comment = '#'
+	r.FieldsPerRecord = 2
+	r.TrimLeadingSpace = true
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	var payments []Payment
+	for i, record := range records {
+		p, err := newPayment(record[0], record[1])
+		if err != nil && i == 0 { // a header
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		payments = append(payments, p)
+	}
+	return payments, nil
+}
+
+func parsePaymentsJSON(content []byte) ([]Payment, error) {
+	if content[0] == '{' {
+		return parsePaymentsObject(content)
+	}
+	var list []struct {
+		Address string `json:"address"`
+		Amount  int    `json:"amount"`
+	}
+	if err := json.Unmarshal(content, &list); err != nil {
+		return nil, err
+	}
+	var payments []Payment
+	for _, p := range list {
+		payments = append(payments, Payment{p.Address, p.Amount})
+	}
+	return payments, nil
+}
+
+// parsePaymentsObject reads {"ADDRESS": AMOUNT, ...} key by key, a map would keep only the last of two same keys
+func parsePaymentsObject(content []byte) ([]Payment, error) {
+	dec := json.NewDecoder(bytes.NewReader(content))
+	if _, err := dec.Token(); err != nil { // {
+		return nil, err
+	}
+	var payments []Payment
+	seen := make(map[string]bool)
+	for dec.More() {
+		token, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		address := token.(string) // object keys are always strings
+		if seen[address] {
+			return nil, fmt.Errorf("%s is paid twice", address)
+		}
+		seen[address] = true
+		var amount int
+		if err := dec.Decode(&amount); err != nil {
+			return nil, fmt.Errorf("bad amount for %s: %s", address, err)
+		}
+		payments = append(payments, Payment{address, amount})
+	}
+	if _, err := dec.Token(); err != nil { // }
+		return nil, err
+	}
+	if dec.More() {
+		return nil, errors.New("the payments file has more after the object")
+	}
+	sort.Slice(payments, func(i, j int) bool { return payments[i].To < payments[j].To })
+	return payments, nil
+}
+
+func checkPayments(payments []Payment) error {
+	if len(payments) == 0 {
+		return errors.New("no payments to send")
+	}
+	seen := make(map[string]bool) // by locking key, the Base58 and Bech32 forms of an address are the same
+	for _, p := range payments {
+		key, err := DecodeAddress(p.To)
+		if err != nil {
+			return err
+		}
+		if p.Amount <= 0 {
+			return fmt.Errorf("the amount for %s is not positive", p.To)
+		}
+		if seen[string(key)] {
+			return fmt.Errorf("%s is paid twice", p.To)
+		}
+		seen[string(key)] = true
+	}
+	_, err := PaymentsTotal(payments)
+	return err
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParsePayments(t *testing.T) {
+	a, b := string(NewWallet().GetAddress()), string(NewWallet().GetAddress())
+	want := map[string]int{a: 5, b: 7}
+	files := map[string]string{
+		"csv":    "address,amount\n" + a + ",5\n# a comment\n" + b + ",7\n",
+		"array":  `[{"address":"` + a + `","amount":5},{"address":"` + b + `","amount":7}]`,
+		"object": `{"` + a + `": 5, "` + b + `": 7}`,
+	}
+	for name, content := range files {
+		parse := parsePaymentsCSV
+		if name != "csv" {
+			parse = parsePaymentsJSON
+		}
+		payments, err := parse([]byte(content))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if len(payments) != 2 || payments[0].Amount != want[payments[0].To] || payments[1].Amount != want[payments[1].To] {
+			t.Errorf("%s: %v", name, payments)
+		}
+		if err := checkPayments(payments); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+
+	for _, bad := range []string{
+		`{"` + a + `": 5, "` + a + `": 7}`,
+		`{"` + a + `": "5"}`,
+		`{"` + a + `": 5} {}`,
+	} {
+		if _, err := parsePaymentsJSON([]byte(bad)); err == nil {
+			t.Errorf("%s is taken", bad)
+		}
+	}
+	if payments, err := parsePaymentList(a + ":5, " + b + ":7,"); err != nil || len(payments) != 2 || payments[1].Amount != 7 {
+		t.Errorf("the list gave %v: %v", payments, err)
+	}
+	if _, err := parsePaymentList(a + ":five"); err == nil {
+		t.Error("a bad amount is taken")
+	}
+}
+
+func TestCheckPayments(t *testing.T) {
+	w := NewWallet()
+	a := string(w.GetAddress())
+	if Bech32Address(w.LockingKey()) == "" {
+		t.Fatal("no Bech32 form of the address")
+	}
+	for _, payments := range [][]Payment{
+		nil,
+		{{a, 0}},
+		{{"nothing", 1}},
+		{{a, 1}, {Bech32Address(w.LockingKey()), 2}}, // one address in both forms
+		{{a, math.MaxInt64}, {string(NewWallet().GetAddress()), 1}},
+	} {
+		if err := checkPayments(payments); err == nil {
+			t.Errorf("%v is taken", payments)
+		}
+	}
+	if _, err := PaymentsTotal([]Payment{{a, math.MaxInt64}, {a, 1}}); err != errPaymentsOverflow {
+		t.Fatalf("an overflowing total: %v", err)
+	}
+	if total, err := PaymentsTotal([]Payment{{a, 2}, {a, 3}}); err != nil || total != 5 {
+		t.Fatalf("total %d: %v", total, err)
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+// passes a signed tx file on to the central node, or mines it here with the reward to miner
+func (cli *CLI) sendSignedTx(nodeID, file, miner string) {
+	u, err := ReadUnsignedTx(file)
+	if err != nil {
+		log.Panic(err)
+	}
+	if !u.IsSigned() {
+		log.Panic("ERROR: The tx is not signed yet, see signtx")
+	}
+	if miner == "" {
+		sendTx(knownAddr[0], &u.Tx)
+		fmt.Printf("Sent tx %x\n", u.Tx.ID)
+		if !localNodeRunning(nodeID) && dbExists(fmt.Sprintf(dbFile, nodeID)) { // a running node sees it in its mempool
+			bc := NewBlockChain(nodeID)
+			defer bc.db.Close()
+			if wallets, err := NewWallets(nodeID); err == nil && wallets.TrackTx(&u.Tx, &UTXOSet{bc}) {
+				wallets.SaveToFile(nodeID)
+			}
+		}
+		return
+	}
+	if _, err := DecodeAddress(miner); err != nil {
+		log.Panicf("ERROR: Miner address is not valid: %s", err)
+	}
+	bc := NewBlockChain(nodeID)
+	defer bc.db.Close()
+	if !bc.VerifyTransaction(&u.Tx) {
+		log.Panic("ERROR: Invalid transaction")
+	}
+	cbtx := NewCoinbaseTX(miner, "")
+	if _, err := bc.MineBlock([]*Transaction{cbtx, &u.Tx}); err != nil {
+		log.Panic(err)
+	}
+	fmt.Printf("Mined tx %x\n", u.Tx.ID)
+	if wallets, err := NewWallets(nodeID); err == nil && wallets.SyncTxs(bc) {
+		wallets.SaveToFile(nodeID)
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+// the signature proves the key of address, see message.go. No chain is needed
+func (cli *CLI) signMessage(nodeID, address, message, passphrase string) {
+	address, err := NormalizeAddress(address)
+	if err != nil {
+		log.Panicf("ERROR: Address is not valid: %s", err)
+	}
+	wallets, err := NewWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+	if _, found := wallets.Wallets[address]; !found { // watch-only or aggregated addresses too
+		log.Panic(errMessageNoKey)
+	}
+	wallets.unlockWith(passphrase)
+	signature, err := SignMessage(wallets.GetWallet(address), message)
+	if err != nil {
+		log.Panic(err)
+	}
+	fmt.Println(signature)
+}
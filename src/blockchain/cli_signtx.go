@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+// signs an unsigned tx file with the keys of the wallet, no chain is needed
+func (cli *CLI) signTx(nodeID, file, passphrase string) {
+	u, err := ReadUnsignedTx(file)
+	if err != nil {
+		log.Panic(err)
+	}
+	wallets, err := NewWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+	wallets.unlockWith(passphrase)
+	fmt.Println(u)
+	signed, err := u.Sign(wallets)
+	if err != nil && (err != errNothingToSign || u.MuSig == nil) {
+		log.Panic(err)
+	}
+	rounds, err := u.SignMuSig(wallets)
+	if err != nil {
+		log.Panic(err)
+	}
+	if signed == 0 && rounds == 0 {
+		log.Panic(errNothingToSign)
+	}
+	if rounds > 0 {
+		wallets.SaveToFile(nodeID) // the secret nonces, before the public ones leave in the file
+	}
+	u.WriteFile(file)
+	if u.MuSig != nil {
+		fmt.Printf("Signed %d inputs and did %d MuSig rounds\n", signed, rounds)
+	} else {
+		fmt.Printf("Signed %d inputs\n", signed)
+	}
+	if u.IsSigned() {
+		fmt.Printf("The tx is complete. Send it with sendtx -file %s\n", file)
+	} else {
+		fmt.Println("Others still need to sign, pass the file on to them")
+	}
+}
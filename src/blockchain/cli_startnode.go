@@ -5,15 +5,21 @@ import (
 	"log"
 )
 
-func (cli *CLI) startnode(nodeID, miningAddr string) {
+func (cli *CLI) startnode(nodeID, miningAddr, snapshot string, pruneMB int) {
 	fmt.Printf("Starting node %s\n", nodeID)
 	fmt.Printf("Starting node %s\n", nodeID)
 	if len(miningAddr) > 0 {
-		if VerifyAddress(miningAddr) {
+		if _, err := DecodeAddress(miningAddr); err == nil {
 			fmt.Println("Mining is on. Address to receive rewards: ", miningAddr)
 		} else {
-			log.Panic("Wrong miner address!")
+			log.Panicf("Wrong miner address! %s", err)
 		}
 	}
-	StartServer(nodeID, miningAddr)
+	if len(snapshot) > 0 { // sync from the snapshot's block, the history before it is checked in the background
+		cli.loadUTXO(nodeID, snapshot)
+	}
+	if pruneMB > 0 {
+		fmt.Printf("Pruning is on. Block files are kept under %d MB\n", pruneMB)
+	}
+	StartServer(nodeID, miningAddr, pruneMB)
 }
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"time"
+)
+
+// the key is stored like one from createwallet, an encrypted wallet needs walletPassphrase
+func (cli *CLI) vanity(nodeID, prefix string, ignoreCase, schnorr bool, workers int, walletPassphrase string) {
+	difficulty, err := VanityDifficulty(prefix, ignoreCase, schnorr)
+	if err == errPrefixUnreachable {
+		log.Panicf("ERROR: %s, key hash addresses start with 1 and Schnorr ones (-schnorr) with 3", err)
+	}
+	if err != nil {
+		log.Panic(err)
+	}
+	wallets, _ := NewWallets(nodeID)
+	wallets.unlockWith(walletPassphrase) // before the search, not to lose what it finds
+
+	rate := VanityRate(workers, schnorr)
+	fmt.Printf("Difficulty: 1 in %.0f\n", difficulty)
+	fmt.Printf("Expected time: %s at %.0f keys/s with %d workers, 50%% chance within %s\n",
+		vanityTime(difficulty/rate), rate, workers, vanityTime(math.Ln2*difficulty/rate))
+
+	w, tried := VanitySearch(prefix, ignoreCase, schnorr, workers, func(tried uint64, elapsed time.Duration) {
+		chance := 1 - math.Exp(-float64(tried)/difficulty)
+		fmt.Printf("%d keys tried, %.0f keys/s, %.1f%% chance to have found it by now\n",
+			tried, float64(tried)/elapsed.Seconds(), 100*chance)
+	})
+	address := fmt.Sprintf("%s", w.GetAddress())
+	wallets, _ = NewWallets(nodeID) // a running node may have written it during the search
+	wallets.unlockWith(walletPassphrase)
+	wallets.add(address, w)
+	wallets.SaveToFile(nodeID)
+	fmt.Printf("Found after %d keys\n", tried)
+	fmt.Printf("Your new address: %s\n", address)
+}
+
+func vanityTime(seconds float64) string {
+	const year = 365 * 24 * time.Hour
+	if seconds > year.Seconds() {
+		return fmt.Sprintf("%.3g years", seconds/year.Seconds())
+	}
+	return time.Duration(seconds * float64(time.Second)).Round(time.Millisecond).String()
+}
@@ -0,0 +1,29 @@
+package main
+
+import "fmt"
+
+func (cli *CLI) verifyChain(nodeID string, depth, level int, repair bool) {
+	bc := NewBlockChain(nodeID)
+	defer bc.db.Close()
+	problems, checked := bc.VerifyChain(depth, level)
+	for _, p := range problems {
+		fmt.Println(p)
+	}
+	if len(problems) == 0 {
+		if depth == 0 {
+			fmt.Printf("No problems found in the chain (%d blocks) at level %d\n", checked, level)
+		} else {
+			fmt.Printf("No problems found in the last %d blocks at level %d\n", checked, level)
+		}
+		return
+	}
+	fmt.Printf("%d problems found\n", len(problems))
+	if !repair {
+		fmt.Println("Run verifychain -repair to fix them")
+		return
+	}
+	bc.RepairChain(problems)
+	if left, _ := bc.VerifyChain(depth, level); len(left) > 0 {
+		fmt.Printf("%d problems are left after the repair\n", len(left))
+	}
+}
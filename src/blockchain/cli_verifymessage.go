@@ -0,0 +1,20 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+)
+
+// exits with 1 when the signature is not the one of address, scripts can check it
+func (cli *CLI) verifyMessage(address, signature, message string) {
+	valid, err := VerifyMessage(address, signature, message)
+	if err != nil {
+		log.Panic(err)
+	}
+	if !valid {
+		fmt.Printf("The signature is NOT valid for %s\n", address)
+		os.Exit(1)
+	}
+	fmt.Printf("The signature is valid for %s\n", address)
+}
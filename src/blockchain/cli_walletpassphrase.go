@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+// the running node of nodeID keeps its wallet unlocked for timeout seconds, 0 locks it again
+func (cli *CLI) walletPassphrase(nodeID, passphrase string, timeout int) {
+	if !localNodeRunning(nodeID) {
+		log.Panicf("no node %s is running. Commands without a node take the passphrase themselves, e.g. send -passphrase", nodeID)
+	}
+	if timeout > 0 { // check it here, the node only prints what went wrong
+		wallets, err := NewWallets(nodeID)
+		if err != nil {
+			log.Panic(err)
+		}
+		if err = wallets.Unlock(passphrase); err != nil {
+			log.Panic(err)
+		}
+	}
+	sendWalletPassphrase(nodeID, passphrase, timeout)
+	if timeout > 0 {
+		fmt.Printf("Wallet of node %s is unlocked for %d seconds\n", nodeID, timeout)
+	} else {
+		fmt.Printf("Wallet of node %s is locked\n", nodeID)
+	}
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+Coin selection picks the outputs of an address a tx spends, send -strategy names the way:
+
+	bnb      branch and bound for outputs that add up to the amount exactly, so the tx needs no
+	         change output. Largest first when there are none
+	largest  the largest outputs first, the fewest inputs
+	oldest   the oldest outputs first, by the height of their block
+	random   outputs in a random order, the inputs tell nothing about how the wallet picks them
+
+Frozen outputs (freeze) and the ones pending txs of the wallet spend are never selected. Coin
+control (send -inputs) spends exactly the given outputs and selects nothing.
+*/
+const defaultCoinSelection = "bnb"
+const bnbMaxTries = 100000 // nodes of the search tree before bnb gives up
+
+var errNotEnoughCoins = errors.New("Not Enough Coins")
+
+// Coin is an unspent output of the wallet with where it is
+type Coin struct {
+	Outpoint
+	UTXOEntry
+}
+
+// CoinSelector picks coins worth amount or more, nil when they do not add up to amount
+type CoinSelector func(coins []Coin, amount int) []Coin
+
+var coinSelectors = map[string]CoinSelector{
+	"bnb":     selectBranchAndBound,
+	"largest": selectLargestFirst,
+	"oldest":  selectOldestFirst,
+	"random":  selectRandom,
+}
+
+type CoinControl struct {
+	Strategy string          // a name of coinSelectors, "" for defaultCoinSelection
+	Inputs   []Outpoint      // spend just these
+	Frozen   map[string]bool // outpoints (txid:vout) the strategy skips
+	Spent    map[string]bool // outpoints pending txs of the wallet spend
+}
+
+func (op Outpoint) String() string {
+	return fmt.Sprintf("%x:%d", op.TXid, op.Vout)
+}
+
+func ParseOutpoint(s string) (Outpoint, error) {
+	parts := strings.Split(strings.TrimSpace(s), ":")
+	if len(parts) != 2 {
+		return Outpoint{}, fmt.Errorf("%q is not an outpoint, it is txid:vout", s)
+	}
+	txid, err := hex.DecodeString(parts[0])
+	if err != nil || len(txid) == 0 {
+		return Outpoint{}, fmt.Errorf("%q is not an outpoint, bad txid", s)
+	}
+	vout, err := strconv.Atoi(parts[1])
+	if err != nil || vout < 0 {
+		return Outpoint{}, fmt.Errorf("%q is not an outpoint, bad vout", s)
+	}
+	return Outpoint{txid, vout}, nil
+}
+
+// ParseOutpoints reads a comma separated list of outpoints
+func ParseOutpoints(list string) ([]Outpoint, error) {
+	var ops []Outpoint
+	for _, s := range strings.Split(list, ",") {
+		if strings.TrimSpace(s) == "" {
+			continue
+		}
+		op, err := ParseOutpoint(s)
+		if err != nil {
+			return nil, err
+		}
+		ops = append(ops, op)
+	}
+	return ops, nil
+}
+
+// NewCoinControl checks strategy and inputs, the frozen and pending spent outputs come from wallets when it is not nil
+func NewCoinControl(strategy, inputs string, wallets *Wallets) (*CoinControl, error) {
+	if _, found := coinSelectors[strategy]; strategy != "" && !found {
+		return nil, fmt.Errorf("unknown coin selection %q, it is bnb, largest, oldest or random", strategy)
+	}
+	ops, err := ParseOutpoints(inputs)
+	if err != nil {
+		return nil, err
+	}
+	cc := &CoinControl{Strategy: strategy, Inputs: ops}
+	if wallets != nil {
+		cc.Frozen, cc.Spent = wallets.Frozen, wallets.PendingSpends()
+	}
+	return cc, nil
+}
+
+// FindCoins are the unspent outputs locked to lockingKey, in key order
+func (utxo UTXOSet) FindCoins(lockingKey []byte) []Coin {
+	var coins []Coin
+	err := utxo.blockchain.db.View(func(tx *StorageTx) error {
+		return tx.Chainstate().ForEach(func(op Outpoint, entry UTXOEntry) error {
+			if entry.Output.isLockedWithKey(lockingKey) {
+				coins = append(coins, Coin{Outpoint{append([]byte{}, op.TXid...), op.Vout}, entry})
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+	return coins
+}
+
+// SelectCoins picks the coins of lockingKey a tx of amount spends, and what they are worth
+func (utxo UTXOSet) SelectCoins(lockingKey []byte, amount int, cc *CoinControl) ([]Coin, int, error) {
+	if cc == nil {
+		cc = &CoinControl{}
+	}
+	var selected []Coin
+	if len(cc.Inputs) > 0 {
+		var err error
+		if selected, err = utxo.controlledCoins(lockingKey, cc); err != nil {
+			return nil, 0, err
+		}
+	} else {
+		var coins []Coin
+		for _, c := range utxo.FindCoins(lockingKey) {
+			if !cc.Frozen[c.String()] && !cc.Spent[c.String()] {
+				coins = append(coins, c)
+			}
+		}
+		strategy := cc.Strategy
+		if strategy == "" {
+			strategy = defaultCoinSelection
+		}
+		if selected = coinSelectors[strategy](coins, amount); selected == nil {
+			selected = coins // they do not add up to amount, acc tells how much there is
+		}
+	}
+	acc := 0
+	for _, c := range selected {
+		acc += c.Output.Value
+	}
+	if acc < amount {
+		return nil, acc, errNotEnoughCoins
+	}
+	return selected, acc, nil
+}
+
+// controlledCoins are the coins of send -inputs, each must be unspent, of lockingKey, not frozen and not pending spent
+func (utxo UTXOSet) controlledCoins(lockingKey []byte, cc *CoinControl) ([]Coin, error) {
+	var coins []Coin
+	seen := make(map[string]bool)
+	err := utxo.blockchain.db.View(func(tx *StorageTx) error {
+		for _, op := range cc.Inputs {
+			if seen[op.String()] {
+				return fmt.Errorf("%s is given twice", op)
+			}
+			seen[op.String()] = true
+			entry, ok := tx.Chainstate().Get(op)
+			if !ok {
+				return fmt.Errorf("%s is not unspent", op)
+			}
+			if !entry.Output.isLockedWithKey(lockingKey) {
+				return fmt.Errorf("%s does not belong to the sender", op)
+			}
+			if cc.Frozen[op.String()] {
+				return fmt.Errorf("%s is frozen, unfreeze it first", op)
+			}
+			if cc.Spent[op.String()] {
+				return fmt.Errorf("%s is spent by a pending tx of the wallet", op)
+			}
+			coins = append(coins, Coin{op, entry})
+		}
+		return nil
+	})
+	return coins, err
+}
+
+// takeUntil takes coins in their order until they are worth amount
+func takeUntil(coins []Coin, amount int) []Coin {
+	acc := 0
+	for i, c := range coins {
+		acc += c.Output.Value
+		if acc >= amount {
+			return coins[:i+1]
+		}
+	}
+	return nil
+}
+
+func selectLargestFirst(coins []Coin, amount int) []Coin {
+	sorted := append([]Coin{}, coins...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Output.Value > sorted[j].Output.Value })
+	return takeUntil(sorted, amount)
+}
+
+func selectOldestFirst(coins []Coin, amount int) []Coin {
+	sorted := append([]Coin{}, coins...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Height < sorted[j].Height })
+	return takeUntil(sorted, amount)
+}
+
+func selectRandom(coins []Coin, amount int) []Coin {
+	shuffled := append([]Coin{}, coins...)
+	for i := len(shuffled) - 1; i > 0; i-- { // Fisher-Yates
+		j, err := rand.Int(rand.Reader, big.NewInt(int64(i+1)))
+		if err != nil {
+			log.Panic(err)
+		}
+		shuffled[i], shuffled[j.Int64()] = shuffled[j.Int64()], shuffled[i]
+	}
+	return takeUntil(shuffled, amount)
+}
+
+/*
+selectBranchAndBound searches the subsets of the coins, largest first, for one worth exactly
+amount. A branch is cut when it is worth more than amount, or when the coins left can not make up
+for what is missing. After bnbMaxTries the largest first selection is taken
+*/
+func selectBranchAndBound(coins []Coin, amount int) []Coin {
+	sorted := append([]Coin{}, coins...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Output.Value > sorted[j].Output.Value })
+	left := make([]int, len(sorted)+1) // left[i] is the worth of sorted[i:]
+	for i := len(sorted) - 1; i >= 0; i-- {
+		left[i] = left[i+1] + sorted[i].Output.Value
+	}
+
+	tries := 0
+	var picked []int
+	var search func(i, acc int) bool
+	search = func(i, acc int) bool {
+		if acc == amount {
+			return true
+		}
+		tries++
+		if i == len(sorted) || acc > amount || acc+left[i] < amount || tries > bnbMaxTries {
+			return false
+		}
+		picked = append(picked, i)
+		if search(i+1, acc+sorted[i].Output.Value) {
+			return true
+		}
+		picked = picked[:len(picked)-1]
+		return search(i+1, acc)
+	}
+	if amount > 0 && search(0, 0) {
+		var selected []Coin
+		for _, i := range picked {
+			selected = append(selected, sorted[i])
+		}
+		return selected
+	}
+	return takeUntil(sorted, amount)
+}
+
+// AddressesByLockingKey maps the locking keys (hex) of the wallet's addresses, watch-only too, to the address
+func (wallets *Wallets) AddressesByLockingKey() map[string]string {
+	addresses := make(map[string]string)
+	for address, w := range wallets.Wallets {
+		addresses[hex.EncodeToString(w.LockingKey())] = address
+	}
+	for address, entry := range wallets.Watch {
+		addresses[hex.EncodeToString(entry.LockingKey)] = address
+	}
+	return addresses
+}
+
+// Freeze keeps unspent outputs of the wallet out of coin selection
+func (wallets *Wallets) Freeze(ops []Outpoint, UTXO *UTXOSet) error {
+	mine := wallets.AddressesByLockingKey()
+	err := UTXO.blockchain.db.View(func(tx *StorageTx) error {
+		for _, op := range ops {
+			entry, ok := tx.Chainstate().Get(op)
+			if !ok {
+				return fmt.Errorf("%s is not unspent", op)
+			}
+			if _, found := mine[hex.EncodeToString(entry.Output.PubKeyHash)]; !found {
+				return fmt.Errorf("%s does not belong to the wallet", op)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if wallets.Frozen == nil {
+		wallets.Frozen = make(map[string]bool)
+	}
+	for _, op := range ops {
+		wallets.Frozen[op.String()] = true
+	}
+	return nil
+}
+
+// Unfreeze lets the outpoints back into coin selection
+func (wallets *Wallets) Unfreeze(ops []Outpoint) {
+	for _, op := range ops {
+		delete(wallets.Frozen, op.String())
+	}
+}
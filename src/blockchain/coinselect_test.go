@@ -0,0 +1,120 @@
+package main
+
+import (
+	"testing"
+)
+
+// coinsOf are coins of the values, the first is the oldest
+func coinsOf(values ...int) []Coin {
+	var coins []Coin
+	for i, v := range values {
+		coins = append(coins, Coin{Outpoint{[]byte{byte(i + 1)}, 0}, UTXOEntry{TXOutput{v, nil}, i, false}})
+	}
+	return coins
+}
+
+func coinValues(coins []Coin) []int {
+	var values []int
+	for _, c := range coins {
+		values = append(values, c.Output.Value)
+	}
+	return values
+}
+
+func TestCoinSelectors(t *testing.T) {
+	coins := coinsOf(3, 10, 1, 6, 4)
+	for _, c := range []struct {
+		strategy string
+		amount   int
+		want     []int
+	}{
+		{"oldest", 12, []int{3, 10}},
+		{"largest", 12, []int{10, 6}},
+		{"bnb", 7, []int{6, 1}},
+		{"bnb", 14, []int{10, 4}},
+		{"bnb", 24, []int{10, 6, 4, 3, 1}},
+		{"bnb", 12, []int{10, 6}}, // no exact match, it is largest first
+	} {
+		got := coinValues(coinSelectors[c.strategy](coins, c.amount))
+		if len(got) != len(c.want) {
+			t.Errorf("%s %d: %v, want %v", c.strategy, c.amount, got, c.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != c.want[i] {
+				t.Errorf("%s %d: %v, want %v", c.strategy, c.amount, got, c.want)
+				break
+			}
+		}
+	}
+	for strategy, selector := range coinSelectors {
+		if selected := selector(coins, 25); selected != nil {
+			t.Errorf("%s: selected %v for more than there is", strategy, coinValues(selected))
+		}
+		if total := outputsValue(coinOutputs(selector(coins, 9))); total < 9 {
+			t.Errorf("%s: selected %d for 9", strategy, total)
+		}
+	}
+}
+
+func coinOutputs(coins []Coin) []TXOutput {
+	var outs []TXOutput
+	for _, c := range coins {
+		outs = append(outs, c.Output)
+	}
+	return outs
+}
+
+func TestParseOutpoint(t *testing.T) {
+	op := Outpoint{[]byte{0xab, 0xcd}, 7}
+	back, err := ParseOutpoint(" " + op.String() + " ")
+	if err != nil || back.String() != "abcd:7" {
+		t.Fatalf("parsed %s: %v", back, err)
+	}
+	for _, s := range []string{"abcd", "abcd:", ":1", "xyz:1", "abcd:-1", "abcd:1:2"} {
+		if _, err := ParseOutpoint(s); err == nil {
+			t.Errorf("%q is taken", s)
+		}
+	}
+	ops, err := ParseOutpoints("ab:0, ,cd:1,")
+	if err != nil || len(ops) != 2 || ops[1].Vout != 1 {
+		t.Fatalf("parsed %v: %v", ops, err)
+	}
+	if _, err := NewCoinControl("smallest", "", nil); err == nil {
+		t.Fatal("an unknown strategy is taken")
+	}
+}
+
+func TestSelectCoinsControl(t *testing.T) {
+	bc, w := newTestChain(t)
+	if _, err := bc.MineBlock([]*Transaction{NewCoinbaseTX(string(w.GetAddress()), "")}); err != nil {
+		t.Fatal(err)
+	}
+	UTXO := UTXOSet{bc}
+	coins := UTXO.FindCoins(w.LockingKey())
+	if len(coins) != 2 {
+		t.Fatalf("%d coins", len(coins))
+	}
+	all := outputsValue(coinOutputs(coins))
+	if _, acc, err := UTXO.SelectCoins(w.LockingKey(), all+1, nil); err != errNotEnoughCoins || acc != all {
+		t.Fatalf("%d spendable of %d: %v", acc, all, err)
+	}
+
+	frozen := &CoinControl{Frozen: map[string]bool{coins[0].String(): true}}
+	selected, _, err := UTXO.SelectCoins(w.LockingKey(), 1, frozen)
+	if err != nil || len(selected) != 1 || selected[0].String() != coins[1].String() {
+		t.Fatalf("selected a frozen coin: %v", err)
+	}
+	if _, _, err := UTXO.SelectCoins(w.LockingKey(), 1, &CoinControl{Inputs: []Outpoint{coins[0].Outpoint}, Frozen: frozen.Frozen}); err == nil {
+		t.Fatal("a frozen input is spent")
+	}
+	if _, _, err := UTXO.SelectCoins(w.LockingKey(), 1, &CoinControl{Inputs: []Outpoint{coins[1].Outpoint, coins[1].Outpoint}}); err == nil {
+		t.Fatal("an input is spent twice")
+	}
+	if _, _, err := UTXO.SelectCoins(NewWallet().LockingKey(), 1, &CoinControl{Inputs: []Outpoint{coins[1].Outpoint}}); err == nil {
+		t.Fatal("an input of another key is spent")
+	}
+	if selected, acc, err := UTXO.SelectCoins(w.LockingKey(), 1, &CoinControl{Inputs: []Outpoint{coins[1].Outpoint}}); err != nil || len(selected) != 1 || acc != coins[1].Output.Value {
+		t.Fatalf("the given input is not spent: %v", err)
+	}
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+)
+
+/*
+An HD wallet derives all of its keys from one seed, so the mnemonic of the seed is a backup of
+every address it hands out. Keys are derived like BIP-32, on the P-256 curve the way SLIP-10 does
+it for nist256p1:
+
+	master   I = HMAC-SHA512("Nist256p1 seed", seed)
+	child i  I = HMAC-SHA512(chain code, 0x00 | key | i)             hardened, i >= 2^31
+	         I = HMAC-SHA512(chain code, compressed public key | i)  normal
+	         key = IL + parent key mod n, chain code = IR
+
+When IL is not below n or the key is 0 the derivation is done again with 0x01 | IR | i as data.
+A normal child of a public key is IL*G + parent point, so public keys can be derived without the
+private ones.
+
+Addresses are at m/44'/1'/0'/chain/index, chain 0 hands out receiving addresses and chain 1 the
+change of sent transactions.
+
+An extended public key is written like a BIP-32 one, Base58 with a checksum:
+
+	version 4 | depth 1 | parent fingerprint 4 | index 4 | chain code 32 | compressed key 33
+
+The account key of a locked wallet has no parent fingerprint (0), it is not needed to derive.
+*/
+const hdHardened = uint32(1) << 31
+const hdReceiveChain = 0
+const hdChangeChain = 1
+const hdGapLimit = 20 // unused addresses in a row after which discovery stops
+
+var hdAccountPath = []uint32{44 + hdHardened, 1 + hdHardened, 0 + hdHardened}
+
+var xpubVersion = []byte{0x04, 0x88, 0xb2, 0x1e}
+
+var errHardenedFromPublic = errors.New("a hardened child can not be derived from a public key")
+var errBadExtendedKey = errors.New("not a valid extended public key")
+
+type ExtendedKey struct {
+	Key       []byte // private key, nil for a public only key
+	PubKey    []byte // compressed public key
+	ChainCode []byte
+	Depth     byte
+	ParentFP  []byte // first 4 bytes of the parent's key hash
+	Index     uint32
+}
+
+func NewMasterKey(seed []byte) *ExtendedKey {
+	data := seed
+	for {
+		mac := hmac.New(sha512.New, []byte("Nist256p1 seed"))
+		mac.Write(data)
+		I := mac.Sum(nil)
+		if k := new(big.Int).SetBytes(I[:32]); k.Sign() > 0 && k.Cmp(elliptic.P256().Params().N) < 0 {
+			return newPrivateExtendedKey(I[:32], I[32:], 0, make([]byte, 4), 0)
+		}
+		data = I
+	}
+}
+
+func newPrivateExtendedKey(key, chainCode []byte, depth byte, parentFP []byte, index uint32) *ExtendedKey {
+	curve := elliptic.P256()
+	x, y := curve.ScalarBaseMult(key)
+	return &ExtendedKey{key, elliptic.MarshalCompressed(curve, x, y), chainCode, depth, parentFP, index}
+}
+
+func (k *ExtendedKey) IsPrivate() bool {
+	return k.Key != nil
+}
+
+func (k *ExtendedKey) Fingerprint() []byte {
+	return HashPubKey(k.PubKey)[:4]
+}
+
+// Child derives child i, hardened when i >= hdHardened
+func (k *ExtendedKey) Child(i uint32) (*ExtendedKey, error) {
+	if i >= hdHardened && !k.IsPrivate() {
+		return nil, errHardenedFromPublic
+	}
+	curve := elliptic.P256()
+	n := curve.Params().N
+	index := make([]byte, 4)
+	binary.BigEndian.PutUint32(index, i)
+
+	var data []byte
+	if i >= hdHardened {
+		data = append(append([]byte{0}, k.Key...), index...)
+	} else {
+		data = append(append([]byte{}, k.PubKey...), index...)
+	}
+	for {
+		mac := hmac.New(sha512.New, k.ChainCode)
+		mac.Write(data)
+		I := mac.Sum(nil)
+		IL := new(big.Int).SetBytes(I[:32])
+		retry := append(append([]byte{1}, I[32:]...), index...)
+		if IL.Cmp(n) >= 0 {
+			data = retry
+			continue
+		}
+		if k.IsPrivate() {
+			child := IL.Add(IL, new(big.Int).SetBytes(k.Key))
+			child.Mod(child, n)
+			if child.Sign() == 0 {
+				data = retry
+				continue
+			}
+			return newPrivateExtendedKey(child.FillBytes(make([]byte, 32)), I[32:], k.Depth+1, k.Fingerprint(), i), nil
+		}
+		px, py := elliptic.UnmarshalCompressed(curve, k.PubKey)
+		if px == nil {
+			return nil, errors.New("bad public key in extended key")
+		}
+		x, y := curve.ScalarBaseMult(I[:32])
+		x, y = curve.Add(x, y, px, py)
+		if x.Sign() == 0 && y.Sign() == 0 { // the point at infinity
+			data = retry
+			continue
+		}
+		return &ExtendedKey{nil, elliptic.MarshalCompressed(curve, x, y), I[32:], k.Depth + 1, k.Fingerprint(), i}, nil
+	}
+}
+
+func (k *ExtendedKey) Derive(path []uint32) (*ExtendedKey, error) {
+	key := k
+	for _, i := range path {
+		var err error
+		if key, err = key.Child(i); err != nil {
+			return nil, err
+		}
+	}
+	return key, nil
+}
+
+// Neuter is the public only key of k
+func (k *ExtendedKey) Neuter() *ExtendedKey {
+	return &ExtendedKey{nil, k.PubKey, k.ChainCode, k.Depth, k.ParentFP, k.Index}
+}
+
+// String is the extended public key of k
+func (k *ExtendedKey) String() string {
+	var buff bytes.Buffer
+	buff.Write(xpubVersion)
+	buff.WriteByte(k.Depth)
+	buff.Write(k.ParentFP)
+	index := make([]byte, 4)
+	binary.BigEndian.PutUint32(index, k.Index)
+	buff.Write(index)
+	buff.Write(k.ChainCode)
+	buff.Write(k.PubKey)
+	payload := buff.Bytes()
+	return string(Base58Encode(append(payload, CheckSum(payload)...)))
+}
+
+func ParseExtendedPublicKey(s string) (*ExtendedKey, error) {
+	data := Base58Decode([]byte(s))
+	if len(data) != 4+1+4+4+32+33+addressChecksumLen {
+		return nil, errBadExtendedKey
+	}
+	payload, checksum := data[:len(data)-addressChecksumLen], data[len(data)-addressChecksumLen:]
+	if !bytes.Equal(CheckSum(payload), checksum) || !bytes.Equal(payload[:4], xpubVersion) {
+		return nil, errBadExtendedKey
+	}
+	k := &ExtendedKey{
+		PubKey:    payload[45:],
+		ChainCode: payload[13:45],
+		Depth:     payload[4],
+		ParentFP:  payload[5:9],
+		Index:     binary.BigEndian.Uint32(payload[9:13]),
+	}
+	if x, _ := elliptic.UnmarshalCompressed(elliptic.P256(), k.PubKey); x == nil {
+		return nil, errBadExtendedKey
+	}
+	return k, nil
+}
+
+// Wallet is a wallet with the key of k, Path tells where it comes from
+func (k *ExtendedKey) Wallet(path []uint32) *Wallet {
+	curve := elliptic.P256()
+	x, y := elliptic.UnmarshalCompressed(curve, k.PubKey)
+	w := &Wallet{PublicKey: MarshalPubKey(&ecdsa.PublicKey{Curve: curve, X: x, Y: y}), Path: path}
+	if k.IsPrivate() {
+		w.PrivateKey = ecdsa.PrivateKey{PublicKey: ecdsa.PublicKey{Curve: curve, X: x, Y: y}, D: new(big.Int).SetBytes(k.Key)}
+	}
+	return w
+}
+
+// FormatPath writes a path like m/44'/1'/0'/0/3
+func FormatPath(path []uint32) string {
+	var buff bytes.Buffer
+	buff.WriteString("m")
+	for _, i := range path {
+		if i >= hdHardened {
+			fmt.Fprintf(&buff, "/%d'", i-hdHardened)
+		} else {
+			fmt.Fprintf(&buff, "/%d", i)
+		}
+	}
+	return buff.String()
+}
+
+// HDState is the seed of the HD wallet in a wallet file and how far each chain has handed out addresses
+type HDState struct {
+	Seed          []byte // nil while an encrypted wallet is locked
+	EncryptedSeed []byte
+	Account       []byte // compressed public key | chain code of the account, to hand out addresses while locked
+	Next          [2]int // next index of the receive and the change chain
+}
+
+func newHDState(seed []byte) *HDState {
+	hd := &HDState{Seed: seed}
+	account, err := NewMasterKey(seed).Derive(hdAccountPath)
+	if err != nil {
+		log.Panic(err)
+	}
+	hd.Account = append(append([]byte{}, account.PubKey...), account.ChainCode...)
+	return hd
+}
+
+func (hd *HDState) seal(masterKey []byte) {
+	if hd.Account == nil { // written before encryption existed
+		hd.Account = newHDState(hd.Seed).Account
+	}
+	hd.EncryptedSeed = seal(masterKey, hd.Seed, nil)
+}
+
+func (hd *HDState) path(chain, index int) []uint32 {
+	return append(append([]uint32{}, hdAccountPath...), uint32(chain), uint32(index))
+}
+
+// AccountKey is the extended public key of the account, what a watch-only node imports
+func (hd *HDState) AccountKey() *ExtendedKey {
+	if hd.Seed != nil {
+		account, err := NewMasterKey(hd.Seed).Derive(hdAccountPath)
+		if err != nil {
+			log.Panic(err)
+		}
+		return account.Neuter()
+	}
+	last := hdAccountPath[len(hdAccountPath)-1]
+	return &ExtendedKey{nil, hd.Account[:33], hd.Account[33:], byte(len(hdAccountPath)), make([]byte, 4), last}
+}
+
+// wallet is the key at chain/index, without its private key while the seed is locked away
+func (hd *HDState) wallet(chain, index int) *Wallet {
+	path := hd.path(chain, index)
+	var key *ExtendedKey
+	var err error
+	if hd.Seed != nil {
+		key, err = NewMasterKey(hd.Seed).Derive(path)
+	} else {
+		key, err = hd.AccountKey().Derive(path[len(hdAccountPath):])
+	}
+	if err != nil {
+		log.Panic(err)
+	}
+	return key.Wallet(path)
+}
+
+// discoverChains derives the receive and change chains until hdGapLimit keys in a row are unused
+func discoverChains(lockingKey func(chain, index int) []byte, used map[string]bool) [2]int {
+	var next [2]int
+	for _, chain := range []int{hdReceiveChain, hdChangeChain} {
+		for index, gap := 0, 0; gap < hdGapLimit; index++ {
+			if used[hex.EncodeToString(lockingKey(chain, index))] {
+				next[chain], gap = index+1, 0
+			} else {
+				gap++
+			}
+		}
+	}
+	return next
+}
+
+/*
+LockingKeysInUse are the locking keys (hex) of all outputs in the chain, the spent ones too. On a
+pruned node only the outputs of the kept blocks and the unspent ones are known
+*/
+func (bc *BlockChain) LockingKeysInUse() map[string]bool {
+	used := make(map[string]bool)
+	for _, b := range bc.lastBlocks(0) {
+		for _, tx := range b.Transactions {
+			for _, out := range tx.VOut {
+				used[hex.EncodeToString(out.PubKeyHash)] = true
+			}
+		}
+	}
+	err := bc.db.View(func(tx *StorageTx) error {
+		return tx.Chainstate().ForEach(func(_ Outpoint, entry UTXOEntry) error {
+			used[hex.EncodeToString(entry.Output.PubKeyHash)] = true
+			return nil
+		})
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+	return used
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"testing"
+)
+
+type slip10Step struct {
+	index                     uint32
+	chainCode, key, publicKey string
+}
+
+// the nist256p1 vectors of SLIP-10, the first step is the master key
+var slip10Vectors = []struct {
+	seed  string
+	steps []slip10Step
+}{
+	{"000102030405060708090a0b0c0d0e0f", []slip10Step{
+		{0, "beeb672fe4621673f722f38529c07392fecaa61015c80c34f29ce8b41b3cb6ea",
+			"612091aaa12e22dd2abef664f8a01a82cae99ad7441b7ef8110424915c268bc2",
+			"0266874dc6ade47b3ecd096745ca09bcd29638dd52c2c12117b11ed3e458cfa9e8"},
+		{0 + hdHardened, "3460cea53e6a6bb5fb391eeef3237ffd8724bf0a40e94943c98b83825342ee11",
+			"6939694369114c67917a182c59ddb8cafc3004e63ca5d3b84403ba8613debc0c",
+			"0384610f5ecffe8fda089363a41f56a5c7ffc1d81b59a612d0d649b2d22355590c"},
+		{1, "4187afff1aafa8445010097fb99d23aee9f599450c7bd140b6826ac22ba21d0c",
+			"284e9d38d07d21e4e281b645089a94f4cf5a5a81369acf151a1c3a57f18b2129",
+			"03526c63f8d0b4bbbf9c80df553fe66742df4676b241dabefdef67733e070f6844"},
+		{2 + hdHardened, "98c7514f562e64e74170cc3cf304ee1ce54d6b6da4f880f313e8204c2a185318",
+			"694596e8a54f252c960eb771a3c41e7e32496d03b954aeb90f61635b8e092aa7",
+			"0359cf160040778a4b14c5f4d7b76e327ccc8c4a6086dd9451b7482b5a4972dda0"},
+		{2, "ba96f776a5c3907d7fd48bde5620ee374d4acfd540378476019eab70790c63a0",
+			"5996c37fd3dd2679039b23ed6f70b506c6b56b3cb5e424681fb0fa64caf82aaa",
+			"029f871f4cb9e1c97f9f4de9ccd0d4a2f2a171110c61178f84430062230833ff20"},
+		{1000000000, "b9b7b82d326bb9cb5b5b121066feea4eb93d5241103c9e7a18aad40f1dde8059",
+			"21c4f269ef0a5fd1badf47eeacebeeaa3de22eb8e5b0adcd0f27dd99d34d0119",
+			"02216cd26d31147f72427a453c443ed2cde8a1e53c9cc44e5ddf739725413fe3f4"},
+	}},
+	// derivation retry: IL of the second child is not below n
+	{"000102030405060708090a0b0c0d0e0f", []slip10Step{
+		{0, "beeb672fe4621673f722f38529c07392fecaa61015c80c34f29ce8b41b3cb6ea",
+			"612091aaa12e22dd2abef664f8a01a82cae99ad7441b7ef8110424915c268bc2",
+			"0266874dc6ade47b3ecd096745ca09bcd29638dd52c2c12117b11ed3e458cfa9e8"},
+		{28578 + hdHardened, "e94c8ebe30c2250a14713212f6449b20f3329105ea15b652ca5bdfc68f6c65c2",
+			"06f0db126f023755d0b8d86d4591718a5210dd8d024e3e14b6159d63f53aa669",
+			"02519b5554a4872e8c9c1c847115363051ec43e93400e030ba3c36b52a3e70a5b7"},
+		{33941, "9e87fe95031f14736774cd82f25fd885065cb7c358c1edf813c72af535e83071",
+			"092154eed4af83e078ff9b84322015aefe5769e31270f62c3f66c33888335f3a",
+			"0235bfee614c0d5b2cae260000bb1d0d84b270099ad790022c1ae0b2e782efe120"},
+	}},
+	// seed retry: the first master key is not below n
+	{"a7305bc8df8d0951f0cb224c0e95d7707cbdf2c6ce7e8d481fec69c7ff5e9446", []slip10Step{
+		{0, "7762f9729fed06121fd13f326884c82f59aa95c57ac492ce8c9654e60efd130c",
+			"3b8c18469a4634517d6d0b65448f8e6c62091b45540a1743c5846be55d47d88f",
+			"0383619fadcde31063d8c5cb00dbfe1713f3e6fa169d8541a798752a1c1ca0cb20"},
+	}},
+}
+
+func TestSLIP10Vectors(t *testing.T) {
+	for _, v := range slip10Vectors {
+		key := NewMasterKey(mustHex(t, v.seed))
+		for i, step := range v.steps {
+			if i > 0 {
+				var err error
+				if key, err = key.Child(step.index); err != nil {
+					t.Fatal(err)
+				}
+			}
+			name := fmt.Sprintf("%s step %d", v.seed, i)
+			if hex.EncodeToString(key.ChainCode) != step.chainCode {
+				t.Errorf("%s: chain code %x", name, key.ChainCode)
+			}
+			if hex.EncodeToString(key.Key) != step.key {
+				t.Errorf("%s: key %x", name, key.Key)
+			}
+			if hex.EncodeToString(key.PubKey) != step.publicKey {
+				t.Errorf("%s: public key %x", name, key.PubKey)
+			}
+		}
+	}
+}
+
+func TestPublicDerivation(t *testing.T) {
+	account, err := NewMasterKey(mustHex(t, slip10Vectors[0].seed)).Derive(hdAccountPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := []uint32{hdChangeChain, 7}
+	private, err := account.Derive(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	public, err := account.Neuter().Derive(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(private.PubKey, public.PubKey) || !bytes.Equal(private.ChainCode, public.ChainCode) {
+		t.Fatal("the public derivation gives another key")
+	}
+	if _, err := account.Neuter().Child(hdHardened); err != errHardenedFromPublic {
+		t.Fatalf("hardened child of a public key: %v", err)
+	}
+}
+
+func TestExtendedPublicKeyRoundTrip(t *testing.T) {
+	hd := newHDState(mustHex(t, slip10Vectors[0].seed))
+	xpub := hd.AccountKey().String()
+	parsed, err := ParseExtendedPublicKey(xpub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.String() != xpub || parsed.IsPrivate() {
+		t.Fatalf("%s came back as %s", xpub, parsed)
+	}
+	// a locked wallet derives the same addresses from the account key
+	locked := &HDState{Account: hd.Account}
+	if !bytes.Equal(locked.wallet(hdReceiveChain, 3).LockingKey(), hd.wallet(hdReceiveChain, 3).LockingKey()) {
+		t.Fatal("the account key derives another address")
+	}
+	broken := []byte(xpub)
+	broken[len(broken)-1] ^= 1
+	if _, err := ParseExtendedPublicKey(string(broken)); err == nil {
+		t.Fatal("an extended key with a bad checksum parses")
+	}
+}
+
+func TestDiscoverChains(t *testing.T) {
+	key := func(chain, index int) []byte { return []byte{byte(chain), byte(index)} }
+	used := map[string]bool{
+		hex.EncodeToString(key(hdReceiveChain, 0)):              true,
+		hex.EncodeToString(key(hdReceiveChain, hdGapLimit)):     true, // found after a gap of 19
+		hex.EncodeToString(key(hdReceiveChain, 2*hdGapLimit+2)): true, // beyond the gap limit
+		hex.EncodeToString(key(hdChangeChain, 4)):               true,
+	}
+	next := discoverChains(key, used)
+	if next != [2]int{hdGapLimit + 1, 5} {
+		t.Fatalf("next indexes %v", next)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+)
+
+/*
+Signed messages prove the key of an address without spending from it. The signed hash is a
+tagged hash of the message, its input starts with sha256(messageTag) twice, which the input
+of no transaction sighash does, so a message signature can never be replayed as an input signature.
+
+The signature is base64 of
+
+	recid || r || s   65 bytes, for key hash addresses. The public key is recovered from it
+	                  and its HashPubKey compared with the address
+	R.x || s          64 bytes, BIP-340 for Schnorr addresses, checked with the key of the address
+*/
+const messageTag = "BabyBlockChain/message"
+
+var errMessageNoKey = errors.New("the address has no key in the wallet to sign with")
+
+func MessageHash(message string) []byte {
+	return taggedHash(messageTag, []byte(message))
+}
+
+// SignMessage signs message with the key of w, a locked wallet has to be unlocked first
+func SignMessage(w Wallet, message string) (string, error) {
+	if !w.CanSign() {
+		return "", errWalletLocked
+	}
+	hash := MessageHash(message)
+	if w.IsSchnorr() {
+		sig, err := SchnorrSign(w.SchnorrKey, hash, nil)
+		if err != nil {
+			return "", err
+		}
+		return base64.StdEncoding.EncodeToString(sig), nil
+	}
+	sig := signECDSA(&w.PrivateKey, hash)
+	for recid := byte(0); recid < 4; recid++ {
+		pub, err := recoverECDSA(hash, sig, recid)
+		if err == nil && bytes.Equal(MarshalPubKey(pub), w.PublicKey) {
+			return base64.StdEncoding.EncodeToString(append([]byte{recid}, sig...)), nil
+		}
+	}
+	return "", errors.New("the public key of the signature can not be recovered")
+}
+
+/*
+VerifyMessage tells whether signature was made for message by the key of address. An error
+is a malformed address or signature, a well formed signature by another key is just false
+*/
+func VerifyMessage(address, signature, message string) (bool, error) {
+	lockingKey, err := DecodeAddress(address)
+	if err != nil {
+		return false, err
+	}
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return false, errors.New("the signature is not base64")
+	}
+	hash := MessageHash(message)
+	switch {
+	case len(lockingKey) == schnorrKeyLen && len(sig) == schnorrSigLen:
+		return SchnorrVerify(lockingKey, hash, sig), nil
+	case len(lockingKey) == ripemd160Size && len(sig) == 1+2*sigScalarLen:
+		pub, err := recoverECDSA(hash, sig[1:], sig[0])
+		if err != nil { // no key at all, it is not the one of the address either
+			return false, nil
+		}
+		return bytes.Equal(HashPubKey(MarshalPubKey(pub)), lockingKey), nil
+	}
+	return false, errBadSignature
+}
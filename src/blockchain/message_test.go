@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"testing"
+)
+
+func TestRecoverECDSA(t *testing.T) {
+	priv := rfc6979TestKey(t)
+	hash := sha256.Sum256([]byte("test"))
+	sig := signECDSA(priv, hash[:])
+	found := false
+	for recid := byte(0); recid < 4; recid++ {
+		pub, err := recoverECDSA(hash[:], sig, recid)
+		if err == nil && bytes.Equal(MarshalPubKey(pub), MarshalPubKey(&priv.PublicKey)) {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatal("no recid recovers the public key")
+	}
+}
+
+func TestSignVerifyMessage(t *testing.T) {
+	wallets := &Wallets{Wallets: make(map[string]*Wallet)}
+	other := wallets.CreateWallet()
+	for _, address := range []string{wallets.CreateWallet(), wallets.CreateSchnorrWallet()} {
+		signature, err := SignMessage(*wallets.Wallets[address], "hello")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok, err := VerifyMessage(address, signature, "hello"); err != nil || !ok {
+			t.Fatalf("%s: the signature does not verify: %v", address, err)
+		}
+		if ok, err := VerifyMessage(address, signature, "hello!"); err != nil || ok {
+			t.Fatalf("%s: the signature verifies another message: %v", address, err)
+		}
+		if ok, _ := VerifyMessage(other, signature, "hello"); ok {
+			t.Fatalf("%s: the signature verifies for another address", address)
+		}
+		sig, _ := base64.StdEncoding.DecodeString(signature)
+		sig[len(sig)-1] ^= 1
+		if ok, _ := VerifyMessage(address, base64.StdEncoding.EncodeToString(sig), "hello"); ok {
+			t.Fatalf("%s: a tampered signature verifies", address)
+		}
+		if _, err := VerifyMessage(address, base64.StdEncoding.EncodeToString(sig[1:]), "hello"); err != errBadSignature {
+			t.Fatalf("%s: a short signature: %v", address, err)
+		}
+	}
+	if _, err := VerifyMessage(other, "not base64!", "hello"); err == nil {
+		t.Fatal("a signature that is not base64 is taken")
+	}
+
+	if err := wallets.EncryptWallet("pw"); err != nil {
+		t.Fatal(err)
+	}
+	wallets.Lock()
+	if _, err := SignMessage(*wallets.Wallets[other], "hello"); err != errWalletLocked {
+		t.Fatalf("a locked wallet signs: %v", err)
+	}
+}
+
+// the message hash is tagged, not the plain sha256 of the message
+func TestMessageHashIsTagged(t *testing.T) {
+	if bytes.Equal(MessageHash("hello"), MessageHash("hello ")) {
+		t.Fatal("two messages hash alike")
+	}
+	plain := sha256.Sum256([]byte("hello"))
+	if bytes.Equal(MessageHash("hello"), plain[:]) {
+		t.Fatal("the message hash is not tagged")
+	}
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"bytes"
+	"crypto/elliptic"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+)
+
+// the gob layout used before the canonical encoding, only needed to read old db files
+type legacyTXInput struct {
+	TXid      []byte
+	Vout      int
+	Signature []byte
+	PubKey    []byte
+}
+
+type legacyTXOutput struct {
+	Value      int
+	PubKeyHash []byte
+}
+
+type legacyTransaction struct {
+	ID   []byte
+	VIn  []legacyTXInput
+	VOut []legacyTXOutput
+}
+
+type legacyBlock struct {
+	Timestamp     int64
+	Transactions  []*legacyTransaction
+	PrevBlockHash []byte
+	Hash          []byte
+	Nonce         int
+	Height        int
+}
+
+/*
+the gob layout of wallet files before Wallet.GobEncode: the ecdsa key as it is, with the curve
+behind an interface that gob knows by the type name Go 1.15 gave it. Only P-256 was ever used
+*/
+type legacyCurve struct {
+	CurveParams *elliptic.CurveParams
+}
+
+type legacyWallet struct {
+	PrivateKey struct {
+		PublicKey struct {
+			Curve interface{}
+			X, Y  *big.Int
+		}
+		D *big.Int
+	}
+	PublicKey []byte
+}
+
+type legacyWallets struct {
+	Wallets map[string]*legacyWallet
+}
+
+func init() {
+	gob.RegisterName("crypto/elliptic.p256Curve", legacyCurve{})
+}
+
+// decodeLegacyWallets reads a wallet file from before the canonical encoding
+func decodeLegacyWallets(data []byte) (map[string]*Wallet, error) {
+	var lws legacyWallets
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&lws); err != nil {
+		return nil, err
+	}
+	ws := make(map[string]*Wallet)
+	for address, lw := range lws.Wallets {
+		if lw.PrivateKey.D == nil {
+			return nil, fmt.Errorf("the key of %s is missing", address)
+		}
+		w := &Wallet{}
+		w.setECDSAKey(lw.PrivateKey.D.FillBytes(make([]byte, sigScalarLen)))
+		// the old public key dropped leading zero bytes of X and Y, the fixed width one may get another address
+		w.PublicKey = MarshalPubKey(&w.PrivateKey.PublicKey)
+		ws[address] = w
+	}
+	return ws, nil
+}
+
+func deserializeLegacyBlock(data []byte) (*legacyBlock, error) {
+	var b legacyBlock
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&b)
+	if err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// isLegacyDB tells whether the tip block of a db file is still gob encoded
+func isLegacyDB(db *Storage) bool {
+	legacy := false
+	err := db.View(func(tx *StorageTx) error {
+		bucket := tx.kv.Bucket([]byte(blocksBucket)) // gob blocks only ever lived in the blocks bucket
+		if bucket == nil {
+			return nil
+		}
+		tipData := bucket.Get(bucket.Get([]byte("l")))
+		if tipData == nil {
+			return nil
+		}
+		if _, err := DeserializeBlock(tipData); err != nil {
+			if _, err = deserializeLegacyBlock(tipData); err == nil {
+				legacy = true
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+	return legacy
+}
+
+/*
+MigrateDB rewrites a gob encoded blockchain_<node>.db with the canonical encoding.
+Transaction IDs and block hashes are defined over the new encoding, so every tx gets a new ID,
+inputs are pointed at the new IDs and every block is mined again on top of its migrated parent.
+Old signatures don't match the new sighash: with resign, inputs whose key is in one of the wallets
+of keyIDs are signed again (passphrase unlocks encrypted ones). When any input is left with its old
+signature nothing is written, such a chain would not verify.
+The old file is kept next to the new one with a .legacy suffix.
+*/
+func MigrateDB(nodeID string, resign bool, keyIDs []string, passphrase string) {
+	thisdbFile := fmt.Sprintf(dbFile, nodeID)
+	legacyFile := thisdbFile + ".legacy"
+	tmpFile := thisdbFile + ".migrating"
+	if !dbExists(thisdbFile) {
+		fmt.Println("No existing blockchain found.")
+		os.Exit(1)
+	}
+
+	oldDB := openStorage(thisdbFile)
+	if !isLegacyDB(oldDB) {
+		oldDB.Close()
+		fmt.Println("Blockchain is already in the current format.")
+		return
+	}
+
+	// collect the active chain from the tip back to the genesis block
+	var legacyChain []*legacyBlock
+	err := oldDB.View(func(tx *StorageTx) error {
+		bucket := tx.kv.Bucket([]byte(blocksBucket))
+		hash := bucket.Get([]byte("l"))
+		for len(hash) > 0 {
+			b, err := deserializeLegacyBlock(bucket.Get(hash))
+			if err != nil {
+				return err
+			}
+			legacyChain = append(legacyChain, b)
+			hash = b.PrevBlockHash
+		}
+		return nil
+	})
+	oldDB.Close()
+	if err != nil {
+		log.Panic(err)
+	}
+
+	keys := make(map[string]*Wallet) // hex pubkey -> wallet able to sign again
+	if resign {
+		var all []*Wallet
+		for _, id := range keyIDs {
+			wallets, err := NewWallets(id)
+			if err != nil {
+				log.Panic(err)
+			}
+			wallets.unlockWith(passphrase)
+			for _, w := range wallets.Wallets {
+				all = append(all, w)
+			}
+		}
+		for _, w := range all {
+			if w.IsSchnorr() {
+				continue // there were no Schnorr keys before the migration
+			}
+			keys[hex.EncodeToString(w.PublicKey)] = w
+			// the old public key, X and Y without their leading zero bytes
+			legacyPub := append(w.PrivateKey.X.Bytes(), w.PrivateKey.Y.Bytes()...)
+			keys[hex.EncodeToString(legacyPub)] = w
+		}
+	}
+
+	newIDs := make(map[string][]byte) // old txid -> new txid
+	migrated := make(map[string]Transaction)
+	var blocks []*block
+	var prevHash []byte
+	resigned, unsigned := 0, 0
+	for i := len(legacyChain) - 1; i >= 0; i-- {
+		old := legacyChain[i]
+		pending := old.Transactions
+		var txs []*Transaction
+		// a tx may spend one that comes later in the same block, so keep going until all are placed
+		for len(pending) > 0 {
+			var waiting []*legacyTransaction
+			for _, ltx := range pending {
+				tx, ok := migrateTransaction(ltx, newIDs)
+				if !ok {
+					waiting = append(waiting, ltx)
+					continue
+				}
+				if !tx.isCoinbaseTX() {
+					signed := resignInputs(tx, keys, migrated)
+					resigned += signed
+					unsigned += len(tx.VIn) - signed
+				}
+				tx.ID = tx.Hash()
+				newIDs[hex.EncodeToString(ltx.ID)] = tx.ID
+				migrated[hex.EncodeToString(tx.ID)] = *tx
+				txs = append(txs, tx)
+			}
+			if len(waiting) == len(pending) {
+				log.Panicf("block %x spends unknown transactions", old.Hash)
+			}
+			pending = waiting
+		}
+		// the witnesses of the signed inputs change the coinbase and so its ID
+		if coinbase := findCoinbase(txs); coinbase != nil {
+			oldID := coinbase.ID
+			AddWitnessCommitment(txs)
+			for id, newID := range newIDs {
+				if bytes.Equal(newID, oldID) {
+					newIDs[id] = coinbase.ID
+				}
+			}
+			delete(migrated, hex.EncodeToString(oldID))
+			migrated[hex.EncodeToString(coinbase.ID)] = *coinbase
+		}
+
+		b := &block{old.Timestamp, txs, prevHash, []byte{}, 0, old.Height}
+		pow := NewProofOfWork(b)
+		b.Nonce, b.Hash = pow.Run()
+		prevHash = b.Hash
+		blocks = append(blocks, b)
+	}
+
+	if unsigned > 0 {
+		// the signatures of the old format don't verify, a chain with them would be rejected by every node
+		fmt.Printf("%d of %d inputs have legacy signatures and no key in the wallet to sign them again.\n",
+			unsigned, resigned+unsigned)
+		fmt.Println("Run migratedb -resign -wallets with the wallets that have their keys, the database was left as it is.")
+		os.Exit(1)
+	}
+
+	os.Remove(tmpFile)
+	os.RemoveAll(blockDir(tmpFile))
+	newDB := openStorage(tmpFile)
+	err = newDB.Update(func(tx *StorageTx) error {
+		for _, b := range blocks {
+			if err := tx.Blocks().Put(b); err != nil {
+				return err
+			}
+		}
+		return tx.Blocks().SetTip(prevHash)
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+	bc := &BlockChain{tip: prevHash, db: newDB}
+	bc.ReindexChain()
+	UTXOSet{bc}.Reindex()
+	newDB.Close()
+
+	if err = os.Rename(thisdbFile, legacyFile); err != nil {
+		log.Panic(err)
+	}
+	if err = os.Rename(tmpFile, thisdbFile); err != nil {
+		log.Panic(err)
+	}
+	os.Remove(blockDir(thisdbFile)) // left empty by opening the legacy db
+	if err = os.Rename(blockDir(tmpFile), blockDir(thisdbFile)); err != nil && !os.IsNotExist(err) {
+		log.Panic(err)
+	}
+	fmt.Printf("Migrated %d blocks, %d inputs signed again.\n", len(blocks), resigned)
+	fmt.Printf("The old database was kept as %s\n", legacyFile)
+}
+
+/*
+resignInputs replaces the legacy signature of each input whose key is in keys with a witness,
+and returns how many it signed. An input is signed only when the witness key hashes to the
+output it spends
+*/
+func resignInputs(tx *Transaction, keys map[string]*Wallet, prevTXs map[string]Transaction) int {
+	if len(tx.Witness) != len(tx.VIn) {
+		tx.Witness = make([]TXWitness, len(tx.VIn))
+	}
+	signed := 0
+	for i, vin := range tx.VIn {
+		w, found := keys[hex.EncodeToString(vin.PubKey)]
+		if !found {
+			continue
+		}
+		prevOut := prevTXs[hex.EncodeToString(vin.TXid)].VOut[vin.Vout]
+		pubKey := MarshalPubKey(&w.PrivateKey.PublicKey)
+		if !bytes.Equal(HashPubKey(pubKey), prevOut.PubKeyHash) {
+			continue // locked to the short form of the key, no witness can match it
+		}
+		sigHash, err := tx.SignatureHash(i, prevOut, SigHashAll)
+		if err != nil {
+			log.Panic(err)
+		}
+		tx.VIn[i].Signature = nil
+		tx.VIn[i].PubKey = nil
+		tx.Witness[i] = TXWitness{EncodeSignature(signECDSA(&w.PrivateKey, sigHash), SigHashAll), pubKey}
+		signed++
+	}
+	return signed
+}
+
+// returns false when an input refers to a tx that has not been migrated yet
+func migrateTransaction(ltx *legacyTransaction, newIDs map[string][]byte) (*Transaction, bool) {
+	tx := &Transaction{}
+	for _, in := range ltx.VIn { // old-style inputs, Sign moves them to the witness
+		txid := in.TXid
+		if in.Vout != -1 {
+			newID, ok := newIDs[hex.EncodeToString(in.TXid)]
+			if !ok {
+				return nil, false
+			}
+			txid = newID
+		}
+		tx.VIn = append(tx.VIn, TXInput{txid, in.Vout, in.Signature, in.PubKey})
+	}
+	for _, out := range ltx.VOut {
+		tx.VOut = append(tx.VOut, TXOutput{out.Value, out.PubKeyHash})
+	}
+	return tx, true
+}
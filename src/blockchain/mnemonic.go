@@ -0,0 +1,106 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+/*
+A mnemonic writes the entropy of a seed as words, in the way of BIP-39: the entropy is followed by
+the first bits of its SHA-256 (one per 32 bits of entropy) and every 11 bits pick a word of
+mnemonicWords. 128 bits give 12 words, 256 bits give 24.
+
+The seed is PBKDF2-HMAC-SHA512 of the words with the salt "mnemonic" + passphrase, 2048 rounds.
+Words and passphrase are used as they are, without the NFKD normalization of BIP-39, which only
+matters for passphrases outside of ASCII.
+*/
+const mnemonicEntropyBits = 128
+
+var errBadMnemonic = errors.New("not a valid mnemonic")
+
+func NewMnemonic(entropyBits int) string {
+	entropy := make([]byte, entropyBits/8)
+	if _, err := rand.Read(entropy); err != nil {
+		log.Panic(err)
+	}
+	mnemonic, err := EntropyToMnemonic(entropy)
+	if err != nil {
+		log.Panic(err)
+	}
+	return mnemonic
+}
+
+func EntropyToMnemonic(entropy []byte) (string, error) {
+	bits := len(entropy) * 8
+	if bits < 128 || bits > 256 || bits%32 != 0 {
+		return "", fmt.Errorf("entropy of %d bits, it must be 128 to 256 in steps of 32", bits)
+	}
+	checksumBits := uint(bits / 32)
+	hash := sha256.Sum256(entropy)
+	n := new(big.Int).SetBytes(entropy)
+	n.Lsh(n, checksumBits)
+	n.Or(n, big.NewInt(int64(hash[0]>>(8-checksumBits))))
+
+	words := make([]string, (bits+int(checksumBits))/11)
+	mask := big.NewInt(2047)
+	for i := len(words) - 1; i >= 0; i-- { // the last 11 bits are the last word
+		words[i] = mnemonicWords[new(big.Int).And(n, mask).Int64()]
+		n.Rsh(n, 11)
+	}
+	return strings.Join(words, " "), nil
+}
+
+// MnemonicToEntropy checks the words and the checksum and gives back the entropy
+func MnemonicToEntropy(mnemonic string) ([]byte, error) {
+	words := strings.Fields(mnemonic)
+	if len(words) < 12 || len(words) > 24 || len(words)%3 != 0 {
+		return nil, fmt.Errorf("%s: %d words, it must be 12, 15, 18, 21 or 24", errBadMnemonic, len(words))
+	}
+	n := new(big.Int)
+	for _, word := range words {
+		index := mnemonicWordIndex(word)
+		if index < 0 {
+			return nil, fmt.Errorf("%s: unknown word %q", errBadMnemonic, word)
+		}
+		n.Lsh(n, 11)
+		n.Or(n, big.NewInt(int64(index)))
+	}
+	checksumBits := uint(len(words) * 11 / 33)
+	checksum := new(big.Int).And(n, big.NewInt(1<<checksumBits-1)).Int64()
+	n.Rsh(n, checksumBits)
+	entropy := n.FillBytes(make([]byte, int(checksumBits)*4))
+	hash := sha256.Sum256(entropy)
+	if int64(hash[0]>>(8-checksumBits)) != checksum {
+		return nil, fmt.Errorf("%s: the checksum does not match, a word is wrong", errBadMnemonic)
+	}
+	return entropy, nil
+}
+
+func mnemonicWordIndex(word string) int {
+	lo, hi := 0, len(mnemonicWords) // the list is sorted
+	for lo < hi {
+		mid := (lo + hi) / 2
+		if mnemonicWords[mid] < word {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	if lo < len(mnemonicWords) && mnemonicWords[lo] == word {
+		return lo
+	}
+	return -1
+}
+
+func MnemonicSeed(mnemonic, passphrase string) []byte {
+	normalized := strings.Join(strings.Fields(mnemonic), " ")
+	return pbkdf2.Key([]byte(normalized), []byte("mnemonic"+passphrase), 2048, 64, sha512.New)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// from the BIP-39 test vectors, all seeds with the passphrase TREZOR
+var bip39Vectors = []struct {
+	entropy, mnemonic, seed string
+}{
+	{"00000000000000000000000000000000",
+		"abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about",
+		"c55257c360c07c72029aebc1b53c05ed0362ada38ead3e3e9efa3708e53495531f09a6987599d18264c1e1c92f2cf141630c7a3c4ab7c81b2f001698e7463b04"},
+	{"7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f",
+		"legal winner thank year wave sausage worth useful legal winner thank yellow",
+		"2e8905819b8723fe2c1d161860e5ee1830318dbf49a83bd451cfb8440c28bd6fa457fe1296106559a3c80937a1c1069be3a3a5bd381ee6260e8d9739fce1f607"},
+	{"ffffffffffffffffffffffffffffffff",
+		"zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo wrong",
+		"ac27495480225222079d7be181583751e86f571027b0497b5b5d11218e0a8a13332572917f0f8e5a589620c6f15b11c61dee327651a14c34e18231052e48c069"},
+	{"0000000000000000000000000000000000000000000000000000000000000000",
+		strings.Repeat("abandon ", 23) + "art",
+		"bda85446c68413707090a52022edd26a1c9462295029f2e60cd7c4f2bbd3097170af7a4d73245cafa9c3cca8d561a7c3de6f5d4a10be8ed2a5e608d68f92fcc8"},
+}
+
+func TestMnemonicVectors(t *testing.T) {
+	for _, v := range bip39Vectors {
+		entropy := mustHex(t, v.entropy)
+		mnemonic, err := EntropyToMnemonic(entropy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mnemonic != v.mnemonic {
+			t.Errorf("%s: mnemonic %q", v.entropy, mnemonic)
+		}
+		back, err := MnemonicToEntropy(v.mnemonic)
+		if err != nil || !bytes.Equal(back, entropy) {
+			t.Errorf("%s: entropy back %x, %v", v.entropy, back, err)
+		}
+		if seed := hex.EncodeToString(MnemonicSeed(v.mnemonic, "TREZOR")); seed != v.seed {
+			t.Errorf("%s: seed %s", v.entropy, seed)
+		}
+	}
+}
+
+func TestMnemonicRejects(t *testing.T) {
+	for _, mnemonic := range []string{
+		"abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon", // checksum
+		"abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon",         // length
+		"abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abut",    // not a word
+	} {
+		if _, err := MnemonicToEntropy(mnemonic); err == nil {
+			t.Errorf("%q is taken", mnemonic)
+		}
+	}
+}
+
+func TestNewMnemonicRoundTrip(t *testing.T) {
+	mnemonic := NewMnemonic(mnemonicEntropyBits)
+	if words := len(strings.Fields(mnemonic)); words != 12 {
+		t.Fatalf("%d words", words)
+	}
+	entropy, err := MnemonicToEntropy(mnemonic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := EntropyToMnemonic(entropy); again != mnemonic {
+		t.Fatalf("%q came back as %q", mnemonic, again)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"math/big"
+)
+
+/*
+MuHash is a rolling hash of a set, in the way of MuHash3072: every element is hashed to a number
+modulo the prime 2^3072 - 1103717 and the set is the product of its elements. Adding multiplies
+the numerator, removing multiplies the denominator, so the set can be updated one element at a
+time in any order and the digest does not depend on the order.
+
+An element is expanded to 3072 bits with SHA-256 in counter mode over the SHA-256 of the data.
+The digest is the SHA-256 of numerator / denominator.
+*/
+const muHashBytes = 384
+
+var muHashPrime = func() *big.Int {
+	p := new(big.Int).Lsh(big.NewInt(1), 3072)
+	return p.Sub(p, big.NewInt(1103717))
+}()
+
+type MuHash struct {
+	num *big.Int
+	den *big.Int
+}
+
+// NewMuHash is the hash of the empty set
+func NewMuHash() *MuHash {
+	return &MuHash{big.NewInt(1), big.NewInt(1)}
+}
+
+func muHashElement(data []byte) *big.Int {
+	seed := sha256.Sum256(data)
+	expanded := make([]byte, 0, muHashBytes)
+	counter := make([]byte, 4)
+	for i := 0; len(expanded) < muHashBytes; i++ {
+		binary.LittleEndian.PutUint32(counter, uint32(i))
+		h := sha256.Sum256(append(seed[:], counter...))
+		expanded = append(expanded, h[:]...)
+	}
+	x := new(big.Int).SetBytes(expanded)
+	return x.Mod(x, muHashPrime)
+}
+
+func (m *MuHash) Add(data []byte) {
+	m.num.Mul(m.num, muHashElement(data))
+	m.num.Mod(m.num, muHashPrime)
+}
+
+func (m *MuHash) Remove(data []byte) {
+	m.den.Mul(m.den, muHashElement(data))
+	m.den.Mod(m.den, muHashPrime)
+}
+
+func (m *MuHash) Digest() []byte {
+	v := new(big.Int).ModInverse(m.den, muHashPrime)
+	v.Mul(v, m.num)
+	v.Mod(v, muHashPrime)
+	digest := sha256.Sum256(v.FillBytes(make([]byte, muHashBytes)))
+	return digest[:]
+}
+
+// numerator | denominator, 384 bytes each
+func (m *MuHash) Serialize() []byte {
+	var buff bytes.Buffer
+	buff.Write(m.num.FillBytes(make([]byte, muHashBytes)))
+	buff.Write(m.den.FillBytes(make([]byte, muHashBytes)))
+	return buff.Bytes()
+}
+
+func DeserializeMuHash(data []byte) (*MuHash, error) {
+	if len(data) != 2*muHashBytes {
+		return nil, errors.New("bad MuHash length")
+	}
+	m := &MuHash{new(big.Int).SetBytes(data[:muHashBytes]), new(big.Int).SetBytes(data[muHashBytes:])}
+	if m.num.Cmp(muHashPrime) >= 0 || m.den.Sign() == 0 || m.den.Cmp(muHashPrime) >= 0 {
+		return nil, errors.New("bad MuHash value")
+	}
+	return m, nil
+}
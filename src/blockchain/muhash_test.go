@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// digests computed apart from this code from the construction in muhash.go
+func TestMuHashDigests(t *testing.T) {
+	m := NewMuHash()
+	if got := hex.EncodeToString(m.Digest()); got != "ab1642a5fbec142ed166521affcb32a1018793ccff8a30ce6b951a790f5d56a5" {
+		t.Errorf("empty set %s", got)
+	}
+	m.Add([]byte("abc"))
+	if got := hex.EncodeToString(m.Digest()); got != "c4865550462eeb67105cac5fa0788e1a2a2c4c84cfa8179c866201b89ec28688" {
+		t.Errorf("{abc} %s", got)
+	}
+	m.Add([]byte("def"))
+	if got := hex.EncodeToString(m.Digest()); got != "41010cc894346fa5cbea0135ca48709dc9bd0117a5e46d3924f048f86e48831f" {
+		t.Errorf("{abc, def} %s", got)
+	}
+}
+
+func TestMuHashOrderAndRemove(t *testing.T) {
+	a, b := NewMuHash(), NewMuHash()
+	for _, e := range []string{"1", "2", "3"} {
+		a.Add([]byte(e))
+	}
+	for _, e := range []string{"3", "1", "4", "2"} {
+		b.Add([]byte(e))
+	}
+	b.Remove([]byte("4"))
+	if !bytes.Equal(a.Digest(), b.Digest()) {
+		t.Fatal("the digest depends on the order or on a removed element")
+	}
+	b.Remove([]byte("1"))
+	if bytes.Equal(a.Digest(), b.Digest()) {
+		t.Fatal("removing an element does not change the digest")
+	}
+
+	back, err := DeserializeMuHash(b.Serialize())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(back.Digest(), b.Digest()) {
+		t.Fatal("the digest changed in a round trip")
+	}
+	if _, err := DeserializeMuHash(b.Serialize()[1:]); err == nil {
+		t.Fatal("a short MuHash is taken")
+	}
+}
+
+func TestChainstateCommitment(t *testing.T) {
+	bc, w := newTestChain(t)
+	if _, err := bc.MineBlock([]*Transaction{NewCoinbaseTX(string(w.GetAddress()), "")}); err != nil {
+		t.Fatal(err)
+	}
+	// the commitment kept up to date by Put and Delete is the one of the whole set
+	kept, _ := utxoState(t, bc)
+	m := NewMuHash()
+	err := bc.db.View(func(tx *StorageTx) error {
+		return tx.Chainstate().ForEach(func(op Outpoint, entry UTXOEntry) error {
+			m.Add(append(op.Key(), entry.Serialize()...))
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(kept, m.Digest()) {
+		t.Fatal("the kept commitment is not the one of the UTXO set")
+	}
+}
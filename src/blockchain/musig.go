@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"math/big"
+)
+
+/*
+MuSig2-style key aggregation and two-round multi-signing (after BIP-327, x-only keys).
+
+	keys   Q = sum(a_i * P_i), a_i = H(L || pk_i), L = H(pk_1 || ... || pk_u)
+	round1 every signer publishes two nonce points R_i1, R_i2
+	round2 with R = sum(R_i1) + b * sum(R_i2), b = H(aggnonce || Q || m) and e as in BIP-340,
+	       every signer publishes s_i = k_i1 + b k_i2 + e a_i d_i
+	final  (R.x, sum(s_i)) is an ordinary BIP-340 signature for Q
+
+On chain the aggregate key is a normal Schnorr output, nothing reveals that several parties own it.
+musigspend.go runs the rounds over an unsigned tx file to spend such an output.
+*/
+
+const musigPubNonceLen = 66 // two compressed points
+
+var errMuSigSigner = errors.New("secret key is not part of the aggregate key")
+
+type MuSigKeyContext struct {
+	PubKeys [][]byte
+	coefs   []*big.Int
+	Q       *secpPoint
+}
+
+type MuSigSecNonce struct {
+	k1, k2 *big.Int
+	pubKey []byte
+}
+
+func MuSigAggregateKeys(pubKeys [][]byte) (*MuSigKeyContext, error) {
+	if len(pubKeys) == 0 {
+		return nil, errors.New("no keys to aggregate")
+	}
+	L := taggedHash("KeyAgg list", bytes.Join(pubKeys, nil))
+	ctx := &MuSigKeyContext{PubKeys: pubKeys}
+	var points []*secpPoint
+	for _, pk := range pubKeys {
+		if len(pk) != schnorrKeyLen {
+			return nil, errBadSchnorrKey
+		}
+		P, err := liftX(new(big.Int).SetBytes(pk))
+		if err != nil {
+			return nil, err
+		}
+		a := new(big.Int).SetBytes(taggedHash("KeyAgg coefficient", L, pk))
+		a.Mod(a, secpN)
+		ctx.coefs = append(ctx.coefs, a)
+		points = append(points, P)
+	}
+	ctx.Q = secpMultiMul(ctx.coefs, points)
+	if ctx.Q.isInfinity() {
+		return nil, errors.New("aggregate key is infinity")
+	}
+	return ctx, nil
+}
+
+// AggregateKey is the x-only key the output is locked to
+func (ctx *MuSigKeyContext) AggregateKey() []byte {
+	return ctx.Q.xBytes()
+}
+
+func (p *secpPoint) compressed() []byte {
+	x, y := p.toAffine()
+	prefix := byte(0x02)
+	if y.Bit(0) == 1 {
+		prefix = 0x03
+	}
+	return append([]byte{prefix}, x.FillBytes(make([]byte, 32))...)
+}
+
+func parseCompressed(data []byte) (*secpPoint, error) {
+	if len(data) != 33 || (data[0] != 0x02 && data[0] != 0x03) {
+		return nil, errNotOnCurve
+	}
+	P, err := liftX(new(big.Int).SetBytes(data[1:]))
+	if err != nil {
+		return nil, err
+	}
+	if data[0] == 0x03 {
+		P = P.negate()
+	}
+	return P, nil
+}
+
+/*
+MuSigNonceGen draws the two secret nonces of one signing session. They are random on purpose:
+reusing a MuSig nonce for two messages leaks the secret key, so a deterministic nonce is not an option
+*/
+func MuSigNonceGen(secKey []byte) (*MuSigSecNonce, []byte) {
+	pk, err := SchnorrPubKey(secKey)
+	if err != nil {
+		log.Panic(err)
+	}
+	nonce := &MuSigSecNonce{randomScalar(), randomScalar(), pk}
+	pub := append(secpBaseMul(nonce.k1).compressed(), secpBaseMul(nonce.k2).compressed()...)
+	return nonce, pub
+}
+
+func MuSigAggregateNonces(pubNonces [][]byte) ([]byte, error) {
+	R1, R2 := secpInfinity(), secpInfinity()
+	for _, pub := range pubNonces {
+		if len(pub) != musigPubNonceLen {
+			return nil, errors.New("malformed public nonce")
+		}
+		p1, err := parseCompressed(pub[:33])
+		if err != nil {
+			return nil, err
+		}
+		p2, err := parseCompressed(pub[33:])
+		if err != nil {
+			return nil, err
+		}
+		R1, R2 = R1.add(p1), R2.add(p2)
+	}
+	if R1.isInfinity() || R2.isInfinity() {
+		return nil, errors.New("aggregate nonce is infinity")
+	}
+	return append(R1.compressed(), R2.compressed()...), nil
+}
+
+// session values shared by every signer: nonce coefficient b, final nonce R and challenge e
+func (ctx *MuSigKeyContext) session(aggNonce, msg []byte) (*big.Int, *secpPoint, *big.Int, error) {
+	if len(aggNonce) != musigPubNonceLen {
+		return nil, nil, nil, errors.New("malformed aggregate nonce")
+	}
+	R1, err := parseCompressed(aggNonce[:33])
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	R2, err := parseCompressed(aggNonce[33:])
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	b := new(big.Int).SetBytes(taggedHash("MuSig/noncecoef", aggNonce, ctx.AggregateKey(), msg))
+	b.Mod(b, secpN)
+	R := secpMultiMul([]*big.Int{big.NewInt(1), b}, []*secpPoint{R1, R2})
+	if R.isInfinity() {
+		R = secpGenerator()
+	}
+	e := schnorrChallenge(R.xBytes(), ctx.AggregateKey(), msg)
+	return b, R, e, nil
+}
+
+// PartialSign uses up secNonce, it can't be used for a second signature
+func (ctx *MuSigKeyContext) PartialSign(secNonce *MuSigSecNonce, secKey, aggNonce, msg []byte) ([]byte, error) {
+	if secNonce.k1 == nil {
+		return nil, errors.New("nonce has already been used")
+	}
+	k1, k2 := secNonce.k1, secNonce.k2
+	secNonce.k1, secNonce.k2 = nil, nil
+
+	pk, err := SchnorrPubKey(secKey)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(pk, secNonce.pubKey) {
+		return nil, errors.New("nonce belongs to a different key")
+	}
+	idx := -1
+	for i, key := range ctx.PubKeys {
+		if bytes.Equal(key, pk) {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 {
+		return nil, errMuSigSigner
+	}
+	b, R, e, err := ctx.session(aggNonce, msg)
+	if err != nil {
+		return nil, err
+	}
+
+	d := new(big.Int).SetBytes(secKey)
+	if !secpBaseMul(d).hasEvenY() { // P_i was lifted with an even y
+		d.Sub(secpN, d)
+	}
+	if !ctx.Q.hasEvenY() { // the signature verifies against the even-y version of Q
+		d.Sub(secpN, d)
+	}
+	if !R.hasEvenY() {
+		k1 = new(big.Int).Sub(secpN, k1)
+		k2 = new(big.Int).Sub(secpN, k2)
+	}
+	s := new(big.Int).Mul(e, ctx.coefs[idx])
+	s.Mul(s, d)
+	s.Add(s, k1)
+	s.Add(s, new(big.Int).Mul(b, k2))
+	s.Mod(s, secpN)
+	return s.FillBytes(make([]byte, 32)), nil
+}
+
+// Aggregate sums the partial signatures into a BIP-340 signature for the aggregate key
+func (ctx *MuSigKeyContext) Aggregate(aggNonce, msg []byte, partials [][]byte) ([]byte, error) {
+	_, R, _, err := ctx.session(aggNonce, msg)
+	if err != nil {
+		return nil, err
+	}
+	s := new(big.Int)
+	for _, partial := range partials {
+		if len(partial) != 32 {
+			return nil, errors.New("malformed partial signature")
+		}
+		s.Add(s, new(big.Int).SetBytes(partial))
+	}
+	s.Mod(s, secpN)
+	sig := append(R.xBytes(), s.FillBytes(make([]byte, 32))...)
+	if !SchnorrVerify(ctx.AggregateKey(), msg, sig) {
+		return nil, errors.New("aggregate signature does not verify")
+	}
+	return sig, nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"testing"
+)
+
+func musigSigners(t *testing.T, n int) ([][]byte, [][]byte) {
+	t.Helper()
+	var secKeys, pubKeys [][]byte
+	for i := 0; i < n; i++ {
+		sk := NewSchnorrKey()
+		pk, err := SchnorrPubKey(sk)
+		if err != nil {
+			t.Fatal(err)
+		}
+		secKeys, pubKeys = append(secKeys, sk), append(pubKeys, pk)
+	}
+	return secKeys, pubKeys
+}
+
+// musigSign runs both rounds for every signer and returns the aggregate signature
+func musigSign(t *testing.T, ctx *MuSigKeyContext, secKeys [][]byte, msg []byte) []byte {
+	t.Helper()
+	var secNonces []*MuSigSecNonce
+	var pubNonces [][]byte
+	for _, sk := range secKeys {
+		secNonce, pubNonce := MuSigNonceGen(sk)
+		secNonces, pubNonces = append(secNonces, secNonce), append(pubNonces, pubNonce)
+	}
+	aggNonce, err := MuSigAggregateNonces(pubNonces)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var partials [][]byte
+	for i, sk := range secKeys {
+		partial, err := ctx.PartialSign(secNonces[i], sk, aggNonce, msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		partials = append(partials, partial)
+	}
+	sig, err := ctx.Aggregate(aggNonce, msg, partials)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sig
+}
+
+func TestMuSigAggregateKey(t *testing.T) {
+	var keys [][]byte
+	for _, v := range bip340SignVectors[:3] {
+		keys = append(keys, mustHex(t, v.pubKey))
+	}
+	ctx, err := MuSigAggregateKeys(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// not a BIP-327 vector, the keys are x-only here: it pins the aggregation against changes
+	if !bytes.Equal(ctx.AggregateKey(), mustHex(t, "9630b8d0c9ca52f1f0a2dbab864792e2a1869401ad868e12b3b6bb7868a52bdc")) {
+		t.Fatalf("aggregate key %x", ctx.AggregateKey())
+	}
+	reversed, err := MuSigAggregateKeys([][]byte{keys[2], keys[1], keys[0]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(reversed.AggregateKey(), ctx.AggregateKey()) {
+		t.Fatal("the order of the keys does not change the aggregate key")
+	}
+	if _, err := MuSigAggregateKeys(nil); err == nil {
+		t.Fatal("no keys aggregate")
+	}
+}
+
+func TestMuSigSignRoundTrip(t *testing.T) {
+	for _, n := range []int{1, 2, 3, 5} {
+		secKeys, pubKeys := musigSigners(t, n)
+		ctx, err := MuSigAggregateKeys(pubKeys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg := sha256.Sum256([]byte{byte(n)})
+		sig := musigSign(t, ctx, secKeys, msg[:])
+		if !SchnorrVerify(ctx.AggregateKey(), msg[:], sig) {
+			t.Fatalf("%d signers: the signature does not verify", n)
+		}
+	}
+}
+
+func TestMuSigRejects(t *testing.T) {
+	secKeys, pubKeys := musigSigners(t, 2)
+	ctx, err := MuSigAggregateKeys(pubKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := sha256.Sum256([]byte("musig"))
+	secNonce1, pubNonce1 := MuSigNonceGen(secKeys[0])
+	secNonce2, pubNonce2 := MuSigNonceGen(secKeys[1])
+	aggNonce, err := MuSigAggregateNonces([][]byte{pubNonce1, pubNonce2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	partial1, err := ctx.PartialSign(secNonce1, secKeys[0], aggNonce, msg[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ctx.PartialSign(secNonce1, secKeys[0], aggNonce, msg[:]); err == nil {
+		t.Fatal("a nonce signs twice")
+	}
+	outsider, _ := musigSigners(t, 1)
+	outsiderNonce, _ := MuSigNonceGen(outsider[0])
+	if _, err := ctx.PartialSign(outsiderNonce, outsider[0], aggNonce, msg[:]); err != errMuSigSigner {
+		t.Fatalf("a key outside the aggregate signs: %v", err)
+	}
+	partial2, err := ctx.PartialSign(secNonce2, secKeys[1], aggNonce, msg[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ctx.Aggregate(aggNonce, msg[:], [][]byte{partial1, partial1}); err == nil {
+		t.Fatal("a wrong partial signature aggregates")
+	}
+	if _, err := ctx.Aggregate(aggNonce, msg[:], [][]byte{partial1, partial2}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+/*
+Spending a MuSig output goes through an unsigned tx file (see unsignedtx.go) passed from signer
+to signer. The watching node knows the signer keys from aggregatekeys -watch and puts them into
+the file for every input locked to the aggregate key. Each signtx then takes the inputs one round
+further for the keys of its wallet:
+
+	round1 a fresh nonce, the secret half is kept in the wallet until round 2
+	round2 once every signer has its nonce in the file, a partial signature
+	final  the last partial signature completes the BIP-340 signature of the input
+
+A secret nonce is deleted as soon as it has signed, it must never sign twice.
+*/
+var errMuSigNonceMissing = errors.New("the secret nonce of this signer is not in the wallet, start the signing again")
+
+// MuSigInput is the signing session of one input: a nonce and a partial signature per signer, nil until there
+type MuSigInput struct {
+	PubKeys   [][]byte
+	PubNonces [][]byte
+	Partials  [][]byte
+}
+
+func newMuSigInput(pubKeys [][]byte) *MuSigInput {
+	return &MuSigInput{pubKeys, make([][]byte, len(pubKeys)), make([][]byte, len(pubKeys))}
+}
+
+func allSet(values [][]byte) bool {
+	for _, v := range values {
+		if v == nil {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *MuSigInput) Serialize() []byte {
+	var buff bytes.Buffer
+	writeVarInt(&buff, uint64(len(s.PubKeys)))
+	for i := range s.PubKeys {
+		writeVarBytes(&buff, s.PubKeys[i])
+		writeVarBytes(&buff, s.PubNonces[i])
+		writeVarBytes(&buff, s.Partials[i])
+	}
+	return buff.Bytes()
+}
+
+func readMuSigInput(r *bytes.Reader) (*MuSigInput, error) {
+	n, err := readCount(r)
+	if err != nil || n == 0 {
+		return nil, err // no session, the input is not a MuSig one
+	}
+	s := &MuSigInput{}
+	for i := 0; i < n; i++ {
+		var fields [3][]byte
+		for j := range fields {
+			if fields[j], err = readVarBytes(r); err != nil {
+				return nil, err
+			}
+			if len(fields[j]) == 0 {
+				fields[j] = nil
+			}
+		}
+		s.PubKeys = append(s.PubKeys, fields[0])
+		s.PubNonces = append(s.PubNonces, fields[1])
+		s.Partials = append(s.Partials, fields[2])
+	}
+	return s, nil
+}
+
+// the secret nonce kept for pubNonce, sealed like the keys when the file is encrypted
+func (wallets *Wallets) keepNonce(pubNonce []byte, secNonce *MuSigSecNonce) {
+	secret := append(secNonce.k1.FillBytes(make([]byte, 32)), secNonce.k2.FillBytes(make([]byte, 32))...)
+	if wallets.IsEncrypted() {
+		secret = seal(wallets.masterKey, secret, pubNonce)
+	}
+	if wallets.Nonces == nil {
+		wallets.Nonces = make(map[string][]byte)
+	}
+	wallets.Nonces[hex.EncodeToString(pubNonce)] = secret
+}
+
+// takeNonce removes the secret nonce of pubNonce from the wallet, it signs once
+func (wallets *Wallets) takeNonce(pubNonce, pubKey []byte) (*MuSigSecNonce, error) {
+	id := hex.EncodeToString(pubNonce)
+	secret, found := wallets.Nonces[id]
+	if !found {
+		return nil, errMuSigNonceMissing
+	}
+	delete(wallets.Nonces, id)
+	if wallets.IsEncrypted() {
+		var err error
+		if secret, err = unseal(wallets.masterKey, secret, pubNonce); err != nil {
+			return nil, err
+		}
+	}
+	return &MuSigSecNonce{new(big.Int).SetBytes(secret[:32]), new(big.Int).SetBytes(secret[32:]), pubKey}, nil
+}
+
+/*
+SignMuSig takes every MuSig input one round further for the keys of wallets among its signers and
+returns how many rounds it did. The wallet has to be saved after it, it keeps or drops secret nonces
+*/
+func (u *UnsignedTx) SignMuSig(wallets *Wallets) (int, error) {
+	bySchnorrKey := make(map[string]*Wallet)
+	for _, w := range wallets.Wallets {
+		if w.IsSchnorr() {
+			bySchnorrKey[hex.EncodeToString(w.PublicKey)] = w
+		}
+	}
+	if len(u.Tx.Witness) != len(u.Tx.VIn) {
+		u.Tx.Witness = make([]TXWitness, len(u.Tx.VIn))
+	}
+	rounds := 0
+	for i, s := range u.MuSig {
+		if s == nil || len(u.Tx.Witness[i].Signature) > 0 {
+			continue
+		}
+		ctx, err := MuSigAggregateKeys(s.PubKeys)
+		if err != nil {
+			return rounds, err
+		}
+		if !bytes.Equal(ctx.AggregateKey(), u.Spent[i].PubKeyHash) {
+			return rounds, fmt.Errorf("input %d is not locked to the aggregate key of its signers", i)
+		}
+		msg, err := u.Tx.SignatureHash(i, u.Spent[i], SigHashAll)
+		if err != nil {
+			return rounds, err
+		}
+		for j, pubKey := range s.PubKeys {
+			w, found := bySchnorrKey[hex.EncodeToString(pubKey)]
+			if !found || s.Partials[j] != nil || (s.PubNonces[j] != nil && !allSet(s.PubNonces)) {
+				continue // not ours, done, or waiting for the nonces of the others
+			}
+			if !w.CanSign() {
+				return rounds, errWalletLocked
+			}
+			if s.PubNonces[j] == nil {
+				secNonce, pubNonce := MuSigNonceGen(w.SchnorrKey)
+				wallets.keepNonce(pubNonce, secNonce)
+				s.PubNonces[j] = pubNonce
+				rounds++
+				continue
+			}
+			secNonce, err := wallets.takeNonce(s.PubNonces[j], pubKey)
+			if err != nil {
+				return rounds, err
+			}
+			aggNonce, err := MuSigAggregateNonces(s.PubNonces)
+			if err != nil {
+				return rounds, err
+			}
+			if s.Partials[j], err = ctx.PartialSign(secNonce, w.SchnorrKey, aggNonce, msg); err != nil {
+				return rounds, err
+			}
+			rounds++
+		}
+		if allSet(s.Partials) {
+			aggNonce, err := MuSigAggregateNonces(s.PubNonces)
+			if err != nil {
+				return rounds, err
+			}
+			sig, err := ctx.Aggregate(aggNonce, msg, s.Partials)
+			if err != nil {
+				return rounds, err
+			}
+			u.Tx.Witness[i] = TXWitness{EncodeSignature(sig, SigHashAll), nil}
+		}
+	}
+	return rounds, nil
+}
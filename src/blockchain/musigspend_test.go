@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+)
+
+// unsignedSpend is an unsigned tx spending one output of value 5 locked to lockingKey per input
+func unsignedSpend(lockingKeys ...[]byte) *UnsignedTx {
+	u := &UnsignedTx{}
+	for i, key := range lockingKeys {
+		txid := sha256.Sum256([]byte{byte(i)})
+		u.Tx.VIn = append(u.Tx.VIn, TXInput{txid[:], i, nil, nil})
+		u.Spent = append(u.Spent, TXOutput{5, key})
+	}
+	u.Tx.VOut = []TXOutput{{4 * len(lockingKeys), NewWallet().LockingKey()}}
+	u.Tx.ID = u.Tx.Hash()
+	return u
+}
+
+func roundTrip(t *testing.T, u *UnsignedTx) *UnsignedTx {
+	t.Helper()
+	back, err := DeserializeUnsignedTx(u.Serialize())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(back.Serialize(), u.Serialize()) {
+		t.Fatal("the unsigned tx changed in a round trip")
+	}
+	return back
+}
+
+func TestSignMuSigRounds(t *testing.T) {
+	var signers []*Wallets
+	var pubKeys [][]byte
+	for i := 0; i < 3; i++ {
+		ws := &Wallets{Wallets: make(map[string]*Wallet)}
+		address := ws.CreateSchnorrWallet()
+		signers = append(signers, ws)
+		pubKeys = append(pubKeys, ws.Wallets[address].PublicKey)
+	}
+	ctx, err := MuSigAggregateKeys(pubKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := unsignedSpend(ctx.AggregateKey(), ctx.AggregateKey())
+	u.MuSig = []*MuSigInput{newMuSigInput(pubKeys), newMuSigInput(pubKeys)}
+
+	// the file goes from signer to signer, twice for the nonces and the partial signatures
+	for round := 0; round < 2; round++ {
+		for i, ws := range signers {
+			rounds, err := u.SignMuSig(ws)
+			if err != nil {
+				t.Fatalf("round %d signer %d: %v", round, i, err)
+			}
+			if rounds != 2 {
+				t.Fatalf("round %d signer %d did %d rounds", round, i, rounds)
+			}
+			u = roundTrip(t, u)
+		}
+	}
+	if !u.IsSigned() {
+		t.Fatal("the inputs are not signed after both rounds")
+	}
+	for i := range u.Tx.VIn {
+		msg, err := u.Tx.SignatureHash(i, u.Spent[i], SigHashAll)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig, _, err := DecodeSignature(u.Tx.Witness[i].Signature)
+		if err != nil || !SchnorrVerify(ctx.AggregateKey(), msg, sig) {
+			t.Fatalf("input %d: the aggregate signature does not verify", i)
+		}
+	}
+	for i, ws := range signers {
+		if len(ws.Nonces) != 0 {
+			t.Fatalf("signer %d kept %d secret nonces", i, len(ws.Nonces))
+		}
+	}
+}
+
+func TestSignMuSigNeedsItsNonce(t *testing.T) {
+	ws := &Wallets{Wallets: make(map[string]*Wallet)}
+	address := ws.CreateSchnorrWallet()
+	_, others := musigSigners(t, 1)
+	pubKeys := [][]byte{ws.Wallets[address].PublicKey, others[0]}
+	ctx, err := MuSigAggregateKeys(pubKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := unsignedSpend(ctx.AggregateKey())
+	u.MuSig = []*MuSigInput{newMuSigInput(pubKeys)}
+	if _, err := u.SignMuSig(ws); err != nil {
+		t.Fatal(err)
+	}
+	_, u.MuSig[0].PubNonces[1] = MuSigNonceGen(NewSchnorrKey())
+	ws.Nonces = nil // e.g. a wallet file restored from an older copy
+	if _, err := u.SignMuSig(ws); err != errMuSigNonceMissing {
+		t.Fatalf("signed without the secret nonce: %v", err)
+	}
+}
+
+func TestSignMuSigEncryptedBetweenRounds(t *testing.T) {
+	inTempDir(t)
+	var signers []*Wallets
+	var pubKeys [][]byte
+	for i := 0; i < 2; i++ {
+		ws := &Wallets{Wallets: make(map[string]*Wallet)}
+		address := ws.CreateSchnorrWallet()
+		signers = append(signers, ws)
+		pubKeys = append(pubKeys, ws.Wallets[address].PublicKey)
+	}
+	ctx, err := MuSigAggregateKeys(pubKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := unsignedSpend(ctx.AggregateKey())
+	u.MuSig = []*MuSigInput{newMuSigInput(pubKeys)}
+	for _, ws := range signers {
+		if _, err := u.SignMuSig(ws); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the first signer encrypts its wallet with the nonce pending
+	id := hex.EncodeToString(u.MuSig[0].PubNonces[0])
+	plain := signers[0].Nonces[id]
+	if err := signers[0].EncryptWallet("pw"); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(signers[0].Nonces[id], plain) {
+		t.Fatal("the pending nonce is not sealed")
+	}
+	signers[0].SaveToFile("test")
+	loaded, err := NewWallets("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := loaded.Unlock("pw"); err != nil {
+		t.Fatal(err)
+	}
+	signers[0] = loaded
+
+	for i, ws := range signers {
+		if _, err := u.SignMuSig(ws); err != nil {
+			t.Fatalf("signer %d: %v", i, err)
+		}
+	}
+	if !u.IsSigned() {
+		t.Fatal("the input is not signed after both rounds")
+	}
+}
@@ -9,6 +9,8 @@ import (
 	"io/ioutil"
 	"log"
 	"net"
+	"sync"
+	"time"
 )
 
 const protocol = "tcp"
@@ -20,15 +22,22 @@ var miningAddr string
 var nodeAddr string
 var blocksInTransit = [][]byte{}
 var mempool = make(map[string]Transaction)
+var pruneTarget int64 // bytes of block files to keep, 0 when the node does not prune
+var walletNodeID string
+var nodeWallets *Wallets // the wallet of this node, unlocked by walletpassphrase for a while
+var walletMu sync.Mutex
+var chainMu sync.Mutex // the handlers run concurrently, it is held while one of them changes the chain or the UTXO set
+var walletLockTimer *time.Timer
 
 type addrMsg struct {
 	Addrlist []string
 }
 
 type versionMsg struct {
-	Version    int
-	BestHeight int
-	AddrFrom   string
+	Version     int
+	BestHeight  int
+	AddrFrom    string
+	PruneHeight int // blocks below it are pruned on the sender, 0 when it has them all
 }
 
 /*show me what blocks you have, not give me your blocks*/
@@ -65,6 +74,24 @@ type txMsg struct {
 	Tx       []byte
 }
 
+// walletpassphrase, send and freeze come from the CLI on the same machine, not from peers
+type walletPassphraseMsg struct {
+	Passphrase string
+	Timeout    int // seconds, 0 locks the wallet right away
+}
+
+type sendMsg struct {
+	From     string
+	Payments []Payment // one for send, all of them for sendmany
+	Strategy string    // coin selection, see coinselect.go
+	Inputs   string    // outpoints to spend, comma separated
+}
+
+type freezeMsg struct {
+	Outpoints string
+	Unfreeze  bool
+}
+
 func sendAddr(addr string) {
 	nodes := addrMsg{knownAddr}
 	nodes.Addrlist = append(nodes.Addrlist, nodeAddr)
@@ -76,7 +103,7 @@ func sendAddr(addr string) {
 
 func sendVersion(addr string, bc *BlockChain) {
 	bestHeight := bc.GetBestHeight()
-	payload := gobEncode(versionMsg{nodeVersion, bestHeight, nodeAddr})
+	payload := gobEncode(versionMsg{nodeVersion, bestHeight, nodeAddr, bc.db.PruneHeight()})
 	/*
 		First 12 bytes specify command name (“version” in this case), and the latter bytes will contain gob-encoded message structure.
 	*/
@@ -217,7 +244,11 @@ func handleVersion(request []byte, bc *BlockChain) {
 	if myBestHeight > payload.BestHeight { // mine is longer
 		sendVersion(payload.AddrFrom, bc)
 	} else if myBestHeight < payload.BestHeight {
-		sendGetBlocks(payload.AddrFrom)
+		if payload.PruneHeight > myBestHeight+1 { // it does not have the blocks after ours any more
+			fmt.Printf("%s is pruned below height %d, not syncing from it\n", payload.AddrFrom, payload.PruneHeight)
+		} else {
+			sendGetBlocks(payload.AddrFrom)
+		}
 	}
 
 	if !nodeIsKnown(payload.AddrFrom) {
@@ -235,6 +266,9 @@ func handleGetBlocks(request []byte, bc *BlockChain) {
 		log.Panic(err)
 	}
 	blocks := bc.GetBlockHashes()
+	if pruneHeight := bc.db.PruneHeight(); pruneHeight > 0 { // newest first, only the ones still here
+		blocks = blocks[:len(blocks)-pruneHeight]
+	}
 	sendInv(payload.AddrFrom, "blocks", blocks) // send you my nodes
 }
 
@@ -250,8 +284,8 @@ func handleInv(request []byte, bc *BlockChain) {
 
 	fmt.Printf("Recevied inventory with %d %s from %s \n", len(payload.Items), payload.Type, payload.AddrFrom)
 	if payload.Type == "blocks" {
-		blocksInTransit = payload.Items     // these blocks need to be downloaded
-		downloadedBlock := payload.Items[0] // one inv for one hash here
+		blocksInTransit = bc.snapshotDownloadOrder(payload.Items) // these blocks need to be downloaded
+		downloadedBlock := blocksInTransit[0]                     // one inv for one hash here
 		// In our implementation, we’ll never send inv with multiple hashes
 		sendGetData(payload.AddrFrom, "blocks", downloadedBlock) // download the actual block data
 		var newTransit [][]byte
@@ -287,6 +321,10 @@ func handleGetData(request []byte, bc *BlockChain) {
 	fmt.Println("Handling Data Request.")
 	if payload.Type == "blocks" {
 		block, err := bc.GetBlock([]byte(payload.ID))
+		if err == errBlockPruned {
+			fmt.Printf("Block %x is pruned, not sent\n", payload.ID)
+			return
+		}
 		if err != nil {
 			log.Panic(err)
 		}
@@ -315,7 +353,9 @@ func handleBlocks(request []byte, bc *BlockChain) {
 	blockData := payload.Block
 	b := Deserialize(blockData)
 	fmt.Println("Recevied a new block!") // downloaded a block
-	bc.AddBlock(b)                       // add to the chain
+	chainMu.Lock()
+	defer chainMu.Unlock()
+	bc.AddBlock(b) // add to the chain
 	fmt.Printf("Added block %x\n", b.Hash)
 
 	if len(blocksInTransit) > 0 { // download one, still have these to go
@@ -323,8 +363,14 @@ func handleBlocks(request []byte, bc *BlockChain) {
 		sendGetData(payload.AddrFrom, "blocks", blockHash)
 		blocksInTransit = blocksInTransit[1:] // update
 	} else { // all downloaded and find unspent outputs
+		if bc.indexesBehind {
+			bc.ReindexChain() // blocks came newest first, so they could not be connected one by one
+		}
 		utxo := UTXOSet{bc}
-		utxo.Reindex()
+		utxo.CatchUp() // only the new blocks, the set may also come from a snapshot
+		go utxo.validateSnapshot()
+		pruneBlocks(bc)
+		syncNodeWallet(bc)
 	}
 }
 
@@ -339,6 +385,7 @@ func handleTxs(request []byte, bc *BlockChain) {
 	}
 	tx := DeserializeTransaction(payload.Tx)
 	mempool[hex.EncodeToString(tx.ID)] = tx
+	trackNodeWalletTx(&tx, bc)
 
 	if nodeAddr == knownAddr[0] {
 		fmt.Println("I'm central node")
@@ -358,29 +405,39 @@ func handleTxs(request []byte, bc *BlockChain) {
 			. When there are 2 or more transactions in the mempool of the current (miner) node, mining begins.
 		*/
 	MiningTxs:
-		var verifiedTxs []*Transaction
+		var candidates []*Transaction
 		for id := range mempool {
 			t := mempool[id]
-			if bc.VerifyTransaction(&t) {
-				verifiedTxs = append(verifiedTxs, &t) // Invalid transactions are ignored,
-			}
+			candidates = append(candidates, &t)
 		}
+		chainMu.Lock()
+		verifiedTxs := bc.FilterValidTransactions(candidates)      // Invalid transactions are ignored,
+		verifiedTxs, conflicted := bc.FilterSpendable(verifiedTxs) // and so are double spends
 		if len(verifiedTxs) == 0 {
+			chainMu.Unlock()
 			fmt.Println("All transactions are invalid! Waiting for new ones...")
 			return
 		}
 
 		cbTx := NewCoinbaseTX(miningAddr, "") // coinbase transaction with the reward
 		verifiedTxs = append(verifiedTxs, cbTx)
-		newBlock := bc.MineBlock(verifiedTxs) // mined newblock
+		newBlock, err := bc.MineBlock(verifiedTxs) // mined newblock
+		if err != nil {
+			chainMu.Unlock()
+			fmt.Printf("Not mining: %s\n", err)
+			return
+		}
 		utxo := UTXOSet{bc}
-		utxo.Reindex()
+		utxo.CatchUp()
+		pruneBlocks(bc)
+		chainMu.Unlock()
+		syncNodeWallet(bc)
 
 		fmt.Println("New block is mined!")
 
-		for _, txs := range verifiedTxs {
+		for _, txs := range append(verifiedTxs, conflicted...) {
 			txId := hex.EncodeToString(txs.ID)
-			delete(mempool, txId) //delete old txs
+			delete(mempool, txId) //delete old txs, and the ones spending the same outputs
 		}
 
 		/* Every other nodes the current node is aware of*/
@@ -402,6 +459,10 @@ func handleConncetion(conn net.Conn, bc *BlockChain) {
 	if err != nil {
 		log.Panic(err)
 	}
+	if len(request) < commandLength { // e.g. localNodeRunning only checking the port
+		conn.Close()
+		return
+	}
 	command := bytesToCommand(request[:commandLength])
 	fmt.Printf("Received %s command\n", command)
 
@@ -420,14 +481,221 @@ func handleConncetion(conn net.Conn, bc *BlockChain) {
 		handleBlocks(request, bc)
 	case "txs":
 		handleTxs(request, bc)
+	case "walletpass":
+		handleWalletPassphrase(request)
+	case "send":
+		handleSend(request, bc)
+	case "freeze":
+		handleFreeze(request, bc)
 	default:
 		fmt.Println("Command Unknown")
 	}
 	conn.Close()
 }
 
-func StartServer(nodeID, minerAddr string) {
+func handleWalletPassphrase(request []byte) {
+	var payload walletPassphraseMsg
+	dec := gob.NewDecoder(bytes.NewReader(request[commandLength:]))
+	if err := dec.Decode(&payload); err != nil {
+		log.Panic(err)
+	}
+	walletMu.Lock()
+	defer walletMu.Unlock()
+	reloadNodeWallet() // encryptwallet may have run since the start
+	if walletLockTimer != nil {
+		walletLockTimer.Stop()
+	}
+	if payload.Timeout == 0 {
+		nodeWallets.Lock()
+		fmt.Println("Wallet is locked")
+		return
+	}
+	if err := nodeWallets.Unlock(payload.Passphrase); err != nil {
+		fmt.Printf("Wallet stays locked: %s\n", err)
+		return
+	}
+	walletLockTimer = time.AfterFunc(time.Duration(payload.Timeout)*time.Second, func() {
+		walletMu.Lock()
+		defer walletMu.Unlock()
+		nodeWallets.Lock()
+		fmt.Println("Wallet is locked again")
+	})
+	fmt.Printf("Wallet is unlocked for %d seconds\n", payload.Timeout)
+}
+
+// handleSend signs a tx with the node's wallet and passes it on like the send command does
+func handleSend(request []byte, bc *BlockChain) {
+	var payload sendMsg
+	dec := gob.NewDecoder(bytes.NewReader(request[commandLength:]))
+	if err := dec.Decode(&payload); err != nil {
+		log.Panic(err)
+	}
+	tx := signNodeSend(payload, bc)
+	if tx == nil {
+		return
+	}
+	// the wallet is not locked anymore, handleTxs looks at it too
+	if nodeAddr == knownAddr[0] {
+		handleTxs(append(commandToBytes("txs"), gobEncode(txMsg{nodeAddr, tx.Serialize()})...), bc)
+	} else {
+		sendTx(knownAddr[0], tx)
+	}
+	amount, _ := PaymentsTotal(payload.Payments) // signNodeSend refuses payments that overflow
+	if len(payload.Payments) == 1 {
+		fmt.Printf("Sent %d from %s to %s in tx %x\n", amount, payload.From, payload.Payments[0].To, tx.ID)
+	} else {
+		fmt.Printf("Sent %d from %s to %d addresses in tx %x\n", amount, payload.From, len(payload.Payments), tx.ID)
+	}
+}
+
+/*
+reloadNodeWallet reads the wallet file again before the node uses its copy: wallet commands write
+the file while the node runs, and saving a stale copy would drop their keys or their encryption.
+The wallet stays unlocked when it was, unless the file has been encrypted since. walletMu is held
+*/
+func reloadNodeWallet() {
+	fresh, err := NewWallets(walletNodeID)
+	if err != nil {
+		return // still no file
+	}
+	if nodeWallets.masterKey != nil && fresh.IsEncrypted() {
+		if err := fresh.unlockMaster(nodeWallets.masterKey); err != nil {
+			fresh.Lock()
+		}
+	}
+	nodeWallets = fresh
+}
+
+// signNodeSend builds and signs the tx of a send and records it in the wallet, nil when it can not
+func signNodeSend(payload sendMsg, bc *BlockChain) *Transaction {
+	walletMu.Lock()
+	defer walletMu.Unlock()
+	reloadNodeWallet()
+	wallet, found := nodeWallets.Wallets[payload.From]
+	if !found {
+		fmt.Printf("Not sending: %s is not in the wallet\n", payload.From)
+		return nil
+	}
+	if !wallet.CanSign() {
+		fmt.Printf("Not sending: %s\n", errWalletLocked)
+		return nil
+	}
+	utxo := UTXOSet{bc}
+	nodeWallets.SyncTxs(bc)
+	cc, err := NewCoinControl(payload.Strategy, payload.Inputs, nodeWallets)
+	if err != nil {
+		fmt.Printf("Not sending: %s\n", err)
+		return nil
+	}
+	amount, err := PaymentsTotal(payload.Payments)
+	if err != nil {
+		fmt.Printf("Not sending: %s\n", err)
+		return nil
+	}
+	if _, acc, err := utxo.SelectCoins(wallet.LockingKey(), amount, cc); err != nil {
+		fmt.Printf("Not sending: %s, %s has %d spendable, not %d\n", err, payload.From, acc, amount)
+		return nil
+	}
+	change := ""
+	if nodeWallets.HD != nil {
+		change = nodeWallets.NewHDAddress(hdChangeChain)
+	}
+	tx := NewPaymentsTransaction(wallet, payload.Payments, change, &utxo, cc)
+	nodeWallets.TrackTx(tx, &utxo)
+	nodeWallets.SaveToFile(walletNodeID)
+	return tx
+}
+
+// trackNodeWalletTx records a mempool tx that pays to or spends from the node's wallet
+func trackNodeWalletTx(tx *Transaction, bc *BlockChain) {
+	walletMu.Lock()
+	defer walletMu.Unlock()
+	reloadNodeWallet()
+	if nodeWallets.TrackTx(tx, &UTXOSet{bc}) {
+		nodeWallets.SaveToFile(walletNodeID)
+		fmt.Printf("Wallet tx %x is pending\n", tx.ID)
+	}
+}
+
+// syncNodeWallet brings the txs of the node's wallet up to date after new blocks
+func syncNodeWallet(bc *BlockChain) {
+	walletMu.Lock()
+	defer walletMu.Unlock()
+	reloadNodeWallet()
+	if nodeWallets.SyncTxs(bc) { // false without addresses, a node without a wallet file gets none
+		nodeWallets.SaveToFile(walletNodeID)
+	}
+}
+
+// handleFreeze changes the frozen outputs of the node's wallet, the file would be written over otherwise
+func handleFreeze(request []byte, bc *BlockChain) {
+	var payload freezeMsg
+	dec := gob.NewDecoder(bytes.NewReader(request[commandLength:]))
+	if err := dec.Decode(&payload); err != nil {
+		log.Panic(err)
+	}
+	walletMu.Lock()
+	defer walletMu.Unlock()
+	reloadNodeWallet()
+	ops, err := ParseOutpoints(payload.Outpoints)
+	if err == nil && payload.Unfreeze {
+		nodeWallets.Unfreeze(ops)
+	} else if err == nil {
+		err = nodeWallets.Freeze(ops, &UTXOSet{bc})
+	}
+	if err != nil {
+		fmt.Printf("Not freezing: %s\n", err)
+		return
+	}
+	nodeWallets.SaveToFile(walletNodeID)
+	fmt.Printf("%d outputs of the wallet are frozen\n", len(nodeWallets.Frozen))
+}
+
+// localNodeRunning tells whether a node with nodeID listens on this machine
+func localNodeRunning(nodeID string) bool {
+	conn, err := net.Dial(protocol, fmt.Sprintf("localhost:%s", nodeID))
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+func sendWalletPassphrase(nodeID, passphrase string, timeout int) {
+	payload := gobEncode(walletPassphraseMsg{passphrase, timeout})
+	sendData(fmt.Sprintf("localhost:%s", nodeID), append(commandToBytes("walletpass"), payload...))
+}
+
+func sendSend(nodeID, from string, payments []Payment, strategy, inputs string) {
+	payload := gobEncode(sendMsg{from, payments, strategy, inputs})
+	sendData(fmt.Sprintf("localhost:%s", nodeID), append(commandToBytes("send"), payload...))
+}
+
+func sendFreeze(nodeID, outpoints string, unfreeze bool) {
+	payload := gobEncode(freezeMsg{outpoints, unfreeze})
+	sendData(fmt.Sprintf("localhost:%s", nodeID), append(commandToBytes("freeze"), payload...))
+}
+
+// pruneBlocks deletes old block files when the node prunes, not while a snapshot still needs the history
+func pruneBlocks(bc *BlockChain) {
+	if pruneTarget == 0 {
+		return
+	}
+	if _, pending := bc.pendingSnapshot(); pending {
+		return
+	}
+	freed, err := bc.db.Prune(pruneTarget, bc.GetBestHeight())
+	if err != nil {
+		log.Panic(err)
+	}
+	if freed > 0 {
+		fmt.Printf("Pruned %d bytes of block files, blocks are kept from height %d\n", freed, bc.db.PruneHeight())
+	}
+}
+
+func StartServer(nodeID, minerAddr string, pruneMB int) {
 	miningAddr = minerAddr
+	pruneTarget = int64(pruneMB) << 20
 	nodeAddr = fmt.Sprintf("localhost:%s", nodeID)
 	listener, err := net.Listen(protocol, nodeAddr)
 	if err != nil {
@@ -435,7 +703,18 @@ func StartServer(nodeID, minerAddr string) {
 	}
 	defer listener.Close()
 
+	walletNodeID = nodeID
+	nodeWallets, _ = NewWallets(nodeID) // a node without a wallet file gets an empty one
+
 	bc := NewBlockChain(nodeID)
+	go UTXOSet{bc}.validateSnapshot() // a snapshot loaded before the last stop may still wait for it
+	if pruneTarget > 0 {
+		if bc.hasAddressIndex() {
+			log.Panic(errPrunedAddrIndex)
+		}
+		pruneBlocks(bc)
+	}
+	syncNodeWallet(bc) // blocks may have come in through other commands since the last start
 
 	/*
 	 if current node is not the central one, it must send version message to the central node
@@ -13,6 +13,7 @@ const hashLength = 256
 
 type ProofOfWork struct {
 	b      *block
+	header *blockHeader // built once, only the nonce changes while mining
 	target *big.Int
 }
 
@@ -21,23 +22,14 @@ func NewProofOfWork(b *block) *ProofOfWork {
 	target := big.NewInt(1)
 	target.Lsh(target, uint(hashLength-targetBits)) // left shift those bits
 	// like 0x10000000000000000000000000000000000000000000000000000000000 as a target
-	pow := &ProofOfWork{b, target}
+	pow := &ProofOfWork{b, b.Header(), target}
 	return pow
 }
 
+// the header encoding is exactly what gets hashed, so the block hash is sha256(header)
 func (pow *ProofOfWork) prepareData(nonce int) []byte {
-	data := bytes.Join(
-		[][]byte{
-			pow.b.PrevBlockHash,
-			//pow.b.Data,
-			pow.b.HashTransactions(), // we use hash of trans now to refer the actual data
-			IntToHex(pow.b.Timestamp),
-			IntToHex(int64(targetBits)),
-			IntToHex(int64(nonce)),
-		},
-		[]byte{}, // use an empty []byte to expand the two-row bytes array
-	)
-	return data // data to sha256
+	pow.header.Nonce = nonce
+	return pow.header.Serialize() // data to sha256
 }
 
 func (pow *ProofOfWork) Validate() bool {
@@ -45,7 +37,7 @@ func (pow *ProofOfWork) Validate() bool {
 	data := pow.prepareData(pow.b.Nonce)
 	hash := sha256.Sum256(data)
 	hashInt.SetBytes(hash[:])
-	isValid := hashInt.Cmp(pow.target) == -1
+	isValid := hashInt.Cmp(pow.target) == -1 && bytes.Equal(hash[:], pow.b.Hash)
 	return isValid
 }
 
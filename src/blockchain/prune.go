@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+)
+
+/*
+A pruned node deletes old block and undo files to stay under a size target. Whole files go, oldest
+first, and only when all of their blocks are more than minBlocksToKeep below the tip and the file
+is not being appended to. The index entries of the blocks stay with their headers, Get then
+returns errBlockPruned. The UTXO set and the recent blocks, enough for a reorg, are kept.
+
+The height of the first block that is still complete is stored under "p" in the block index and
+sent to peers in the version message, so they do not ask for older blocks.
+*/
+const minBlocksToKeep = 288
+
+var errPruningNeedsFiles = errors.New("pruning needs block files, the memory engine has none")
+
+// a block or undo file and the highest block it has data of
+type pruneCandidate struct {
+	prefix    string
+	number    uint32
+	size      int64
+	maxHeight int // -1 when no entry points into the file
+}
+
+// PruneHeight is the height of the first block that was not pruned, 0 when nothing was
+func (s *Storage) PruneHeight() int {
+	height := 0
+	err := s.View(func(tx *StorageTx) error {
+		bucket := tx.kv.Bucket([]byte(blockIndexBucket))
+		if bucket == nil {
+			return nil
+		}
+		if data := bucket.Get([]byte("p")); len(data) == 4 {
+			height = int(binary.LittleEndian.Uint32(data))
+		}
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+	return height
+}
+
+/*
+Prune deletes files until the block and undo files take at most target bytes, tipHeight is the
+height of the active chain. It returns how many bytes were freed
+*/
+func (s *Storage) Prune(target int64, tipHeight int) (int64, error) {
+	if s.files == nil {
+		return 0, errPruningNeedsFiles
+	}
+	candidates := make(map[string]*pruneCandidate)
+	name := func(prefix string, number uint32) string {
+		return fmt.Sprintf("%s%05d", prefix, number)
+	}
+	s.files.Lock()
+	current := make(map[string]uint32)
+	for prefix, n := range s.files.current {
+		current[prefix] = n
+	}
+	s.files.Unlock()
+	total := int64(0)
+	for _, prefix := range []string{blockFilePrefix, undoFilePrefix} {
+		numbers, err := s.files.fileNumbers(prefix)
+		if err != nil {
+			return 0, err
+		}
+		for _, n := range numbers {
+			info, err := os.Stat(s.files.path(prefix, n))
+			if err != nil {
+				return 0, err
+			}
+			total += info.Size()
+			if n != current[prefix] {
+				candidates[name(prefix, n)] = &pruneCandidate{prefix, n, info.Size(), -1}
+			}
+		}
+	}
+	if total <= target {
+		return 0, nil
+	}
+
+	store := fileBlockStore{nil, s.files}
+	err := s.View(func(tx *StorageTx) error {
+		store.tx = tx.kv
+		return forEachIndexEntry(tx, func(hash []byte, e blockIndexEntry) error {
+			header, err := store.Header(hash)
+			if err != nil {
+				return err
+			}
+			for prefix, pos := range map[string]filePos{blockFilePrefix: e.Block, undoFilePrefix: e.Undo} {
+				c := candidates[name(prefix, pos.File)]
+				if pos.Length > 0 && c != nil && header.Height > c.maxHeight {
+					c.maxHeight = header.Height
+				}
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var sorted []*pruneCandidate
+	for _, c := range candidates {
+		sorted = append(sorted, c)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].maxHeight < sorted[j].maxHeight })
+	deleted := make(map[string]bool)
+	freed := int64(0)
+	for _, c := range sorted {
+		if total-freed <= target || c.maxHeight > tipHeight-minBlocksToKeep {
+			break
+		}
+		deleted[name(c.prefix, c.number)] = true
+		freed += c.size
+	}
+	if freed == 0 {
+		return 0, nil
+	}
+
+	// the entries first, a crash then leaves files nothing points to, which the next prune deletes
+	err = s.Update(func(tx *StorageTx) error {
+		store.tx = tx.kv
+		bucket := tx.kv.Bucket([]byte(blockIndexBucket))
+		pruneHeight := 0
+		if data := bucket.Get([]byte("p")); len(data) == 4 {
+			pruneHeight = int(binary.LittleEndian.Uint32(data))
+		}
+		updated := make(map[string]blockIndexEntry)
+		err := forEachIndexEntry(tx, func(hash []byte, e blockIndexEntry) error {
+			blockGone := e.Block.Length > 0 && deleted[name(blockFilePrefix, e.Block.File)]
+			undoGone := e.Undo.Length > 0 && deleted[name(undoFilePrefix, e.Undo.File)]
+			if !blockGone && !undoGone {
+				return nil
+			}
+			header, err := store.Header(hash) // read before the block is gone
+			if err != nil {
+				return err
+			}
+			e.Header = header
+			if blockGone {
+				e.Block = filePos{}
+				if header.Height+1 > pruneHeight {
+					pruneHeight = header.Height + 1
+				}
+			}
+			if undoGone {
+				e.Undo = filePos{}
+			}
+			updated[string(hash)] = e
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for hash, e := range updated { // not while the cursor is on the bucket
+			if err = bucket.Put([]byte(hash), e.Serialize()); err != nil {
+				return err
+			}
+		}
+		height := make([]byte, 4)
+		binary.LittleEndian.PutUint32(height, uint32(pruneHeight))
+		return bucket.Put([]byte("p"), height)
+	})
+	if err != nil {
+		return 0, err
+	}
+	for _, c := range sorted {
+		if deleted[name(c.prefix, c.number)] {
+			if err = os.Remove(s.files.path(c.prefix, c.number)); err != nil {
+				return freed, err
+			}
+		}
+	}
+	return freed, nil
+}
+
+// forEachIndexEntry calls fn for every block of the block index, the tip and prune keys are skipped
+func forEachIndexEntry(tx *StorageTx, fn func(hash []byte, e blockIndexEntry) error) error {
+	bucket := tx.kv.Bucket([]byte(blockIndexBucket))
+	if bucket == nil {
+		return nil
+	}
+	c := bucket.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		if bytes.Equal(k, []byte("l")) || bytes.Equal(k, []byte("p")) {
+			continue
+		}
+		e, err := DeserializeBlockIndexEntry(v)
+		if err != nil {
+			return err
+		}
+		if err = fn(k, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestPruneNeedsBlockFiles(t *testing.T) {
+	bc, _ := newTestChain(t)
+	if _, err := bc.db.Prune(0, bc.GetBestHeight()); err != errPruningNeedsFiles {
+		t.Fatalf("pruned the memory engine: %v", err)
+	}
+	if height := bc.db.PruneHeight(); height != 0 {
+		t.Fatalf("prune height %d", height)
+	}
+}
+
+func TestPruneKeepsRecentBlocks(t *testing.T) {
+	bc, w := newFileTestChain(t, "prune")
+	defer bc.db.Close()
+	for i := 0; i < 3; i++ {
+		if _, err := bc.MineBlock([]*Transaction{NewCoinbaseTX(string(w.GetAddress()), "")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// every block is within minBlocksToKeep of the tip and in the file still written to
+	freed, err := bc.db.Prune(0, bc.GetBestHeight())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if freed != 0 || bc.db.PruneHeight() != 0 {
+		t.Fatalf("freed %d bytes, prune height %d", freed, bc.db.PruneHeight())
+	}
+	if _, err := bc.GetBlock(bc.tip); err != nil {
+		t.Fatalf("the tip is gone: %v", err)
+	}
+}
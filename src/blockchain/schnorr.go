@@ -0,0 +1,215 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"log"
+	"math/big"
+)
+
+/*
+BIP-340 Schnorr signatures over secp256k1.
+Public keys are the 32-byte x coordinate of a point with even y, signatures are R.x || s (64 bytes).
+An output paying a Schnorr key stores the key itself instead of a hash, like taproot outputs do.
+*/
+
+const schnorrKeyLen = 32
+const schnorrSigLen = 64
+
+var errBadSchnorrKey = errors.New("invalid schnorr secret key")
+
+func taggedHash(tag string, msgs ...[]byte) []byte {
+	tagHash := sha256.Sum256([]byte(tag))
+	h := sha256.New()
+	h.Write(tagHash[:])
+	h.Write(tagHash[:])
+	for _, m := range msgs {
+		h.Write(m)
+	}
+	return h.Sum(nil)
+}
+
+// uniform in [1, n-1]
+func randomScalar() *big.Int {
+	k := make([]byte, 32)
+	for {
+		if _, err := rand.Read(k); err != nil {
+			log.Panic(err)
+		}
+		v := new(big.Int).SetBytes(k)
+		if v.Sign() > 0 && v.Cmp(secpN) < 0 {
+			return v
+		}
+	}
+}
+
+func NewSchnorrKey() []byte {
+	return randomScalar().FillBytes(make([]byte, schnorrKeyLen))
+}
+
+// SchnorrPubKey returns the x-only public key of a secret key
+func SchnorrPubKey(secKey []byte) ([]byte, error) {
+	d := new(big.Int).SetBytes(secKey)
+	if d.Sign() == 0 || d.Cmp(secpN) >= 0 {
+		return nil, errBadSchnorrKey
+	}
+	return secpBaseMul(d).xBytes(), nil
+}
+
+/*
+SchnorrSign signs a 32-byte message. aux is the auxiliary randomness of BIP-340, nil means 32 zero
+bytes which makes the signature deterministic, the nonce is still derived from key and message
+*/
+func SchnorrSign(secKey, msg, aux []byte) ([]byte, error) {
+	d0 := new(big.Int).SetBytes(secKey)
+	if d0.Sign() == 0 || d0.Cmp(secpN) >= 0 {
+		return nil, errBadSchnorrKey
+	}
+	if aux == nil {
+		aux = make([]byte, 32)
+	}
+	P := secpBaseMul(d0)
+	d := new(big.Int).Set(d0)
+	if !P.hasEvenY() {
+		d.Sub(secpN, d)
+	}
+	pk := P.xBytes()
+
+	t := d.FillBytes(make([]byte, 32))
+	auxHash := taggedHash("BIP0340/aux", aux)
+	for i := range t {
+		t[i] ^= auxHash[i]
+	}
+	k0 := new(big.Int).SetBytes(taggedHash("BIP0340/nonce", t, pk, msg))
+	k0.Mod(k0, secpN)
+	if k0.Sign() == 0 {
+		return nil, errors.New("schnorr nonce is zero")
+	}
+	R := secpBaseMul(k0)
+	k := k0
+	if !R.hasEvenY() {
+		k = new(big.Int).Sub(secpN, k0)
+	}
+	rx := R.xBytes()
+	e := schnorrChallenge(rx, pk, msg)
+
+	s := new(big.Int).Mul(e, d)
+	s.Add(s, k).Mod(s, secpN)
+	sig := append(rx, s.FillBytes(make([]byte, 32))...)
+	if !SchnorrVerify(pk, msg, sig) {
+		return nil, errors.New("schnorr signature does not verify")
+	}
+	return sig, nil
+}
+
+func schnorrChallenge(rx, pk, msg []byte) *big.Int {
+	e := new(big.Int).SetBytes(taggedHash("BIP0340/challenge", rx, pk, msg))
+	return e.Mod(e, secpN)
+}
+
+// parseSchnorrSig splits a signature into r and s, checking both ranges
+func parseSchnorrSig(sig []byte) (*big.Int, *big.Int, bool) {
+	if len(sig) != schnorrSigLen {
+		return nil, nil, false
+	}
+	r := new(big.Int).SetBytes(sig[:32])
+	s := new(big.Int).SetBytes(sig[32:])
+	if r.Cmp(secpP) >= 0 || s.Cmp(secpN) >= 0 {
+		return nil, nil, false
+	}
+	return r, s, true
+}
+
+func SchnorrVerify(pk, msg, sig []byte) bool {
+	if len(pk) != schnorrKeyLen {
+		return false
+	}
+	P, err := liftX(new(big.Int).SetBytes(pk))
+	if err != nil {
+		return false
+	}
+	r, s, ok := parseSchnorrSig(sig)
+	if !ok {
+		return false
+	}
+	e := schnorrChallenge(sig[:32], pk, msg)
+	// R = s*G - e*P
+	R := secpMultiMul([]*big.Int{s, new(big.Int).Sub(secpN, e)}, []*secpPoint{secpGenerator(), P})
+	if R.isInfinity() || !R.hasEvenY() {
+		return false
+	}
+	x, _ := R.toAffine()
+	return x.Cmp(r) == 0
+}
+
+/*
+SchnorrBatch collects signatures and checks them all at once (BIP-340 batch verification):
+
+	(s_1 + a_2 s_2 + ... + a_u s_u) G = R_1 + a_2 R_2 + ... + a_u R_u + e_1 P_1 + a_2 e_2 P_2 + ...
+
+with random a_i, so one multi-scalar multiplication replaces u separate verifications.
+A failing batch only says that at least one signature is invalid.
+*/
+type SchnorrBatch struct {
+	pubKeys [][]byte
+	msgs    [][]byte
+	sigs    [][]byte
+}
+
+func NewSchnorrBatch() *SchnorrBatch {
+	return &SchnorrBatch{}
+}
+
+func (batch *SchnorrBatch) Add(pk, msg, sig []byte) {
+	batch.pubKeys = append(batch.pubKeys, pk)
+	batch.msgs = append(batch.msgs, msg)
+	batch.sigs = append(batch.sigs, sig)
+}
+
+func (batch *SchnorrBatch) Len() int {
+	return len(batch.sigs)
+}
+
+func (batch *SchnorrBatch) Verify() bool {
+	switch len(batch.sigs) {
+	case 0:
+		return true
+	case 1:
+		return SchnorrVerify(batch.pubKeys[0], batch.msgs[0], batch.sigs[0])
+	}
+	sum := new(big.Int)
+	scalars := []*big.Int{}
+	points := []*secpPoint{}
+	for i := range batch.sigs {
+		pk, sig := batch.pubKeys[i], batch.sigs[i]
+		if len(pk) != schnorrKeyLen {
+			return false
+		}
+		P, err := liftX(new(big.Int).SetBytes(pk))
+		if err != nil {
+			return false
+		}
+		r, s, ok := parseSchnorrSig(sig)
+		if !ok {
+			return false
+		}
+		R, err := liftX(r)
+		if err != nil {
+			return false
+		}
+		a := big.NewInt(1)
+		if i > 0 {
+			a = randomScalar()
+		}
+		e := schnorrChallenge(sig[:32], pk, batch.msgs[i])
+		sum.Add(sum, new(big.Int).Mul(a, s))
+		// move everything to one side: sum(a_i R_i) + sum(a_i e_i P_i) - (sum a_i s_i) G == 0
+		scalars = append(scalars, a, new(big.Int).Mul(a, e))
+		points = append(points, R, P)
+	}
+	sum.Mod(sum, secpN)
+	scalars = append(scalars, new(big.Int).Sub(secpN, sum))
+	points = append(points, secpGenerator())
+	return secpMultiMul(scalars, points).isInfinity()
+}
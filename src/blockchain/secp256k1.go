@@ -0,0 +1,192 @@
+package main
+
+import (
+	"errors"
+	"math/big"
+)
+
+/*
+secp256k1 arithmetic for the Schnorr signatures, y^2 = x^3 + 7 over F_p.
+crypto/elliptic only implements curves with a = -3, so the group law is written out here.
+Points are kept in Jacobian coordinates (X, Y, Z) ~ (X/Z^2, Y/Z^3), Z = 0 is the point at infinity.
+Nothing here is constant time, which is fine for a demo chain but not for keys worth money.
+*/
+
+var (
+	secpP, _  = new(big.Int).SetString("fffffffffffffffffffffffffffffffffffffffffffffffffffffffefffffc2f", 16)
+	secpN, _  = new(big.Int).SetString("fffffffffffffffffffffffffffffffebaaedce6af48a03bbfd25e8cd0364141", 16)
+	secpGx, _ = new(big.Int).SetString("79be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798", 16)
+	secpGy, _ = new(big.Int).SetString("483ada7726a3c4655da4fbfc0e1108a8fd17b448a68554199c47d08ffb10d4b8", 16)
+	secpB     = big.NewInt(7)
+)
+
+var errNotOnCurve = errors.New("point is not on secp256k1")
+
+type secpPoint struct {
+	x, y, z *big.Int
+}
+
+func secpInfinity() *secpPoint {
+	return &secpPoint{new(big.Int), new(big.Int), new(big.Int)}
+}
+
+func secpAffine(x, y *big.Int) *secpPoint {
+	return &secpPoint{new(big.Int).Set(x), new(big.Int).Set(y), big.NewInt(1)}
+}
+
+func secpGenerator() *secpPoint {
+	return secpAffine(secpGx, secpGy)
+}
+
+func (p *secpPoint) isInfinity() bool {
+	return p.z.Sign() == 0
+}
+
+func fieldMod(v *big.Int) *big.Int {
+	return v.Mod(v, secpP)
+}
+
+// toAffine returns x and y of a finite point
+func (p *secpPoint) toAffine() (*big.Int, *big.Int) {
+	zInv := new(big.Int).ModInverse(p.z, secpP)
+	zInv2 := fieldMod(new(big.Int).Mul(zInv, zInv))
+	x := fieldMod(new(big.Int).Mul(p.x, zInv2))
+	y := fieldMod(new(big.Int).Mul(p.y, fieldMod(new(big.Int).Mul(zInv2, zInv))))
+	return x, y
+}
+
+func (p *secpPoint) double() *secpPoint {
+	if p.isInfinity() || p.y.Sign() == 0 {
+		return secpInfinity()
+	}
+	A := fieldMod(new(big.Int).Mul(p.x, p.x))
+	B := fieldMod(new(big.Int).Mul(p.y, p.y))
+	C := fieldMod(new(big.Int).Mul(B, B))
+	D := new(big.Int).Add(p.x, B)
+	D.Mul(D, D).Sub(D, A).Sub(D, C).Lsh(D, 1)
+	fieldMod(D)
+	E := fieldMod(new(big.Int).Mul(A, big.NewInt(3)))
+	F := fieldMod(new(big.Int).Mul(E, E))
+
+	x3 := new(big.Int).Sub(F, new(big.Int).Lsh(D, 1))
+	fieldMod(x3)
+	y3 := new(big.Int).Sub(D, x3)
+	y3.Mul(y3, E).Sub(y3, new(big.Int).Lsh(C, 3))
+	fieldMod(y3)
+	z3 := new(big.Int).Mul(p.y, p.z)
+	z3.Lsh(z3, 1)
+	fieldMod(z3)
+	return &secpPoint{x3, y3, z3}
+}
+
+func (p *secpPoint) add(q *secpPoint) *secpPoint {
+	if p.isInfinity() {
+		return q
+	}
+	if q.isInfinity() {
+		return p
+	}
+	z1z1 := fieldMod(new(big.Int).Mul(p.z, p.z))
+	z2z2 := fieldMod(new(big.Int).Mul(q.z, q.z))
+	u1 := fieldMod(new(big.Int).Mul(p.x, z2z2))
+	u2 := fieldMod(new(big.Int).Mul(q.x, z1z1))
+	s1 := fieldMod(new(big.Int).Mul(p.y, fieldMod(new(big.Int).Mul(q.z, z2z2))))
+	s2 := fieldMod(new(big.Int).Mul(q.y, fieldMod(new(big.Int).Mul(p.z, z1z1))))
+	if u1.Cmp(u2) == 0 {
+		if s1.Cmp(s2) != 0 {
+			return secpInfinity()
+		}
+		return p.double()
+	}
+	h := fieldMod(new(big.Int).Sub(u2, u1))
+	i := new(big.Int).Lsh(h, 1)
+	fieldMod(i.Mul(i, i))
+	j := fieldMod(new(big.Int).Mul(h, i))
+	r := new(big.Int).Sub(s2, s1)
+	fieldMod(r.Lsh(r, 1))
+	v := fieldMod(new(big.Int).Mul(u1, i))
+
+	x3 := new(big.Int).Mul(r, r)
+	x3.Sub(x3, j).Sub(x3, new(big.Int).Lsh(v, 1))
+	fieldMod(x3)
+	y3 := new(big.Int).Sub(v, x3)
+	y3.Mul(y3, r).Sub(y3, new(big.Int).Lsh(new(big.Int).Mul(s1, j), 1))
+	fieldMod(y3)
+	z3 := new(big.Int).Add(p.z, q.z)
+	z3.Mul(z3, z3).Sub(z3, z1z1).Sub(z3, z2z2).Mul(z3, h)
+	fieldMod(z3)
+	return &secpPoint{x3, y3, z3}
+}
+
+func (p *secpPoint) negate() *secpPoint {
+	if p.isInfinity() {
+		return p
+	}
+	return &secpPoint{new(big.Int).Set(p.x), fieldMod(new(big.Int).Sub(secpP, p.y)), new(big.Int).Set(p.z)}
+}
+
+func (p *secpPoint) mul(k *big.Int) *secpPoint {
+	return secpMultiMul([]*big.Int{k}, []*secpPoint{p})
+}
+
+func secpBaseMul(k *big.Int) *secpPoint {
+	return secpGenerator().mul(k)
+}
+
+/*
+secpMultiMul computes sum(k_i * P_i) with one shared chain of doublings (Strauss-Shamir).
+For n points this costs 256 doublings instead of n * 256, which is where batch
+verification gets its speed from
+*/
+func secpMultiMul(scalars []*big.Int, points []*secpPoint) *secpPoint {
+	maxBits := 0
+	reduced := make([]*big.Int, len(scalars))
+	for i, k := range scalars {
+		reduced[i] = new(big.Int).Mod(k, secpN)
+		if reduced[i].BitLen() > maxBits {
+			maxBits = reduced[i].BitLen()
+		}
+	}
+	acc := secpInfinity()
+	for bit := maxBits - 1; bit >= 0; bit-- {
+		acc = acc.double()
+		for i, k := range reduced {
+			if k.Bit(bit) == 1 {
+				acc = acc.add(points[i])
+			}
+		}
+	}
+	return acc
+}
+
+// liftX returns the point with the given x and an even y (BIP-340)
+func liftX(x *big.Int) (*secpPoint, error) {
+	if x.Sign() < 0 || x.Cmp(secpP) >= 0 {
+		return nil, errNotOnCurve
+	}
+	c := new(big.Int).Exp(x, big.NewInt(3), secpP)
+	c.Add(c, secpB)
+	fieldMod(c)
+	// p = 3 mod 4, so a square root is c^((p+1)/4)
+	e := new(big.Int).Add(secpP, big.NewInt(1))
+	e.Rsh(e, 2)
+	y := new(big.Int).Exp(c, e, secpP)
+	if new(big.Int).Exp(y, big.NewInt(2), secpP).Cmp(c) != 0 {
+		return nil, errNotOnCurve
+	}
+	if y.Bit(0) == 1 {
+		y.Sub(secpP, y)
+	}
+	return secpAffine(x, y), nil
+}
+
+// x-only encoding of a finite point, 32 bytes
+func (p *secpPoint) xBytes() []byte {
+	x, _ := p.toAffine()
+	return x.FillBytes(make([]byte, 32))
+}
+
+func (p *secpPoint) hasEvenY() bool {
+	_, y := p.toAffine()
+	return y.Bit(0) == 0
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+/*
+Binary encoding shared by hashing, storage and the network.
+All integers are little endian. varint is the bitcoin CompactSize:
+
+	< 0xfd        1 byte
+	<= 0xffff     0xfd + uint16
+	<= 0xffffffff 0xfe + uint32
+	otherwise     0xff + uint64
+
+varbytes is a varint length followed by the bytes.
+
+transaction:
+	[0x00 0x01]  marker and flag, only present when the tx carries witness data
+	varint   input count
+	  varbytes txid
+	  uint32   vout (0xffffffff for the coinbase input)
+	  varbytes signature
+	  varbytes pubkey (the coinbase data for the coinbase input)
+	varint   output count
+	  int64    value
+	  varbytes pubkey hash
+	[witness]    one entry per input, only after the marker
+	  varbytes signature
+	  varbytes pubkey
+
+A zero input count can't start a valid tx, so a leading 0x00 is never ambiguous.
+The txid is computed over the encoding without marker and witness, the wtxid over all of it.
+
+block header:
+	int64    timestamp
+	varbytes previous block hash (empty for the genesis block)
+	32 bytes merkle root of the transactions
+	uint32   target bits
+	uint64   nonce
+	uint32   height
+
+block:
+	header
+	varint   transaction count
+	transactions
+
+The transaction ID is sha256 of its encoding without witness and the block hash is sha256 of its header.
+Neither is stored, both are recomputed when decoding.
+*/
+
+const coinbaseVout = 0xffffffff
+
+var errNonCanonical = errors.New("non-canonical varint")
+
+func writeUint32(buff *bytes.Buffer, v uint32) {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], v)
+	buff.Write(b[:])
+}
+
+func writeUint64(buff *bytes.Buffer, v uint64) {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], v)
+	buff.Write(b[:])
+}
+
+func writeVarInt(buff *bytes.Buffer, v uint64) {
+	switch {
+	case v < 0xfd:
+		buff.WriteByte(byte(v))
+	case v <= 0xffff:
+		var b [2]byte
+		binary.LittleEndian.PutUint16(b[:], uint16(v))
+		buff.WriteByte(0xfd)
+		buff.Write(b[:])
+	case v <= 0xffffffff:
+		buff.WriteByte(0xfe)
+		writeUint32(buff, uint32(v))
+	default:
+		buff.WriteByte(0xff)
+		writeUint64(buff, v)
+	}
+}
+
+func writeVarBytes(buff *bytes.Buffer, data []byte) {
+	writeVarInt(buff, uint64(len(data)))
+	buff.Write(data)
+}
+
+func readUint32(r *bytes.Reader) (uint32, error) {
+	var b [4]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint32(b[:]), nil
+}
+
+func readUint64(r *bytes.Reader) (uint64, error) {
+	var b [8]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint64(b[:]), nil
+}
+
+// readVarInt only accepts the shortest encoding, so every value has exactly one representation
+func readVarInt(r *bytes.Reader) (uint64, error) {
+	prefix, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	switch prefix {
+	case 0xfd:
+		var b [2]byte
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return 0, err
+		}
+		v := uint64(binary.LittleEndian.Uint16(b[:]))
+		if v < 0xfd {
+			return 0, errNonCanonical
+		}
+		return v, nil
+	case 0xfe:
+		v, err := readUint32(r)
+		if err != nil {
+			return 0, err
+		}
+		if v <= 0xffff {
+			return 0, errNonCanonical
+		}
+		return uint64(v), nil
+	case 0xff:
+		v, err := readUint64(r)
+		if err != nil {
+			return 0, err
+		}
+		if v <= 0xffffffff {
+			return 0, errNonCanonical
+		}
+		return v, nil
+	default:
+		return uint64(prefix), nil
+	}
+}
+
+func readVarBytes(r *bytes.Reader) ([]byte, error) {
+	n, err := readVarInt(r)
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(r.Len()) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// a count can't be larger than the bytes left, every element takes at least one byte
+func readCount(r *bytes.Reader) (int, error) {
+	n, err := readVarInt(r)
+	if err != nil {
+		return 0, err
+	}
+	if n > uint64(r.Len()) {
+		return 0, fmt.Errorf("count %d exceeds remaining data", n)
+	}
+	return int(n), nil
+}
+
+func writeTXInput(buff *bytes.Buffer, in TXInput) {
+	writeVarBytes(buff, in.TXid)
+	writeUint32(buff, uint32(in.Vout)) // -1 becomes coinbaseVout
+	writeVarBytes(buff, in.Signature)
+	writeVarBytes(buff, in.PubKey)
+}
+
+func readTXInput(r *bytes.Reader) (TXInput, error) {
+	var in TXInput
+	var err error
+	if in.TXid, err = readVarBytes(r); err != nil {
+		return in, err
+	}
+	vout, err := readUint32(r)
+	if err != nil {
+		return in, err
+	}
+	if vout == coinbaseVout {
+		in.Vout = -1
+	} else {
+		in.Vout = int(vout)
+	}
+	if in.Signature, err = readVarBytes(r); err != nil {
+		return in, err
+	}
+	if in.PubKey, err = readVarBytes(r); err != nil {
+		return in, err
+	}
+	return in, nil
+}
+
+func writeTXOutput(buff *bytes.Buffer, out TXOutput) {
+	writeUint64(buff, uint64(int64(out.Value)))
+	writeVarBytes(buff, out.PubKeyHash)
+}
+
+func readTXOutput(r *bytes.Reader) (TXOutput, error) {
+	var out TXOutput
+	value, err := readUint64(r)
+	if err != nil {
+		return out, err
+	}
+	out.Value = int(int64(value))
+	if out.PubKeyHash, err = readVarBytes(r); err != nil {
+		return out, err
+	}
+	return out, nil
+}
+
+const witnessMarker = 0x00
+const witnessFlag = 0x01
+
+func writeTransaction(buff *bytes.Buffer, tx *Transaction, withWitness bool) {
+	withWitness = withWitness && tx.HasWitness()
+	if withWitness {
+		buff.WriteByte(witnessMarker)
+		buff.WriteByte(witnessFlag)
+	}
+	writeVarInt(buff, uint64(len(tx.VIn)))
+	for _, in := range tx.VIn {
+		writeTXInput(buff, in)
+	}
+	writeVarInt(buff, uint64(len(tx.VOut)))
+	for _, out := range tx.VOut {
+		writeTXOutput(buff, out)
+	}
+	if withWitness {
+		for _, w := range tx.Witness {
+			writeVarBytes(buff, w.Signature)
+			writeVarBytes(buff, w.PubKey)
+		}
+	}
+}
+
+func readTransaction(r *bytes.Reader) (*Transaction, error) {
+	tx := &Transaction{}
+	withWitness := false
+	if marker, err := r.ReadByte(); err != nil {
+		return nil, err
+	} else if marker == witnessMarker {
+		flag, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if flag != witnessFlag {
+			return nil, fmt.Errorf("unknown transaction flag 0x%02x", flag)
+		}
+		withWitness = true
+	} else {
+		r.UnreadByte()
+	}
+	nIn, err := readCount(r)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < nIn; i++ {
+		in, err := readTXInput(r)
+		if err != nil {
+			return nil, err
+		}
+		tx.VIn = append(tx.VIn, in)
+	}
+	nOut, err := readCount(r)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < nOut; i++ {
+		out, err := readTXOutput(r)
+		if err != nil {
+			return nil, err
+		}
+		tx.VOut = append(tx.VOut, out)
+	}
+	if withWitness {
+		tx.Witness = make([]TXWitness, nIn)
+		for i := range tx.Witness {
+			if tx.Witness[i].Signature, err = readVarBytes(r); err != nil {
+				return nil, err
+			}
+			if tx.Witness[i].PubKey, err = readVarBytes(r); err != nil {
+				return nil, err
+			}
+		}
+		if !tx.HasWitness() {
+			return nil, errors.New("witness marker without witness data")
+		}
+	}
+	tx.ID = tx.Hash()
+	return tx, nil
+}
+
+func writeBlockHeader(buff *bytes.Buffer, h *blockHeader) {
+	writeUint64(buff, uint64(h.Timestamp))
+	writeVarBytes(buff, h.PrevBlockHash)
+	buff.Write(h.MerkleRoot)
+	writeUint32(buff, uint32(h.TargetBits))
+	writeUint64(buff, uint64(int64(h.Nonce)))
+	writeUint32(buff, uint32(h.Height))
+}
+
+func readBlockHeader(r *bytes.Reader) (*blockHeader, error) {
+	h := &blockHeader{}
+	timestamp, err := readUint64(r)
+	if err != nil {
+		return nil, err
+	}
+	h.Timestamp = int64(timestamp)
+	if h.PrevBlockHash, err = readVarBytes(r); err != nil {
+		return nil, err
+	}
+	h.MerkleRoot = make([]byte, merkleRootLen)
+	if _, err = io.ReadFull(r, h.MerkleRoot); err != nil {
+		return nil, err
+	}
+	bits, err := readUint32(r)
+	if err != nil {
+		return nil, err
+	}
+	h.TargetBits = int(bits)
+	nonce, err := readUint64(r)
+	if err != nil {
+		return nil, err
+	}
+	h.Nonce = int(int64(nonce))
+	height, err := readUint32(r)
+	if err != nil {
+		return nil, err
+	}
+	h.Height = int(height)
+	return h, nil
+}
+
+func checkFullyRead(r *bytes.Reader) error {
+	if r.Len() != 0 {
+		return fmt.Errorf("%d trailing bytes", r.Len())
+	}
+	return nil
+}
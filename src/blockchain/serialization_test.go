@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestVarIntCanonical(t *testing.T) {
+	for _, v := range []uint64{0, 0xfc, 0xfd, 0xffff, 0x10000, 0xffffffff, 0x100000000} {
+		var buff bytes.Buffer
+		writeVarInt(&buff, v)
+		back, err := readVarInt(bytes.NewReader(buff.Bytes()))
+		if err != nil || back != v {
+			t.Errorf("%d came back as %d: %v", v, back, err)
+		}
+	}
+	// the same values in a longer encoding than needed
+	for _, data := range [][]byte{
+		{0xfd, 0xfc, 0x00},
+		{0xfe, 0xff, 0xff, 0x00, 0x00},
+		{0xff, 0xff, 0xff, 0xff, 0xff, 0x00, 0x00, 0x00, 0x00},
+	} {
+		if _, err := readVarInt(bytes.NewReader(data)); err != errNonCanonical {
+			t.Errorf("%x: %v", data, err)
+		}
+	}
+}
+
+func TestTransactionEncodingRoundTrip(t *testing.T) {
+	tx := Transaction{
+		VIn:  []TXInput{{[]byte{1, 2}, 0, []byte{3}, []byte{4, 5}}, {[]byte{6}, 7, nil, nil}},
+		VOut: []TXOutput{{5, []byte{8}}, {0, nil}},
+	}
+	tx.ID = tx.Hash()
+	data := tx.Serialize()
+	if !bytes.Equal(data, tx.SerializeNoWitness()) || data[0] == witnessMarker {
+		t.Fatal("a tx without witness is written with a marker")
+	}
+	back := DeserializeTransaction(data)
+	if !bytes.Equal(back.Serialize(), data) || !bytes.Equal(back.ID, tx.ID) || back.VIn[1].Vout != 7 {
+		t.Fatal("the tx changed in a round trip")
+	}
+
+	coinbase := NewCoinbaseTX(string(NewWallet().GetAddress()), "data")
+	if back := DeserializeTransaction(coinbase.Serialize()); !back.isCoinbaseTX() {
+		t.Fatal("the coinbase input did not come back")
+	}
+
+	for _, bad := range [][]byte{append(data, 0), data[:len(data)-1]} {
+		r := bytes.NewReader(bad)
+		if _, err := readTransaction(r); err == nil && checkFullyRead(r) == nil {
+			t.Errorf("%x is taken", bad)
+		}
+	}
+}
+
+func TestBlockEncodingRoundTrip(t *testing.T) {
+	coinbase := NewCoinbaseTX(string(NewWallet().GetAddress()), "")
+	b := NewBlock([]*Transaction{coinbase}, []byte{1, 2, 3}, 7)
+	back, err := DeserializeBlock(b.Serialize())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the hash is not stored, it comes back from the header
+	if !bytes.Equal(back.Hash, b.Hash) || back.Height != 7 || !bytes.Equal(back.Serialize(), b.Serialize()) {
+		t.Fatal("the block changed in a round trip")
+	}
+	if _, err := DeserializeBlock(append(b.Serialize(), 0)); err == nil {
+		t.Fatal("trailing bytes are taken")
+	}
+}
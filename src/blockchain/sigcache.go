@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"sync"
+)
+
+const defaultSigCacheSize = 100000
+
+/*
+SigCache remembers signatures that already verified, keyed by (sighash, pubkey, sig).
+A mempool tx is checked when it arrives and again when it's mined into a block, the second
+check is then a map lookup. It is shared by all verification workers.
+*/
+type SigCache struct {
+	sync.RWMutex
+	entries    map[[sha256.Size]byte]struct{}
+	maxEntries int
+}
+
+var sigCache = NewSigCache(defaultSigCacheSize)
+
+func NewSigCache(maxEntries int) *SigCache {
+	return &SigCache{entries: make(map[[sha256.Size]byte]struct{}), maxEntries: maxEntries}
+}
+
+func sigCacheKey(sigHash, pubKey, sig []byte) [sha256.Size]byte {
+	h := sha256.New()
+	for _, part := range [][]byte{sigHash, pubKey, sig} {
+		var buff bytes.Buffer
+		writeVarBytes(&buff, part) // length prefixed, the parts can't run into each other
+		h.Write(buff.Bytes())
+	}
+	var key [sha256.Size]byte
+	copy(key[:], h.Sum(nil))
+	return key
+}
+
+func (c *SigCache) Exists(sigHash, pubKey, sig []byte) bool {
+	key := sigCacheKey(sigHash, pubKey, sig)
+	c.RLock()
+	_, ok := c.entries[key]
+	c.RUnlock()
+	return ok
+}
+
+// Add stores a valid signature, when the cache is full a random entry makes room for it
+func (c *SigCache) Add(sigHash, pubKey, sig []byte) {
+	if c.maxEntries <= 0 {
+		return
+	}
+	key := sigCacheKey(sigHash, pubKey, sig)
+	c.Lock()
+	defer c.Unlock()
+	if len(c.entries) >= c.maxEntries {
+		for k := range c.entries { // map iteration order is random
+			delete(c.entries, k)
+			break
+		}
+	}
+	c.entries[key] = struct{}{}
+}
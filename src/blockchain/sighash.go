@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+)
+
+// SigHashType selects which parts of a transaction an input signature commits to.
+// It is appended as the last byte of every signature, like in bitcoin
+type SigHashType byte
+
+const (
+	SigHashAll          SigHashType = 0x01 // commit to every input and every output
+	SigHashNone         SigHashType = 0x02 // commit to the inputs only, outputs can be changed by anyone
+	SigHashSingle       SigHashType = 0x03 // commit to the output with the same index as the input
+	SigHashAnyoneCanPay SigHashType = 0x80 // modifier: commit to the signed input only, others can be added
+)
+
+const sigHashMask = 0x1f
+
+var errSigHashSingle = errors.New("SIGHASH_SINGLE without a matching output")
+
+func (t SigHashType) baseType() SigHashType {
+	return t & sigHashMask
+}
+
+func (t SigHashType) anyoneCanPay() bool {
+	return t&SigHashAnyoneCanPay != 0
+}
+
+func (t SigHashType) isValid() bool {
+	base := t.baseType()
+	return base >= SigHashAll && base <= SigHashSingle && t&^(sigHashMask|SigHashAnyoneCanPay) == 0
+}
+
+func (t SigHashType) String() string {
+	var name string
+	switch t.baseType() {
+	case SigHashAll:
+		name = "ALL"
+	case SigHashNone:
+		name = "NONE"
+	case SigHashSingle:
+		name = "SINGLE"
+	default:
+		return fmt.Sprintf("UNKNOWN(0x%02x)", byte(t))
+	}
+	if t.anyoneCanPay() {
+		name += "|ANYONECANPAY"
+	}
+	return name
+}
+
+/*
+SignatureHash builds the message signed by input InId.
+The preimage is a fixed-order binary encoding (see serialization.go), never a formatted struct:
+
+	inputs     all outpoints (txid, vout), or only the signed one with ANYONECANPAY
+	prevout    value and pubkey hash of the output being spent
+	outputs    all outputs (ALL), none (NONE) or the one at InId (SINGLE)
+	index      InId
+	hashtype   4 bytes
+
+and the result is its double sha256. Signatures and public keys are never part of the preimage
+*/
+func (tx *Transaction) SignatureHash(InId int, prevOut TXOutput, hashType SigHashType) ([]byte, error) {
+	if InId < 0 || InId >= len(tx.VIn) {
+		return nil, fmt.Errorf("input index %d out of range", InId)
+	}
+	if !hashType.isValid() {
+		return nil, fmt.Errorf("invalid sighash type 0x%02x", byte(hashType))
+	}
+	var buff bytes.Buffer
+
+	inputs := tx.VIn
+	if hashType.anyoneCanPay() {
+		inputs = tx.VIn[InId : InId+1]
+	}
+	writeVarInt(&buff, uint64(len(inputs)))
+	for _, vin := range inputs {
+		writeVarBytes(&buff, vin.TXid)
+		writeUint32(&buff, uint32(vin.Vout))
+	}
+
+	writeTXOutput(&buff, prevOut)
+
+	switch hashType.baseType() {
+	case SigHashAll:
+		writeVarInt(&buff, uint64(len(tx.VOut)))
+		for _, out := range tx.VOut {
+			writeTXOutput(&buff, out)
+		}
+	case SigHashNone:
+		writeVarInt(&buff, 0)
+	case SigHashSingle:
+		if InId >= len(tx.VOut) {
+			return nil, errSigHashSingle
+		}
+		writeVarInt(&buff, 1)
+		writeTXOutput(&buff, tx.VOut[InId])
+	}
+
+	writeUint32(&buff, uint32(InId))
+	writeUint32(&buff, uint32(hashType))
+
+	first := sha256.Sum256(buff.Bytes())
+	second := sha256.Sum256(first[:])
+	return second[:], nil
+}
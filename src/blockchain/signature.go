@@ -0,0 +1,239 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"math/big"
+)
+
+// a signature is r || s, both left padded to 32 bytes, followed by one sighash byte
+// Schnorr signatures (R.x || s) have the same size, so both share the encoding
+const sigScalarLen = 32
+const signatureLen = 2*sigScalarLen + 1
+
+var errBadSignature = errors.New("malformed signature")
+
+/*
+rfc6979Nonces returns a generator of deterministic ECDSA nonces (RFC 6979, section 3.2)
+the same key and message always give the same k, so no random source is involved in signing
+calling the generator again continues the HMAC_DRBG, which is only needed when k is unusable
+*/
+func rfc6979Nonces(priv *big.Int, msgHash []byte, q *big.Int) func() *big.Int {
+	qlen := q.BitLen()
+	rolen := (qlen + 7) / 8
+
+	bits2int := func(data []byte) *big.Int {
+		v := new(big.Int).SetBytes(data)
+		if blen := len(data) * 8; blen > qlen {
+			v.Rsh(v, uint(blen-qlen))
+		}
+		return v
+	}
+	int2octets := func(v *big.Int) []byte {
+		out := make([]byte, rolen)
+		return v.FillBytes(out)
+	}
+	bits2octets := func(data []byte) []byte {
+		z := bits2int(data)
+		if z.Cmp(q) >= 0 {
+			z.Sub(z, q)
+		}
+		return int2octets(z)
+	}
+	mac := func(key []byte, parts ...[]byte) []byte {
+		h := hmac.New(sha256.New, key)
+		for _, p := range parts {
+			h.Write(p)
+		}
+		return h.Sum(nil)
+	}
+
+	x := int2octets(priv)
+	h1 := bits2octets(msgHash)
+	V := make([]byte, sha256.Size)
+	K := make([]byte, sha256.Size)
+	for i := range V {
+		V[i] = 0x01
+	}
+	K = mac(K, V, []byte{0x00}, x, h1)
+	V = mac(K, V)
+	K = mac(K, V, []byte{0x01}, x, h1)
+	V = mac(K, V)
+
+	first := true
+	return func() *big.Int {
+		for {
+			if !first {
+				K = mac(K, V, []byte{0x00})
+				V = mac(K, V)
+			}
+			first = false
+			var T []byte
+			for len(T)*8 < qlen {
+				V = mac(K, V)
+				T = append(T, V...)
+			}
+			k := bits2int(T)
+			if k.Sign() > 0 && k.Cmp(q) < 0 {
+				return k
+			}
+		}
+	}
+}
+
+/*
+signECDSA signs a 32-byte hash with an RFC 6979 nonce and returns the fixed-width r || s
+s is always normalised to the lower half of the group order, so (r, n-s) can't be used to
+produce a second valid encoding of the same signature
+*/
+func signECDSA(privKey *ecdsa.PrivateKey, msgHash []byte) []byte {
+	curve := privKey.Curve
+	N := curve.Params().N
+	halfN := new(big.Int).Rsh(N, 1)
+	e := hashToInt(msgHash, N)
+	nonces := rfc6979Nonces(privKey.D, msgHash, N)
+
+	for {
+		k := nonces()
+		x, _ := curve.ScalarBaseMult(k.Bytes())
+		r := new(big.Int).Mod(x, N)
+		if r.Sign() == 0 {
+			continue
+		}
+		s := new(big.Int).Mul(r, privKey.D)
+		s.Add(s, e)
+		s.Mul(s, new(big.Int).ModInverse(k, N))
+		s.Mod(s, N)
+		if s.Sign() == 0 {
+			continue
+		}
+		if s.Cmp(halfN) > 0 {
+			s.Sub(N, s)
+		}
+		sig := make([]byte, 2*sigScalarLen)
+		r.FillBytes(sig[:sigScalarLen])
+		s.FillBytes(sig[sigScalarLen:])
+		return sig
+	}
+}
+
+// verifyECDSA checks a fixed-width r || s, rejecting out of range and high-s values
+func verifyECDSA(pubKey *ecdsa.PublicKey, msgHash []byte, sig []byte) bool {
+	if len(sig) != 2*sigScalarLen {
+		return false
+	}
+	N := pubKey.Curve.Params().N
+	halfN := new(big.Int).Rsh(N, 1)
+	r := new(big.Int).SetBytes(sig[:sigScalarLen])
+	s := new(big.Int).SetBytes(sig[sigScalarLen:])
+	if r.Sign() == 0 || s.Sign() == 0 || r.Cmp(N) >= 0 || s.Cmp(halfN) > 0 {
+		return false
+	}
+	return ecdsa.Verify(pubKey, msgHash, r, s)
+}
+
+// same truncation as crypto/ecdsa does for hashes longer than the order
+func hashToInt(msgHash []byte, N *big.Int) *big.Int {
+	orderBits := N.BitLen()
+	orderBytes := (orderBits + 7) / 8
+	if len(msgHash) > orderBytes {
+		msgHash = msgHash[:orderBytes]
+	}
+	ret := new(big.Int).SetBytes(msgHash)
+	if excess := len(msgHash)*8 - orderBits; excess > 0 {
+		ret.Rsh(ret, uint(excess))
+	}
+	return ret
+}
+
+// EncodeSignature appends the sighash byte to a fixed-width signature
+func EncodeSignature(sig []byte, hashType SigHashType) []byte {
+	return append(append([]byte{}, sig...), byte(hashType))
+}
+
+// DecodeSignature splits an input signature into r || s and its sighash type
+func DecodeSignature(data []byte) ([]byte, SigHashType, error) {
+	if len(data) != signatureLen {
+		return nil, 0, errBadSignature
+	}
+	hashType := SigHashType(data[len(data)-1])
+	if !hashType.isValid() {
+		return nil, 0, errBadSignature
+	}
+	return data[:len(data)-1], hashType, nil
+}
+
+// public keys are X || Y, each padded to the size of the field
+func MarshalPubKey(pub *ecdsa.PublicKey) []byte {
+	size := (pub.Curve.Params().BitSize + 7) / 8
+	out := make([]byte, 2*size)
+	pub.X.FillBytes(out[:size])
+	pub.Y.FillBytes(out[size:])
+	return out
+}
+
+func ParsePubKey(data []byte) (*ecdsa.PublicKey, error) {
+	curve := elliptic.P256()
+	size := (curve.Params().BitSize + 7) / 8
+	if len(data) != 2*size {
+		return nil, errors.New("malformed public key")
+	}
+	x := new(big.Int).SetBytes(data[:size])
+	y := new(big.Int).SetBytes(data[size:])
+	if !curve.IsOnCurve(x, y) {
+		return nil, errors.New("public key is not on the curve")
+	}
+	return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+}
+
+/*
+recoverECDSA finds the public key of a signature made by signECDSA from its r || s and recid:
+bit 0 is the parity of R.y, bit 1 is set when R.x was r + N. Only messages use it, a
+transaction input carries its public key
+*/
+func recoverECDSA(msgHash []byte, sig []byte, recid byte) (*ecdsa.PublicKey, error) {
+	curve := elliptic.P256()
+	params := curve.Params()
+	N := params.N
+	if len(sig) != 2*sigScalarLen || recid > 3 {
+		return nil, errBadSignature
+	}
+	r := new(big.Int).SetBytes(sig[:sigScalarLen])
+	s := new(big.Int).SetBytes(sig[sigScalarLen:])
+	if r.Sign() == 0 || s.Sign() == 0 || r.Cmp(N) >= 0 || s.Cmp(N) >= 0 {
+		return nil, errBadSignature
+	}
+	x := new(big.Int).Set(r)
+	if recid&2 != 0 {
+		x.Add(x, N)
+	}
+	if x.Cmp(params.P) >= 0 {
+		return nil, errBadSignature
+	}
+	compressed := make([]byte, 1+sigScalarLen)
+	compressed[0] = 2 + recid&1
+	x.FillBytes(compressed[1:])
+	Rx, Ry := elliptic.UnmarshalCompressed(curve, compressed)
+	if Rx == nil {
+		return nil, errBadSignature
+	}
+
+	// Q = r^-1 (s R - e G)
+	negE := new(big.Int).Sub(N, hashToInt(msgHash, N))
+	negE.Mod(negE, N)
+	sRx, sRy := curve.ScalarMult(Rx, Ry, s.Bytes())
+	eGx, eGy := curve.ScalarBaseMult(negE.Bytes())
+	Qx, Qy := curve.Add(sRx, sRy, eGx, eGy)
+	Qx, Qy = curve.ScalarMult(Qx, Qy, new(big.Int).ModInverse(r, N).Bytes())
+	if Qx.Sign() == 0 && Qy.Sign() == 0 {
+		return nil, errBadSignature
+	}
+	pub := &ecdsa.PublicKey{Curve: curve, X: Qx, Y: Qy}
+	if !verifyECDSA(pub, msgHash, sig) {
+		return nil, errBadSignature
+	}
+	return pub, nil
+}
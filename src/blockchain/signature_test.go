@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"encoding/hex"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(strings.ToLower(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// RFC 6979 A.2.5, ECDSA on P-256 with SHA-256
+func rfc6979TestKey(t *testing.T) *ecdsa.PrivateKey {
+	priv := &ecdsa.PrivateKey{D: new(big.Int).SetBytes(mustHex(t, "C9AFA9D845BA75166B5C215767B1D6934E50C3DB36E89B127B8A622B120F6721"))}
+	priv.Curve = elliptic.P256()
+	priv.X, priv.Y = priv.Curve.ScalarBaseMult(priv.D.Bytes())
+	return priv
+}
+
+func TestRFC6979Vectors(t *testing.T) {
+	priv := rfc6979TestKey(t)
+	if !bytes.Equal(priv.X.Bytes(), mustHex(t, "60FED4BA255A9D31C961EB74C6356D68C049B8923B61FA6CE669622E60F29FB6")) {
+		t.Fatalf("public key X %x", priv.X)
+	}
+	N := priv.Curve.Params().N
+	tests := []struct {
+		msg, k, r, s string
+	}{
+		{"sample",
+			"A6E3C57DD01ABE90086538398355DD4C3B17AA873382B0F24D6129493D8AAD60",
+			"EFD48B2AACB6A8FD1140DD9CD45E81D69D2C877B56AAF991C34D0EA84EAF3716",
+			"F7CB1C942D657C41D436C7A1B6E29F65F3E900DBB9AFF4064DC4AB2F843ACDA8"},
+		{"test",
+			"D16B6AE827F17175E040871A1C7EC3500192C4C92677336EC2537ACAEE0008E0",
+			"F1ABB023518351CD71D881567B1EA663ED3EFCF6C5132B354F28D3B0B7D38367",
+			"019F4113742A2B14BD25926B49C649155F267E60D3814B4C0CC84250E46F0083"},
+	}
+	for _, test := range tests {
+		hash := sha256.Sum256([]byte(test.msg))
+		if k := rfc6979Nonces(priv.D, hash[:], N)(); k.Cmp(new(big.Int).SetBytes(mustHex(t, test.k))) != 0 {
+			t.Errorf("%s: k %x", test.msg, k)
+		}
+		// signECDSA keeps s in the lower half of the order
+		s := new(big.Int).SetBytes(mustHex(t, test.s))
+		if s.Cmp(new(big.Int).Rsh(N, 1)) > 0 {
+			s.Sub(N, s)
+		}
+		want := append(mustHex(t, test.r), s.FillBytes(make([]byte, sigScalarLen))...)
+		sig := signECDSA(priv, hash[:])
+		if !bytes.Equal(sig, want) {
+			t.Errorf("%s: signature %x", test.msg, sig)
+		}
+		if !verifyECDSA(&priv.PublicKey, hash[:], sig) {
+			t.Errorf("%s: the signature does not verify", test.msg)
+		}
+	}
+}
+
+func TestVerifyECDSARejectsHighS(t *testing.T) {
+	priv := rfc6979TestKey(t)
+	hash := sha256.Sum256([]byte("sample"))
+	sig := signECDSA(priv, hash[:])
+	N := priv.Curve.Params().N
+	s := new(big.Int).Sub(N, new(big.Int).SetBytes(sig[sigScalarLen:]))
+	high := append(append([]byte{}, sig[:sigScalarLen]...), s.FillBytes(make([]byte, sigScalarLen))...)
+	if verifyECDSA(&priv.PublicKey, hash[:], high) {
+		t.Fatal("the high-s form of a signature verifies")
+	}
+}
+
+func TestSignatureEncoding(t *testing.T) {
+	priv := rfc6979TestKey(t)
+	hash := sha256.Sum256([]byte("sample"))
+	sig := signECDSA(priv, hash[:])
+	decoded, hashType, err := DecodeSignature(EncodeSignature(sig, SigHashAll))
+	if err != nil || hashType != SigHashAll || !bytes.Equal(decoded, sig) {
+		t.Fatalf("round trip gave %x %v %v", decoded, hashType, err)
+	}
+	if _, _, err := DecodeSignature(sig); err != errBadSignature {
+		t.Fatal("a signature without its sighash byte decodes")
+	}
+	pub, err := ParsePubKey(MarshalPubKey(&priv.PublicKey))
+	if err != nil || pub.X.Cmp(priv.X) != 0 || pub.Y.Cmp(priv.Y) != 0 {
+		t.Fatalf("public key round trip: %v", err)
+	}
+}
+
+func TestSignatureHashTypes(t *testing.T) {
+	tx := &Transaction{
+		VIn:  []TXInput{{[]byte{1}, 0, nil, nil}, {[]byte{2}, 1, nil, nil}},
+		VOut: []TXOutput{{5, []byte{7}}},
+	}
+	prevOut := TXOutput{9, []byte{8}}
+	sighashes := func(hashType SigHashType) [][]byte {
+		var hashes [][]byte
+		for i := range tx.VIn {
+			hash, err := tx.SignatureHash(i, prevOut, hashType)
+			if err != nil {
+				t.Fatalf("%s input %d: %v", hashType, i, err)
+			}
+			hashes = append(hashes, hash)
+		}
+		return hashes
+	}
+	all, none := sighashes(SigHashAll)[0], sighashes(SigHashNone)[0]
+	alone := sighashes(SigHashAll | SigHashAnyoneCanPay)[0]
+
+	// the signature and public key fields are not part of the preimage
+	tx.VIn[1].Signature, tx.VIn[1].PubKey = []byte{3}, []byte{4}
+	if !bytes.Equal(sighashes(SigHashAll)[0], all) {
+		t.Fatal("the sighash commits to a signature")
+	}
+	tx.VOut[0].Value++
+	if bytes.Equal(sighashes(SigHashAll)[0], all) {
+		t.Fatal("ALL does not commit to the outputs")
+	}
+	if !bytes.Equal(sighashes(SigHashNone)[0], none) {
+		t.Fatal("NONE commits to the outputs")
+	}
+	tx.VOut[0].Value--
+	tx.VIn[1].TXid = []byte{6}
+	if !bytes.Equal(sighashes(SigHashAll | SigHashAnyoneCanPay)[0], alone) {
+		t.Fatal("ANYONECANPAY commits to the other inputs")
+	}
+	if _, err := tx.SignatureHash(1, prevOut, SigHashSingle); err != errSigHashSingle {
+		t.Fatalf("SINGLE without a matching output: %v", err)
+	}
+	if _, err := tx.SignatureHash(0, prevOut, SigHashType(0x04)); err == nil {
+		t.Fatal("an unknown sighash type is taken")
+	}
+	if _, err := tx.SignatureHash(2, prevOut, SigHashAll); err == nil {
+		t.Fatal("an input out of range is taken")
+	}
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync/atomic"
+)
+
+/*
+A UTXO snapshot file lets a new node start from the UTXO set at some block instead of replaying
+the whole chain:
+
+	magic "utxo" | uint8 version | snapshotBase | uint64 entry count
+	per entry: varbytes outpoint key | varbytes UTXOEntry
+
+The commitment in the base is checked against the entries when the file is loaded. Whether the
+set is really the one of that block is only known once the history up to it is replayed, a node
+does that in the background after it has synced, see validateSnapshot.
+*/
+const snapshotVersion = 1
+
+var validatingSnapshot int32 // 1 while validateSnapshot runs
+
+var snapshotMagic = []byte("utxo")
+
+// snapshotBase is the block a snapshot was taken at and the commitment of the set there
+type snapshotBase struct {
+	Hash       []byte
+	Height     int
+	Commitment []byte
+}
+
+func (base snapshotBase) Serialize() []byte {
+	var buff bytes.Buffer
+	writeVarBytes(&buff, base.Hash)
+	writeUint32(&buff, uint32(base.Height))
+	writeVarBytes(&buff, base.Commitment)
+	return buff.Bytes()
+}
+
+func readSnapshotBase(r *bytes.Reader) (snapshotBase, error) {
+	var base snapshotBase
+	var err error
+	if base.Hash, err = readVarBytes(r); err != nil {
+		return base, err
+	}
+	height, err := readUint32(r)
+	if err != nil {
+		return base, err
+	}
+	base.Height = int(height)
+	base.Commitment, err = readVarBytes(r)
+	return base, err
+}
+
+func DeserializeSnapshotBase(data []byte) (snapshotBase, error) {
+	r := bytes.NewReader(data)
+	base, err := readSnapshotBase(r)
+	if err != nil {
+		return base, err
+	}
+	return base, checkFullyRead(r)
+}
+
+// DumpSnapshot writes the UTXO set to path, the set must be at a block of the chain
+func (utxo UTXOSet) DumpSnapshot(path string) (snapshotBase, int, error) {
+	var base snapshotBase
+	count := 0
+	file, err := os.Create(path)
+	if err != nil {
+		return base, 0, err
+	}
+	defer file.Close()
+	w := bufio.NewWriter(file)
+
+	err = utxo.blockchain.db.View(func(tx *StorageTx) error { // one view, the set can not change under us
+		chainstate := tx.Chainstate()
+		if _, ok := chainstate.SnapshotBase(); ok {
+			return errors.New("the UTXO set comes from a snapshot that is not validated yet")
+		}
+		b, err := tx.Blocks().Get(chainstate.BestBlock())
+		if err != nil {
+			return err
+		}
+		base = snapshotBase{b.Hash, b.Height, chainstate.Commitment()}
+		var entries bytes.Buffer
+		err = chainstate.ForEach(func(op Outpoint, entry UTXOEntry) error {
+			writeVarBytes(&entries, op.Key())
+			writeVarBytes(&entries, entry.Serialize())
+			count++
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		var header bytes.Buffer
+		header.Write(snapshotMagic)
+		header.WriteByte(snapshotVersion)
+		header.Write(base.Serialize())
+		writeUint64(&header, uint64(count))
+		if _, err = w.Write(header.Bytes()); err != nil {
+			return err
+		}
+		_, err = w.Write(entries.Bytes())
+		return err
+	})
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		file.Close()
+		os.Remove(path)
+		return base, 0, err
+	}
+	return base, count, nil
+}
+
+/*
+LoadSnapshot replaces the UTXO set with the one in the file at path. The chain must still be below
+the snapshot's block, the set then waits at that block until the node has synced up to it
+*/
+func (utxo UTXOSet) LoadSnapshot(path string) (snapshotBase, int, error) {
+	var base snapshotBase
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return base, 0, err
+	}
+	r := bytes.NewReader(data)
+	magic := make([]byte, len(snapshotMagic))
+	if _, err = r.Read(magic); err != nil || !bytes.Equal(magic, snapshotMagic) {
+		return base, 0, errors.New("not a UTXO snapshot file")
+	}
+	if version, err := r.ReadByte(); err != nil || version != snapshotVersion {
+		return base, 0, fmt.Errorf("unknown UTXO snapshot version %d", version)
+	}
+	if base, err = readSnapshotBase(r); err != nil {
+		return base, 0, err
+	}
+	count, err := readUint64(r)
+	if err != nil {
+		return base, 0, err
+	}
+	if height := utxo.blockchain.GetBestHeight(); height >= base.Height {
+		return base, 0, fmt.Errorf("the chain is at height %d already, the snapshot is at %d", height, base.Height)
+	}
+
+	err = utxo.blockchain.db.Update(func(tx *StorageTx) error {
+		chainstate := tx.Chainstate()
+		if err := chainstate.Reset(); err != nil {
+			return err
+		}
+		for i := uint64(0); i < count; i++ {
+			key, err := readVarBytes(r)
+			if err != nil {
+				return err
+			}
+			value, err := readVarBytes(r)
+			if err != nil {
+				return err
+			}
+			op, err := OutpointFromKey(key)
+			if err != nil {
+				return err
+			}
+			entry, err := DeserializeUTXOEntry(value)
+			if err != nil {
+				return err
+			}
+			if chainstate.Has(op) {
+				return fmt.Errorf("outpoint %x:%d is twice in the snapshot", op.TXid, op.Vout)
+			}
+			if err = chainstate.Put(op, entry); err != nil {
+				return err
+			}
+		}
+		if err := checkFullyRead(r); err != nil {
+			return err
+		}
+		if !bytes.Equal(chainstate.Commitment(), base.Commitment) {
+			return errors.New("the entries of the snapshot do not match its commitment")
+		}
+		if err := chainstate.SetBestBlock(base.Hash); err != nil {
+			return err
+		}
+		return chainstate.SetSnapshotBase(&base)
+	})
+	return base, int(count), err
+}
+
+// pendingSnapshot is the base of a loaded snapshot that validateSnapshot has not checked yet
+func (bc *BlockChain) pendingSnapshot() (snapshotBase, bool) {
+	var base snapshotBase
+	pending := false
+	err := bc.db.View(func(tx *StorageTx) error {
+		base, pending = tx.Chainstate().SnapshotBase()
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+	return base, pending
+}
+
+/*
+snapshotDownloadOrder puts the blocks a pending snapshot needs first: its base block, then the
+ones above it oldest first, each of them connects to the set as it arrives. The history below
+the base follows, newest first like any other download, for validateSnapshot. items are the
+hashes of an inv, newest first
+*/
+func (bc *BlockChain) snapshotDownloadOrder(items [][]byte) [][]byte {
+	base, pending := bc.pendingSnapshot()
+	if !pending {
+		return items
+	}
+	for k, hash := range items {
+		if bytes.Equal(hash, base.Hash) {
+			var order [][]byte
+			for i := k; i >= 0; i-- {
+				order = append(order, items[i])
+			}
+			return append(order, items[k+1:]...)
+		}
+	}
+	return items // the peer is on another chain, the snapshot waits
+}
+
+/*
+validateSnapshot replays the chain from the genesis block to the snapshot's block into a set in
+memory and compares the commitments. A node runs it in the background once it has the blocks,
+when they differ the set is rebuilt from the chain. The blocks to replay are fixed under chainMu
+first, the result is applied under it again, so the handlers never see a half done set
+*/
+func (utxo UTXOSet) validateSnapshot() {
+	bc := utxo.blockchain
+	chainMu.Lock()
+	base, pending := bc.pendingSnapshot()
+	var hashes [][]byte
+	for height := 0; pending && height <= base.Height; height++ {
+		b, err := bc.GetBlockByHeight(height)
+		if err != nil {
+			pending = false // not synced up to the snapshot yet
+			break
+		}
+		hashes = append(hashes, b.Hash)
+	}
+	chainMu.Unlock()
+	if !pending || !bytes.Equal(hashes[base.Height], base.Hash) {
+		return
+	}
+	if !atomic.CompareAndSwapInt32(&validatingSnapshot, 0, 1) {
+		return
+	}
+	defer atomic.StoreInt32(&validatingSnapshot, 0)
+	fmt.Printf("Validating the UTXO snapshot at height %d in the background\n", base.Height)
+
+	scratch := &memoryStore{buckets: make(map[string]*memBucket)}
+	var commitment []byte
+	err := scratch.Update(func(tx KVTx) error {
+		chainstate := kvChainstateStore{tx}
+		for _, hash := range hashes {
+			b, err := bc.GetBlock(hash)
+			if err != nil {
+				return err
+			}
+			if _, err = applyBlock(chainstate, &b); err != nil {
+				return err
+			}
+		}
+		commitment = chainstate.Commitment()
+		return nil
+	})
+	if err == nil && !bytes.Equal(commitment, base.Commitment) {
+		err = fmt.Errorf("the chain gives commitment %x, the snapshot has %x", commitment, base.Commitment)
+	}
+
+	chainMu.Lock()
+	defer chainMu.Unlock()
+	if err != nil {
+		fmt.Printf("UTXO snapshot is invalid: %s. Rebuilding the UTXO set from the chain\n", err)
+		utxo.Reindex()
+		return
+	}
+	err = bc.db.Update(func(tx *StorageTx) error {
+		return tx.Chainstate().SetSnapshotBase(nil)
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+	fmt.Printf("UTXO snapshot at height %d is valid\n", base.Height)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// copyChain copies the db and block files of node from to node to, the chain must be closed
+func copyChain(t *testing.T, from, to string) {
+	t.Helper()
+	src, dst := fmt.Sprintf(dbFile, from), fmt.Sprintf(dbFile, to)
+	paths := []string{""}
+	entries, err := ioutil.ReadDir(blockDir(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		paths = append(paths, e.Name())
+	}
+	if err := os.MkdirAll(blockDir(dst), 0700); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range paths {
+		from, to := src, dst
+		if name != "" {
+			from, to = filepath.Join(blockDir(src), name), filepath.Join(blockDir(dst), name)
+		}
+		data, err := ioutil.ReadFile(from)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(to, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSnapshotDumpAndLoad(t *testing.T) {
+	bc, w := newFileTestChain(t, "a")
+	bc.db.Close()
+	copyChain(t, "a", "b") // a node with the same genesis block that has not synced yet
+
+	bc = NewBlockChain("a")
+	defer bc.db.Close()
+	var mined [][]byte // newest first, like the hashes of an inv
+	for i := 0; i < 2; i++ {
+		tx := NewPaymentsTransaction(w, []Payment{{string(NewWallet().GetAddress()), 1}}, "", &UTXOSet{bc}, nil)
+		b, err := bc.MineBlock([]*Transaction{NewCoinbaseTX(string(w.GetAddress()), ""), tx})
+		if err != nil {
+			t.Fatal(err)
+		}
+		mined = append([][]byte{b.Hash}, mined...)
+	}
+	commitment, _ := utxoState(t, bc)
+	base, count, err := UTXOSet{bc}.DumpSnapshot("utxo.dat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(base.Hash, bc.tip) || base.Height != 2 || !bytes.Equal(base.Commitment, commitment) {
+		t.Fatalf("the snapshot base is %+v", base)
+	}
+	if _, _, err := (UTXOSet{bc}).LoadSnapshot("utxo.dat"); err == nil {
+		t.Fatal("a snapshot loaded into a chain at its height")
+	}
+
+	fresh := NewBlockChain("b")
+	defer fresh.db.Close()
+	loaded, n, err := UTXOSet{fresh}.LoadSnapshot("utxo.dat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != count || !bytes.Equal(loaded.Hash, base.Hash) {
+		t.Fatalf("loaded %d of %d entries at %x", n, count, loaded.Hash)
+	}
+	if got, best := utxoState(t, fresh); !bytes.Equal(got, commitment) || !bytes.Equal(best, base.Hash) {
+		t.Fatal("the loaded set is not the one dumped")
+	}
+	if _, pending := fresh.pendingSnapshot(); !pending {
+		t.Fatal("the loaded snapshot is not waiting for validation")
+	}
+	all := append(append([][]byte{}, mined...), fresh.tip)
+	if order := fresh.snapshotDownloadOrder(all); !bytes.Equal(order[0], base.Hash) {
+		t.Fatalf("the base block is not downloaded first: %x", order[0])
+	}
+
+	// the node syncs the blocks below the snapshot and replays them
+	for i := len(mined) - 1; i >= 0; i-- {
+		b, err := bc.GetBlock(mined[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		fresh.AddBlock(&b)
+	}
+	UTXOSet{fresh}.validateSnapshot()
+	if _, pending := fresh.pendingSnapshot(); pending {
+		t.Fatal("the snapshot is not validated after the sync")
+	}
+	if got, _ := utxoState(t, fresh); !bytes.Equal(got, commitment) {
+		t.Fatal("the validated set changed")
+	}
+}
+
+func TestSnapshotRejectsTamperedEntries(t *testing.T) {
+	bc, w := newFileTestChain(t, "a")
+	bc.db.Close()
+	copyChain(t, "a", "b")
+	bc = NewBlockChain("a")
+	defer bc.db.Close()
+	if _, err := bc.MineBlock([]*Transaction{NewCoinbaseTX(string(w.GetAddress()), "")}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := (UTXOSet{bc}).DumpSnapshot("utxo.dat"); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile("utxo.dat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-1] ^= 1 // the last byte of the last entry
+	if err := ioutil.WriteFile("utxo.dat", data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	fresh := NewBlockChain("b")
+	defer fresh.db.Close()
+	before, _ := utxoState(t, fresh)
+	if _, _, err := (UTXOSet{fresh}).LoadSnapshot("utxo.dat"); err == nil {
+		t.Fatal("a snapshot whose entries do not match its commitment loaded")
+	}
+	if after, _ := utxoState(t, fresh); !bytes.Equal(after, before) {
+		t.Fatal("a rejected snapshot changed the UTXO set")
+	}
+}
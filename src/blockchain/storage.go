@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+)
+
+/*
+Everything the node keeps on disk goes through Storage. It is made of two layers:
+
+	KVStore   an embedded key-value engine: named buckets of sorted keys, read-only views and
+	          read-write updates. An update is one batch, all of its writes land or none do
+	stores    BlockStore, ChainstateStore and IndexStore, what the chain code works with,
+	          built on top of any KVStore. Blocks of a db on disk are in block files, see blockfiles.go
+
+The engine is picked with the DB_ENGINE env. var:
+
+	bbolt   go.etcd.io/bbolt, the maintained fork of bolt (default)
+	bolt    github.com/boltdb/bolt, the engine the node started with
+	memory  maps in the process, nothing is written to disk. For tests
+
+bolt and bbolt share the file format, an existing db file can be opened with either one.
+*/
+const dbEngineEnv = "DB_ENGINE"
+const defaultDBEngine = "bbolt"
+
+var errBucketNotFound = errors.New("bucket not found")
+var errBucketExists = errors.New("bucket already exists")
+var errTxNotWritable = errors.New("tx not writable")
+
+type KVStore interface {
+	View(fn func(tx KVTx) error) error
+	Update(fn func(tx KVTx) error) error
+	Close() error
+}
+
+type KVTx interface {
+	Bucket(name []byte) KVBucket // nil when the bucket does not exist
+	CreateBucket(name []byte) (KVBucket, error)
+	CreateBucketIfNotExists(name []byte) (KVBucket, error)
+	DeleteBucket(name []byte) error
+}
+
+// values returned by Get and the cursor are only valid until the end of the tx
+type KVBucket interface {
+	Get(key []byte) []byte
+	Put(key, value []byte) error
+	Delete(key []byte) error
+	Cursor() KVCursor
+}
+
+type KVCursor interface {
+	First() ([]byte, []byte)
+	Last() ([]byte, []byte)
+	Next() ([]byte, []byte)
+	Prev() ([]byte, []byte)
+	Seek(seek []byte) ([]byte, []byte) // first key >= seek
+}
+
+type Storage struct {
+	kv    KVStore
+	files *blockFiles // nil for a memory db
+}
+
+// StorageTx hands out the stores of one view or update, writes through any of them are one batch
+type StorageTx struct {
+	kv    KVTx
+	files *blockFiles
+}
+
+func dbEngine() string {
+	engine := os.Getenv(dbEngineEnv)
+	if engine == "" {
+		return defaultDBEngine
+	}
+	return engine
+}
+
+func dbExists(thisdbFile string) bool {
+	if dbEngine() == "memory" {
+		return memoryDBExists(thisdbFile)
+	}
+	if _, err := os.Stat(thisdbFile); os.IsNotExist(err) {
+		return false
+	}
+	return true
+}
+
+// OpenStorage opens the db at path with the configured engine, creating it when needed
+func OpenStorage(path string) (*Storage, error) {
+	var kv KVStore
+	var err error
+	switch engine := dbEngine(); engine {
+	case "bbolt":
+		kv, err = openBBolt(path)
+	case "bolt":
+		kv, err = openBolt(path)
+	case "memory":
+		kv = openMemory(path)
+	default:
+		return nil, fmt.Errorf("unknown %s %q, use bbolt, bolt or memory", dbEngineEnv, engine)
+	}
+	if err != nil {
+		return nil, err
+	}
+	s := &Storage{kv: kv}
+	if dbEngine() != "memory" {
+		if s.files, err = openBlockFiles(blockDir(path)); err != nil {
+			kv.Close()
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func openStorage(path string) *Storage {
+	s, err := OpenStorage(path)
+	if err != nil {
+		log.Panic(err)
+	}
+	return s
+}
+
+func (s *Storage) View(fn func(tx *StorageTx) error) error {
+	return s.kv.View(func(tx KVTx) error {
+		return fn(&StorageTx{tx, s.files})
+	})
+}
+
+// Update runs fn as one atomic batch, when fn returns an error nothing it wrote is kept
+func (s *Storage) Update(fn func(tx *StorageTx) error) error {
+	return s.kv.Update(func(tx KVTx) error {
+		return fn(&StorageTx{tx, s.files})
+	})
+}
+
+func (s *Storage) Close() error {
+	return s.kv.Close()
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	bbolt "go.etcd.io/bbolt"
+	"time"
+)
+
+// KVStore on go.etcd.io/bbolt, same API as bolt
+
+type bboltStore struct {
+	db *bbolt.DB
+}
+
+type bboltTx struct {
+	tx *bbolt.Tx
+}
+
+type bboltBucket struct {
+	b *bbolt.Bucket
+}
+
+func openBBolt(path string) (KVStore, error) {
+	// the timeout turns a second node on the same file into an error instead of a hang
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	return &bboltStore{db}, nil
+}
+
+func (s *bboltStore) View(fn func(tx KVTx) error) error {
+	return s.db.View(func(tx *bbolt.Tx) error {
+		return fn(bboltTx{tx})
+	})
+}
+
+func (s *bboltStore) Update(fn func(tx KVTx) error) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return fn(bboltTx{tx})
+	})
+}
+
+func (s *bboltStore) Close() error {
+	return s.db.Close()
+}
+
+func (t bboltTx) Bucket(name []byte) KVBucket {
+	b := t.tx.Bucket(name)
+	if b == nil {
+		return nil
+	}
+	return bboltBucket{b}
+}
+
+func (t bboltTx) CreateBucket(name []byte) (KVBucket, error) {
+	b, err := t.tx.CreateBucket(name)
+	if err == bbolt.ErrBucketExists {
+		return nil, errBucketExists
+	}
+	if err != nil {
+		return nil, err
+	}
+	return bboltBucket{b}, nil
+}
+
+func (t bboltTx) CreateBucketIfNotExists(name []byte) (KVBucket, error) {
+	b, err := t.tx.CreateBucketIfNotExists(name)
+	if err != nil {
+		return nil, err
+	}
+	return bboltBucket{b}, nil
+}
+
+func (t bboltTx) DeleteBucket(name []byte) error {
+	err := t.tx.DeleteBucket(name)
+	if err == bbolt.ErrBucketNotFound {
+		return errBucketNotFound
+	}
+	return err
+}
+
+func (b bboltBucket) Get(key []byte) []byte {
+	return b.b.Get(key)
+}
+
+func (b bboltBucket) Put(key, value []byte) error {
+	if err := b.b.Put(key, value); err != bbolt.ErrTxNotWritable {
+		return err
+	}
+	return errTxNotWritable
+}
+
+func (b bboltBucket) Delete(key []byte) error {
+	if err := b.b.Delete(key); err != bbolt.ErrTxNotWritable {
+		return err
+	}
+	return errTxNotWritable
+}
+
+func (b bboltBucket) Cursor() KVCursor {
+	return b.b.Cursor()
+}
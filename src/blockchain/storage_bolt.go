@@ -0,0 +1,100 @@
+package main
+
+import (
+	"github.com/boltdb/bolt"
+)
+
+// KVStore on github.com/boltdb/bolt, the types map one to one
+
+type boltStore struct {
+	db *bolt.DB
+}
+
+type boltTx struct {
+	tx *bolt.Tx
+}
+
+type boltBucket struct {
+	b *bolt.Bucket
+}
+
+func openBolt(path string) (KVStore, error) {
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &boltStore{db}, nil
+}
+
+func (s *boltStore) View(fn func(tx KVTx) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		return fn(boltTx{tx})
+	})
+}
+
+func (s *boltStore) Update(fn func(tx KVTx) error) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return fn(boltTx{tx})
+	})
+}
+
+func (s *boltStore) Close() error {
+	return s.db.Close()
+}
+
+func (t boltTx) Bucket(name []byte) KVBucket {
+	b := t.tx.Bucket(name)
+	if b == nil {
+		return nil
+	}
+	return boltBucket{b}
+}
+
+func (t boltTx) CreateBucket(name []byte) (KVBucket, error) {
+	b, err := t.tx.CreateBucket(name)
+	if err == bolt.ErrBucketExists {
+		return nil, errBucketExists
+	}
+	if err != nil {
+		return nil, err
+	}
+	return boltBucket{b}, nil
+}
+
+func (t boltTx) CreateBucketIfNotExists(name []byte) (KVBucket, error) {
+	b, err := t.tx.CreateBucketIfNotExists(name)
+	if err != nil {
+		return nil, err
+	}
+	return boltBucket{b}, nil
+}
+
+func (t boltTx) DeleteBucket(name []byte) error {
+	err := t.tx.DeleteBucket(name)
+	if err == bolt.ErrBucketNotFound {
+		return errBucketNotFound
+	}
+	return err
+}
+
+func (b boltBucket) Get(key []byte) []byte {
+	return b.b.Get(key)
+}
+
+func (b boltBucket) Put(key, value []byte) error {
+	if err := b.b.Put(key, value); err != bolt.ErrTxNotWritable {
+		return err
+	}
+	return errTxNotWritable
+}
+
+func (b boltBucket) Delete(key []byte) error {
+	if err := b.b.Delete(key); err != bolt.ErrTxNotWritable {
+		return err
+	}
+	return errTxNotWritable
+}
+
+func (b boltBucket) Cursor() KVCursor {
+	return b.b.Cursor()
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"sort"
+	"sync"
+)
+
+/*
+KVStore kept in maps, for tests. Stores live as long as the process, opening the same path twice
+gives the same store, so a chain can be created, closed and opened again like a db file.
+An update works on copies of the buckets it touches and swaps them in when it succeeds.
+*/
+
+type memoryStore struct {
+	sync.RWMutex
+	buckets map[string]*memBucket
+}
+
+type memBucket struct {
+	keys   []string // sorted
+	values map[string][]byte
+}
+
+type memoryTx struct {
+	buckets  map[string]*memBucket
+	writable bool
+	copied   map[string]bool // buckets of this update that are private copies already
+}
+
+type memoryBucket struct {
+	tx *memoryTx
+	b  *memBucket
+}
+
+type memoryCursor struct {
+	b   *memBucket
+	pos int
+}
+
+var memoryDBs = struct {
+	sync.Mutex
+	stores map[string]*memoryStore
+}{stores: make(map[string]*memoryStore)}
+
+func openMemory(path string) KVStore {
+	memoryDBs.Lock()
+	defer memoryDBs.Unlock()
+	s, ok := memoryDBs.stores[path]
+	if !ok {
+		s = &memoryStore{buckets: make(map[string]*memBucket)}
+		memoryDBs.stores[path] = s
+	}
+	return s
+}
+
+func memoryDBExists(path string) bool {
+	memoryDBs.Lock()
+	defer memoryDBs.Unlock()
+	_, ok := memoryDBs.stores[path]
+	return ok
+}
+
+func (s *memoryStore) View(fn func(tx KVTx) error) error {
+	s.RLock()
+	defer s.RUnlock()
+	return fn(&memoryTx{buckets: s.buckets})
+}
+
+func (s *memoryStore) Update(fn func(tx KVTx) error) error {
+	s.Lock()
+	defer s.Unlock()
+	tx := &memoryTx{buckets: make(map[string]*memBucket), writable: true, copied: make(map[string]bool)}
+	for name, b := range s.buckets {
+		tx.buckets[name] = b
+	}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	s.buckets = tx.buckets
+	return nil
+}
+
+// the data stays in memoryDBs for the next open
+func (s *memoryStore) Close() error {
+	return nil
+}
+
+func (tx *memoryTx) Bucket(name []byte) KVBucket {
+	b, ok := tx.buckets[string(name)]
+	if !ok {
+		return nil
+	}
+	if tx.writable && !tx.copied[string(name)] {
+		b = b.clone()
+		tx.buckets[string(name)] = b
+		tx.copied[string(name)] = true
+	}
+	return &memoryBucket{tx, b}
+}
+
+func (tx *memoryTx) CreateBucket(name []byte) (KVBucket, error) {
+	if !tx.writable {
+		return nil, errTxNotWritable
+	}
+	if _, ok := tx.buckets[string(name)]; ok {
+		return nil, errBucketExists
+	}
+	b := &memBucket{values: make(map[string][]byte)}
+	tx.buckets[string(name)] = b
+	tx.copied[string(name)] = true
+	return &memoryBucket{tx, b}, nil
+}
+
+func (tx *memoryTx) CreateBucketIfNotExists(name []byte) (KVBucket, error) {
+	if b := tx.Bucket(name); b != nil {
+		return b, nil
+	}
+	return tx.CreateBucket(name)
+}
+
+func (tx *memoryTx) DeleteBucket(name []byte) error {
+	if !tx.writable {
+		return errTxNotWritable
+	}
+	if _, ok := tx.buckets[string(name)]; !ok {
+		return errBucketNotFound
+	}
+	delete(tx.buckets, string(name))
+	delete(tx.copied, string(name))
+	return nil
+}
+
+func (b *memBucket) clone() *memBucket {
+	c := &memBucket{append([]string(nil), b.keys...), make(map[string][]byte, len(b.values))}
+	for k, v := range b.values {
+		c.values[k] = v // values are never changed in place
+	}
+	return c
+}
+
+func (b *memoryBucket) Get(key []byte) []byte {
+	return b.b.values[string(key)]
+}
+
+func (b *memoryBucket) Put(key, value []byte) error {
+	if !b.tx.writable {
+		return errTxNotWritable
+	}
+	k := string(key)
+	if _, ok := b.b.values[k]; !ok {
+		i := sort.SearchStrings(b.b.keys, k)
+		b.b.keys = append(b.b.keys, "")
+		copy(b.b.keys[i+1:], b.b.keys[i:])
+		b.b.keys[i] = k
+	}
+	b.b.values[k] = append([]byte{}, value...)
+	return nil
+}
+
+func (b *memoryBucket) Delete(key []byte) error {
+	if !b.tx.writable {
+		return errTxNotWritable
+	}
+	k := string(key)
+	if _, ok := b.b.values[k]; !ok {
+		return nil
+	}
+	delete(b.b.values, k)
+	i := sort.SearchStrings(b.b.keys, k)
+	b.b.keys = append(b.b.keys[:i], b.b.keys[i+1:]...)
+	return nil
+}
+
+// like a bolt cursor, it must not be used across writes to its bucket
+func (b *memoryBucket) Cursor() KVCursor {
+	return &memoryCursor{b.b, -1}
+}
+
+func (c *memoryCursor) at() ([]byte, []byte) {
+	if c.pos < 0 || c.pos >= len(c.b.keys) {
+		return nil, nil
+	}
+	k := c.b.keys[c.pos]
+	return []byte(k), c.b.values[k]
+}
+
+func (c *memoryCursor) First() ([]byte, []byte) {
+	c.pos = 0
+	return c.at()
+}
+
+func (c *memoryCursor) Last() ([]byte, []byte) {
+	c.pos = len(c.b.keys) - 1
+	return c.at()
+}
+
+func (c *memoryCursor) Next() ([]byte, []byte) {
+	if c.pos < len(c.b.keys) {
+		c.pos++
+	}
+	return c.at()
+}
+
+func (c *memoryCursor) Prev() ([]byte, []byte) {
+	if c.pos >= 0 {
+		c.pos--
+	}
+	return c.at()
+}
+
+func (c *memoryCursor) Seek(seek []byte) ([]byte, []byte) {
+	c.pos = sort.SearchStrings(c.b.keys, string(seek))
+	return c.at()
+}
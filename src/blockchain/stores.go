@@ -0,0 +1,364 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"log"
+)
+
+/*
+The stores the chain code works with. They are only handed out by a StorageTx, so writes to several
+of them in one Storage.Update are applied together, e.g. a new block, the tip and the indexes.
+*/
+
+var errBlockNotFound = errors.New("Block is not found.")
+var errUndoNotFound = errors.New("block has no undo data")
+var errBlockPruned = errors.New("block is pruned")
+
+// BlockStore keeps blocks by hash, their undo data and the tip of the active chain
+type BlockStore interface {
+	Get(hash []byte) (*block, error) // errBlockNotFound when it is unknown, errBlockPruned when only its header is left
+	Has(hash []byte) bool
+	Header(hash []byte) (*blockHeader, error)
+	Put(b *block) error
+	Tip() []byte // nil before the genesis block is stored
+	SetTip(hash []byte) error
+	PutUndo(hash, undo []byte) error
+	GetUndo(hash []byte) ([]byte, error) // errUndoNotFound when the block has none
+}
+
+// ChainstateStore is the UTXO set: outpoint -> the unspent output with its height and coinbase flag
+type ChainstateStore interface {
+	Get(op Outpoint) (UTXOEntry, bool)
+	Has(op Outpoint) bool
+	Unspent(txid []byte) map[int]UTXOEntry // the entries of one tx by vout
+	Put(op Outpoint, entry UTXOEntry) error
+	Delete(op Outpoint) error
+	ForEach(fn func(op Outpoint, entry UTXOEntry) error) error // in key order, outputs of a tx together
+	Reset() error                                              // drop every entry
+	Commitment() []byte                                        // MuHash digest of every entry, kept up to date by Put and Delete
+	BestBlock() []byte                                         // the block the set is at, nil when unknown
+	SetBestBlock(hash []byte) error
+	SnapshotBase() (snapshotBase, bool)       // the snapshot the set was loaded from, until history is validated
+	SetSnapshotBase(base *snapshotBase) error // nil once validated
+}
+
+// IndexStore holds the chain indexes, each one a bucket that can be dropped and built again
+type IndexStore interface {
+	Index(name string) KVBucket // nil when the index does not exist
+	CreateIndex(name string) (KVBucket, error)
+	DropIndex(name string) error // no error when it does not exist
+}
+
+// blocks are in block files, except in a memory db which has no directory for them
+func (tx *StorageTx) Blocks() BlockStore {
+	if tx.files != nil {
+		return fileBlockStore{tx.kv, tx.files}
+	}
+	return kvBlockStore{tx.kv}
+}
+
+func (tx *StorageTx) Chainstate() ChainstateStore {
+	return kvChainstateStore{tx.kv}
+}
+
+func (tx *StorageTx) Indexes() IndexStore {
+	return kvIndexStore{tx.kv}
+}
+
+// the blocks bucket: block hash -> serialized block, and "l" -> hash of the tip. Undo data is in its own bucket
+const undoBucket = "undo"
+
+type kvBlockStore struct {
+	tx KVTx
+}
+
+func (s kvBlockStore) Get(hash []byte) (*block, error) {
+	bucket := s.tx.Bucket([]byte(blocksBucket))
+	if bucket == nil {
+		return nil, errBlockNotFound
+	}
+	data := bucket.Get(hash)
+	if data == nil {
+		return nil, errBlockNotFound
+	}
+	return DeserializeBlock(data)
+}
+
+func (s kvBlockStore) Has(hash []byte) bool {
+	bucket := s.tx.Bucket([]byte(blocksBucket))
+	return bucket != nil && bucket.Get(hash) != nil
+}
+
+func (s kvBlockStore) Header(hash []byte) (*blockHeader, error) {
+	b, err := s.Get(hash)
+	if err != nil {
+		return nil, err
+	}
+	return b.Header(), nil
+}
+
+func (s kvBlockStore) Put(b *block) error {
+	bucket, err := s.tx.CreateBucketIfNotExists([]byte(blocksBucket))
+	if err != nil {
+		return err
+	}
+	return bucket.Put(b.Hash, b.Serialize())
+}
+
+func (s kvBlockStore) Tip() []byte {
+	bucket := s.tx.Bucket([]byte(blocksBucket))
+	if bucket == nil {
+		return nil
+	}
+	return bucket.Get([]byte("l"))
+}
+
+func (s kvBlockStore) SetTip(hash []byte) error {
+	bucket, err := s.tx.CreateBucketIfNotExists([]byte(blocksBucket))
+	if err != nil {
+		return err
+	}
+	return bucket.Put([]byte("l"), hash)
+}
+
+func (s kvBlockStore) PutUndo(hash, undo []byte) error {
+	if !s.Has(hash) {
+		return errBlockNotFound
+	}
+	bucket, err := s.tx.CreateBucketIfNotExists([]byte(undoBucket))
+	if err != nil {
+		return err
+	}
+	return bucket.Put(hash, undo)
+}
+
+func (s kvBlockStore) GetUndo(hash []byte) ([]byte, error) {
+	bucket := s.tx.Bucket([]byte(undoBucket))
+	if bucket == nil {
+		return nil, errUndoNotFound
+	}
+	undo := bucket.Get(hash)
+	if undo == nil {
+		return nil, errUndoNotFound
+	}
+	return undo, nil
+}
+
+/*
+The UTXO set is in the utxo bucket, its state in utxostate:
+
+	"muhash"   -> MuHash of the entries, an entry is hashed as key | value
+	"best"     -> hash of the block the set is at
+	"snapshot" -> snapshotBase, while the set comes from a snapshot that is not validated yet
+*/
+const utxoStateBucket = "utxostate"
+
+type kvChainstateStore struct {
+	tx KVTx
+}
+
+func (s kvChainstateStore) Get(op Outpoint) (UTXOEntry, bool) {
+	bucket := s.tx.Bucket([]byte(utxoBucket))
+	if bucket == nil {
+		return UTXOEntry{}, false
+	}
+	data := bucket.Get(op.Key())
+	if data == nil {
+		return UTXOEntry{}, false
+	}
+	entry, err := DeserializeUTXOEntry(data)
+	if err != nil {
+		log.Panic(err)
+	}
+	return entry, true
+}
+
+func (s kvChainstateStore) Has(op Outpoint) bool {
+	bucket := s.tx.Bucket([]byte(utxoBucket))
+	return bucket != nil && bucket.Get(op.Key()) != nil
+}
+
+func (s kvChainstateStore) Unspent(txid []byte) map[int]UTXOEntry {
+	unspent := make(map[int]UTXOEntry)
+	bucket := s.tx.Bucket([]byte(utxoBucket))
+	if bucket == nil {
+		return unspent
+	}
+	c := bucket.Cursor()
+	for k, v := c.Seek(txid); k != nil && len(k) == len(txid)+4 && bytes.HasPrefix(k, txid); k, v = c.Next() {
+		op, err := OutpointFromKey(k)
+		if err != nil {
+			log.Panic(err)
+		}
+		entry, err := DeserializeUTXOEntry(v)
+		if err != nil {
+			log.Panic(err)
+		}
+		unspent[op.Vout] = entry
+	}
+	return unspent
+}
+
+func (s kvChainstateStore) Put(op Outpoint, entry UTXOEntry) error {
+	bucket, err := s.tx.CreateBucketIfNotExists([]byte(utxoBucket))
+	if err != nil {
+		return err
+	}
+	key, value := op.Key(), entry.Serialize()
+	m, err := s.muHash()
+	if err != nil {
+		return err
+	}
+	if old := bucket.Get(key); old != nil {
+		m.Remove(append(append([]byte{}, key...), old...))
+	}
+	m.Add(append(append([]byte{}, key...), value...))
+	if err = s.putState("muhash", m.Serialize()); err != nil {
+		return err
+	}
+	return bucket.Put(key, value)
+}
+
+func (s kvChainstateStore) Delete(op Outpoint) error {
+	bucket := s.tx.Bucket([]byte(utxoBucket))
+	if bucket == nil {
+		return nil
+	}
+	key := op.Key()
+	old := bucket.Get(key)
+	if old == nil {
+		return nil
+	}
+	m, err := s.muHash()
+	if err != nil {
+		return err
+	}
+	m.Remove(append(append([]byte{}, key...), old...))
+	if err = s.putState("muhash", m.Serialize()); err != nil {
+		return err
+	}
+	return bucket.Delete(key)
+}
+
+func (s kvChainstateStore) ForEach(fn func(op Outpoint, entry UTXOEntry) error) error {
+	bucket := s.tx.Bucket([]byte(utxoBucket))
+	if bucket == nil {
+		return nil
+	}
+	c := bucket.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		op, err := OutpointFromKey(k)
+		if err != nil {
+			return err
+		}
+		entry, err := DeserializeUTXOEntry(v)
+		if err != nil {
+			return err
+		}
+		if err = fn(op, entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s kvChainstateStore) Reset() error {
+	for _, name := range []string{utxoBucket, utxoStateBucket} {
+		err := s.tx.DeleteBucket([]byte(name))
+		if err != nil && err != errBucketNotFound {
+			return err
+		}
+		if _, err = s.tx.CreateBucket([]byte(name)); err != nil {
+			return err
+		}
+	}
+	return s.putState("muhash", NewMuHash().Serialize())
+}
+
+func (s kvChainstateStore) state(key string) []byte {
+	bucket := s.tx.Bucket([]byte(utxoStateBucket))
+	if bucket == nil {
+		return nil
+	}
+	return bucket.Get([]byte(key))
+}
+
+func (s kvChainstateStore) putState(key string, value []byte) error {
+	bucket, err := s.tx.CreateBucketIfNotExists([]byte(utxoStateBucket))
+	if err != nil {
+		return err
+	}
+	return bucket.Put([]byte(key), value)
+}
+
+func (s kvChainstateStore) muHash() (*MuHash, error) {
+	data := s.state("muhash")
+	if data == nil {
+		return NewMuHash(), nil
+	}
+	return DeserializeMuHash(data)
+}
+
+func (s kvChainstateStore) Commitment() []byte {
+	m, err := s.muHash()
+	if err != nil {
+		log.Panic(err)
+	}
+	return m.Digest()
+}
+
+func (s kvChainstateStore) BestBlock() []byte {
+	best := s.state("best")
+	if len(best) == 0 {
+		return nil
+	}
+	return best
+}
+
+func (s kvChainstateStore) SetBestBlock(hash []byte) error {
+	return s.putState("best", hash)
+}
+
+func (s kvChainstateStore) SnapshotBase() (snapshotBase, bool) {
+	data := s.state("snapshot")
+	if data == nil {
+		return snapshotBase{}, false
+	}
+	base, err := DeserializeSnapshotBase(data)
+	if err != nil {
+		log.Panic(err)
+	}
+	return base, true
+}
+
+func (s kvChainstateStore) SetSnapshotBase(base *snapshotBase) error {
+	if base == nil {
+		bucket := s.tx.Bucket([]byte(utxoStateBucket))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.Delete([]byte("snapshot"))
+	}
+	return s.putState("snapshot", base.Serialize())
+}
+
+type kvIndexStore struct {
+	tx KVTx
+}
+
+func (s kvIndexStore) Index(name string) KVBucket {
+	return s.tx.Bucket([]byte(name))
+}
+
+func (s kvIndexStore) CreateIndex(name string) (KVBucket, error) {
+	return s.tx.CreateBucketIfNotExists([]byte(name))
+}
+
+func (s kvIndexStore) DropIndex(name string) error {
+	err := s.tx.DeleteBucket([]byte(name))
+	if err == errBucketNotFound {
+		return nil
+	}
+	return err
+}
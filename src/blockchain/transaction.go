@@ -3,22 +3,21 @@ package main
 import (
 	"bytes"
 	"crypto/ecdsa"
-	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
-	"encoding/gob"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
-	"math/big"
 )
 
 const subsidy = 10
 
 type Transaction struct {
-	ID   []byte
-	VIn  []TXInput
-	VOut []TXOutput
+	ID      []byte
+	VIn     []TXInput
+	VOut    []TXOutput
+	Witness []TXWitness // one per input for segwit transactions, empty for old-style ones
 } // a tx may have multiple input and output
 
 func (tx *Transaction) isCoinbaseTX() bool {
@@ -28,45 +27,43 @@ func (tx *Transaction) isCoinbaseTX() bool {
 
 // SetID func has been regrouped into Serialize and Hash
 
-// serialize a tx, same code as serialize for block
+// serialize a tx with the canonical encoding described in serialization.go, witness included
 func (tx Transaction) Serialize() []byte {
 	var encoded bytes.Buffer
-	e := gob.NewEncoder(&encoded)
-	err := e.Encode(tx)
-	if err != nil {
-		log.Panic(err)
-	}
+	writeTransaction(&encoded, &tx, true)
+	return encoded.Bytes()
+}
+
+// the encoding the txid is computed over
+func (tx Transaction) SerializeNoWitness() []byte {
+	var encoded bytes.Buffer
+	writeTransaction(&encoded, &tx, false)
 	return encoded.Bytes()
 }
 
 func DeserializeTransaction(txData []byte) Transaction {
-	var tx Transaction
-	d := gob.NewDecoder(bytes.NewReader(txData))
-	err := d.Decode(&tx) // remember the point
+	r := bytes.NewReader(txData)
+	tx, err := readTransaction(r)
+	if err == nil {
+		err = checkFullyRead(r)
+	}
 	if err != nil {
 		log.Panic(err)
 	}
-	return tx
+	return *tx
 }
 
+// the ID is not part of the encoding, so the hash never depends on it
+// neither do signatures and public keys of segwit inputs
 func (tx *Transaction) Hash() []byte {
-	var hash [32]byte
-	txCopy := *tx
-	txCopy.ID = []byte{}
-	hash = sha256.Sum256(txCopy.Serialize())
+	hash := sha256.Sum256(tx.SerializeNoWitness())
 	return hash[:]
 }
 
-func (tx *Transaction) SetID() {
-	var encoded bytes.Buffer
-	var hash [32]byte
-	buf := gob.NewEncoder(&encoded)
-	err := buf.Encode(tx)
-	if err != nil {
-		log.Panic(err)
-	}
-	hash = sha256.Sum256(encoded.Bytes()) // Hash of the transaction, not the block
-	tx.ID = hash[:]
+// WitnessHash is the wtxid, it covers the witness too and equals the txid when there is none
+func (tx *Transaction) WitnessHash() []byte {
+	hash := sha256.Sum256(tx.Serialize())
+	return hash[:]
 }
 
 // coinbase type of transaction doesn't need the last tx output
@@ -82,7 +79,7 @@ func NewCoinbaseTX(to, data string) *Transaction {
 	}
 	txin := TXInput{[]byte{}, -1, nil, []byte(data)} // remember this tx need no previous tx output
 	txout := NewTXOutput(subsidy, to)
-	tx := Transaction{nil, []TXInput{txin}, []TXOutput{*txout}}
+	tx := Transaction{nil, []TXInput{txin}, []TXOutput{*txout}, nil}
 	tx.ID = tx.Hash() // New way
 	return &tx
 }
@@ -100,114 +97,190 @@ func (out *TXOutput) CanBeUnlockedWith(unlockingData string) bool {
 
 // coinbase-type tx can be used to generate the GENESIS BLOCK, aka the first block in blockchain
 
+// Payment is one output a tx pays
+type Payment struct {
+	To     string
+	Amount int
+}
+
 // a more general type of transaction
-func NewUTXOTransaction(wallet *Wallet, to string, amount int, UTXO *UTXOSet) *Transaction {
-	// find out all unspent tx to spend
-	var inputs []TXInput
-	var outputs []TXOutput
+// the change goes to change, or back to the wallet's address when it is empty
+// cc picks the outputs to spend, nil selects them the default way
+func NewUTXOTransaction(wallet *Wallet, to, change string, amount int, UTXO *UTXOSet, cc *CoinControl) *Transaction {
+	return NewPaymentsTransaction(wallet, []Payment{{to, amount}}, change, UTXO, cc)
+}
+
+// NewPaymentsTransaction pays all of payments in one tx, with a single change output
+func NewPaymentsTransaction(wallet *Wallet, payments []Payment, change string, UTXO *UTXOSet, cc *CoinControl) *Transaction {
 	//wallets, err := NewWallets()
 	//if err != nil {
 	//	log.Panic(err)
 	//}
 	//wallet := wallets.GetWallet(from) // who sent the coin
-	FromPubKeyHash := HashPubKey(wallet.PublicKey)
-	acc, validOutputs := UTXO.FindSpendableOutputs(FromPubKeyHash, amount)
-
-	// validOutputs : map : string -> []int
-	if acc < amount {
-		log.Panic("Not Enough Coins")
+	if change == "" {
+		change = fmt.Sprintf("%s", wallet.GetAddress())
 	}
-	for txid, outs := range validOutputs {
-		hid, err := hex.DecodeString(txid)
-		if err != nil {
-			log.Panic(err)
-		}
-		for _, out := range outs {
-			txinput := TXInput{hid, out, nil, wallet.PublicKey}
-			inputs = append(inputs, txinput)
+	tx := buildUTXOTransaction(wallet.LockingKey(), payments, change, UTXO, cc)
+	UTXO.blockchain.SignTransaction(&tx, wallet) // first sign then return
+	return &tx
+}
+
+var errPaymentsOverflow = errors.New("the payments add up to more than an amount can hold")
+
+// PaymentsTotal is what payments pay together
+func PaymentsTotal(payments []Payment) (int, error) {
+	total := 0
+	for _, p := range payments {
+		if p.Amount > 0 && total+p.Amount < total {
+			return 0, errPaymentsOverflow
 		}
+		total += p.Amount
 	}
-	// two outputs : one for specific tx (receiver address); one for coin change
-	from := fmt.Sprintf("%s", wallet.GetAddress())
-	outputs = append(outputs, *NewTXOutput(amount, to))
-	if acc > amount { // why need this if statement
-		outputs = append(outputs, *NewTXOutput(acc-amount, from))
-	}
-	tx := Transaction{nil, inputs, outputs}
-	tx.ID = tx.Hash()
-	UTXO.blockchain.SignTransaction(&tx, wallet.PrivateKey) // first sign then return
-	return &tx
+	return total, nil
 }
 
-func (tx *Transaction) TrimmedCopy() Transaction {
-	var txInput []TXInput
-	var txOutput []TXOutput
-	for _, in := range tx.VIn {
-		txInput = append(txInput, TXInput{in.TXid, in.Vout, nil, nil})
-	} // we leave the Signature and PubKey out of the input
-	for _, out := range tx.VOut {
-		txOutput = append(txOutput, TXOutput{out.Value, out.PubKeyHash})
+// buildUTXOTransaction spends outputs locked to FromPubKeyHash, the inputs are not signed yet
+func buildUTXOTransaction(FromPubKeyHash []byte, payments []Payment, change string, UTXO *UTXOSet, cc *CoinControl) Transaction {
+	// find out all unspent tx to spend
+	var inputs []TXInput
+	var outputs []TXOutput
+	amount, err := PaymentsTotal(payments)
+	if err != nil {
+		log.Panic(err)
+	}
+	coins, acc, err := UTXO.SelectCoins(FromPubKeyHash, amount, cc)
+	if err != nil {
+		log.Panic(err)
 	}
-	txCopy := Transaction{tx.ID, txInput, txOutput}
-	return txCopy
+	for _, coin := range coins {
+		txinput := TXInput{coin.TXid, coin.Vout, nil, nil} // the key goes to the witness when signing
+		inputs = append(inputs, txinput)
+	}
+	// an output for every payment (receiver address) and one for coin change
+	for _, p := range payments {
+		outputs = append(outputs, *NewTXOutput(p.Amount, p.To))
+	}
+	if acc > amount { // no change when the coins add up to amount, see selectBranchAndBound
+		outputs = append(outputs, *NewTXOutput(acc-amount, change))
+	}
+	tx := Transaction{nil, inputs, outputs, nil}
+	tx.ID = tx.Hash() // witness is not part of the txid, so it's final already
+	return tx
+}
+
+// sign the ECDSA inputs with SIGHASH_ALL unless hashType says otherwise
+// the nonce is derived from the key and the sighash (RFC 6979), so signing twice gives the same bytes
+// signatures and the public key always go to the witness, any old-style unlocking data is dropped
+func (tx *Transaction) Sign(privKey ecdsa.PrivateKey, prevTXs map[string]Transaction, hashType SigHashType) {
+	pubKey := MarshalPubKey(&privKey.PublicKey)
+	tx.signInputs(prevTXs, OutputPubKeyHash, func(sigHash []byte) TXWitness {
+		sig := signECDSA(&privKey, sigHash)
+		return TXWitness{EncodeSignature(sig, hashType), pubKey}
+	}, hashType)
+}
+
+// sign the inputs spending Schnorr outputs, the key is in the output so the witness only has the signature
+func (tx *Transaction) SignSchnorr(secKey []byte, prevTXs map[string]Transaction, hashType SigHashType) {
+	tx.signInputs(prevTXs, OutputSchnorr, func(sigHash []byte) TXWitness {
+		sig, err := SchnorrSign(secKey, sigHash, nil)
+		if err != nil {
+			log.Panic(err)
+		}
+		return TXWitness{EncodeSignature(sig, hashType), nil}
+	}, hashType)
 }
 
-// sign the transaction
-func (tx *Transaction) Sign(privKey ecdsa.PrivateKey, prevTXs map[string]Transaction) {
+func (tx *Transaction) signInputs(prevTXs map[string]Transaction, outType OutputType, sign func([]byte) TXWitness, hashType SigHashType) {
 	if tx.isCoinbaseTX() {
 		return // Coinbase-type transaction need no inputs
 	}
-	txCopy := tx.TrimmedCopy() // part of a tx need to be signed, not all of it
-	for InId, vin := range txCopy.VIn {
-		prevTx := prevTXs[hex.EncodeToString(vin.TXid)]            // get the tx in the input
-		txCopy.VIn[InId].Signature = nil                           // make sure of that
-		txCopy.VIn[InId].PubKey = prevTx.VOut[vin.Vout].PubKeyHash // set the pubkey of the input to be hash
-
-		//txCopy.ID = txCopy.Hash() // hold it for now, hashes all the data above
-		//txCopy.VIn[InId].PubKey = nil // after hash and serialize, we put it down again
-		//r, s, err := ecdsa.Sign(rand.Reader, &privKey, txCopy.ID)
-
-		dataSign := fmt.Sprintf("%x\n", txCopy)
-		r, s, err := ecdsa.Sign(rand.Reader, &privKey, []byte(dataSign))
+	if len(tx.Witness) != len(tx.VIn) {
+		tx.Witness = make([]TXWitness, len(tx.VIn))
+	}
+	for InId, vin := range tx.VIn {
+		prevTx := prevTXs[hex.EncodeToString(vin.TXid)] // get the tx in the input
+		if vin.Vout < 0 || vin.Vout >= len(prevTx.VOut) {
+			log.Panic("ERROR: Previous output does not exist")
+		}
+		prevOut := prevTx.VOut[vin.Vout]
+		if prevOut.Type() != outType {
+			continue // signed by a different key
+		}
+		sigHash, err := tx.SignatureHash(InId, prevOut, hashType)
 		if err != nil {
 			log.Panic(err)
 		}
-		tx.VIn[InId].Signature = append(r.Bytes(), s.Bytes()...) // set it right
-		// we sign the input seperately, so we need to set ID for Vin every time
-		// tx is actually the transaction holding the signature
-		txCopy.VIn[InId].PubKey = nil
+		tx.VIn[InId].Signature = nil
+		tx.VIn[InId].PubKey = nil
+		tx.Witness[InId] = sign(sigHash) // set it right
 	}
 }
 
 func (tx *Transaction) Verify(prevTxs map[string]Transaction) bool {
-	txCopy := tx.TrimmedCopy()
-	curve := elliptic.P256()
+	batch := NewSchnorrBatch()
+	return tx.verifyInputs(prevTxs, batch) && verifySchnorrBatch(batch)
+}
 
+func (tx *Transaction) verifyInputs(prevTxs map[string]Transaction, batch *SchnorrBatch) bool {
+	if tx.HasWitness() && len(tx.Witness) != len(tx.VIn) {
+		return false
+	}
 	for InId, vin := range tx.VIn {
-		// regenerate the data to be signed, identical to the Sign
-		prevTX := prevTxs[hex.EncodeToString(vin.TXid)]
-		txCopy.VIn[InId].Signature = nil
-		txCopy.VIn[InId].PubKey = prevTX.VOut[vin.Vout].PubKeyHash
-		//txCopy.ID = txCopy.Hash()
-		//txCopy.VIn[InId].PubKey = nil
-
-		// set pubkey
-		PubKeyBytes := vin.PubKey
-		x, y := big.Int{}, big.Int{}
-		x.SetBytes(PubKeyBytes[:len(PubKeyBytes)/2])
-		y.SetBytes(PubKeyBytes[len(PubKeyBytes)/2:])
-		PubKey := ecdsa.PublicKey{curve, &x, &y}
-
-		// set signature
-		signature := vin.Signature
-		r, s := big.Int{}, big.Int{}
-		r.SetBytes(signature[:len(signature)/2])
-		s.SetBytes(signature[len(signature)/2:])
-		dataVerify := fmt.Sprintf("%x\n", txCopy)
-		if ecdsa.Verify(&PubKey, []byte(dataVerify), &r, &s) == false {
+		prevTX, ok := prevTxs[hex.EncodeToString(vin.TXid)]
+		if !ok || vin.Vout < 0 || vin.Vout >= len(prevTX.VOut) {
+			return false
+		}
+		if !tx.verifyInput(InId, prevTX.VOut[vin.Vout], batch) {
 			return false
 		}
-		txCopy.VIn[InId].PubKey = nil
 	}
 	return true
 }
+
+// both segwit and old-style inputs sign the same sighash, only the place of the signature differs
+// ECDSA signatures are checked right away, Schnorr ones are only added to batch
+// signatures found in the signature cache are not checked again
+func (tx *Transaction) verifyInput(InId int, prevOut TXOutput, batch *SchnorrBatch) bool {
+	if tx.HasWitness() && (len(tx.VIn[InId].Signature) != 0 || len(tx.VIn[InId].PubKey) != 0) {
+		return false // nothing signs these fields of a segwit tx, anyone could change its txid with them
+	}
+	// regenerate the data to be signed, identical to the Sign
+	signature, pubKey := tx.inputUnlock(InId)
+	sig, hashType, err := DecodeSignature(signature)
+	if err != nil {
+		return false
+	}
+	sigHash, err := tx.SignatureHash(InId, prevOut, hashType)
+	if err != nil {
+		return false
+	}
+
+	switch prevOut.Type() {
+	case OutputPubKeyHash:
+		if bytes.Compare(HashPubKey(pubKey), prevOut.PubKeyHash) != 0 {
+			return false // the key doesn't belong to the output being spent
+		}
+		if sigCache.Exists(sigHash, pubKey, sig) {
+			return true
+		}
+		PubKey, err := ParsePubKey(pubKey)
+		if err != nil {
+			return false
+		}
+		if !verifyECDSA(PubKey, sigHash, sig) {
+			return false
+		}
+		sigCache.Add(sigHash, pubKey, sig)
+		return true
+	case OutputSchnorr:
+		if !tx.HasWitness() || len(pubKey) != 0 {
+			return false // Schnorr outputs can only be spent through the witness
+		}
+		if !sigCache.Exists(sigHash, prevOut.PubKeyHash, sig) {
+			batch.Add(prevOut.PubKeyHash, sigHash, sig)
+		}
+		return true
+	default:
+		return false
+	}
+}
@@ -2,7 +2,6 @@ package main
 
 import (
 	"bytes"
-	"encoding/gob"
 	"log"
 )
 
@@ -14,11 +13,43 @@ type TXInput struct {
 	PubKey    []byte // signature of the last user
 }
 
+// TXWitness holds what unlocks an input of a segwit transaction
+// it is kept out of the txid, so re-encoding a signature can't change the ID of the transaction
+type TXWitness struct {
+	Signature []byte
+	PubKey    []byte
+}
+
 type TXOutput struct {
 	Value int // the bitcoin
 	//ScriptPubKey string // the public key to verify a transaction
-	PubKeyHash []byte // hash my pubkey for you to check
+	PubKeyHash []byte // hash my pubkey for you to check, or the key itself for Schnorr outputs
+}
+
+// OutputType tells how an output is unlocked, it follows from the length of PubKeyHash
+// the same way segwit v0 and v1 programs are told apart by their size
+type OutputType int
+
+const (
+	OutputPubKeyHash        OutputType = iota // 20-byte hash of a P-256 ECDSA key
+	OutputSchnorr                             // 32-byte x-only secp256k1 key, spent with a BIP-340 signature
+	OutputWitnessCommitment                   // unspendable, see witness.go
+	OutputUnknown
+)
+
+func (out *TXOutput) Type() OutputType {
+	switch {
+	case out.isWitnessCommitment():
+		return OutputWitnessCommitment
+	case len(out.PubKeyHash) == ripemd160Size:
+		return OutputPubKeyHash
+	case len(out.PubKeyHash) == schnorrKeyLen:
+		return OutputSchnorr
+	default:
+		return OutputUnknown
+	}
 }
+
 type TXOutputs struct {
 	Outputs []TXOutput
 }
@@ -29,9 +60,10 @@ func (in *TXInput) UseKey(pubKeyHash []byte) bool {
 }
 
 func (out *TXOutput) Lock(address []byte) {
-	// address to verify
-	decoded := Base58Decode(address)
-	pubKeyHash := decoded[1 : len(decoded)-4] // the middle part
+	pubKeyHash, err := DecodeAddress(string(address)) // Base58 or Bech32
+	if err != nil {
+		log.Panic(err)
+	}
 	out.PubKeyHash = pubKeyHash
 }
 
@@ -45,22 +77,29 @@ func NewTXOutput(coins int, address string) *TXOutput {
 	return tx
 }
 
+// varint count followed by the outputs, same output layout as in a transaction
 func (outs TXOutputs) Serialize() []byte {
 	var buff bytes.Buffer
-	enc := gob.NewEncoder(&buff)
-	err := enc.Encode(outs)
-	if err != nil {
-		log.Panic(err)
+	writeVarInt(&buff, uint64(len(outs.Outputs)))
+	for _, out := range outs.Outputs {
+		writeTXOutput(&buff, out)
 	}
 	return buff.Bytes()
 }
 
 func DeserializeOutputs(value []byte) TXOutputs {
 	var outs TXOutputs
-	dec := gob.NewDecoder(bytes.NewReader(value))
-	err := dec.Decode(&outs)
+	r := bytes.NewReader(value)
+	n, err := readCount(r)
 	if err != nil {
 		log.Panic(err)
 	}
+	for i := 0; i < n; i++ {
+		out, err := readTXOutput(r)
+		if err != nil {
+			log.Panic(err)
+		}
+		outs.Outputs = append(outs.Outputs, out)
+	}
 	return outs
 }
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+)
+
+/*
+Undo data of a block: what connecting it removed from the UTXO set, so it can be put back.
+One list per transaction of the block, in block order (the coinbase list is empty):
+
+	varint tx count | per tx: varint count | per spent output:
+	    varbytes txid | uint32 vout | output | uint32 height | uint8 coinbase
+
+It is written by UTXOSet.Update next to the block, see BlockStore.PutUndo.
+*/
+type spentOutput struct {
+	Outpoint
+	UTXOEntry
+}
+
+type blockUndo struct {
+	Txs [][]spentOutput
+}
+
+func (u *blockUndo) Serialize() []byte {
+	var buff bytes.Buffer
+	writeVarInt(&buff, uint64(len(u.Txs)))
+	for _, spent := range u.Txs {
+		writeVarInt(&buff, uint64(len(spent)))
+		for _, s := range spent {
+			writeVarBytes(&buff, s.TXid)
+			writeUint32(&buff, uint32(s.Vout))
+			writeTXOutput(&buff, s.Output)
+			writeUint32(&buff, uint32(s.Height))
+			coinbase := byte(0)
+			if s.Coinbase {
+				coinbase = 1
+			}
+			buff.WriteByte(coinbase)
+		}
+	}
+	return buff.Bytes()
+}
+
+func DeserializeBlockUndo(data []byte) (*blockUndo, error) {
+	r := bytes.NewReader(data)
+	n, err := readCount(r)
+	if err != nil {
+		return nil, err
+	}
+	u := &blockUndo{}
+	for i := 0; i < n; i++ {
+		count, err := readCount(r)
+		if err != nil {
+			return nil, err
+		}
+		spent := []spentOutput{}
+		for j := 0; j < count; j++ {
+			var s spentOutput
+			if s.TXid, err = readVarBytes(r); err != nil {
+				return nil, err
+			}
+			vout, err := readUint32(r)
+			if err != nil {
+				return nil, err
+			}
+			if s.Output, err = readTXOutput(r); err != nil {
+				return nil, err
+			}
+			height, err := readUint32(r)
+			if err != nil {
+				return nil, err
+			}
+			coinbase, err := r.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			if coinbase > 1 {
+				return nil, fmt.Errorf("bad coinbase flag %d in undo data", coinbase)
+			}
+			s.Vout, s.Height, s.Coinbase = int(vout), int(height), coinbase == 1
+			spent = append(spent, s)
+		}
+		u.Txs = append(u.Txs, spent)
+	}
+	return u, checkFullyRead(r)
+}
+
+/*
+Disconnect takes a block off the UTXO set, it must be the last block applied with Update.
+Outputs the block created are removed and the outputs it spent are put back from its undo data,
+the set is then exactly what it was before the block. Blocks applied before undo data existed, or
+covered by a loaded snapshot, have none until the next Reindex and errUndoNotFound is returned
+*/
+func (utxo UTXOSet) Disconnect(b *block) error {
+	return utxo.blockchain.db.Update(func(stx *StorageTx) error {
+		return disconnectUTXO(stx, b)
+	})
+}
+
+// disconnectUTXO is Disconnect inside an update, see setTip
+func disconnectUTXO(stx *StorageTx, b *block) error {
+	if best := stx.Chainstate().BestBlock(); !bytes.Equal(best, b.Hash) {
+		return fmt.Errorf("block %x is not the last block of the UTXO set, that is %x", b.Hash, best)
+	}
+	data, err := stx.Blocks().GetUndo(b.Hash)
+	if err != nil {
+		return err
+	}
+	undo, err := DeserializeBlockUndo(data)
+	if err != nil {
+		return err
+	}
+	if len(undo.Txs) != len(b.Transactions) {
+		return fmt.Errorf("undo data of block %x does not match its transactions", b.Hash)
+	}
+	chainstate := stx.Chainstate()
+	// backwards, a tx may spend an output created earlier in the same block
+	for i := len(b.Transactions) - 1; i >= 0; i-- {
+		tx := b.Transactions[i]
+		for vout := range tx.VOut {
+			if err = chainstate.Delete(Outpoint{tx.ID, vout}); err != nil {
+				return err
+			}
+		}
+		for _, s := range undo.Txs[i] {
+			if chainstate.Has(s.Outpoint) {
+				return fmt.Errorf("undo data of block %x does not match the UTXO set", b.Hash)
+			}
+			if err = chainstate.Put(s.Outpoint, s.UTXOEntry); err != nil {
+				return err
+			}
+		}
+	}
+	return chainstate.SetBestBlock(b.PrevBlockHash)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+// utxoState is the commitment and best block of the UTXO set
+func utxoState(t *testing.T, bc *BlockChain) ([]byte, []byte) {
+	t.Helper()
+	var commitment, best []byte
+	err := bc.db.View(func(tx *StorageTx) error {
+		commitment, best = tx.Chainstate().Commitment(), tx.Chainstate().BestBlock()
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return commitment, best
+}
+
+func TestBlockUndoRoundTrip(t *testing.T) {
+	u := &blockUndo{[][]spentOutput{
+		{},
+		{{Outpoint{[]byte{1, 2, 3}, 4}, UTXOEntry{TXOutput{7, []byte{9}}, 12, true}},
+			{Outpoint{[]byte{5}, 0}, UTXOEntry{TXOutput{1, []byte{8, 8}}, 3, false}}},
+	}}
+	back, err := DeserializeBlockUndo(u.Serialize())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(back.Serialize(), u.Serialize()) || len(back.Txs) != 2 || !back.Txs[1][0].Coinbase {
+		t.Fatal("the undo data changed in a round trip")
+	}
+	if _, err := DeserializeBlockUndo(append(u.Serialize(), 0)); err == nil {
+		t.Fatal("trailing bytes are taken")
+	}
+}
+
+func TestDisconnectRestoresUTXOSet(t *testing.T) {
+	bc, w := newTestChain(t)
+	before, genesis := utxoState(t, bc)
+	tx := NewPaymentsTransaction(w, []Payment{{string(NewWallet().GetAddress()), 4}}, "", &UTXOSet{bc}, nil)
+	b, err := bc.MineBlock([]*Transaction{NewCoinbaseTX(string(w.GetAddress()), ""), tx})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after, best := utxoState(t, bc); bytes.Equal(after, before) || !bytes.Equal(best, b.Hash) {
+		t.Fatal("the block did not change the UTXO set")
+	}
+	if err := (UTXOSet{bc}).Disconnect(b); err != nil {
+		t.Fatal(err)
+	}
+	if after, best := utxoState(t, bc); !bytes.Equal(after, before) || !bytes.Equal(best, genesis) {
+		t.Fatal("the UTXO set is not what it was before the block")
+	}
+}
+
+func TestDisconnectOnlyTheBestBlock(t *testing.T) {
+	bc, w := newTestChain(t)
+	first, err := bc.MineBlock([]*Transaction{NewCoinbaseTX(string(w.GetAddress()), "")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bc.MineBlock([]*Transaction{NewCoinbaseTX(string(w.GetAddress()), "")}); err != nil {
+		t.Fatal(err)
+	}
+	before, _ := utxoState(t, bc)
+	if err := (UTXOSet{bc}).Disconnect(first); err == nil {
+		t.Fatal("a block below the best one was disconnected")
+	}
+	if after, _ := utxoState(t, bc); !bytes.Equal(after, before) {
+		t.Fatal("a refused disconnect changed the UTXO set")
+	}
+}
+
+func TestReorgMatchesReindex(t *testing.T) {
+	bc, w := newTestChain(t)
+	genesis, err := bc.GetBlock(bc.tip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	to := NewWallet()
+	tx := NewPaymentsTransaction(w, []Payment{{string(to.GetAddress()), 4}}, "", &UTXOSet{bc}, nil)
+	if _, err := bc.MineBlock([]*Transaction{NewCoinbaseTX(string(w.GetAddress()), ""), tx}); err != nil {
+		t.Fatal(err)
+	}
+	// a longer branch from the genesis block without the payment
+	b1 := forkBlock(&genesis)
+	b2 := forkBlock(b1)
+	bc.AddBlock(b1)
+	bc.AddBlock(b2)
+	if !bytes.Equal(bc.tip, b2.Hash) {
+		t.Fatal("the longer branch is not the tip")
+	}
+	if balance := outputsValue((UTXOSet{bc}).FindUTXO(to.LockingKey())); balance != 0 {
+		t.Fatalf("the payment of the old branch is still unspent: %d", balance)
+	}
+	reorged, best := utxoState(t, bc)
+	if !bytes.Equal(best, b2.Hash) {
+		t.Fatal("the UTXO set is not at the new tip")
+	}
+	UTXOSet{bc}.Reindex()
+	if reindexed, _ := utxoState(t, bc); !bytes.Equal(reindexed, reorged) {
+		t.Fatal("the UTXO set after the reorg is not the one of a reindex")
+	}
+}
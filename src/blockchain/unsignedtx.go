@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"strings"
+)
+
+/*
+An unsigned tx goes from a watching node to the node with the key, which may be offline, and back
+as a file with the hex of:
+
+	tx | varint count | the output spent by each input | varint count | the MuSig session of each input
+	   | varint count | the derivation path of each input
+
+The signer has no chain, the spent outputs tell it which key signs an input and what the
+signature commits to. It writes the same file back with the tx signed, sendtx passes it on.
+Inputs locked to a MuSig key carry their signers and how far they are, see musigspend.go. A file
+without any has no sessions at all. Inputs of an address derived from an extended public key carry
+its chain/index, the signer derives the key from its HD seed even when it never handed the address
+out itself, e.g. the change addresses of the watching node. Files without paths end after the sessions.
+*/
+var errNothingToSign = errors.New("none of the inputs is locked to a key in the wallet")
+
+type UnsignedTx struct {
+	Tx    Transaction
+	Spent []TXOutput
+	MuSig []*MuSigInput // nil, or one per input with nil for the inputs that are not MuSig ones
+	Paths [][]uint32    // nil, or the chain/index of each input below the account key, nil when unknown
+}
+
+// NewUnsignedTransaction spends outputs locked to lockingKey, like NewPaymentsTransaction without the signing
+func NewUnsignedTransaction(lockingKey []byte, payments []Payment, change string, UTXO *UTXOSet, cc *CoinControl) *UnsignedTx {
+	u := &UnsignedTx{Tx: buildUTXOTransaction(lockingKey, payments, change, UTXO, cc)}
+	err := UTXO.blockchain.db.View(func(tx *StorageTx) error {
+		for _, vin := range u.Tx.VIn {
+			entry, ok := tx.Chainstate().Get(Outpoint{vin.TXid, vin.Vout})
+			if !ok {
+				return errSpendsMissingOutput
+			}
+			u.Spent = append(u.Spent, entry.Output)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+	return u
+}
+
+func (u *UnsignedTx) Serialize() []byte {
+	var buff bytes.Buffer
+	buff.Write(u.Tx.Serialize())
+	buff.Write(TXOutputs{u.Spent}.Serialize())
+	writeVarInt(&buff, uint64(len(u.MuSig)))
+	for _, s := range u.MuSig {
+		if s == nil {
+			writeVarInt(&buff, 0)
+			continue
+		}
+		buff.Write(s.Serialize())
+	}
+	if u.Paths != nil { // left out otherwise, older signers read the file as before
+		writeVarInt(&buff, uint64(len(u.Paths)))
+		for _, path := range u.Paths {
+			writeVarInt(&buff, uint64(len(path)))
+			for _, index := range path {
+				writeVarInt(&buff, uint64(index))
+			}
+		}
+	}
+	return buff.Bytes()
+}
+
+func DeserializeUnsignedTx(data []byte) (*UnsignedTx, error) {
+	r := bytes.NewReader(data)
+	tx, err := readTransaction(r)
+	if err != nil {
+		return nil, err
+	}
+	n, err := readCount(r)
+	if err != nil {
+		return nil, err
+	}
+	u := &UnsignedTx{Tx: *tx}
+	for i := 0; i < n; i++ {
+		out, err := readTXOutput(r)
+		if err != nil {
+			return nil, err
+		}
+		u.Spent = append(u.Spent, out)
+	}
+	if len(u.Spent) != len(tx.VIn) {
+		return nil, errors.New("the unsigned tx does not have a spent output per input")
+	}
+	if r.Len() == 0 { // written before MuSig sessions
+		return u, nil
+	}
+	if n, err = readCount(r); err != nil {
+		return nil, err
+	}
+	if n != 0 && n != len(tx.VIn) {
+		return nil, errors.New("the unsigned tx does not have a MuSig session per input")
+	}
+	for i := 0; i < n; i++ {
+		s, err := readMuSigInput(r)
+		if err != nil {
+			return nil, err
+		}
+		u.MuSig = append(u.MuSig, s)
+	}
+	if r.Len() == 0 { // written before derivation paths
+		return u, nil
+	}
+	if n, err = readCount(r); err != nil {
+		return nil, err
+	}
+	if n != len(tx.VIn) {
+		return nil, errors.New("the unsigned tx does not have a derivation path per input")
+	}
+	for i := 0; i < n; i++ {
+		depth, err := readCount(r)
+		if err != nil {
+			return nil, err
+		}
+		var path []uint32
+		for j := 0; j < depth; j++ {
+			index, err := readVarInt(r)
+			if err != nil {
+				return nil, err
+			}
+			if index > math.MaxUint32 {
+				return nil, errors.New("the derivation path has an index out of range")
+			}
+			path = append(path, uint32(index))
+		}
+		u.Paths = append(u.Paths, path)
+	}
+	return u, checkFullyRead(r)
+}
+
+func (u *UnsignedTx) WriteFile(path string) {
+	err := ioutil.WriteFile(path, []byte(hex.EncodeToString(u.Serialize())+"\n"), 0644)
+	if err != nil {
+		log.Panic(err)
+	}
+}
+
+func ReadUnsignedTx(path string) (*UnsignedTx, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := hex.DecodeString(strings.TrimSpace(string(content)))
+	if err != nil {
+		return nil, err
+	}
+	return DeserializeUnsignedTx(data)
+}
+
+/*
+Sign signs the inputs whose spent output is locked to a key of wallets, or to the key of the HD
+wallet at the path of the input, and returns how many. Each key only sees its own spent outputs,
+the other inputs are skipped by signInputs
+*/
+func (u *UnsignedTx) Sign(wallets *Wallets) (int, error) {
+	byKey := make(map[string]*Wallet)
+	for _, w := range wallets.Wallets {
+		byKey[hex.EncodeToString(w.LockingKey())] = w
+	}
+	inputs := make(map[*Wallet][]int)
+	for i, out := range u.Spent {
+		key := hex.EncodeToString(out.PubKeyHash)
+		if _, found := byKey[key]; !found && wallets.HD != nil && i < len(u.Paths) && isHDPath(u.Paths[i]) {
+			// the address is not in the wallet, the key at the path is the right one when it locks the output
+			if w := wallets.HD.wallet(int(u.Paths[i][0]), int(u.Paths[i][1])); bytes.Equal(w.LockingKey(), out.PubKeyHash) {
+				byKey[key] = w
+			}
+		}
+		if w, found := byKey[key]; found {
+			inputs[w] = append(inputs[w], i)
+		}
+	}
+	if len(inputs) == 0 {
+		return 0, errNothingToSign
+	}
+	signed := 0
+	for w, ins := range inputs {
+		if !w.CanSign() {
+			return 0, errWalletLocked
+		}
+		prevTXs := make(map[string]Transaction)
+		for _, vin := range u.Tx.VIn { // every input needs its tx, empty outputs are not signed
+			id := hex.EncodeToString(vin.TXid)
+			prev := prevTXs[id]
+			for len(prev.VOut) <= vin.Vout {
+				prev.VOut = append(prev.VOut, TXOutput{})
+			}
+			prevTXs[id] = prev
+		}
+		for _, i := range ins {
+			vin := u.Tx.VIn[i]
+			prevTXs[hex.EncodeToString(vin.TXid)].VOut[vin.Vout] = u.Spent[i]
+		}
+		if w.IsSchnorr() {
+			u.Tx.SignSchnorr(w.SchnorrKey, prevTXs, SigHashAll)
+		} else {
+			u.Tx.Sign(w.PrivateKey, prevTXs, SigHashAll)
+		}
+		signed += len(ins)
+	}
+	return signed, nil
+}
+
+// isHDPath tells whether path is a chain/index below the account key
+func isHDPath(path []uint32) bool {
+	return len(path) == 2 && path[0] <= hdChangeChain && path[1] < hdHardened
+}
+
+// IsSigned tells whether every input has a signature
+func (u *UnsignedTx) IsSigned() bool {
+	if len(u.Tx.Witness) != len(u.Tx.VIn) {
+		return false
+	}
+	for _, w := range u.Tx.Witness {
+		if len(w.Signature) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (u *UnsignedTx) String() string {
+	var lines []string
+	spent := 0
+	for _, out := range u.Spent {
+		spent += out.Value
+	}
+	lines = append(lines, fmt.Sprintf("tx %x spends %d in %d inputs", u.Tx.ID, spent, len(u.Tx.VIn)))
+	for _, out := range u.Tx.VOut {
+		lines = append(lines, fmt.Sprintf("  pays %d to %s", out.Value, LockingKeyAddress(out.PubKeyHash)))
+	}
+	for i, s := range u.MuSig {
+		if s != nil {
+			nonces, partials := 0, 0
+			for j := range s.PubKeys {
+				if s.PubNonces[j] != nil {
+					nonces++
+				}
+				if s.Partials[j] != nil {
+					partials++
+				}
+			}
+			lines = append(lines, fmt.Sprintf("  input %d: MuSig of %d keys, %d nonces, %d partial signatures",
+				i, len(s.PubKeys), nonces, partials))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestUnsignedTxFormats(t *testing.T) {
+	u := unsignedSpend(NewWallet().LockingKey(), NewWallet().LockingKey())
+	plain := roundTrip(t, u)
+	if plain.MuSig != nil || plain.Paths != nil {
+		t.Fatal("a plain unsigned tx came back with sessions or paths")
+	}
+
+	// a file from before MuSig sessions ends after the spent outputs
+	old := append(u.Tx.Serialize(), TXOutputs{u.Spent}.Serialize()...)
+	if back, err := DeserializeUnsignedTx(old); err != nil || len(back.Spent) != 2 {
+		t.Fatalf("a file without sessions: %v", err)
+	}
+
+	_, pubKeys := musigSigners(t, 2)
+	u.MuSig = []*MuSigInput{nil, newMuSigInput(pubKeys)}
+	u.Paths = [][]uint32{{hdChangeChain, 3}, nil}
+	back := roundTrip(t, u)
+	if back.MuSig[0] != nil || len(back.MuSig[1].PubKeys) != 2 || back.MuSig[1].PubNonces[0] != nil {
+		t.Fatal("the MuSig sessions came back wrong")
+	}
+	if len(back.Paths) != 2 || back.Paths[0][1] != 3 || back.Paths[1] != nil {
+		t.Fatalf("the paths came back as %v", back.Paths)
+	}
+
+	data := u.Serialize()
+	if _, err := DeserializeUnsignedTx(append(data, 0)); err == nil {
+		t.Fatal("trailing bytes are taken")
+	}
+}
+
+func TestSignWithDerivationPath(t *testing.T) {
+	signer := &Wallets{Wallets: make(map[string]*Wallet)}
+	signer.setHD(newHDState(MnemonicSeed(NewMnemonic(mnemonicEntropyBits), "")))
+	// a change address the signer never handed out, like one of a watching node
+	change := signer.HD.wallet(hdChangeChain, 5)
+	u := unsignedSpend(change.LockingKey())
+	if _, err := u.Sign(signer); err != errNothingToSign {
+		t.Fatalf("signed without the path: %v", err)
+	}
+	u.Paths = [][]uint32{{hdChangeChain, 4}}
+	if _, err := u.Sign(signer); err != errNothingToSign {
+		t.Fatalf("signed with the key of another path: %v", err)
+	}
+	u.Paths = [][]uint32{{hdChangeChain, 5}}
+	u = roundTrip(t, u)
+	if signed, err := u.Sign(signer); err != nil || signed != 1 || !u.IsSigned() {
+		t.Fatalf("signed %d inputs: %v", signed, err)
+	}
+	msg, err := u.Tx.SignatureHash(0, u.Spent[0], SigHashAll)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, _, err := DecodeSignature(u.Tx.Witness[0].Signature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := ParsePubKey(u.Tx.Witness[0].PubKey)
+	if err != nil || !verifyECDSA(pub, msg, sig) {
+		t.Fatalf("the signature does not verify: %v", err)
+	}
+}
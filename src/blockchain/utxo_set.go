@@ -1,49 +1,143 @@
 package main
 
 import (
+	"bytes"
+	"encoding/binary"
 	"encoding/hex"
-	"github.com/boltdb/bolt"
+	"errors"
+	"fmt"
 	"log"
 )
 
-const utxoBucket = "chainstate"
+/*
+The UTXO set has one entry per unspent output:
+
+	key   txid | uint32 vout, big endian so the outputs of a tx are next to each other
+	value output | uint32 height | uint8 coinbase
+
+It used to map a txid to the list of its unspent outputs, where spending one shifted the
+positions of the others. That bucket (chainstate) is dropped and rebuilt as utxo on startup.
+The commitment of the set and the block it is at are kept next to it, see kvChainstateStore.
+*/
+const utxoBucket = "utxo"
+const oldUTXOBucket = "chainstate"
+
+var errSpendsMissingOutput = errors.New("block spends an output that is not in the UTXO set")
+var errBadBlockSignature = errors.New("block has a transaction whose signature does not verify")
+var errInvalidTransaction = errors.New("invalid transaction")
 
 type UTXOSet struct {
 	blockchain *BlockChain
 }
 
+type Outpoint struct {
+	TXid []byte
+	Vout int
+}
+
+// UTXOEntry is an unspent output with where it comes from
+type UTXOEntry struct {
+	Output   TXOutput
+	Height   int  // height of the block that created the output
+	Coinbase bool // created by a coinbase tx
+}
+
+func (op Outpoint) Key() []byte {
+	key := make([]byte, len(op.TXid)+4)
+	copy(key, op.TXid)
+	binary.BigEndian.PutUint32(key[len(op.TXid):], uint32(op.Vout))
+	return key
+}
+
+func OutpointFromKey(key []byte) (Outpoint, error) {
+	if len(key) < 4 {
+		return Outpoint{}, errors.New("outpoint key is too short")
+	}
+	n := len(key) - 4
+	return Outpoint{append([]byte{}, key[:n]...), int(binary.BigEndian.Uint32(key[n:]))}, nil
+}
+
+func writeUTXOEntry(buff *bytes.Buffer, e UTXOEntry) {
+	writeTXOutput(buff, e.Output)
+	writeUint32(buff, uint32(e.Height))
+	coinbase := byte(0)
+	if e.Coinbase {
+		coinbase = 1
+	}
+	buff.WriteByte(coinbase)
+}
+
+func readUTXOEntry(r *bytes.Reader) (UTXOEntry, error) {
+	var e UTXOEntry
+	var err error
+	if e.Output, err = readTXOutput(r); err != nil {
+		return e, err
+	}
+	height, err := readUint32(r)
+	if err != nil {
+		return e, err
+	}
+	coinbase, err := r.ReadByte()
+	if err != nil {
+		return e, err
+	}
+	if coinbase > 1 {
+		return e, errors.New("bad coinbase flag in UTXO entry")
+	}
+	e.Height, e.Coinbase = int(height), coinbase == 1
+	return e, nil
+}
+
+func (e UTXOEntry) Serialize() []byte {
+	var buff bytes.Buffer
+	writeUTXOEntry(&buff, e)
+	return buff.Bytes()
+}
+
+func DeserializeUTXOEntry(data []byte) (UTXOEntry, error) {
+	r := bytes.NewReader(data)
+	e, err := readUTXOEntry(r)
+	if err != nil {
+		return e, err
+	}
+	return e, checkFullyRead(r)
+}
+
 /*
-uses FindUTXO to find unspent outputs, and stores them in a database. This is where caching happens.
+Reindex builds the set again by replaying the active chain from the genesis block, the undo data
+of every block is written again on the way
 */
 func (utxo UTXOSet) Reindex() {
-	db := utxo.blockchain.db // use the same db but different bucket
-	bucketName := []byte(utxoBucket)
-	err := db.Update(func(tx *bolt.Tx) error {
-		err := tx.DeleteBucket(bucketName)
-		if err != nil && err != bolt.ErrBucketNotFound {
-			log.Panic(err)
+	if height := utxo.blockchain.db.PruneHeight(); height > 0 {
+		log.Panicf("the blocks below height %d are pruned, the UTXO set can not be rebuilt. Sync a new db instead", height)
+	}
+	var hashes [][]byte
+	bci := utxo.blockchain.Iterator()
+	for {
+		b := bci.Next()
+		hashes = append(hashes, b.Hash)
+		if len(b.PrevBlockHash) == 0 {
+			break
 		}
-		_, err = tx.CreateBucket(bucketName)
+	}
 
-		if err != nil {
-			log.Panic(err)
+	db := utxo.blockchain.db // use the same db but different store
+
+	// the old set is only replaced once the new one is complete
+	err := db.Update(func(tx *StorageTx) error {
+		if err := tx.kv.DeleteBucket([]byte(oldUTXOBucket)); err != nil && err != errBucketNotFound {
+			return err
 		}
-		return nil
-	})
-	if err != nil {
-		log.Panic(err)
-	}
-	UTXO := utxo.blockchain.FindUTXO()
-	err = db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(utxoBucket))
-		for txId, outs := range UTXO {
-			key, e := hex.DecodeString(txId)
-			if e != nil {
-				log.Panic(e)
+		if err := tx.Chainstate().Reset(); err != nil {
+			return err
+		}
+		for i := len(hashes) - 1; i >= 0; i-- {
+			b, err := tx.Blocks().Get(hashes[i])
+			if err != nil {
+				return err
 			}
-			e = b.Put(key, outs.Serialize())
-			if e != nil {
-				log.Panic(e)
+			if err = connectUTXO(tx, b); err != nil {
+				return err
 			}
 		}
 		return nil
@@ -57,23 +151,18 @@ func (utxo UTXOSet) Reindex() {
 //  used to send coins:
 func (utxo UTXOSet) FindSpendableOutputs(PubKeyHash []byte, amount int) (int, map[string][]int) {
 	accumulated := 0
-	unspentOutputs := make(map[string][]int) // txid : outIdx
+	unspentOutputs := make(map[string][]int) // txid : vout
 	db := utxo.blockchain.db
 
-	err := db.View(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket([]byte(utxoBucket))
-		c := bucket.Cursor()
-		for k, v := c.First(); k != nil; k, v = c.Next() {
-			txid := hex.EncodeToString(k)
-			outs := DeserializeOutputs(v)           // remember the Reindex
-			for outIdx, out := range outs.Outputs { // outIdx is basically the idx, really? This way, there would be so many same output idx
-				if out.isLockedWithKey(PubKeyHash) && accumulated < amount {
-					accumulated += out.Value
-					unspentOutputs[txid] = append(unspentOutputs[txid], outIdx)
-				}
+	err := db.View(func(tx *StorageTx) error {
+		return tx.Chainstate().ForEach(func(op Outpoint, entry UTXOEntry) error {
+			if entry.Output.isLockedWithKey(PubKeyHash) && accumulated < amount {
+				txid := hex.EncodeToString(op.TXid)
+				accumulated += entry.Output.Value
+				unspentOutputs[txid] = append(unspentOutputs[txid], op.Vout)
 			}
-		}
-		return nil
+			return nil
+		})
 	})
 	if err != nil {
 		log.Panic(err)
@@ -85,18 +174,13 @@ func (utxo UTXOSet) FindSpendableOutputs(PubKeyHash []byte, amount int) (int, ma
 func (utxo UTXOSet) FindUTXO(PubKeyHash []byte) []TXOutput {
 	var unspentTXO []TXOutput
 	db := utxo.blockchain.db
-	err := db.View(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket([]byte(utxoBucket))
-		c := bucket.Cursor()
-		for k, v := c.First(); k != nil; k, v = c.Next() {
-			outs := DeserializeOutputs(v)
-			for _, out := range outs.Outputs {
-				if out.isLockedWithKey(PubKeyHash) { // only pick the unlocked output
-					unspentTXO = append(unspentTXO, out)
-				}
+	err := db.View(func(tx *StorageTx) error {
+		return tx.Chainstate().ForEach(func(_ Outpoint, entry UTXOEntry) error {
+			if entry.Output.isLockedWithKey(PubKeyHash) { // only pick the unlocked output
+				unspentTXO = append(unspentTXO, entry.Output)
 			}
-		}
-		return nil
+			return nil
+		})
 	})
 	if err != nil {
 		log.Panic(err)
@@ -104,72 +188,198 @@ func (utxo UTXOSet) FindUTXO(PubKeyHash []byte) []TXOutput {
 	return unspentTXO
 }
 
+// IsUnspent tells whether output vout of txid is in the set, a single key lookup
+func (utxo UTXOSet) IsUnspent(txid []byte, vout int) bool {
+	found := false
+	err := utxo.blockchain.db.View(func(tx *StorageTx) error {
+		found = tx.Chainstate().Has(Outpoint{txid, vout})
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+	return found
+}
+
+/*
+unspentTransaction is a stand-in for a tx whose block is pruned: its unspent outputs at their
+positions, the spent ones left empty. ok is false when nothing of the tx is unspent
+*/
+func (utxo UTXOSet) unspentTransaction(txid []byte) (Transaction, bool) {
+	var unspent map[int]UTXOEntry
+	err := utxo.blockchain.db.View(func(tx *StorageTx) error {
+		unspent = tx.Chainstate().Unspent(txid)
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+	if len(unspent) == 0 {
+		return Transaction{}, false
+	}
+	t := Transaction{ID: txid}
+	for vout, entry := range unspent {
+		for len(t.VOut) <= vout {
+			t.VOut = append(t.VOut, TXOutput{})
+		}
+		t.VOut[vout] = entry.Output
+	}
+	return t, true
+}
+
 // Such separation requires solid synchronization mechanism
 // But we don’t want to reindex every time a new block is mined
 // Thus, we need a mechanism of updating the UTXO set:
 
 func (utxo UTXOSet) Update(block *block) {
 	db := utxo.blockchain.db
-	err := db.Update(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket([]byte(utxoBucket))
-		for _, tx := range block.Transactions {
-			if tx.isCoinbaseTX() == false {
-				for _, vin := range tx.VIn {
-					updateOuts := TXOutputs{}
-					oldOuts := DeserializeOutputs(bucket.Get(vin.TXid))
-					for outIdx, out := range oldOuts.Outputs {
-						if outIdx != vin.Vout {
-							updateOuts.Outputs = append(updateOuts.Outputs, out)
-						}
-					}
-					if len(updateOuts.Outputs) == 0 {
-						e := bucket.Delete(vin.TXid) // no need to cache
-						if e != nil {
-							log.Panic(e)
-						}
-					} else {
-						e := bucket.Put(vin.TXid, updateOuts.Serialize()) // only cache the new
-						// store outputs of most recent transactions.
-						if e != nil {
-							log.Panic(e)
-						}
-					}
+	err := db.Update(func(stx *StorageTx) error {
+		return connectUTXO(stx, block)
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+	/*
+		Updating means removing spent outputs and
+		adding unspent outputs from newly mined transactions.
+		The removed outputs are kept as the undo data of the block, see Disconnect.
+	*/
+}
+
+// connectUTXO applies a block to the set and writes its undo data, the signatures of the block have to verify
+func connectUTXO(stx *StorageTx, block *block) error {
+	chainstate := stx.Chainstate()
+	undo, err := applyBlock(chainstate, block)
+	if err != nil {
+		return err
+	}
+	if !verifyBlockSpends(block, undo) {
+		return errBadBlockSignature
+	}
+	if err = chainstate.SetBestBlock(block.Hash); err != nil {
+		return err
+	}
+	return stx.Blocks().PutUndo(block.Hash, undo.Serialize())
+}
+
+// applyBlock spends the inputs of the block's transactions and adds their outputs, it returns what was spent
+func applyBlock(chainstate ChainstateStore, block *block) (*blockUndo, error) {
+	undo := &blockUndo{}
+	for _, tx := range block.Transactions {
+		spent := []spentOutput{}
+		if tx.isCoinbaseTX() == false {
+			for _, vin := range tx.VIn {
+				op := Outpoint{vin.TXid, vin.Vout}
+				entry, ok := chainstate.Get(op)
+				if !ok {
+					return nil, errSpendsMissingOutput
 				}
+				if err := chainstate.Delete(op); err != nil {
+					return nil, err
+				}
+				spent = append(spent, spentOutput{op, entry}) // remember it, Disconnect puts it back
 			}
-			// tx is the candidate (new, because it is in the block), bucket get is the target (old)
-			newOuts := TXOutputs{}
-			for _, out := range tx.VOut {
-				newOuts.Outputs = append(newOuts.Outputs, out)
+		}
+		for vout, out := range tx.VOut {
+			if out.Type() == OutputWitnessCommitment { // can never be spent
+				continue
 			}
-			e := bucket.Put(tx.ID, newOuts.Serialize()) // store outputs of most recent transactions.
-			if e != nil {
-				log.Panic(e)
+			entry := UTXOEntry{out, block.Height, tx.isCoinbaseTX()}
+			if err := chainstate.Put(Outpoint{tx.ID, vout}, entry); err != nil {
+				return nil, err
 			}
 		}
+		undo.Txs = append(undo.Txs, spent)
+	}
+	return undo, nil
+}
+
+/*
+CatchUp connects the blocks of the active chain after the one the set is at, e.g. once a node has
+downloaded new blocks. The set is rebuilt when that block is not on the active chain, unless the set
+comes from a snapshot, then it waits for the snapshot's block to arrive
+*/
+func (utxo UTXOSet) CatchUp() {
+	bc := utxo.blockchain
+	var best []byte
+	var base snapshotBase
+	fromSnapshot := false
+	err := bc.db.View(func(tx *StorageTx) error {
+		best = tx.Chainstate().BestBlock()
+		base, fromSnapshot = tx.Chainstate().SnapshotBase()
 		return nil
 	})
 	if err != nil {
 		log.Panic(err)
 	}
-	/*
-		Updating means removing spent outputs and
-		adding unspent outputs from newly mined transactions.
-	*/
+	if bytes.Equal(best, bc.tip) {
+		return
+	}
+
+	var blocks []*block
+	if b, err := bc.GetBlock(best); err == nil {
+		if active, err := bc.GetBlockByHeight(b.Height); err == nil && bytes.Equal(active.Hash, best) {
+			for height := b.Height + 1; height <= bc.GetBestHeight(); height++ {
+				next, err := bc.GetBlockByHeight(height)
+				if err != nil {
+					log.Panic(err)
+				}
+				blocks = append(blocks, next)
+			}
+		}
+	}
+	if blocks == nil {
+		if fromSnapshot {
+			fmt.Printf("Block %x of the UTXO snapshot is not on the chain yet\n", base.Hash)
+			return
+		}
+		utxo.Reindex()
+		return
+	}
+	for _, b := range blocks { // one at a time, the set stays at the last good block
+		err = bc.db.Update(func(tx *StorageTx) error {
+			return connectUTXO(tx, b)
+		})
+		if err == errSpendsMissingOutput || err == errBadBlockSignature {
+			fmt.Printf("Block %x is invalid, the UTXO set stays before it: %s\n", b.Hash, err)
+			return
+		}
+		if err != nil {
+			log.Panic(err)
+		}
+	}
 }
 
+// counts the transactions with unspent outputs, the outputs of a tx have neighbouring keys
 func (utx UTXOSet) CountTransactions() int {
 	count := 0
+	var last []byte
 	db := utx.blockchain.db
-	err := db.View(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket([]byte(utxoBucket))
-		c := bucket.Cursor()
-		for i, _ := c.First(); i != nil; i, _ = c.Next() {
-			count++
-		}
-		return nil
+	err := db.View(func(tx *StorageTx) error {
+		return tx.Chainstate().ForEach(func(op Outpoint, _ UTXOEntry) error {
+			if !bytes.Equal(op.TXid, last) {
+				count++
+				last = op.TXid
+			}
+			return nil
+		})
 	})
 	if err != nil {
 		log.Panic(err)
 	}
 	return count
 }
+
+// hasUTXOSet is false for a db that still has the txid keyed set or no commitment, or none at all
+func (utxo UTXOSet) hasUTXOSet() bool {
+	found := false
+	err := utxo.blockchain.db.View(func(tx *StorageTx) error {
+		state := tx.kv.Bucket([]byte(utxoStateBucket))
+		found = tx.kv.Bucket([]byte(utxoBucket)) != nil && state != nil && state.Get([]byte("muhash")) != nil
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+	return found
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+A vanity address is the address of a random key that starts with a chosen prefix, found by
+trying keys until one matches. Each character of Base58 multiplies the work by about 58.
+
+Whether a prefix can come up at all depends on the version byte: every leading zero byte is a 1,
+so key hash addresses (version 0x00) start with 1 and Schnorr ones (0x51) with 3. The chance of a
+prefix is counted exactly from the range of numbers an address can encode, see prefixChance.
+*/
+const vanityCalibration = 200 * time.Millisecond
+const vanityProgressEvery = 10 * time.Second
+const vanityMaxVariants = 1 << 16 // case variants of an ignorecase prefix
+
+var errPrefixUnreachable = errors.New("no address can start with the prefix")
+
+// prefixVariants are the Base58 strings prefix stands for, its case variants with ignoreCase
+func prefixVariants(prefix string, ignoreCase bool) ([]string, error) {
+	variants := []string{""}
+	for i := 0; i < len(prefix); i++ {
+		var options []byte
+		for _, c := range b58Alphabet {
+			if c == prefix[i] || ignoreCase && strings.EqualFold(string(c), string(prefix[i])) {
+				options = append(options, c)
+			}
+		}
+		if len(options) == 0 {
+			return nil, fmt.Errorf("%q is not a Base58 character, at position %d of the prefix", prefix[i], i)
+		}
+		if len(variants)*len(options) > vanityMaxVariants {
+			return nil, errors.New("the prefix has too many case variants, make it shorter")
+		}
+		var next []string
+		for _, v := range variants {
+			for _, c := range options {
+				next = append(next, v+string(c))
+			}
+		}
+		variants = next
+	}
+	return variants, nil
+}
+
+/*
+prefixChance is the chance that the address of a random key starts with prefix. An address is
+
+	'1' for each leading zero byte | Base58 of the number version | key | checksum
+
+with the key and the checksum taken as uniform. So after its 1s the prefix needs exactly that
+many zero bytes, and the rest of it has to start the Base58 digits of a number in the range left
+*/
+func prefixChance(prefix string, schnorr bool) *big.Rat {
+	keyLen, ver := ripemd160Size, version
+	if schnorr {
+		keyLen, ver = schnorrKeyLen, schnorrVersion
+	}
+	tailBytes := keyLen + addressChecksumLen
+	space := new(big.Int).Lsh(big.NewInt(1), uint(8*tailBytes))
+	ones := len(prefix) - len(strings.TrimLeft(prefix, "1"))
+	rest := prefix[ones:]
+
+	var lo, hi *big.Int // the numbers an address with exactly ones leading 1s encodes
+	switch {
+	case ver != 0x00 && ones > 0, ver == 0x00 && ones == 0, ones-1 > tailBytes:
+		return new(big.Rat)
+	case ver != 0x00:
+		lo = new(big.Int).Mul(big.NewInt(int64(ver)), space)
+		hi = new(big.Int).Add(lo, space)
+	case rest == "": // at least ones 1s, the bytes after them are anything
+		count := new(big.Int).Lsh(big.NewInt(1), uint(8*(tailBytes-(ones-1))))
+		return new(big.Rat).SetFrac(count, space)
+	case ones-1 == tailBytes:
+		return new(big.Rat)
+	default:
+		lo = new(big.Int).Lsh(big.NewInt(1), uint(8*(tailBytes-ones)))
+		hi = new(big.Int).Lsh(big.NewInt(1), uint(8*(tailBytes-ones+1)))
+	}
+	hi.Sub(hi, big.NewInt(1))
+	if rest == "" {
+		return big.NewRat(1, 1)
+	}
+
+	r := new(big.Int).SetBytes(Base58Decode([]byte(rest)))
+	count := new(big.Int)
+	base := big.NewInt(int64(len(b58Alphabet)))
+	scale := big.NewInt(1)
+	for {
+		from := new(big.Int).Mul(r, scale)
+		if from.Cmp(hi) > 0 {
+			break
+		}
+		to := new(big.Int).Add(r, big.NewInt(1))
+		to.Mul(to, scale).Sub(to, big.NewInt(1))
+		if from.Cmp(lo) < 0 {
+			from = lo
+		}
+		if to.Cmp(hi) > 0 {
+			to = hi
+		}
+		if to.Cmp(from) >= 0 {
+			count.Add(count, new(big.Int).Sub(to, from)).Add(count, big.NewInt(1))
+		}
+		scale.Mul(scale, base)
+	}
+	return new(big.Rat).SetFrac(count, space)
+}
+
+// VanityDifficulty is how many keys it takes on average to find prefix
+func VanityDifficulty(prefix string, ignoreCase, schnorr bool) (float64, error) {
+	variants, err := prefixVariants(prefix, ignoreCase)
+	if err != nil {
+		return 0, err
+	}
+	chance := new(big.Rat)
+	for _, v := range variants { // the same length, no address starts with two of them
+		chance.Add(chance, prefixChance(v, schnorr))
+	}
+	if chance.Sign() == 0 {
+		return 0, errPrefixUnreachable
+	}
+	difficulty, _ := new(big.Rat).Inv(chance).Float64()
+	return difficulty, nil
+}
+
+func newVanityKey(schnorr bool) *Wallet {
+	if schnorr {
+		return NewSchnorrWallet()
+	}
+	return NewWallet()
+}
+
+// vanityMatcher tells whether an address starts with prefix
+func vanityMatcher(prefix string, ignoreCase bool) func([]byte) bool {
+	if ignoreCase {
+		lower := []byte(strings.ToLower(prefix))
+		return func(address []byte) bool {
+			return len(address) >= len(lower) && bytes.EqualFold(address[:len(lower)], lower)
+		}
+	}
+	return func(address []byte) bool {
+		return bytes.HasPrefix(address, []byte(prefix))
+	}
+}
+
+// VanityRate is how many keys a second workers try, measured for a moment
+func VanityRate(workers int, schnorr bool) float64 {
+	var tried uint64
+	var wg sync.WaitGroup
+	deadline := time.Now().Add(vanityCalibration)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for time.Now().Before(deadline) {
+				newVanityKey(schnorr).GetAddress()
+				atomic.AddUint64(&tried, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	return float64(tried) / vanityCalibration.Seconds()
+}
+
+/*
+VanitySearch tries keys on workers goroutines until the address of one starts with prefix.
+progress is called now and then with the number of keys tried so far
+*/
+func VanitySearch(prefix string, ignoreCase, schnorr bool, workers int, progress func(tried uint64, elapsed time.Duration)) (*Wallet, uint64) {
+	match := vanityMatcher(prefix, ignoreCase)
+	found := make(chan *Wallet, workers)
+	done := make(chan struct{})
+	var tried uint64
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				w := newVanityKey(schnorr)
+				atomic.AddUint64(&tried, 1)
+				if match(w.GetAddress()) {
+					found <- w
+					return
+				}
+			}
+		}()
+	}
+
+	start := time.Now()
+	ticker := time.NewTicker(vanityProgressEvery)
+	defer ticker.Stop()
+	for {
+		select {
+		case w := <-found:
+			close(done)
+			wg.Wait()
+			return w, atomic.LoadUint64(&tried)
+		case <-ticker.C:
+			progress(atomic.LoadUint64(&tried), time.Since(start))
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"math"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestPrefixChance(t *testing.T) {
+	// every key hash address starts with 1 and every Schnorr one with 3
+	tests := []struct {
+		prefix  string
+		schnorr bool
+		chance  *big.Rat
+	}{
+		{"1", false, big.NewRat(1, 1)},
+		{"11", false, big.NewRat(1, 256)},    // the first key hash byte is zero
+		{"111", false, big.NewRat(1, 65536)}, // and the second one
+		{"3", true, big.NewRat(1, 1)},
+		{"2", false, new(big.Rat)},
+		{"1", true, new(big.Rat)},
+		{"4", true, new(big.Rat)},
+		{"3a", true, new(big.Rat)}, // version 0x51 only leaves digits from 3Q on
+	}
+	for _, test := range tests {
+		if got := prefixChance(test.prefix, test.schnorr); got.Cmp(test.chance) != 0 {
+			t.Errorf("prefixChance(%q, %v) = %s, want %s", test.prefix, test.schnorr, got, test.chance)
+		}
+	}
+}
+
+func TestVanityDifficulty(t *testing.T) {
+	// the values were counted apart from prefixChance, over the Base58 digits of all 25 byte payloads
+	tests := []struct {
+		prefix     string
+		ignoreCase bool
+		difficulty float64
+	}{
+		{"1", false, 1},
+		{"11", false, 256},
+		{"1A", false, 22.942426835423056},
+		{"1a", false, 1353.6031832899605},
+		{"1a", true, 22.56005305483267}, // 1A or 1a
+		{"1Ab", false, 1330.6607564545372},
+	}
+	for _, test := range tests {
+		got, err := VanityDifficulty(test.prefix, test.ignoreCase, false)
+		if err != nil {
+			t.Fatalf("VanityDifficulty(%q): %s", test.prefix, err)
+		}
+		if math.Abs(got-test.difficulty) > test.difficulty*1e-9 {
+			t.Errorf("VanityDifficulty(%q, %v) = %v, want %v", test.prefix, test.ignoreCase, got, test.difficulty)
+		}
+	}
+}
+
+func TestVanityDifficultyErrors(t *testing.T) {
+	for _, prefix := range []string{"2", "3", "21"} {
+		if _, err := VanityDifficulty(prefix, false, false); err != errPrefixUnreachable {
+			t.Errorf("VanityDifficulty(%q) gave %v, want errPrefixUnreachable", prefix, err)
+		}
+	}
+	if _, err := VanityDifficulty("1", false, true); err != errPrefixUnreachable {
+		t.Errorf("a Schnorr address starting with 1 gave %v, want errPrefixUnreachable", err)
+	}
+	if _, err := VanityDifficulty("10", false, false); err == nil {
+		t.Error("0 is not Base58 but the prefix was taken")
+	}
+	if _, err := VanityDifficulty("1abcdefghjkmnpqrst", true, false); err == nil {
+		t.Error("a prefix of 2^17 case variants was taken")
+	}
+}
+
+func TestPrefixVariants(t *testing.T) {
+	variants, err := prefixVariants("1aB", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]bool{"1AB": true, "1Ab": true, "1aB": true, "1ab": true}
+	if len(variants) != len(want) {
+		t.Fatalf("got variants %v", variants)
+	}
+	for _, v := range variants {
+		if !want[v] {
+			t.Errorf("unexpected variant %q", v)
+		}
+	}
+	// Base58 has no O and no I
+	if variants, _ := prefixVariants("1oi", true); len(variants) != 1 || variants[0] != "1oi" {
+		t.Errorf("got variants %v, want [1oi]", variants)
+	}
+}
+
+func TestVanitySearch(t *testing.T) {
+	match := vanityMatcher("1a", true)
+	if !match([]byte("1Abc")) || !match([]byte("1abc")) || match([]byte("1b")) || match([]byte("1")) {
+		t.Error("the ignorecase matcher is wrong")
+	}
+	if vanityMatcher("1a", false)([]byte("1Abc")) {
+		t.Error("1Abc matched 1a")
+	}
+
+	w, tried := VanitySearch("1", false, false, 2, func(uint64, time.Duration) {})
+	if w == nil || tried == 0 || string(w.GetAddress()[:1]) != "1" {
+		t.Errorf("searching for 1 gave %v after %d keys", w, tried)
+	}
+	w, _ = VanitySearch("3", false, true, 1, func(uint64, time.Duration) {})
+	if w == nil || string(w.GetAddress()[:1]) != "3" {
+		t.Error("searching a Schnorr address for 3 failed")
+	}
+}
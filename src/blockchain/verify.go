@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"runtime"
+	"sync"
+)
+
+// one input to check: the tx, which input and the output it spends
+type inputJob struct {
+	txIdx   int
+	tx      *Transaction
+	InId    int
+	prevOut TXOutput
+}
+
+// verifySchnorrBatch checks a batch and caches its signatures when they are all valid
+func verifySchnorrBatch(batch *SchnorrBatch) bool {
+	if !batch.Verify() {
+		return false
+	}
+	for i := range batch.sigs {
+		sigCache.Add(batch.msgs[i], batch.pubKeys[i], batch.sigs[i])
+	}
+	return true
+}
+
+/*
+findPrevTransactionsOnce returns every tx spent by txs, each one looked up once in the txindex.
+Transactions in the list itself are included, so a tx may spend another one of the same block.
+Unknown inputs are left out, verification then fails for their tx
+*/
+func (bc *BlockChain) findPrevTransactionsOnce(txs []*Transaction) map[string]Transaction {
+	prevTXs := make(map[string]Transaction)
+	for _, tx := range txs {
+		prevTXs[hex.EncodeToString(tx.ID)] = *tx
+	}
+	for _, tx := range txs {
+		if tx.isCoinbaseTX() {
+			continue
+		}
+		for _, vin := range tx.VIn {
+			id := hex.EncodeToString(vin.TXid)
+			if _, ok := prevTXs[id]; ok {
+				continue
+			}
+			if prevTX, err := bc.FindPrevTransaction(vin.TXid); err == nil {
+				prevTXs[id] = prevTX
+			}
+		}
+	}
+	return prevTXs
+}
+
+// verifyParallel checks every input of txs and returns which transactions are valid. Previous outputs are looked up once for the whole list
+func (bc *BlockChain) verifyParallel(txs []*Transaction) []bool {
+	valid := make([]bool, len(txs))
+	prevTXs := bc.findPrevTransactionsOnce(txs)
+
+	var jobs []inputJob
+	for i, tx := range txs {
+		valid[i] = true
+		if tx.isCoinbaseTX() {
+			continue
+		}
+		if tx.HasWitness() && len(tx.Witness) != len(tx.VIn) {
+			valid[i] = false
+			continue
+		}
+		for InId, vin := range tx.VIn {
+			prevTX, ok := prevTXs[hex.EncodeToString(vin.TXid)]
+			if !ok || vin.Vout < 0 || vin.Vout >= len(prevTX.VOut) {
+				valid[i] = false
+				break
+			}
+			jobs = append(jobs, inputJob{i, tx, InId, prevTX.VOut[vin.Vout]})
+		}
+	}
+	runInputJobs(jobs, valid)
+	return valid
+}
+
+/*
+verifyBlockSpends checks the signatures of block against the outputs it spends, as applyBlock
+found them in the UTXO set. That is the state of its parent, on whichever branch the block is
+*/
+func verifyBlockSpends(block *block, undo *blockUndo) bool {
+	valid := make([]bool, len(block.Transactions))
+	var jobs []inputJob
+	for i, tx := range block.Transactions {
+		valid[i] = true
+		if tx.isCoinbaseTX() {
+			continue
+		}
+		if tx.HasWitness() && len(tx.Witness) != len(tx.VIn) {
+			return false
+		}
+		for InId := range tx.VIn {
+			jobs = append(jobs, inputJob{i, tx, InId, undo.Txs[i][InId].Output})
+		}
+	}
+	runInputJobs(jobs, valid)
+	for _, ok := range valid {
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+runInputJobs checks the inputs on a pool of runtime.NumCPU() workers and clears valid for the
+transactions with a bad one. Each worker batches the Schnorr signatures it meets, when a batch
+fails its signatures are checked one by one to find the transactions to blame
+*/
+func runInputJobs(jobs []inputJob, valid []bool) {
+	var mu sync.Mutex
+	invalidate := func(txIdx int) {
+		mu.Lock()
+		valid[txIdx] = false
+		mu.Unlock()
+	}
+
+	queue := make(chan inputJob)
+	workers := runtime.NumCPU()
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			batch := NewSchnorrBatch()
+			var batched []inputJob
+			for job := range queue {
+				before := batch.Len()
+				if !job.tx.verifyInput(job.InId, job.prevOut, batch) {
+					invalidate(job.txIdx)
+				} else if batch.Len() > before {
+					batched = append(batched, job)
+				}
+			}
+			if verifySchnorrBatch(batch) {
+				return
+			}
+			for i, job := range batched {
+				single := NewSchnorrBatch()
+				single.Add(batch.pubKeys[i], batch.msgs[i], batch.sigs[i])
+				if !verifySchnorrBatch(single) {
+					invalidate(job.txIdx)
+				}
+			}
+		}()
+	}
+	for _, job := range jobs {
+		queue <- job
+	}
+	close(queue)
+	wg.Wait()
+}
+
+// VerifyTransactions checks all transactions of a block, one bad signature rejects all of them
+func (bc *BlockChain) VerifyTransactions(transactions []*Transaction) bool {
+	for _, ok := range bc.verifyParallel(transactions) {
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+FilterSpendable keeps the candidates for the next block that can go into it together, parents
+before children: every input of a kept tx spends an output of the UTXO set, or of a tx kept before
+it, that no other kept tx spends. The first tx to spend an outpoint wins, the others that spend it
+are returned as conflicted, they can't be mined once the block is. The rest wait for their inputs
+*/
+func (bc *BlockChain) FilterSpendable(candidates []*Transaction) ([]*Transaction, []*Transaction) {
+	UTXO := UTXOSet{bc}
+	var kept, conflicted []*Transaction
+	spent := make(map[string]bool)
+	created := make(map[string]bool) // outputs of the kept txs
+	pending := candidates
+	for progress := true; progress && len(pending) > 0; {
+		progress = false
+		var waiting []*Transaction // spend an output of a tx that is not kept yet
+		for _, tx := range pending {
+			conflict, wait := false, false
+			outpoints := make(map[string]bool)
+			for _, vin := range tx.VIn {
+				if tx.isCoinbaseTX() {
+					break
+				}
+				op := fmt.Sprintf("%x:%d", vin.TXid, vin.Vout)
+				if spent[op] || outpoints[op] {
+					conflict = true
+					break
+				}
+				outpoints[op] = true
+				if !created[op] && !UTXO.IsUnspent(vin.TXid, vin.Vout) {
+					wait = true
+				}
+			}
+			switch {
+			case conflict:
+				conflicted = append(conflicted, tx)
+			case wait:
+				waiting = append(waiting, tx)
+			default:
+				for op := range outpoints {
+					spent[op] = true
+				}
+				for vout := range tx.VOut {
+					created[fmt.Sprintf("%x:%d", tx.ID, vout)] = true
+				}
+				kept = append(kept, tx)
+				progress = true
+			}
+		}
+		pending = waiting
+	}
+	return kept, conflicted
+}
+
+// FilterValidTransactions keeps the transactions whose signatures verify, used for the mempool
+func (bc *BlockChain) FilterValidTransactions(transactions []*Transaction) []*Transaction {
+	var verified []*Transaction
+	for i, ok := range bc.verifyParallel(transactions) {
+		if ok {
+			verified = append(verified, transactions[i])
+		}
+	}
+	return verified
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestAddBlockRejectsBadSignature(t *testing.T) {
+	bc, w := newTestChain(t)
+	to := NewWallet()
+	tx := NewPaymentsTransaction(w, []Payment{{string(to.GetAddress()), 4}}, "", &UTXOSet{bc}, nil)
+	tx.Witness[0].Signature[10] ^= 1
+	tx.ID = tx.Hash()
+	tip := bc.tip
+
+	bc.AddBlock(nextBlock(bc, tx))
+	if !bytes.Equal(bc.tip, tip) {
+		t.Fatal("a block with a bad signature became the tip")
+	}
+	good := NewPaymentsTransaction(w, []Payment{{string(to.GetAddress()), 4}}, "", &UTXOSet{bc}, nil)
+	b := nextBlock(bc, good)
+	bc.AddBlock(b)
+	if !bytes.Equal(bc.tip, b.Hash) {
+		t.Fatal("a valid block was not connected")
+	}
+}
+
+func TestFilterValidTransactions(t *testing.T) {
+	bc, w := newTestChain(t)
+	var txs []*Transaction
+	for i := 0; i < 3; i++ {
+		tx := NewPaymentsTransaction(w, []Payment{{string(NewWallet().GetAddress()), 1}}, "", &UTXOSet{bc}, nil)
+		txs = append(txs, tx)
+	}
+	// a copy of the second one whose signature was tampered with
+	bad := DeserializeTransaction(txs[1].Serialize())
+	bad.Witness[0].Signature[10] ^= 1
+	txs = append(txs, &bad)
+	valid := bc.FilterValidTransactions(txs)
+	if len(valid) != 3 || valid[2] != txs[2] {
+		t.Fatalf("%d valid txs, want the three signed ones", len(valid))
+	}
+	if bc.VerifyTransactions(txs) {
+		t.Fatal("a list with a tampered tx verifies")
+	}
+}
+
+func TestSigCache(t *testing.T) {
+	c := NewSigCache(2)
+	c.Add([]byte{1}, []byte{2, 3}, []byte{4})
+	if !c.Exists([]byte{1}, []byte{2, 3}, []byte{4}) {
+		t.Fatal("an added signature is not in the cache")
+	}
+	// the same bytes split differently are another entry
+	if c.Exists([]byte{1, 2}, []byte{3}, []byte{4}) {
+		t.Fatal("the parts of a key run into each other")
+	}
+	c.Add([]byte{5}, nil, nil)
+	c.Add([]byte{6}, nil, nil)
+	if len(c.entries) != 2 {
+		t.Fatalf("%d entries in a cache of 2", len(c.entries))
+	}
+}
+
+func TestFilterSpendableDropsDoubleSpend(t *testing.T) {
+	bc, w := newTestChain(t)
+	a, b := NewWallet(), NewWallet()
+	first := NewPaymentsTransaction(w, []Payment{{string(a.GetAddress()), 4}}, "", &UTXOSet{bc}, nil)
+	second := NewPaymentsTransaction(w, []Payment{{string(b.GetAddress()), 4}}, "", &UTXOSet{bc}, nil)
+	kept, conflicted := bc.FilterSpendable([]*Transaction{first, second})
+	if len(kept) != 1 || !bytes.Equal(kept[0].ID, first.ID) {
+		t.Fatalf("kept %d txs, want the first spender only", len(kept))
+	}
+	if len(conflicted) != 1 || !bytes.Equal(conflicted[0].ID, second.ID) {
+		t.Fatalf("conflicted %d txs, want the second spender", len(conflicted))
+	}
+	if _, err := bc.MineBlock([]*Transaction{NewCoinbaseTX(string(w.GetAddress()), ""), first, second}); err == nil {
+		t.Fatal("a block with a double spend was mined")
+	}
+}
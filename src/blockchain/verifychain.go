@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+)
+
+/*
+VerifyChain checks the last depth blocks of the active chain, all of them when depth is 0.
+Every level does the checks of the ones below it:
+
+	0  the blocks can be read, link to each other and match the height index, the UTXO set is at the tip
+	1  proof of work, hash of the header and transactions (merkle root), witness commitment, txindex
+	2  undo data of the blocks
+	3  signatures of the transactions
+	4  the UTXO set is built again from the genesis block and its commitment compared with the stored one
+
+Pruned blocks are not checked and level 4 needs every block. It also returns how many blocks it checked.
+*/
+const defaultCheckDepth = 6
+const defaultCheckLevel = 3
+const maxCheckLevel = 4
+
+type problemKind int
+
+const (
+	problemBlock problemKind = iota // the block itself is bad, the chain has to go back before it
+	problemIndex                    // the chain indexes do not match the blocks
+	problemUTXO                     // the UTXO set does not match the chain
+)
+
+type chainProblem struct {
+	Kind   problemKind
+	Height int
+	Hash   []byte
+	Msg    string
+}
+
+func (p chainProblem) String() string {
+	if p.Hash == nil {
+		return p.Msg
+	}
+	return fmt.Sprintf("block %d %x: %s", p.Height, p.Hash, p.Msg)
+}
+
+func (bc *BlockChain) VerifyChain(depth, level int) ([]chainProblem, int) {
+	var problems []chainProblem
+	checked := 0
+	report := func(kind problemKind, height int, hash []byte, format string, a ...interface{}) {
+		problems = append(problems, chainProblem{kind, height, hash, fmt.Sprintf(format, a...)})
+	}
+
+	err := bc.db.View(func(tx *StorageTx) error {
+		_, fromSnapshot := tx.Chainstate().SnapshotBase() // then it waits at the snapshot's block
+		if best := tx.Chainstate().BestBlock(); !fromSnapshot && !bytes.Equal(best, bc.tip) {
+			report(problemUTXO, 0, nil, "the UTXO set is at block %x, the tip is %x", best, bc.tip)
+		}
+		if _, err := activeHeights(tx); err != nil {
+			report(problemIndex, 0, nil, "the height index does not end at the tip")
+		}
+		txIndex := tx.Indexes().Index(txIndexBucket)
+		heights := tx.Indexes().Index(heightIndexBucket)
+		if txIndex == nil || heights == nil {
+			report(problemIndex, 0, nil, "the chain indexes are missing")
+		}
+
+		childHeight := -1
+		for hash := bc.tip; len(hash) > 0 && (depth == 0 || checked < depth); checked++ {
+			header, err := tx.Blocks().Header(hash)
+			if err != nil {
+				report(problemBlock, childHeight-1, hash, "can not be read: %s", err)
+				break
+			}
+			if childHeight >= 0 && header.Height != childHeight-1 {
+				report(problemBlock, header.Height, hash, "height does not follow the block after it (%d)", childHeight)
+			}
+			if len(header.PrevBlockHash) == 0 && header.Height != 0 {
+				report(problemBlock, header.Height, hash, "has no previous block but is not at height 0")
+			}
+			if heights != nil && !bytes.Equal(heights.Get(heightKey(header.Height)), hash) {
+				report(problemIndex, header.Height, hash, "is not in the height index")
+			}
+			childHeight = header.Height
+
+			b, err := tx.Blocks().Get(hash)
+			if err == errBlockPruned {
+				break // the rest is pruned as well
+			}
+			if err != nil {
+				report(problemBlock, header.Height, hash, "can not be read: %s", err)
+				break
+			}
+			if level >= 1 {
+				if !bytes.Equal(b.Hash, hash) {
+					report(problemBlock, b.Height, hash, "header and transactions hash to %x", b.Hash)
+				}
+				if !NewProofOfWork(b).Validate() {
+					report(problemBlock, b.Height, hash, "proof of work is not valid")
+				}
+				if !b.HasValidWitnessCommitment() {
+					report(problemBlock, b.Height, hash, "witness commitment does not match")
+				}
+				for pos, t := range b.Transactions {
+					if txIndex == nil {
+						break
+					}
+					data := txIndex.Get(t.ID)
+					loc, err := DeserializeTxLocation(data)
+					if data == nil || err != nil || !bytes.Equal(loc.BlockHash, hash) || loc.Position != pos {
+						report(problemIndex, b.Height, hash, "tx %x is not in the txindex", t.ID)
+					}
+				}
+			}
+			if level >= 2 {
+				if msg := checkUndo(tx, b); msg != "" {
+					report(problemBlock, b.Height, hash, "%s", msg)
+				}
+			}
+			hash = header.PrevBlockHash
+		}
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	if level >= 3 { // outside the view, the signature checks look transactions up themselves
+		for _, b := range bc.lastBlocks(depth) {
+			if !bc.VerifyTransactions(b.Transactions) && !bc.spendsPrunedBlock(b) {
+				report(problemBlock, b.Height, b.Hash, "has a transaction with an invalid signature")
+			}
+		}
+	}
+	if level >= 4 {
+		if msg := bc.checkUTXOCommitment(); msg != "" {
+			report(problemUTXO, 0, nil, "%s", msg)
+		}
+	}
+	return problems, checked
+}
+
+// checkUndo returns what is wrong with the undo data of b, blocks without any are fine
+func checkUndo(tx *StorageTx, b *block) string {
+	data, err := tx.Blocks().GetUndo(b.Hash)
+	if err == errUndoNotFound {
+		return ""
+	}
+	if err != nil {
+		return fmt.Sprintf("undo data can not be read: %s", err)
+	}
+	undo, err := DeserializeBlockUndo(data)
+	if err != nil {
+		return fmt.Sprintf("undo data is corrupt: %s", err)
+	}
+	if len(undo.Txs) != len(b.Transactions) {
+		return "undo data does not match the transactions"
+	}
+	for i, t := range b.Transactions {
+		spends := len(t.VIn)
+		if t.isCoinbaseTX() {
+			spends = 0
+		}
+		if len(undo.Txs[i]) != spends {
+			return fmt.Sprintf("undo data of tx %x does not match its inputs", t.ID)
+		}
+	}
+	return ""
+}
+
+// on a pruned node the spent outputs of old transactions are gone, their signatures can not be checked
+func (bc *BlockChain) spendsPrunedBlock(b *block) bool {
+	for _, t := range b.Transactions {
+		if t.isCoinbaseTX() {
+			continue
+		}
+		for _, vin := range t.VIn {
+			if _, _, err := bc.FindTransaction(vin.TXid); err == errBlockPruned {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// lastBlocks are the last depth blocks of the active chain that are not pruned, newest first
+func (bc *BlockChain) lastBlocks(depth int) []*block {
+	var blocks []*block
+	err := bc.db.View(func(tx *StorageTx) error {
+		for hash := bc.tip; len(hash) > 0 && (depth == 0 || len(blocks) < depth); {
+			b, err := tx.Blocks().Get(hash)
+			if err != nil {
+				return nil // reported by the walk already
+			}
+			blocks = append(blocks, b)
+			hash = b.PrevBlockHash
+		}
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+	return blocks
+}
+
+// checkUTXOCommitment replays the chain into a set in memory, like validateSnapshot
+func (bc *BlockChain) checkUTXOCommitment() string {
+	if height := bc.db.PruneHeight(); height > 0 {
+		return fmt.Sprintf("the UTXO set can not be checked, the blocks below height %d are pruned", height)
+	}
+	blocks := bc.lastBlocks(0)
+	scratch := &memoryStore{buckets: make(map[string]*memBucket)}
+	var rebuilt, stored []byte
+	err := scratch.Update(func(tx KVTx) error {
+		chainstate := kvChainstateStore{tx}
+		for i := len(blocks) - 1; i >= 0; i-- {
+			if _, err := applyBlock(chainstate, blocks[i]); err != nil {
+				return fmt.Errorf("block %d %x: %s", blocks[i].Height, blocks[i].Hash, err)
+			}
+		}
+		rebuilt = chainstate.Commitment()
+		return nil
+	})
+	if err != nil {
+		return fmt.Sprintf("the chain can not be replayed: %s", err)
+	}
+	err = bc.db.View(func(tx *StorageTx) error {
+		stored = tx.Chainstate().Commitment()
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+	if !bytes.Equal(rebuilt, stored) {
+		return fmt.Sprintf("the UTXO set has commitment %x, the chain gives %x", stored, rebuilt)
+	}
+	return ""
+}
+
+/*
+RepairChain fixes what VerifyChain found. A bad block takes the tip back to the block before the
+lowest bad one, then the indexes and the UTXO set are rebuilt. Otherwise the indexes are rebuilt
+when they are off and the UTXO set when it does not match the chain, a pruned node can only
+catch it up with the tip
+*/
+func (bc *BlockChain) RepairChain(problems []chainProblem) {
+	var badBlock *chainProblem
+	index, utxo := false, false
+	for i, p := range problems {
+		switch p.Kind {
+		case problemBlock:
+			if badBlock == nil || p.Height < badBlock.Height {
+				badBlock = &problems[i]
+			}
+		case problemIndex:
+			index = true
+		case problemUTXO:
+			utxo = true
+		}
+	}
+
+	set := UTXOSet{bc}
+	if badBlock != nil {
+		var parent []byte
+		err := bc.db.Update(func(tx *StorageTx) error {
+			header, err := tx.Blocks().Header(badBlock.Hash)
+			if err != nil {
+				return err
+			}
+			if len(header.PrevBlockHash) == 0 {
+				return fmt.Errorf("the genesis block is bad, the chain has to be created again")
+			}
+			parent = header.PrevBlockHash
+			return tx.Blocks().SetTip(parent)
+		})
+		if err != nil {
+			log.Panic(err)
+		}
+		bc.tip = parent
+		fmt.Printf("Moved the tip back to %x\n", parent)
+		bc.ReindexChain()
+		set.Reindex()
+		fmt.Println("Rebuilt the chain indexes and the UTXO set")
+		return
+	}
+	if index {
+		bc.ReindexChain()
+		fmt.Println("Rebuilt the chain indexes")
+	}
+	if utxo {
+		if bc.db.PruneHeight() > 0 { // can not be rebuilt
+			set.CatchUp()
+			fmt.Println("Caught the UTXO set up with the tip")
+		} else {
+			set.Reindex()
+			fmt.Println("Rebuilt the UTXO set")
+		}
+	}
+}
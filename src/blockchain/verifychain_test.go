@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+// minedChain is a chain with a few blocks of payments on top of its genesis block
+func minedChain(t *testing.T, blocks int) (*BlockChain, *Wallet) {
+	t.Helper()
+	bc, w := newTestChain(t)
+	for i := 0; i < blocks; i++ {
+		tx := NewPaymentsTransaction(w, []Payment{{string(NewWallet().GetAddress()), 1}}, "", &UTXOSet{bc}, nil)
+		if _, err := bc.MineBlock([]*Transaction{NewCoinbaseTX(string(w.GetAddress()), ""), tx}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return bc, w
+}
+
+func problemKinds(problems []chainProblem) map[problemKind]bool {
+	kinds := make(map[problemKind]bool)
+	for _, p := range problems {
+		kinds[p.Kind] = true
+	}
+	return kinds
+}
+
+func TestVerifyChainClean(t *testing.T) {
+	bc, _ := minedChain(t, 3)
+	problems, checked := bc.VerifyChain(0, maxCheckLevel)
+	if len(problems) != 0 {
+		t.Fatalf("problems in a clean chain: %v", problems)
+	}
+	if checked != 4 {
+		t.Fatalf("checked %d blocks, want 4", checked)
+	}
+	if _, checked := bc.VerifyChain(2, 0); checked != 2 {
+		t.Fatalf("checked %d blocks at depth 2", checked)
+	}
+}
+
+func TestRepairUTXOSet(t *testing.T) {
+	bc, w := minedChain(t, 2)
+	before, _ := utxoState(t, bc)
+	coins := UTXOSet{bc}.FindCoins(w.LockingKey())
+	err := bc.db.Update(func(tx *StorageTx) error {
+		return tx.Chainstate().Delete(coins[0].Outpoint)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// only the replay of level 4 sees an entry missing from the set
+	if problems, _ := bc.VerifyChain(0, maxCheckLevel-1); len(problems) != 0 {
+		t.Fatalf("problems below level 4: %v", problems)
+	}
+	problems, _ := bc.VerifyChain(0, maxCheckLevel)
+	if kinds := problemKinds(problems); len(kinds) != 1 || !kinds[problemUTXO] {
+		t.Fatalf("the missing entry is reported as %v", problems)
+	}
+	bc.RepairChain(problems)
+	if after, _ := utxoState(t, bc); !bytes.Equal(after, before) {
+		t.Fatal("the repaired set is not the one of the chain")
+	}
+	if problems, _ := bc.VerifyChain(0, maxCheckLevel); len(problems) != 0 {
+		t.Fatalf("problems after the repair: %v", problems)
+	}
+}
+
+func TestRepairIndexes(t *testing.T) {
+	bc, _ := minedChain(t, 2)
+	err := bc.db.Update(func(tx *StorageTx) error {
+		return tx.Indexes().DropIndex(txIndexBucket)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	problems, _ := bc.VerifyChain(0, 1)
+	if kinds := problemKinds(problems); !kinds[problemIndex] || kinds[problemBlock] {
+		t.Fatalf("the missing txindex is reported as %v", problems)
+	}
+	bc.RepairChain(problems)
+	if problems, _ := bc.VerifyChain(0, maxCheckLevel); len(problems) != 0 {
+		t.Fatalf("problems after the repair: %v", problems)
+	}
+}
+
+func TestRepairBadBlock(t *testing.T) {
+	bc, _ := minedChain(t, 3)
+	bad, err := bc.GetBlockByHeight(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the block stored under its hash is not the one mined any more
+	bad.Transactions[0].VOut[0].Value++
+	err = bc.db.Update(func(tx *StorageTx) error {
+		return tx.Blocks().Put(bad)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	problems, _ := bc.VerifyChain(0, 1)
+	if kinds := problemKinds(problems); !kinds[problemBlock] {
+		t.Fatalf("the changed block is reported as %v", problems)
+	}
+	bc.RepairChain(problems)
+	if !bytes.Equal(bc.tip, bad.PrevBlockHash) || bc.GetBestHeight() != 1 {
+		t.Fatalf("the tip is at height %d after the repair, want the block before the bad one", bc.GetBestHeight())
+	}
+	if problems, _ := bc.VerifyChain(0, maxCheckLevel); len(problems) != 0 {
+		t.Fatalf("problems after the repair: %v", problems)
+	}
+}
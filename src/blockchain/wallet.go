@@ -6,51 +6,168 @@ import (
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
+	"encoding/binary"
+	"errors"
 	"golang.org/x/crypto/ripemd160"
 	"log"
+	"math/big"
 )
 
 const version = byte(0x00)
+const schnorrVersion = byte(0x51) // addresses holding a Schnorr key instead of a key hash
 const addressChecksumLen = 4
+const ripemd160Size = 20
 
 type Wallet struct {
 	PrivateKey ecdsa.PrivateKey
 	PublicKey  []byte
+	SchnorrKey []byte   // secp256k1 secret of a Schnorr wallet, PublicKey is then the x-only key
+	Path       []uint32 // where the key of an HD wallet is derived, nil for a random key
+	Encrypted  []byte   // the sealed secret of a random key in an encrypted wallet file
 }
 
 func NewWallet() *Wallet {
 	private, pubkey := NewKeyPair()
-	wallet := Wallet{private, pubkey}
+	wallet := Wallet{PrivateKey: private, PublicKey: pubkey}
 	return &wallet
 }
 
+func NewSchnorrWallet() *Wallet {
+	secKey := NewSchnorrKey()
+	pubkey, err := SchnorrPubKey(secKey)
+	if err != nil {
+		log.Panic(err)
+	}
+	return &Wallet{PublicKey: pubkey, SchnorrKey: secKey}
+}
+
+func (w Wallet) IsSchnorr() bool {
+	return len(w.SchnorrKey) > 0 || len(w.PublicKey) == schnorrKeyLen // the secret is gone while locked
+}
+
+// LockingKey is what outputs paying this wallet are locked to: the key hash, or the x-only key
+func (w Wallet) LockingKey() []byte {
+	if w.IsSchnorr() {
+		return w.PublicKey
+	}
+	return HashPubKey(w.PublicKey)
+}
+
 func NewKeyPair() (ecdsa.PrivateKey, []byte) {
 	curve := elliptic.P256()
 	private, err := ecdsa.GenerateKey(curve, rand.Reader)
 	if err != nil {
 		log.Panic(err)
 	}
-	pubkey := append(private.PublicKey.X.Bytes(), private.PublicKey.Y.Bytes()...) // expand to append
+	pubkey := MarshalPubKey(&private.PublicKey) // fixed width, X.Bytes() would drop leading zeros
 	return *private, pubkey
 }
 
+// gob can't encode the curve behind ecdsa.PrivateKey without depending on Go's internal type names,
+// so a wallet is stored as its private scalar and public key and the curve is always P-256
+func (w Wallet) GobEncode() ([]byte, error) {
+	var buff bytes.Buffer
+	var d []byte
+	if w.PrivateKey.D != nil {
+		d = w.PrivateKey.D.FillBytes(make([]byte, sigScalarLen))
+	}
+	writeVarBytes(&buff, d)
+	writeVarBytes(&buff, w.PublicKey)
+	writeVarBytes(&buff, w.SchnorrKey)
+	path := make([]byte, 4*len(w.Path))
+	for i, index := range w.Path {
+		binary.BigEndian.PutUint32(path[4*i:], index)
+	}
+	writeVarBytes(&buff, path)
+	writeVarBytes(&buff, w.Encrypted)
+	return buff.Bytes(), nil
+}
+
+func (w *Wallet) GobDecode(data []byte) error {
+	r := bytes.NewReader(data)
+	d, err := readVarBytes(r)
+	if err != nil {
+		return err
+	}
+	pubkey, err := readVarBytes(r)
+	if err != nil {
+		return err
+	}
+	if len(d) > 0 {
+		w.setECDSAKey(d)
+	}
+	w.PublicKey = pubkey
+	if r.Len() > 0 { // wallets written before Schnorr keys existed end here
+		if w.SchnorrKey, err = readVarBytes(r); err != nil {
+			return err
+		}
+	}
+	if r.Len() > 0 { // and before HD wallets
+		path, err := readVarBytes(r)
+		if err != nil {
+			return err
+		}
+		if len(path)%4 != 0 {
+			return errors.New("bad HD path in wallet")
+		}
+		for i := 0; i < len(path); i += 4 {
+			w.Path = append(w.Path, binary.BigEndian.Uint32(path[i:]))
+		}
+	}
+	if r.Len() > 0 { // and before encrypted wallets
+		if w.Encrypted, err = readVarBytes(r); err != nil {
+			return err
+		}
+	}
+	return checkFullyRead(r)
+}
+
+func (w *Wallet) setECDSAKey(d []byte) {
+	curve := elliptic.P256()
+	w.PrivateKey.Curve = curve
+	w.PrivateKey.D = new(big.Int).SetBytes(d)
+	w.PrivateKey.X, w.PrivateKey.Y = curve.ScalarBaseMult(d)
+}
+
+// CanSign is false for a locked wallet, its private key is only in the file sealed
+func (w Wallet) CanSign() bool {
+	return w.PrivateKey.D != nil || len(w.SchnorrKey) > 0
+}
+
 func (w Wallet) GetAddress() []byte {
-	pubKeyHash := HashPubKey(w.PublicKey)
-	payload := append([]byte{version}, pubKeyHash...)
-	checksem := CheckSum(payload)
-	fullPayload := append(payload, checksem...)
-	address := Base58Encode(fullPayload)
-	return address
+	return LockingKeyAddress(w.LockingKey())
 }
 
-func VerifyAddress(address string) bool {
-	fullPayload := Base58Decode([]byte(address))
-	PayloadLen := len(fullPayload) - addressChecksumLen
+// GetBech32Address is the Bech32 form of GetAddress, see address.go
+func (w Wallet) GetBech32Address() string {
+	return Bech32Address(w.LockingKey())
+}
 
-	checksum := fullPayload[PayloadLen:]
-	Payload := fullPayload[:PayloadLen]
-	actualCheckSum := CheckSum(Payload)
-	return bytes.Compare(checksum, actualCheckSum) == 0
+// LockingKeyAddress is the address of an output locked to key, nil for outputs without an address
+func LockingKeyAddress(key []byte) []byte {
+	switch len(key) {
+	case schnorrKeyLen:
+		return SchnorrAddress(key)
+	case ripemd160Size:
+		payload := append([]byte{version}, key...)
+		checksem := CheckSum(payload)
+		fullPayload := append(payload, checksem...)
+		return Base58Encode(fullPayload)
+	}
+	return nil
+}
+
+// the key is not hashed, Schnorr outputs are locked to the key itself
+func SchnorrAddress(xOnlyKey []byte) []byte {
+	payload := append([]byte{schnorrVersion}, xOnlyKey...)
+	fullPayload := append(payload, CheckSum(payload)...)
+	return Base58Encode(fullPayload)
+}
+
+// VerifyAddress takes both forms, DecodeAddress tells what is wrong with one
+func VerifyAddress(address string) bool {
+	_, err := DecodeAddress(address)
+	return err == nil
 }
 
 func HashPubKey(pubkey []byte) []byte {
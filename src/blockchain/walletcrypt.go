@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+/*
+An encrypted wallet file keeps the addresses and public keys readable and only the secrets sealed,
+so listaddress and getbalance work without the passphrase:
+
+	passphrase -> scrypt(salt, N, r, p) -> key sealing the master key
+	master key -> AES-256-GCM -> every random private key, with its public key as additional data
+	                          -> the HD seed
+
+The master key is random and stays the same for the life of the file, changepassphrase only seals
+it again. The keys of HD addresses are not stored at all, they are derived from the seed on
+unlock. While locked, new HD addresses come from the public key of the account.
+*/
+const scryptN = 1 << 15
+const scryptR = 8
+const scryptP = 1
+const walletKeyLen = 32
+
+var errWalletLocked = errors.New("the wallet is locked, unlock it with its passphrase first")
+var errWrongPassphrase = errors.New("wrong wallet passphrase")
+var errWalletEncrypted = errors.New("the wallet is encrypted already")
+var errWalletNotEncrypted = errors.New("the wallet is not encrypted")
+
+type WalletCrypt struct {
+	Salt      []byte
+	N, R, P   int
+	MasterKey []byte // the master key sealed with the passphrase key
+}
+
+// seal encrypts plaintext with key, the result is nonce | ciphertext and tag
+func seal(key, plaintext, ad []byte) []byte {
+	aead := newWalletAEAD(key)
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		log.Panic(err)
+	}
+	return aead.Seal(nonce, nonce, plaintext, ad)
+}
+
+func unseal(key, box, ad []byte) ([]byte, error) {
+	aead := newWalletAEAD(key)
+	if len(box) < aead.NonceSize() {
+		return nil, errors.New("sealed wallet data is too short")
+	}
+	return aead.Open(nil, box[:aead.NonceSize()], box[aead.NonceSize():], ad)
+}
+
+func newWalletAEAD(key []byte) cipher.AEAD {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		log.Panic(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		log.Panic(err)
+	}
+	return aead
+}
+
+func randomBytes(n int) []byte {
+	data := make([]byte, n)
+	if _, err := io.ReadFull(rand.Reader, data); err != nil {
+		log.Panic(err)
+	}
+	return data
+}
+
+func newWalletCrypt(passphrase string, masterKey []byte) *WalletCrypt {
+	c := &WalletCrypt{Salt: randomBytes(16), N: scryptN, R: scryptR, P: scryptP}
+	c.MasterKey = seal(c.passphraseKey(passphrase), masterKey, nil)
+	return c
+}
+
+func (c *WalletCrypt) passphraseKey(passphrase string) []byte {
+	key, err := scrypt.Key([]byte(passphrase), c.Salt, c.N, c.R, c.P, walletKeyLen)
+	if err != nil {
+		log.Panic(err)
+	}
+	return key
+}
+
+func (c *WalletCrypt) openMasterKey(passphrase string) ([]byte, error) {
+	masterKey, err := unseal(c.passphraseKey(passphrase), c.MasterKey, nil)
+	if err != nil {
+		return nil, errWrongPassphrase
+	}
+	return masterKey, nil
+}
+
+// the secret of a random key: varbytes ECDSA scalar | varbytes Schnorr secret
+func walletSecret(w *Wallet) []byte {
+	var buff bytes.Buffer
+	var d []byte
+	if w.PrivateKey.D != nil {
+		d = w.PrivateKey.D.FillBytes(make([]byte, sigScalarLen))
+	}
+	writeVarBytes(&buff, d)
+	writeVarBytes(&buff, w.SchnorrKey)
+	return buff.Bytes()
+}
+
+func setWalletSecret(w *Wallet, secret []byte) error {
+	r := bytes.NewReader(secret)
+	d, err := readVarBytes(r)
+	if err != nil {
+		return err
+	}
+	schnorrKey, err := readVarBytes(r)
+	if err != nil {
+		return err
+	}
+	if len(d) > 0 {
+		w.setECDSAKey(d)
+	}
+	w.SchnorrKey = schnorrKey
+	return checkFullyRead(r)
+}
+
+func (wallets *Wallets) IsEncrypted() bool {
+	return wallets.Crypt != nil
+}
+
+func (wallets *Wallets) IsLocked() bool {
+	return wallets.Crypt != nil && wallets.masterKey == nil
+}
+
+// EncryptWallet seals the secrets of the file with passphrase, the wallet stays unlocked
+func (wallets *Wallets) EncryptWallet(passphrase string) error {
+	if wallets.IsEncrypted() {
+		return errWalletEncrypted
+	}
+	masterKey := randomBytes(walletKeyLen)
+	nonces := make(map[string][]byte) // pending MuSig nonces, takeNonce unseals them
+	for id, secret := range wallets.Nonces {
+		pubNonce, err := hex.DecodeString(id)
+		if err != nil {
+			return err
+		}
+		nonces[id] = seal(masterKey, secret, pubNonce)
+	}
+	for _, w := range wallets.Wallets {
+		if w.Path == nil {
+			w.Encrypted = seal(masterKey, walletSecret(w), w.PublicKey)
+		}
+	}
+	if wallets.HD != nil {
+		wallets.HD.seal(masterKey)
+	}
+	wallets.Nonces = nonces
+	wallets.Crypt = newWalletCrypt(passphrase, masterKey)
+	wallets.masterKey = masterKey
+	return nil
+}
+
+// Unlock brings back the private keys and the HD seed, they stay in memory until Lock
+func (wallets *Wallets) Unlock(passphrase string) error {
+	if !wallets.IsEncrypted() {
+		return errWalletNotEncrypted
+	}
+	masterKey, err := wallets.Crypt.openMasterKey(passphrase)
+	if err != nil {
+		return err
+	}
+	return wallets.unlockMaster(masterKey)
+}
+
+// unlockMaster unseals the secrets with the master key itself, e.g. the one of a copy already unlocked
+func (wallets *Wallets) unlockMaster(masterKey []byte) error {
+	var err error
+	if wallets.HD != nil {
+		if wallets.HD.Seed, err = unseal(masterKey, wallets.HD.EncryptedSeed, nil); err != nil {
+			return err
+		}
+	}
+	for _, w := range wallets.Wallets {
+		if w.Path != nil {
+			*w = *wallets.HD.wallet(int(w.Path[len(w.Path)-2]), int(w.Path[len(w.Path)-1]))
+			continue
+		}
+		secret, err := unseal(masterKey, w.Encrypted, w.PublicKey)
+		if err != nil {
+			return err
+		}
+		if err = setWalletSecret(w, secret); err != nil {
+			return err
+		}
+	}
+	wallets.masterKey = masterKey
+	return nil
+}
+
+// unlockWith unlocks a locked wallet for a command given passphrase
+func (wallets *Wallets) unlockWith(passphrase string) {
+	if !wallets.IsLocked() {
+		return
+	}
+	if passphrase == "" {
+		log.Panic(errWalletLocked)
+	}
+	if err := wallets.Unlock(passphrase); err != nil {
+		log.Panic(err)
+	}
+}
+
+// Lock drops the secrets from memory, the sealed ones stay
+func (wallets *Wallets) Lock() {
+	if !wallets.IsEncrypted() {
+		return
+	}
+	for _, w := range wallets.Wallets {
+		w.PrivateKey = ecdsa.PrivateKey{}
+		w.SchnorrKey = nil
+	}
+	if wallets.HD != nil {
+		wallets.HD.Seed = nil
+	}
+	wallets.masterKey = nil
+}
+
+func (wallets *Wallets) ChangePassphrase(old, new string) error {
+	if !wallets.IsEncrypted() {
+		return errWalletNotEncrypted
+	}
+	masterKey, err := wallets.Crypt.openMasterKey(old)
+	if err != nil {
+		return err
+	}
+	wallets.Crypt = newWalletCrypt(new, masterKey)
+	return nil
+}
+
+// add puts a new random key into the wallet, sealed when the file is encrypted
+func (wallets *Wallets) add(address string, w *Wallet) {
+	if wallets.IsEncrypted() {
+		if wallets.IsLocked() {
+			log.Panic(errWalletLocked)
+		}
+		w.Encrypted = seal(wallets.masterKey, walletSecret(w), w.PublicKey)
+	}
+	wallets.Wallets[address] = w
+}
+
+// stripped is what goes to an encrypted file: everything but the secrets
+func (wallets *Wallets) stripped() *Wallets {
+	ws := &Wallets{Wallets: make(map[string]*Wallet), Crypt: wallets.Crypt, Watch: wallets.Watch, XPubs: wallets.XPubs,
+		Frozen: wallets.Frozen, Txs: wallets.Txs, Synced: wallets.Synced, Nonces: wallets.Nonces}
+	for address, w := range wallets.Wallets {
+		public := *w
+		public.PrivateKey = ecdsa.PrivateKey{}
+		public.SchnorrKey = nil
+		ws.Wallets[address] = &public
+	}
+	if wallets.HD != nil {
+		hd := *wallets.HD
+		hd.Seed = nil
+		ws.HD = &hd
+	}
+	return ws
+}
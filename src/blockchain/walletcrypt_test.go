@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func TestSealRoundTrip(t *testing.T) {
+	key := randomBytes(walletKeyLen)
+	box := seal(key, []byte("secret"), []byte("ad"))
+	if plain, err := unseal(key, box, []byte("ad")); err != nil || string(plain) != "secret" {
+		t.Fatalf("unsealed %q: %v", plain, err)
+	}
+	if _, err := unseal(key, box, []byte("other ad")); err == nil {
+		t.Fatal("unsealed with other additional data")
+	}
+	if _, err := unseal(randomBytes(walletKeyLen), box, []byte("ad")); err == nil {
+		t.Fatal("unsealed with another key")
+	}
+}
+
+func TestEncryptedWalletFile(t *testing.T) {
+	inTempDir(t)
+	wallets := &Wallets{Wallets: make(map[string]*Wallet)}
+	if _, err := wallets.CreateHDWallet(""); err != nil {
+		t.Fatal(err)
+	}
+	random := wallets.CreateWallet()
+	schnorr := wallets.CreateSchnorrWallet()
+	hd := wallets.NewHDAddress(hdReceiveChain)
+	secretD := wallets.Wallets[random].PrivateKey.D
+	secretSchnorr := wallets.Wallets[schnorr].SchnorrKey
+	seed := wallets.HD.Seed
+
+	if err := wallets.EncryptWallet("pw"); err != nil {
+		t.Fatal(err)
+	}
+	if err := wallets.EncryptWallet("pw"); err != errWalletEncrypted {
+		t.Fatalf("encrypted twice: %v", err)
+	}
+	wallets.SaveToFile("test")
+	content, err := ioutil.ReadFile("wallet_test.dat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range [][]byte{secretD.Bytes(), secretSchnorr, seed} {
+		if bytes.Contains(content, secret) {
+			t.Fatal("a secret is in the encrypted file")
+		}
+	}
+
+	loaded, err := NewWallets("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.IsLocked() || loaded.Wallets[random].CanSign() {
+		t.Fatal("the loaded wallet is not locked")
+	}
+	if err := loaded.Unlock("nope"); err != errWrongPassphrase {
+		t.Fatalf("unlocked with a wrong passphrase: %v", err)
+	}
+	if err := loaded.Unlock("pw"); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Wallets[random].PrivateKey.D.Cmp(secretD) != 0 || !bytes.Equal(loaded.Wallets[schnorr].SchnorrKey, secretSchnorr) {
+		t.Fatal("the unlocked keys are not the ones sealed")
+	}
+	if !loaded.Wallets[hd].CanSign() || !bytes.Equal(loaded.HD.Seed, seed) {
+		t.Fatal("the HD keys are not back")
+	}
+
+	loaded.Lock()
+	if loaded.Wallets[schnorr].CanSign() || loaded.HD.Seed != nil {
+		t.Fatal("secrets are left after Lock")
+	}
+	if err := loaded.ChangePassphrase("pw", "pw2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := loaded.Unlock("pw"); err != errWrongPassphrase {
+		t.Fatalf("the old passphrase unlocks: %v", err)
+	}
+	if err := loaded.Unlock("pw2"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUnlockWithMasterKey(t *testing.T) {
+	inTempDir(t)
+	wallets := &Wallets{Wallets: make(map[string]*Wallet)}
+	address := wallets.CreateWallet()
+	if err := wallets.EncryptWallet("pw"); err != nil {
+		t.Fatal(err)
+	}
+	wallets.SaveToFile("test")
+	// a node keeps an unlocked copy and reads the file again, it unlocks with the master key of its copy
+	reloaded, err := NewWallets("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := reloaded.unlockMaster(wallets.masterKey); err != nil {
+		t.Fatal(err)
+	}
+	if !reloaded.Wallets[address].CanSign() {
+		t.Fatal("the key is not back")
+	}
+	if err := reloaded.unlockMaster(randomBytes(walletKeyLen)); err == nil {
+		t.Fatal("unlocked with another master key")
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/elliptic"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+Private keys move between wallets as text, Base58Check like addresses are:
+
+	version | 32-byte secret | CheckSum
+
+The version is the one of the address with the high bit set, so the key of a Schnorr address
+(schnorrVersion) can't be read as an ECDSA one. A wallet dump is a text file of one key per
+line followed by its address, # starts a comment. HD addresses are dumped as plain keys, only
+the mnemonic restores their chains.
+*/
+const privKeyVersion = version | 0x80
+const schnorrPrivKeyVersion = schnorrVersion | 0x80
+const privKeyLen = 32
+
+var errBadPrivKey = errors.New("malformed private key")
+
+// EncodePrivKey is the text form of the key of w, which has to be unlocked
+func EncodePrivKey(w Wallet) (string, error) {
+	if !w.CanSign() {
+		return "", errWalletLocked
+	}
+	var payload []byte
+	if w.IsSchnorr() {
+		payload = append([]byte{schnorrPrivKeyVersion}, w.SchnorrKey...)
+	} else {
+		payload = append([]byte{privKeyVersion}, w.PrivateKey.D.FillBytes(make([]byte, privKeyLen))...)
+	}
+	return string(Base58Encode(append(payload, CheckSum(payload)...))), nil
+}
+
+// DecodePrivKey is the wallet of a key from EncodePrivKey
+func DecodePrivKey(key string) (*Wallet, error) {
+	if key == "" {
+		return nil, errBadPrivKey
+	}
+	for _, c := range []byte(key) {
+		if bytes.IndexByte(b58Alphabet, c) < 0 {
+			return nil, errBadPrivKey
+		}
+	}
+	decoded := Base58Decode([]byte(key))
+	if len(decoded) != 1+privKeyLen+addressChecksumLen {
+		return nil, errBadPrivKey
+	}
+	payload := decoded[:1+privKeyLen]
+	if !bytes.Equal(CheckSum(payload), decoded[1+privKeyLen:]) {
+		return nil, errors.New("bad checksum of the private key")
+	}
+	secret := append([]byte{}, payload[1:]...)
+	switch payload[0] {
+	case privKeyVersion:
+		d := new(big.Int).SetBytes(secret)
+		if d.Sign() == 0 || d.Cmp(elliptic.P256().Params().N) >= 0 {
+			return nil, errBadPrivKey
+		}
+		w := &Wallet{}
+		w.setECDSAKey(secret)
+		w.PublicKey = MarshalPubKey(&w.PrivateKey.PublicKey)
+		return w, nil
+	case schnorrPrivKeyVersion:
+		pubkey, err := SchnorrPubKey(secret)
+		if err != nil {
+			return nil, err
+		}
+		return &Wallet{PublicKey: pubkey, SchnorrKey: secret}, nil
+	}
+	return nil, errors.New("unknown version of the private key")
+}
+
+/*
+ImportKey puts the key of w into the wallet as a random key and returns its address. A watched
+address becomes a spendable one
+*/
+func (wallets *Wallets) ImportKey(w *Wallet) (string, error) {
+	address := fmt.Sprintf("%s", w.GetAddress())
+	if _, found := wallets.Wallets[address]; found {
+		return address, errAlreadyInWallet
+	}
+	wallets.add(address, w)
+	delete(wallets.Watch, address)
+	return address, nil
+}
+
+// DumpWallet writes the keys of the wallet in the text form importwallet reads
+func (wallets *Wallets) DumpWallet(nodeID string) (string, error) {
+	var addresses []string
+	for address := range wallets.Wallets {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+	var out strings.Builder
+	fmt.Fprintf(&out, "# wallet of node %s, dumped %s\n", nodeID, time.Now().UTC().Format(time.RFC3339))
+	fmt.Fprintf(&out, "# KEY ADDRESS, read it back with importwallet. Anyone with this file can spend the coins\n")
+	if wallets.HD != nil {
+		fmt.Fprintf(&out, "# the HD addresses are plain keys here, their mnemonic restores the chains\n")
+	}
+	for _, address := range addresses {
+		w := wallets.Wallets[address]
+		key, err := EncodePrivKey(*w)
+		if err != nil {
+			return "", err
+		}
+		switch {
+		case w.Path != nil:
+			fmt.Fprintf(&out, "%s %s # hd %s\n", key, address, FormatPath(w.Path))
+		case w.IsSchnorr():
+			fmt.Fprintf(&out, "%s %s # schnorr\n", key, address)
+		default:
+			fmt.Fprintf(&out, "%s %s\n", key, address)
+		}
+	}
+	return out.String(), nil
+}
+
+// ParseWalletDump reads the keys of a dump, the address after a key has to be its own
+func ParseWalletDump(content []byte) ([]*Wallet, error) {
+	var keys []*Wallet
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) > 2 {
+			return nil, fmt.Errorf("line %d: expected KEY ADDRESS", n)
+		}
+		w, err := DecodePrivKey(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", n, err)
+		}
+		if len(fields) == 2 {
+			key, err := DecodeAddress(fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s", n, err)
+			}
+			if !bytes.Equal(key, w.LockingKey()) {
+				return nil, fmt.Errorf("line %d: the key is not the one of %s", n, fields[1])
+			}
+		}
+		keys = append(keys, w)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// Rescan forgets where SyncTxs stopped and looks at the whole chain again, for imported keys
+func (wallets *Wallets) Rescan(bc *BlockChain) {
+	wallets.Synced = nil
+	wallets.SyncTxs(bc)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPrivKeyRoundTrip(t *testing.T) {
+	wallets := &Wallets{Wallets: make(map[string]*Wallet)}
+	for _, address := range []string{wallets.CreateWallet(), wallets.CreateSchnorrWallet()} {
+		w := wallets.Wallets[address]
+		key, err := EncodePrivKey(*w)
+		if err != nil {
+			t.Fatal(err)
+		}
+		back, err := DecodePrivKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(back.GetAddress()) != address || !bytes.Equal(back.PublicKey, w.PublicKey) || back.IsSchnorr() != w.IsSchnorr() {
+			t.Fatalf("%s came back as %s", address, back.GetAddress())
+		}
+		// a changed character breaks the checksum
+		tampered := []byte(key)
+		if tampered[5] == 'a' {
+			tampered[5] = 'b'
+		} else {
+			tampered[5] = 'a'
+		}
+		if _, err := DecodePrivKey(string(tampered)); err == nil {
+			t.Fatalf("a tampered key of %s is taken", address)
+		}
+	}
+	for _, bad := range []string{"", "0OIl", string(Base58Encode([]byte{privKeyVersion, 1, 2}))} {
+		if _, err := DecodePrivKey(bad); err == nil {
+			t.Errorf("%q is taken", bad)
+		}
+	}
+	// the secret of an address with another version
+	payload := append([]byte{version}, bytes.Repeat([]byte{1}, privKeyLen)...)
+	if _, err := DecodePrivKey(string(Base58Encode(append(payload, CheckSum(payload)...)))); err == nil {
+		t.Error("a key with the version of an address is taken")
+	}
+}
+
+func TestDumpWalletRoundTrip(t *testing.T) {
+	wallets := &Wallets{Wallets: make(map[string]*Wallet)}
+	if _, err := wallets.CreateHDWallet(""); err != nil {
+		t.Fatal(err)
+	}
+	addresses := []string{wallets.CreateWallet(), wallets.CreateSchnorrWallet(), wallets.NewHDAddress(hdReceiveChain)}
+	dump, err := wallets.DumpWallet("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := ParseWalletDump([]byte(dump))
+	if err != nil {
+		t.Fatal(err)
+	}
+	imported := &Wallets{Wallets: make(map[string]*Wallet)}
+	for _, w := range keys {
+		if _, err := imported.ImportKey(w); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, address := range addresses {
+		if w, found := imported.Wallets[address]; !found || !w.CanSign() {
+			t.Fatalf("%s is not in the imported wallet", address)
+		}
+	}
+	if _, err := imported.ImportKey(keys[0]); err != errAlreadyInWallet {
+		t.Fatalf("a key imported twice: %v", err)
+	}
+
+	key, err := EncodePrivKey(*wallets.Wallets[addresses[0]])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, bad := range []string{
+		key + " " + string(NewWallet().GetAddress()),
+		key + " " + addresses[0] + " extra",
+		"notakey " + addresses[0],
+	} {
+		if _, err := ParseWalletDump([]byte(bad)); err == nil {
+			t.Errorf("%q is taken", bad)
+		}
+	}
+
+	wallets.EncryptWallet("pw")
+	wallets.Lock()
+	if _, err := wallets.DumpWallet("test"); err != errWalletLocked {
+		t.Fatalf("a locked wallet is dumped: %v", err)
+	}
+}
+
+func TestImportKeyRescan(t *testing.T) {
+	bc, w := newTestChain(t)
+	key, err := EncodePrivKey(*w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	back, err := DecodePrivKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wallets := &Wallets{Wallets: make(map[string]*Wallet)}
+	address := string(w.GetAddress())
+	if err := wallets.ImportAddress(address); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wallets.ImportKey(back); err != nil {
+		t.Fatal(err)
+	}
+	if _, watched := wallets.Watch[address]; watched {
+		t.Fatal("the imported key is still watch-only")
+	}
+	wallets.Rescan(bc)
+	if b := wallets.Balance(w.LockingKey(), &UTXOSet{bc}); len(wallets.Txs) != 1 || b.Immature != subsidy {
+		t.Fatalf("the rescan found %d txs, balance %+v", len(wallets.Txs), b)
+	}
+}
@@ -2,8 +2,8 @@ package main
 
 import (
 	"bytes"
-	"crypto/elliptic"
 	"encoding/gob"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -14,6 +14,16 @@ const walletFile = "wallet_%s.dat"
 
 type Wallets struct {
 	Wallets map[string]*Wallet
+	HD      *HDState                // nil until the file has an HD wallet, files without one decode the same
+	Crypt   *WalletCrypt            // nil for a file that is not encrypted, see walletcrypt.go
+	Watch   map[string]*WatchOnly   // addresses followed without their keys, see watchonly.go
+	XPubs   map[string]*WatchedXPub // extended public keys the watch-only addresses come from
+	Frozen  map[string]bool         // outpoints (txid:vout) coin selection skips, see coinselect.go
+	Txs     map[string]*WalletTx    // transactions of the wallet by txid, see wallettx.go
+	Synced  []byte                  // last block SyncTxs looked at
+	Nonces  map[string][]byte       // secret MuSig nonces by public nonce until they sign, see musigspend.go
+
+	masterKey []byte // of an encrypted wallet while it is unlocked
 }
 
 func NewWallets(nodeID string) (*Wallets, error) {
@@ -26,9 +36,75 @@ func NewWallets(nodeID string) (*Wallets, error) {
 func (wallets *Wallets) CreateWallet() string {
 	wallet := NewWallet()
 	address := fmt.Sprintf("%s", wallet.GetAddress())
+	wallets.add(address, wallet)
+	return address
+
+}
+
+// CreateHDWallet gives the file an HD wallet from a new seed and returns its mnemonic
+func (wallets *Wallets) CreateHDWallet(passphrase string) (string, error) {
+	if wallets.HD != nil {
+		return "", errors.New("the wallet file has an HD wallet already")
+	}
+	mnemonic := NewMnemonic(mnemonicEntropyBits)
+	wallets.setHD(newHDState(MnemonicSeed(mnemonic, passphrase)))
+	return mnemonic, nil
+}
+
+// setHD gives the file an HD wallet, the seed is sealed when the file is encrypted
+func (wallets *Wallets) setHD(hd *HDState) {
+	if wallets.IsEncrypted() {
+		if wallets.IsLocked() {
+			log.Panic(errWalletLocked)
+		}
+		hd.seal(wallets.masterKey)
+	}
+	wallets.HD = hd
+}
+
+// NewHDAddress hands out the next address of chain, hdReceiveChain or hdChangeChain
+func (wallets *Wallets) NewHDAddress(chain int) string {
+	wallet := wallets.HD.wallet(chain, wallets.HD.Next[chain])
+	wallets.HD.Next[chain]++
+	address := fmt.Sprintf("%s", wallet.GetAddress())
 	wallets.Wallets[address] = wallet
 	return address
+}
+
+/*
+RestoreHDWallet gives the file the HD wallet of mnemonic and finds the addresses it used: each
+chain is derived until hdGapLimit addresses in a row are not in used, the locking keys of the chain
+*/
+func (wallets *Wallets) RestoreHDWallet(mnemonic, passphrase string, used map[string]bool) ([]string, error) {
+	if wallets.HD != nil {
+		return nil, errors.New("the wallet file has an HD wallet already")
+	}
+	if _, err := MnemonicToEntropy(mnemonic); err != nil {
+		return nil, err
+	}
+	hd := newHDState(MnemonicSeed(mnemonic, passphrase))
+	hd.Next = discoverChains(func(chain, index int) []byte {
+		return hd.wallet(chain, index).LockingKey()
+	}, used)
+
+	wallets.setHD(hd)
+	var found []string
+	for _, chain := range []int{hdReceiveChain, hdChangeChain} {
+		for index := 0; index < hd.Next[chain]; index++ {
+			wallet := hd.wallet(chain, index)
+			address := fmt.Sprintf("%s", wallet.GetAddress())
+			wallets.Wallets[address] = wallet
+			found = append(found, address)
+		}
+	}
+	return found, nil
+}
 
+func (wallets *Wallets) CreateSchnorrWallet() string {
+	wallet := NewSchnorrWallet()
+	address := fmt.Sprintf("%s", wallet.GetAddress())
+	wallets.add(address, wallet)
+	return address
 }
 
 func (w Wallets) GetWallet(address string) Wallet {
@@ -47,30 +123,73 @@ func (wallets *Wallets) LoadFromFile(nodeID string) error {
 		log.Panic(err)
 	}
 	var ws Wallets
-	gob.Register(elliptic.P256()) // gob need to know what kind of type we need to map
-	// wallet stores the ecdsa priv key and pubkey
+	// wallet stores the ecdsa priv key and pubkey, see Wallet.GobDecode
 	decoded := gob.NewDecoder(bytes.NewReader(fileContent))
 	err = decoded.Decode(&ws) // output to ws
 	if err != nil {
-		log.Panic(err)
+		// a file of the old gob layout, it is written in the current one on the next save
+		legacy, legacyErr := decodeLegacyWallets(fileContent)
+		if legacyErr != nil {
+			log.Panic(err)
+		}
+		ws = Wallets{Wallets: legacy}
 	}
 	wallets.Wallets = ws.Wallets
+	wallets.HD = ws.HD
+	wallets.Crypt = ws.Crypt
+	wallets.Watch = ws.Watch
+	wallets.XPubs = ws.XPubs
+	wallets.Frozen = ws.Frozen
+	wallets.Txs = ws.Txs
+	wallets.Synced = ws.Synced
+	wallets.Nonces = ws.Nonces
+	wallets.fixAddresses()
 	return nil
 }
 
+/*
+fixAddresses keys the wallet by the right address again: Base58Encode used to drop all but one
+leading zero byte, so a key hash starting with 0x00 got an address that never decoded
+*/
+func (wallets *Wallets) fixAddresses() {
+	for address, w := range wallets.Wallets {
+		if right := string(w.GetAddress()); right != address {
+			delete(wallets.Wallets, address)
+			wallets.Wallets[right] = w
+		}
+	}
+	for address, entry := range wallets.Watch {
+		if right := string(LockingKeyAddress(entry.LockingKey)); right != address {
+			delete(wallets.Watch, address)
+			wallets.Watch[right] = entry
+		}
+	}
+}
+
 func (wallets *Wallets) SaveToFile(nodeID string) {
 	var content bytes.Buffer
 	thiswalletFile := fmt.Sprintf(walletFile, nodeID)
 
-	gob.Register(elliptic.P256())
-
 	encoder := gob.NewEncoder(&content)
-	err := encoder.Encode(wallets)
+	if wallets.IsEncrypted() { // only the sealed secrets go to the file
+		err := encoder.Encode(wallets.stripped())
+		if err != nil {
+			log.Panic(err)
+		}
+	} else {
+		err := encoder.Encode(wallets)
+		if err != nil {
+			log.Panic(err)
+		}
+	}
+
+	// written next to it and renamed, a crash leaves the old file and nobody else can read the new one
+	tmp := thiswalletFile + ".tmp"
+	err := ioutil.WriteFile(tmp, content.Bytes(), 0600)
 	if err != nil {
 		log.Panic(err)
 	}
-
-	err = ioutil.WriteFile(thiswalletFile, content.Bytes(), 0644)
+	err = os.Rename(tmp, thiswalletFile)
 	if err != nil {
 		log.Panic(err)
 	}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"log"
+	"sort"
+	"time"
+)
+
+/*
+The wallet keeps the transactions that pay to or spend from its addresses in its file, so it
+knows about a tx before a block has it:
+
+	pending     sent by the wallet, or seen by the node in its mempool, and in no block of the
+	            active chain yet. Coin selection skips the outputs it spends, sending again before
+	            the next block does not spend them twice
+	confirmed   in a block of the active chain, found with the txindex. A reorg that takes the
+	            block away makes it pending again
+	conflicted  pending, but an input is spent by another tx of the chain, it can never confirm
+
+SyncTxs looks at the blocks since the last sync for new transactions of the wallet and checks
+the state of the known ones. A coinbase output is immature until it has coinbaseMaturity
+confirmations: the chain lets it be spent, but a reorg can take it away, so it is shown apart.
+*/
+const coinbaseMaturity = 100
+
+type WalletTx struct {
+	Tx         []byte // serialized tx
+	Height     int    // of the block that has the tx, -1 while pending
+	Block      []byte
+	Time       int64 // block time, or when the wallet saw it pending
+	Credit     int   // paid to the wallet
+	Debit      int   // spent from the wallet
+	Coinbase   bool
+	Conflicted bool
+}
+
+// Balance of an address, confirmed does not count what pending txs spend
+type Balance struct {
+	Confirmed int
+	Pending   int // outputs of pending txs, incoming or change
+	Immature  int // coinbase outputs below coinbaseMaturity confirmations
+}
+
+func (wtx *WalletTx) Transaction() Transaction {
+	return DeserializeTransaction(wtx.Tx)
+}
+
+func (wtx *WalletTx) IsPending() bool {
+	return wtx.Height < 0 && !wtx.Conflicted
+}
+
+// trackTx records tx when it touches an address of mine, the locking keys (hex) of the wallet
+func (wallets *Wallets) trackTx(tx *Transaction, mine map[string]string, UTXO *UTXOSet) bool {
+	id := hex.EncodeToString(tx.ID)
+	if _, found := wallets.Txs[id]; found {
+		return false
+	}
+	wtx := &WalletTx{Height: -1, Time: time.Now().Unix(), Coinbase: tx.isCoinbaseTX()}
+	for _, out := range tx.VOut {
+		if _, found := mine[hex.EncodeToString(out.PubKeyHash)]; found {
+			wtx.Credit += out.Value
+		}
+	}
+	if !wtx.Coinbase {
+		for _, vin := range tx.VIn {
+			if prev, ok := wallets.spentOutput(vin, UTXO); ok {
+				if _, found := mine[hex.EncodeToString(prev.PubKeyHash)]; found {
+					wtx.Debit += prev.Value
+				}
+			}
+		}
+	}
+	if wtx.Credit == 0 && wtx.Debit == 0 {
+		return false
+	}
+	wtx.Tx = tx.Serialize()
+	if wallets.Txs == nil {
+		wallets.Txs = make(map[string]*WalletTx)
+	}
+	wallets.Txs[id] = wtx
+	return true
+}
+
+// spentOutput is the output vin spends, from the wallet's txs or from the UTXO set while unspent
+func (wallets *Wallets) spentOutput(vin TXInput, UTXO *UTXOSet) (TXOutput, bool) {
+	if prev, found := wallets.Txs[hex.EncodeToString(vin.TXid)]; found {
+		if outs := prev.Transaction().VOut; vin.Vout < len(outs) {
+			return outs[vin.Vout], true
+		}
+	}
+	var entry UTXOEntry
+	ok := false
+	err := UTXO.blockchain.db.View(func(tx *StorageTx) error {
+		entry, ok = tx.Chainstate().Get(Outpoint{vin.TXid, vin.Vout})
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+	return entry.Output, ok
+}
+
+// TrackTx records a tx of the wallet that is not in a block yet, it tells whether tx is one
+func (wallets *Wallets) TrackTx(tx *Transaction, UTXO *UTXOSet) bool {
+	return wallets.trackTx(tx, wallets.AddressesByLockingKey(), UTXO)
+}
+
+/*
+SyncTxs records the transactions of the wallet in the blocks since the last sync and updates
+the state of all of them. Pruned blocks are skipped. It tells whether the wallet changed
+*/
+func (wallets *Wallets) SyncTxs(bc *BlockChain) bool {
+	UTXO := &UTXOSet{bc}
+	changed := false
+	from, err := bc.activeAncestorHeight(wallets.Synced)
+	if err == errIndexBehind { // the node is syncing, the next call catches up
+		return false
+	}
+	if err != nil {
+		log.Panic(err)
+	}
+	mine := wallets.AddressesByLockingKey()
+	if len(mine) == 0 { // nothing to look for
+		return false
+	}
+	for height := from + 1; height <= bc.GetBestHeight(); height++ {
+		b, err := bc.GetBlockByHeight(height)
+		if err == errBlockPruned {
+			continue
+		}
+		if err == errNoBlockAtHeight { // the history below a snapshot is still downloading
+			break
+		}
+		if err != nil {
+			log.Panic(err)
+		}
+		for _, tx := range b.Transactions {
+			if wallets.trackTx(tx, mine, UTXO) {
+				wallets.Txs[hex.EncodeToString(tx.ID)].Time = b.Timestamp
+				changed = true
+			}
+		}
+		wallets.Synced = b.Hash
+		changed = true
+	}
+
+	for _, wtx := range wallets.Txs {
+		tx := wtx.Transaction()
+		hash, height, found := bc.txBlock(tx.ID)
+		if found {
+			changed = changed || wtx.Height != height || wtx.Conflicted
+			wtx.Height, wtx.Block, wtx.Conflicted = height, hash, false
+			continue
+		}
+		if wtx.Height >= 0 { // its block left the active chain
+			wtx.Height, wtx.Block = -1, nil
+			changed = true
+		}
+		conflicted := wtx.Coinbase // only its own block can have it
+		for _, vin := range tx.VIn {
+			if _, _, confirmed := bc.txBlock(vin.TXid); !wtx.Coinbase && confirmed && !UTXO.IsUnspent(vin.TXid, vin.Vout) {
+				conflicted = true
+			}
+		}
+		changed = changed || wtx.Conflicted != conflicted
+		wtx.Conflicted = conflicted
+	}
+	return changed
+}
+
+// PendingSpends are the outpoints (txid:vout) pending txs of the wallet spend
+func (wallets *Wallets) PendingSpends() map[string]bool {
+	spent := make(map[string]bool)
+	for _, wtx := range wallets.Txs {
+		if !wtx.IsPending() || wtx.Coinbase {
+			continue
+		}
+		for _, vin := range wtx.Transaction().VIn {
+			spent[Outpoint{vin.TXid, vin.Vout}.String()] = true
+		}
+	}
+	return spent
+}
+
+// Balance of the address with lockingKey, the pending part comes from the txs of the wallet
+func (wallets *Wallets) Balance(lockingKey []byte, UTXO *UTXOSet) Balance {
+	var balance Balance
+	spent := wallets.PendingSpends()
+	best := UTXO.blockchain.GetBestHeight()
+	for _, c := range UTXO.FindCoins(lockingKey) {
+		switch {
+		case spent[c.String()]:
+		case c.Coinbase && best-c.Height+1 < coinbaseMaturity:
+			balance.Immature += c.Output.Value
+		default:
+			balance.Confirmed += c.Output.Value
+		}
+	}
+	for _, wtx := range wallets.Txs {
+		if !wtx.IsPending() {
+			continue
+		}
+		tx := wtx.Transaction()
+		for vout, out := range tx.VOut {
+			if bytes.Equal(out.PubKeyHash, lockingKey) && !spent[Outpoint{tx.ID, vout}.String()] {
+				balance.Pending += out.Value
+			}
+		}
+	}
+	return balance
+}
+
+// WalletTxs are the txs of the wallet, pending ones first and then the newest
+func (wallets *Wallets) WalletTxs() []*WalletTx {
+	var txs []*WalletTx
+	for _, wtx := range wallets.Txs {
+		txs = append(txs, wtx)
+	}
+	sort.SliceStable(txs, func(i, j int) bool {
+		if txs[i].IsPending() != txs[j].IsPending() {
+			return txs[i].IsPending()
+		}
+		if txs[i].Height != txs[j].Height {
+			return txs[i].Height > txs[j].Height
+		}
+		return txs[i].Time > txs[j].Time
+	})
+	return txs
+}
+
+// txBlock is the block of the active chain that has tx ID, from the txindex
+func (bc *BlockChain) txBlock(ID []byte) ([]byte, int, bool) {
+	var hash []byte
+	height := -1
+	err := bc.db.View(func(tx *StorageTx) error {
+		index := tx.Indexes().Index(txIndexBucket)
+		if index == nil {
+			return nil
+		}
+		data := index.Get(ID)
+		if data == nil {
+			return nil
+		}
+		loc, err := DeserializeTxLocation(data)
+		if err != nil {
+			return err
+		}
+		header, err := tx.Blocks().Header(loc.BlockHash)
+		if err != nil {
+			return err
+		}
+		hash, height = loc.BlockHash, header.Height
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+	return hash, height, hash != nil
+}
+
+// activeAncestorHeight is the height of the last block below and with hash that is in the active chain, -1 for none
+func (bc *BlockChain) activeAncestorHeight(hash []byte) (int, error) {
+	height := -1
+	err := bc.db.View(func(tx *StorageTx) error {
+		heights, err := activeHeights(tx)
+		if err != nil {
+			return err
+		}
+		for len(hash) > 0 {
+			header, err := tx.Blocks().Header(hash)
+			if err == errBlockNotFound { // a block this node never had, e.g. a wallet file from another node
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if bytes.Equal(heights.Get(heightKey(header.Height)), hash) {
+				height = header.Height
+				return nil
+			}
+			hash = header.PrevBlockHash
+		}
+		return nil
+	})
+	return height, err
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+// walletOf is a wallet file with w in it
+func walletOf(w *Wallet) *Wallets {
+	wallets := &Wallets{Wallets: make(map[string]*Wallet)}
+	wallets.Wallets[string(w.GetAddress())] = w
+	return wallets
+}
+
+func TestWalletTxBalances(t *testing.T) {
+	bc, w := newTestChain(t)
+	UTXO := &UTXOSet{bc}
+	wallets := walletOf(w)
+	if !wallets.SyncTxs(bc) || len(wallets.Txs) != 1 {
+		t.Fatalf("the genesis coinbase is not tracked: %d txs", len(wallets.Txs))
+	}
+	// the genesis coinbase has a single confirmation
+	if b := wallets.Balance(w.LockingKey(), UTXO); b.Immature != subsidy || b.Confirmed != 0 || b.Pending != 0 {
+		t.Fatalf("balance %+v, want %d immature", b, subsidy)
+	}
+
+	to := NewWallet()
+	tx := NewPaymentsTransaction(w, []Payment{{string(to.GetAddress()), 4}}, "", UTXO, nil)
+	double := NewPaymentsTransaction(w, []Payment{{string(NewWallet().GetAddress()), 3}}, "", UTXO, nil)
+	if !wallets.TrackTx(tx, UTXO) || !wallets.TrackTx(double, UTXO) {
+		t.Fatal("the txs of the wallet are not tracked")
+	}
+	if wallets.TrackTx(NewCoinbaseTX(string(to.GetAddress()), ""), UTXO) {
+		t.Fatal("a tx of another wallet is tracked")
+	}
+	wtx := wallets.Txs[hex.EncodeToString(tx.ID)]
+	if !wtx.IsPending() || wtx.Debit != subsidy || wtx.Credit != subsidy-4 {
+		t.Fatalf("the pending tx is %+v", wtx)
+	}
+	// the coin both spend is pending spent, their change is pending
+	if b := wallets.Balance(w.LockingKey(), UTXO); b.Immature != 0 || b.Confirmed != 0 || b.Pending != 2*subsidy-7 {
+		t.Fatalf("balance %+v with the txs pending", b)
+	}
+
+	if _, err := bc.MineBlock([]*Transaction{NewCoinbaseTX(string(to.GetAddress()), ""), tx}); err != nil {
+		t.Fatal(err)
+	}
+	if !wallets.SyncTxs(bc) {
+		t.Fatal("the block did not change the wallet")
+	}
+	if wtx.Height != 1 || wtx.IsPending() {
+		t.Fatalf("the mined tx is %+v", wtx)
+	}
+	if conflicted := wallets.Txs[hex.EncodeToString(double.ID)]; !conflicted.Conflicted || conflicted.IsPending() {
+		t.Fatal("the tx spending the same coin is not conflicted")
+	}
+	if b := wallets.Balance(w.LockingKey(), UTXO); b.Confirmed != subsidy-4 || b.Pending != 0 || b.Immature != 0 {
+		t.Fatalf("balance %+v after the block", b)
+	}
+	txs := wallets.WalletTxs()
+	if len(txs) != 3 || txs[0].Height != 1 {
+		t.Fatalf("%d wallet txs, the newest at height %d", len(txs), txs[0].Height)
+	}
+}
+
+func TestWalletTxReorg(t *testing.T) {
+	bc, w := newTestChain(t)
+	genesis, err := bc.GetBlock(bc.tip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wallets := walletOf(w)
+	tx := NewPaymentsTransaction(w, []Payment{{string(NewWallet().GetAddress()), 4}}, "", &UTXOSet{bc}, nil)
+	if _, err := bc.MineBlock([]*Transaction{NewCoinbaseTX(string(w.GetAddress()), ""), tx}); err != nil {
+		t.Fatal(err)
+	}
+	wallets.SyncTxs(bc)
+	if wtx := wallets.Txs[hex.EncodeToString(tx.ID)]; wtx == nil || wtx.Height != 1 {
+		t.Fatal("the mined tx is not confirmed")
+	}
+
+	// a longer branch without the tx takes its block away, the tx can still confirm
+	b1 := forkBlock(&genesis)
+	bc.AddBlock(b1)
+	bc.AddBlock(forkBlock(b1))
+	wallets.SyncTxs(bc)
+	if wtx := wallets.Txs[hex.EncodeToString(tx.ID)]; !wtx.IsPending() {
+		t.Fatalf("the tx of the old branch is %+v", wtx)
+	}
+	for _, wtx := range wallets.Txs {
+		if wtx.Coinbase && wtx.Height == 1 {
+			t.Fatal("the coinbase of the old branch is still confirmed")
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+)
+
+/*
+Watch-only entries let a node follow addresses it has no keys for, e.g. the ones of a cold
+wallet. An entry is a plain address or one derived from an extended public key (getxpub of the
+cold node), whose receive and change chains are derived like the ones of an HD wallet and found in
+the chain with the same gap limit. Importing the key again looks for newly used addresses.
+
+Sending from a watch-only address builds an unsigned tx that the node with the key signs offline,
+see unsignedtx.go.
+*/
+var errAlreadyInWallet = errors.New("the address has a key in the wallet already")
+
+type WatchOnly struct {
+	LockingKey []byte
+	XPub       string   // extended public key the address comes from, "" for a plain address
+	Path       []uint32 // chain/index below XPub
+	MuSigKeys  [][]byte // the signer keys of a MuSig address, see aggregatekeys -watch
+}
+
+type WatchedXPub struct {
+	Next [2]int // next index of the receive and the change chain
+}
+
+func (wallets *Wallets) IsWatchOnly(address string) bool {
+	_, found := wallets.Watch[address]
+	return found
+}
+
+func (wallets *Wallets) addWatch(address string, entry *WatchOnly) {
+	if wallets.Watch == nil {
+		wallets.Watch = make(map[string]*WatchOnly)
+	}
+	wallets.Watch[address] = entry
+}
+
+func (wallets *Wallets) ImportAddress(address string) error {
+	key, err := DecodeAddress(address)
+	if err != nil {
+		return err
+	}
+	address = string(LockingKeyAddress(key)) // a Bech32 address is kept by its Base58 form too
+	if _, found := wallets.Wallets[address]; found {
+		return errAlreadyInWallet
+	}
+	wallets.addWatch(address, &WatchOnly{LockingKey: key})
+	return nil
+}
+
+// ImportMuSig watches the aggregate address of ctx with the keys of its signers, send -unsigned spends from it
+func (wallets *Wallets) ImportMuSig(ctx *MuSigKeyContext) (string, error) {
+	address := string(SchnorrAddress(ctx.AggregateKey()))
+	if err := wallets.ImportAddress(address); err != nil {
+		return "", err
+	}
+	wallets.Watch[address].MuSigKeys = ctx.PubKeys
+	return address, nil
+}
+
+/*
+ImportXPub watches the addresses of xpub that are in used, the locking keys of the chain, and the
+first unused receiving address. It returns the addresses that are new to the wallet
+*/
+func (wallets *Wallets) ImportXPub(xpub string, used map[string]bool) ([]string, error) {
+	key, err := ParseExtendedPublicKey(xpub)
+	if err != nil {
+		return nil, err
+	}
+	derive := func(chain, index int) (*Wallet, []uint32) {
+		path := []uint32{uint32(chain), uint32(index)}
+		child, err := key.Derive(path)
+		if err != nil { // only hardened children fail, these are not
+			log.Panic(err)
+		}
+		return child.Wallet(path), path
+	}
+	next := discoverChains(func(chain, index int) []byte {
+		w, _ := derive(chain, index)
+		return w.LockingKey()
+	}, used)
+	if next[hdReceiveChain] == 0 {
+		next[hdReceiveChain] = 1 // something to hand out for payments
+	}
+	if wallets.XPubs == nil {
+		wallets.XPubs = make(map[string]*WatchedXPub)
+	}
+	if old, found := wallets.XPubs[xpub]; found { // keep what was handed out since
+		for chain := range next {
+			if old.Next[chain] > next[chain] {
+				next[chain] = old.Next[chain]
+			}
+		}
+	}
+	wallets.XPubs[xpub] = &WatchedXPub{next}
+
+	var added []string
+	for _, chain := range []int{hdReceiveChain, hdChangeChain} {
+		for index := 0; index < next[chain]; index++ {
+			w, path := derive(chain, index)
+			address := fmt.Sprintf("%s", w.GetAddress())
+			if _, found := wallets.Wallets[address]; found || wallets.IsWatchOnly(address) {
+				continue
+			}
+			wallets.addWatch(address, &WatchOnly{w.LockingKey(), xpub, path, nil})
+			added = append(added, address)
+		}
+	}
+	return added, nil
+}
+
+// newWatchChange is the next change address of the xpub address comes from, "" for a plain address
+func (wallets *Wallets) newWatchChange(address string) string {
+	entry := wallets.Watch[address]
+	if entry == nil || entry.XPub == "" {
+		return ""
+	}
+	key, err := ParseExtendedPublicKey(entry.XPub)
+	if err != nil {
+		log.Panic(err)
+	}
+	state := wallets.XPubs[entry.XPub]
+	path := []uint32{hdChangeChain, uint32(state.Next[hdChangeChain])}
+	child, err := key.Derive(path)
+	if err != nil {
+		log.Panic(err)
+	}
+	state.Next[hdChangeChain]++
+	w := child.Wallet(path)
+	change := fmt.Sprintf("%s", w.GetAddress())
+	wallets.addWatch(change, &WatchOnly{w.LockingKey(), entry.XPub, path, nil})
+	return change
+}
+
+func (wallets *Wallets) GetWatchOnlyAddresses() []string {
+	var addresses []string
+	for address := range wallets.Watch {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+	return addresses
+}
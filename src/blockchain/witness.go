@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+)
+
+// a coinbase output starting with this tag commits to the witness data of the block
+// it carries no value and its "pubkey hash" is too long to ever match a real key
+var witnessCommitmentTag = []byte{0xaa, 0x21, 0xa9, 0xed}
+
+const witnessCommitmentLen = 4 + sha256.Size
+
+func (tx *Transaction) HasWitness() bool {
+	for _, w := range tx.Witness {
+		if len(w.Signature) > 0 || len(w.PubKey) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// inputUnlock returns the signature and public key of an input, wherever the tx keeps them
+func (tx *Transaction) inputUnlock(InId int) ([]byte, []byte) {
+	if tx.HasWitness() {
+		return tx.Witness[InId].Signature, tx.Witness[InId].PubKey
+	}
+	return tx.VIn[InId].Signature, tx.VIn[InId].PubKey
+}
+
+func (out *TXOutput) isWitnessCommitment() bool {
+	return out.Value == 0 && len(out.PubKeyHash) == witnessCommitmentLen &&
+		bytes.HasPrefix(out.PubKeyHash, witnessCommitmentTag)
+}
+
+/*
+WitnessRoot is the merkle root of the wtxids of the given transactions.
+The coinbase can't commit to itself, so its leaf is 32 zero bytes
+*/
+func WitnessRoot(transactions []*Transaction) []byte {
+	var leaves [][]byte
+	for _, tx := range transactions {
+		if tx.isCoinbaseTX() {
+			leaves = append(leaves, make([]byte, sha256.Size))
+		} else {
+			leaves = append(leaves, tx.WitnessHash())
+		}
+	}
+	mTree := NewMerkelTree(leaves)
+	return mTree.Root.Data
+}
+
+func witnessCommitment(transactions []*Transaction) []byte {
+	root := WitnessRoot(transactions)
+	hash := sha256.Sum256(root)
+	return append(append([]byte{}, witnessCommitmentTag...), hash[:]...)
+}
+
+func findCoinbase(transactions []*Transaction) *Transaction {
+	for _, tx := range transactions {
+		if tx.isCoinbaseTX() {
+			return tx
+		}
+	}
+	return nil
+}
+
+/*
+AddWitnessCommitment puts the commitment output on the coinbase when any tx has a witness.
+It has to run after the transaction list is final and before mining, since it changes the
+coinbase and therefore the merkle root
+*/
+func AddWitnessCommitment(transactions []*Transaction) {
+	coinbase := findCoinbase(transactions)
+	if coinbase == nil {
+		return
+	}
+	var outputs []TXOutput
+	for _, out := range coinbase.VOut {
+		if !out.isWitnessCommitment() {
+			outputs = append(outputs, out)
+		}
+	}
+	coinbase.VOut = outputs
+	hasWitness := false
+	for _, tx := range transactions {
+		hasWitness = hasWitness || tx.HasWitness()
+	}
+	if hasWitness {
+		coinbase.VOut = append(coinbase.VOut, TXOutput{0, witnessCommitment(transactions)})
+	}
+	coinbase.ID = coinbase.Hash()
+}
+
+// a block with witness data must commit to it, otherwise the witnesses could be swapped freely
+func (b *block) HasValidWitnessCommitment() bool {
+	hasWitness := false
+	for _, tx := range b.Transactions {
+		if tx.isCoinbaseTX() && tx.HasWitness() {
+			return false
+		}
+		hasWitness = hasWitness || tx.HasWitness()
+	}
+	if !hasWitness {
+		return true
+	}
+	coinbase := findCoinbase(b.Transactions)
+	if coinbase == nil {
+		return false
+	}
+	expected := witnessCommitment(b.Transactions)
+	for _, out := range coinbase.VOut {
+		if out.isWitnessCommitment() && bytes.Equal(out.PubKeyHash, expected) {
+			return true
+		}
+	}
+	return false
+}
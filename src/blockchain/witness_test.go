@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWitnessTxid(t *testing.T) {
+	bc, w := newTestChain(t)
+	tx := NewPaymentsTransaction(w, []Payment{{string(NewWallet().GetAddress()), 4}}, "", &UTXOSet{bc}, nil)
+	if !tx.HasWitness() || len(tx.VIn[0].Signature) != 0 {
+		t.Fatal("the signature is not in the witness")
+	}
+	back := DeserializeTransaction(tx.Serialize())
+	if !bytes.Equal(back.Serialize(), tx.Serialize()) || !bytes.Equal(back.ID, tx.ID) {
+		t.Fatal("the tx changed in a round trip")
+	}
+	if bytes.Equal(tx.WitnessHash(), tx.ID) {
+		t.Fatal("the wtxid of a segwit tx is its txid")
+	}
+	// a different witness changes the wtxid only
+	wtxid := tx.WitnessHash()
+	tx.Witness[0].Signature = append([]byte{}, tx.Witness[0].Signature...)
+	tx.Witness[0].Signature[10] ^= 1
+	if !bytes.Equal(tx.Hash(), tx.ID) || bytes.Equal(tx.WitnessHash(), wtxid) {
+		t.Fatal("the witness is part of the txid")
+	}
+
+	coinbase := NewCoinbaseTX(string(w.GetAddress()), "")
+	if !bytes.Equal(coinbase.WitnessHash(), coinbase.ID) {
+		t.Fatal("the wtxid of a tx without witness is not its txid")
+	}
+}
+
+func TestWitnessCommitment(t *testing.T) {
+	bc, w := newTestChain(t)
+	tx := NewPaymentsTransaction(w, []Payment{{string(NewWallet().GetAddress()), 4}}, "", &UTXOSet{bc}, nil)
+	b := nextBlock(bc, tx)
+	if !b.HasValidWitnessCommitment() {
+		t.Fatal("the commitment of a new block is not valid")
+	}
+	tx.Witness[0].Signature = append([]byte{}, tx.Witness[0].Signature...)
+	tx.Witness[0].Signature[10] ^= 1
+	if b.HasValidWitnessCommitment() {
+		t.Fatal("the commitment holds for another witness")
+	}
+}
+
+func TestLegacyFieldsOfSegwitTxRejected(t *testing.T) {
+	bc, w := newTestChain(t)
+	tx := NewPaymentsTransaction(w, []Payment{{string(NewWallet().GetAddress()), 4}}, "", &UTXOSet{bc}, nil)
+	if !bc.VerifyTransaction(tx) {
+		t.Fatal("the signed tx does not verify")
+	}
+	// nothing signs the legacy fields of a segwit tx, bytes put there by a relay change the txid
+	for _, malleate := range []func(in *TXInput){
+		func(in *TXInput) { in.Signature = []byte{0xde, 0xad} },
+		func(in *TXInput) { in.PubKey = []byte{0xbe, 0xef} },
+	} {
+		malleated := DeserializeTransaction(tx.Serialize())
+		malleate(&malleated.VIn[0])
+		malleated.ID = malleated.Hash()
+		if bytes.Equal(malleated.ID, tx.ID) {
+			t.Fatal("the legacy fields are not part of the txid")
+		}
+		if bc.VerifyTransaction(&malleated) {
+			t.Fatal("a segwit tx with legacy signature fields verifies")
+		}
+		tip := bc.tip
+		bc.AddBlock(nextBlock(bc, &malleated))
+		if !bytes.Equal(bc.tip, tip) {
+			t.Fatal("a block with the malleated tx was connected")
+		}
+	}
+}